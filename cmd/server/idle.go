@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// idleMonitor 记录最近一次活动（任意 HTTP 请求或存在活跃房间）的时间，
+// 供按需部署（scale-to-zero）在空闲一段时间后自动退出，交由编排系统回收实例。
+type idleMonitor struct {
+	last   atomic.Int64
+	active func() bool
+}
+
+// newIdleMonitor 创建监视器，active 报告当前是否仍有活跃房间。
+func newIdleMonitor(active func() bool) *idleMonitor {
+	m := &idleMonitor{active: active}
+	m.touch()
+	return m
+}
+
+func (m *idleMonitor) touch() { m.last.Store(time.Now().UnixNano()) }
+
+// idleExemptPaths 是不计为活动的探针与指标路径：k8s 探针与 Prometheus 抓取会持续访问，
+// 若计入则实例永远不会被判定为空闲。
+var idleExemptPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+
+// Wrap 在请求开始与结束时都刷新活动时间，避免长请求结束后立即被判定为空闲；
+// 探针与指标请求（idleExemptPaths）不刷新。
+func (m *idleMonitor) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if idleExemptPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m.touch()
+		defer m.touch()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Wait 在后台按 timeout 的四分之一周期（至少 1 秒）检查，连续空闲超过 timeout 时关闭返回的通道。
+func (m *idleMonitor) Wait(timeout time.Duration) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(max(timeout/4, time.Second))
+		defer ticker.Stop()
+		for range ticker.C {
+			if m.active() {
+				m.touch()
+				continue
+			}
+			if time.Since(time.Unix(0, m.last.Load())) >= timeout {
+				close(done)
+				return
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdleMonitor_WrapSkipsProbes(t *testing.T) {
+	m := newIdleMonitor(func() bool { return false })
+	h := m.Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	stale := time.Now().Add(-time.Hour).UnixNano()
+
+	for _, path := range []string{"/healthz", "/readyz", "/metrics"} {
+		m.last.Store(stale)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+		if m.last.Load() != stale {
+			t.Errorf("Expected %s not to count as activity", path)
+		}
+	}
+	m.last.Store(stale)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/whip/publish/demo", nil))
+	if m.last.Load() == stale {
+		t.Error("Expected an API request to count as activity")
+	}
+}
+
+func TestIdleMonitor_Wait(t *testing.T) {
+	var active atomic.Bool
+	active.Store(true)
+	m := newIdleMonitor(active.Load)
+	done := m.Wait(100 * time.Millisecond)
+
+	// 有活跃房间时不退出
+	select {
+	case <-done:
+		t.Fatal("Expected no idle exit while a room is active")
+	case <-time.After(1500 * time.Millisecond):
+	}
+	active.Store(false)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected idle exit once nothing is active")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"sync"
+)
+
+// logFile 是可在运行时重新打开的日志文件，配合 logrotate 等外部工具：
+// 轮转工具移走旧文件后发送 SIGHUP，服务重新打开同名路径继续写入，无需重启断流。
+type logFile struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+}
+
+// openLogFile 以追加模式打开日志文件。
+func openLogFile(path string) (*logFile, error) {
+	l := &logFile{path: path}
+	if err := l.Reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Write 实现 io.Writer，供 log.SetOutput 使用。
+func (l *logFile) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Write(p)
+}
+
+// Reopen 关闭当前文件句柄并重新打开同一路径；打开失败时保留旧句柄继续写入。
+func (l *logFile) Reopen() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	old := l.f
+	l.f = f
+	l.mu.Unlock()
+	if old != nil {
+		_ = old.Close()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLogFile_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+	l, err := openLogFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Write([]byte("before\n")); err != nil {
+		t.Fatal(err)
+	}
+	// 模拟 logrotate：移走旧文件后重新打开（SIGHUP）
+	rotated := path + ".1"
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reopen(); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err := l.Write([]byte("after\n")); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rotated); string(b) != "before\n" {
+		t.Errorf("Expected rotated file to keep only earlier lines, got %q", b)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "after\n" {
+		t.Errorf("Expected new lines in a fresh file at the same path, got %q", b)
+	}
+
+	// 打开失败时保留旧句柄继续写入
+	l.path = filepath.Join(dir, "missing", "server.log")
+	if err := l.Reopen(); err == nil {
+		t.Fatal("Expected reopen to fail for a missing directory")
+	}
+	if _, err := l.Write([]byte("still\n")); err != nil {
+		t.Fatalf("Expected writes to continue on the old handle, got %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "after\nstill\n" {
+		t.Errorf("Expected the old file to receive writes after a failed reopen, got %q", b)
+	}
+}
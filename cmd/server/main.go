@@ -4,10 +4,11 @@
 
 import (
 	"context"
+	"crypto/tls"
 	"embed"
 	"fmt"
-	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -19,6 +20,7 @@
 
 	"live-webrtc-go/internal/api"
 	"live-webrtc-go/internal/config"
+	"live-webrtc-go/internal/metrics"
 	"live-webrtc-go/internal/sfu"
 	"live-webrtc-go/internal/uploader"
 )
@@ -33,59 +35,153 @@
 // 3) 启动 HTTP/HTTPS 服务并实现优雅退出
 func main() {
 	// 加载配置并初始化依赖（上传器、SFU 管理器、HTTP 处理器）
-	cfg := config.Load()
+	cfg, err := config.LoadStrict()
+	if err != nil {
+		log.Fatalf("invalid configuration:\n%v", err)
+	}
+	var lf *logFile
+	if cfg.LogFile != "" {
+		if lf, err = openLogFile(cfg.LogFile); err != nil {
+			log.Fatalf("open log file: %v", err)
+		}
+		log.SetOutput(lf)
+	}
+	// 就绪探测：上传器初始化完成且开始监听后才返回 200，优雅退出时先撤销
+	var ready readiness
+	if cfg.E2EEPassthrough && cfg.RecordEnabled {
+		log.Printf("E2EE_PASSTHROUGH is set: encrypted media cannot be recorded, RECORD_ENABLED is ignored")
+	}
+	if cfg.JWTEnabled() && cfg.JWTSkipExpiry {
+		log.Printf("JWT_SKIP_EXPIRY is set: JWT exp/nbf are not validated, do not use in production")
+	}
+	metrics.Init(cfg.ConnectBuckets)
+	metrics.SetRoomAllowlist(cfg.MetricsRoomAllowlist)
 	_ = uploader.Init(cfg)
 	mgr := sfu.NewManager(cfg)
 	h := api.NewHTTPHandlers(mgr, cfg)
+	if cfg.SessionMaxLifetime > 0 {
+		go mgr.EnforceSessionLifetime(cfg.SessionMaxLifetime)
+	}
+	if cfg.MetricsLogInterval > 0 {
+		go metrics.LogSummary(cfg.MetricsLogInterval)
+	}
 
-    // 使用标准库 ServeMux 注册各类路由
+    // 使用标准库 ServeMux 注册各类路由；路径中的房间名由各处理函数按 config.ValidateRoomName 校验
     mux := http.NewServeMux()
 
-    // API：WHIP 推流（POST）
+    // API：WHIP 推流（POST）与发布者迁移（POST /api/whip/publish/{room}/migrate）
     mux.HandleFunc("/api/whip/publish/", func(w http.ResponseWriter, r *http.Request) {
         room := strings.TrimPrefix(r.URL.Path, "/api/whip/publish/")
-        if room == "" || strings.Contains(room, "..") {
-            http.Error(w, "invalid room", http.StatusBadRequest)
+        if strings.HasSuffix(room, "/migrate") {
+            h.ServeWHIPMigrate(w, r, strings.TrimSuffix(room, "/migrate"))
             return
         }
         h.ServeWHIPPublish(w, r, room)
     })
 
-    // API：WHEP 播放（POST）
+    // API：WHIP 会话资源（即推流返回的 Location）：DELETE 结束会话，PATCH 交换 trickle ICE 候选
+    mux.HandleFunc("/api/whip/session/", func(w http.ResponseWriter, r *http.Request) {
+        id := strings.TrimPrefix(r.URL.Path, "/api/whip/session/")
+        if id == "" || strings.Contains(id, "/") {
+            api.Reject(w, "session", "bad_request", "invalid session", http.StatusBadRequest)
+            return
+        }
+        h.ServeSession(w, r, id)
+    })
+
+    // API：WHEP 播放（POST）、结束播放（DELETE /api/whep/play/{room}/{id}）、订阅者请求关键帧（POST /api/whep/play/{room}/{id}/pli）
+    // 与满员房间的等候室（GET /api/whep/play/{room}/queue）
     mux.HandleFunc("/api/whep/play/", func(w http.ResponseWriter, r *http.Request) {
         room := strings.TrimPrefix(r.URL.Path, "/api/whep/play/")
-        if room == "" || strings.Contains(room, "..") {
-            http.Error(w, "invalid room", http.StatusBadRequest)
+        if strings.HasSuffix(room, "/queue") {
+            h.ServeWHEPQueue(w, r, strings.TrimSuffix(room, "/queue"))
+            return
+        }
+        if r.Method == http.MethodDelete || r.Method == http.MethodPatch || r.Method == http.MethodOptions {
+            // WHEP 会话资源：DELETE/PATCH /api/whep/play/{room}/{id}
+            if parts := strings.Split(room, "/"); len(parts) == 2 && parts[1] != "" {
+                h.ServeSession(w, r, parts[1])
+                return
+            }
+        }
+        if strings.HasSuffix(room, "/pli") {
+            parts := strings.Split(strings.TrimSuffix(room, "/pli"), "/")
+            if len(parts) != 2 || parts[1] == "" {
+                api.Reject(w, "whep_pli", "bad_request", "invalid subscriber", http.StatusBadRequest)
+                return
+            }
+            h.ServeWHEPKeyframe(w, r, parts[0], parts[1])
             return
         }
         h.ServeWHEPPlay(w, r, room)
     })
 
+    // WebSocket 信令：GET /ws/{room} 升级后以 JSON 消息交换 Offer/Answer 与 ICE 候选
+    mux.HandleFunc("/ws/", func(w http.ResponseWriter, r *http.Request) {
+        h.ServeWebSocket(w, r, strings.TrimPrefix(r.URL.Path, "/ws/"))
+    })
+
     // API：房间列表与录制文件列表（GET）
     mux.HandleFunc("/api/rooms", h.ServeRooms)
+    // API：签发临时 TURN 凭据（GET /api/turn-credentials）
+    mux.HandleFunc("/api/turn-credentials", h.ServeTURNCredentials)
+    // API：与 SFU 相同的 STUN/TURN 服务器列表（GET /api/ice-servers）
+    mux.HandleFunc("/api/ice-servers", h.ServeICEServers)
+    // API：推拉流前检查凭据能否访问房间（GET /api/auth/check?room={room}），不创建房间或连接
+    mux.HandleFunc("/api/auth/check", h.ServeAuthCheck)
+    // API：单个房间详情（GET /api/rooms/{room}）与媒体流健康检查（GET /api/rooms/{room}/health）
+    mux.HandleFunc("/api/rooms/", func(w http.ResponseWriter, r *http.Request) {
+        p := strings.TrimPrefix(r.URL.Path, "/api/rooms/")
+        if p != "" && !strings.Contains(p, "/") {
+            h.ServeRoomDetail(w, r, p)
+            return
+        }
+        room := strings.TrimSuffix(p, "/health")
+        if room == p {
+            http.NotFound(w, r)
+            return
+        }
+        h.ServeRoomHealth(w, r, room)
+    })
     mux.HandleFunc("/api/records", h.ServeRecordsList)
+    // 删除录制文件（DELETE /api/records/{name}，需管理员鉴权）
+    mux.HandleFunc("/api/records/", func(w http.ResponseWriter, r *http.Request) {
+        h.ServeRecord(w, r, strings.TrimPrefix(r.URL.Path, "/api/records/"))
+    })
 
-    // 管理接口：关闭房间（POST /api/admin/rooms/{room}/close）
+    // 管理接口：关闭房间（POST /api/admin/rooms/{room}/close）、级联转推（POST /api/admin/rooms/{room}/relay）、
+    // 预置房间（PUT /api/admin/rooms/{room}）
     mux.HandleFunc("/api/admin/rooms/", func(w http.ResponseWriter, r *http.Request) {
         p := strings.TrimPrefix(r.URL.Path, "/api/admin/rooms/")
+        if strings.HasSuffix(p, "/relay") {
+            h.ServeAdminRelayRoom(w, r, strings.TrimSuffix(p, "/relay"))
+            return
+        }
         if strings.HasSuffix(p, "/close") {
-            room := strings.TrimSuffix(p, "/close")
-            room = strings.TrimSuffix(room, "/")
-            if room == "" || strings.Contains(room, "..") {
-                http.Error(w, "invalid room", http.StatusBadRequest)
-                return
-            }
-            h.ServeAdminCloseRoom(w, r, room)
+            h.ServeAdminCloseRoom(w, r, strings.TrimSuffix(strings.TrimSuffix(p, "/close"), "/"))
+            return
+        }
+        if p != "" && !strings.Contains(p, "/") {
+            h.ServeAdminProvisionRoom(w, r, p)
             return
         }
         http.NotFound(w, r)
     })
 
+    // 管理接口：上传队列与最近失败（GET /api/admin/uploads）
+    mux.HandleFunc("/api/admin/uploads", h.ServeAdminUploads)
+    // 管理接口：导出房间、指标快照与脱敏配置（GET /api/admin/debug/state）
+    mux.HandleFunc("/api/admin/debug/state", h.ServeAdminDebugState)
+    // 管理接口：房间/订阅者/发布者总数、RTP 计数与运行时状态（GET /api/stats）
+    mux.HandleFunc("/api/stats", h.ServeStats)
+
     // 健康检查：用于存活探测与基础监控
     mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
         w.WriteHeader(http.StatusOK)
         _, _ = w.Write([]byte("ok"))
     })
+    // 就绪检查：用于滚动发布与负载均衡摘除，未就绪或关闭中返回 503
+    mux.Handle("/readyz", &ready)
 
     // Prometheus 指标：采集房间数量、订阅者数、RTP 字节/包等
     mux.Handle("/metrics", promhttp.Handler())
@@ -93,41 +189,131 @@ func main() {
     // 录制文件静态服务：直接暴露 RECORD_DIR 下内容
     mux.Handle("/records/", http.StripPrefix("/records/", http.FileServer(http.Dir(cfg.RecordDir))))
 
-    // 内嵌静态页面：publisher.html / player.html 等示例
-    staticFS, _ := fs.Sub(webFS, "web")
-    mux.Handle("/web/", http.StripPrefix("/web/", http.FileServer(http.FS(staticFS))))
-    mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-        if r.URL.Path == "/" {
-            http.Redirect(w, r, "/web/index.html", http.StatusFound)
-            return
-        }
-        http.NotFound(w, r)
-    })
+    // 内嵌静态页面：publisher.html / player.html 等示例；资源缺失时不注册 /web/，其余接口照常服务
+    staticFS, err := webAssets(webFS)
+    if err != nil {
+        log.Printf("web: embedded assets unavailable, /web/ disabled: %v", err)
+    } else {
+        mux.Handle("/web/", http.StripPrefix("/web/", http.FileServer(http.FS(staticFS))))
+    }
+    // 兜底路由：未匹配的路径返回内嵌的 web/404.html（若存在），API 错误响应保持不变
+    if nf := notFoundPage(staticFS); nf != nil {
+        h.SetNotFoundHandler(nf)
+    }
+    mux.HandleFunc("/", h.ServeRoot)
 
     // 启动服务：根据是否配置证书选择 HTTP 或 HTTPS
     addr := cfg.HTTPAddr
     fmt.Printf("Live WebRTC server listening on %s\n", addr)
-    fmt.Println("Open http://localhost:8080/web/publisher.html and http://localhost:8080/web/player.html")
+    if staticFS != nil {
+        fmt.Println("Open http://localhost:8080/web/publisher.html and http://localhost:8080/web/player.html")
+    }
+
+    // SERVER_IDLE_EXIT：无请求且无活跃房间超过设定时长后自动优雅退出
+    var handler http.Handler = mux
+    var idle <-chan struct{}
+    if cfg.ServerIdleExit > 0 {
+        im := newIdleMonitor(func() bool {
+            for _, info := range mgr.ListRooms() {
+                if info.HasPublisher || info.Subscribers > 0 {
+                    return true
+                }
+            }
+            return false
+        })
+        handler = im.Wrap(mux)
+        idle = im.Wait(cfg.ServerIdleExit)
+    }
+    // 访问日志：每个请求一行，输出到 stdout（LOG_FORMAT/LOG_LEVEL）
+    handler = h.AccessLog(handler, os.Stdout)
 
-    srv := &http.Server{Addr: addr, Handler: mux}
+    srv := &http.Server{Addr: addr, Handler: handler}
+    configureALPN(srv, cfg.TLSNextProtos)
+    // TLS 证书热更新：证书文件变化（或收到 SIGHUP）后重新加载，续期无需重启
+    var certs *certReloader
+    if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
+        certs, err = newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, cfg.TLSReloadInterval)
+        if err != nil {
+            log.Fatalf("tls: load certificate: %v", err)
+        }
+        if srv.TLSConfig == nil {
+            srv.TLSConfig = &tls.Config{}
+        }
+        srv.TLSConfig.GetCertificate = certs.GetCertificate
+    }
+    // 先监听再置为就绪，保证 /readyz 返回 200 时端口已可接受连接
+    ln, err := net.Listen("tcp", addr)
+    if err != nil {
+        log.Fatal(err)
+    }
     go func() {
         var err error
-        if cfg.TLSCertFile != "" && cfg.TLSKeyFile != "" {
-            err = srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+        if certs != nil {
+            err = srv.ServeTLS(ln, "", "")
         } else {
-            err = srv.ListenAndServe()
+            err = srv.Serve(ln)
         }
         if err != nil && err != http.ErrServerClosed {
             log.Fatal(err)
         }
     }()
+    ready.Set(true)
 
-    // 优雅退出：捕获中断信号，优雅关闭 HTTP 并清理房间连接
+    // SIGHUP：重新打开日志文件以配合外部日志轮转（输出到 stderr 时忽略），并立即重新加载 TLS 证书
+    hup := make(chan os.Signal, 1)
+    signal.Notify(hup, syscall.SIGHUP)
+    go func() {
+        for range hup {
+            if certs != nil {
+                if err := certs.Reload(); err != nil {
+                    log.Printf("tls: reload certificate: %v", err)
+                }
+            }
+            if lf == nil {
+                continue
+            }
+            if err := lf.Reopen(); err != nil {
+                log.Printf("reopen log file: %v", err)
+            }
+        }
+    }()
+
+    // 优雅退出：捕获中断信号或空闲超时，优雅关闭 HTTP 并清理房间连接
     stop := make(chan os.Signal, 1)
     signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-    <-stop
+    select {
+    case <-stop:
+    case <-idle:
+        log.Printf("idle for %s, shutting down", cfg.ServerIdleExit)
+    }
+    // 先让就绪探针失败、负载均衡摘除本实例，再停止接受请求；期间再次收到信号则立即继续
+    ready.drain(cfg.ShutdownDrainDelay, stop)
     ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
     defer cancel()
     _ = srv.Shutdown(ctx)
+    h.Close()
     mgr.CloseAll()
+    // 关闭房间会把录制文件加入上传队列，等待其上传完成（有上限），超时未完成的保留在本地
+    if cfg.UploadShutdownTimeout > 0 && uploader.Enabled() {
+        uctx, ucancel := context.WithTimeout(context.Background(), cfg.UploadShutdownTimeout)
+        defer ucancel()
+        if err := uploader.Wait(uctx); err != nil {
+            log.Printf("%v; recordings are kept in %s", err, cfg.RecordDir)
+        }
+    }
+}
+
+// configureALPN 按配置覆盖 TLS 的 ALPN 协议列表。列表中不含 "h2" 时同时清空
+// TLSNextProto，否则 net/http 仍会自动追加 h2 并启用 HTTP/2。
+func configureALPN(srv *http.Server, protos []string) {
+    if len(protos) == 0 {
+        return
+    }
+    srv.TLSConfig = &tls.Config{NextProtos: protos}
+    for _, p := range protos {
+        if p == "h2" {
+            return
+        }
+    }
+    srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
 }
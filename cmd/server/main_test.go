@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestConfigureALPN(t *testing.T) {
+	srv := &http.Server{}
+	configureALPN(srv, nil)
+	if srv.TLSConfig != nil || srv.TLSNextProto != nil {
+		t.Fatal("Expected an empty list to keep the net/http defaults")
+	}
+
+	srv = &http.Server{}
+	configureALPN(srv, []string{"h2", "http/1.1"})
+	if srv.TLSConfig == nil || !slices.Equal(srv.TLSConfig.NextProtos, []string{"h2", "http/1.1"}) {
+		t.Fatalf("Expected NextProtos to follow the config, got %+v", srv.TLSConfig)
+	}
+	if srv.TLSNextProto != nil {
+		t.Error("Expected HTTP/2 to stay enabled when h2 is listed")
+	}
+
+	srv = &http.Server{}
+	configureALPN(srv, []string{"http/1.1"})
+	if srv.TLSConfig == nil || !slices.Equal(srv.TLSConfig.NextProtos, []string{"http/1.1"}) {
+		t.Fatalf("Expected NextProtos to follow the config, got %+v", srv.TLSConfig)
+	}
+	// 非 nil 的空映射会阻止 net/http 自动启用 HTTP/2
+	if srv.TLSNextProto == nil || len(srv.TLSNextProto) != 0 {
+		t.Errorf("Expected TLSNextProto to be an empty non-nil map without h2, got %v", srv.TLSNextProto)
+	}
+}
+
+func TestReadiness_Drain(t *testing.T) {
+	var ready readiness
+	status := func() int {
+		w := httptest.NewRecorder()
+		ready.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+		return w.Code
+	}
+	if c := status(); c != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 before listening, got %d", c)
+	}
+	ready.Set(true)
+	if c := status(); c != http.StatusOK {
+		t.Errorf("Expected 200 once listening, got %d", c)
+	}
+
+	stop := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		ready.drain(time.Hour, stop)
+		close(done)
+	}()
+	waitReady := time.Now().Add(2 * time.Second)
+	for status() != http.StatusServiceUnavailable && time.Now().Before(waitReady) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if c := status(); c != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while draining, got %d", c)
+	}
+	select {
+	case <-done:
+		t.Fatal("Expected drain to wait for the delay")
+	case <-time.After(50 * time.Millisecond):
+	}
+	// 排空期间再次收到信号则立即结束等待
+	stop <- os.Interrupt
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a second signal to end the drain early")
+	}
+
+	ready.Set(true)
+	start := time.Now()
+	ready.drain(50*time.Millisecond, nil)
+	if time.Since(start) < 50*time.Millisecond || status() != http.StatusServiceUnavailable {
+		t.Errorf("Expected drain to wait for the delay and stay not ready, got %d after %s", status(), time.Since(start))
+	}
+}
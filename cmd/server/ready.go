@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// readiness 是 /readyz 的就绪标志：初始化完成且开始监听后置为就绪，优雅退出开始时撤销，
+// 使 Kubernetes 等编排系统在滚动发布时只把流量交给已就绪、且未在关闭中的实例。
+// 与 /healthz（进程存活）不同，未就绪时返回 503。
+type readiness struct {
+	ready atomic.Bool
+}
+
+// Set 更新就绪状态。
+func (r *readiness) Set(ready bool) { r.ready.Store(ready) }
+
+// ServeHTTP 处理 GET /readyz：就绪时返回 200 "ready"，否则 503 "not ready"。
+func (r *readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	if !r.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write([]byte("ready"))
+}
+
+// drain 撤销就绪状态并等待 delay（SHUTDOWN_DRAIN_DELAY），让就绪探针失败、负载均衡摘除本实例后
+// 再停止接受请求；期间再次从 stop 收到信号则立即返回。
+func (r *readiness) drain(delay time.Duration, stop <-chan os.Signal) {
+	r.Set(false)
+	if delay <= 0 {
+		return
+	}
+	log.Printf("draining for %s before shutdown", delay)
+	select {
+	case <-time.After(delay):
+	case <-stop:
+	}
+}
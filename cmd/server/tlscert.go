@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader 为 tls.Config.GetCertificate 提供可热更新的证书：证书或私钥文件的修改时间变化后
+// 重新加载，续期（如 Let's Encrypt）后的新证书无需重启即可生效，已建立的推拉流不受影响。
+// 文件最多每 interval 检查一次；加载失败时继续使用旧证书。
+type certReloader struct {
+	certFile, keyFile string
+	interval          time.Duration // 检查文件修改时间的最小间隔，0 表示只在 Reload 时重新加载
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time // 已加载证书对应的两个文件中较新的修改时间
+	checked time.Time
+}
+
+// newCertReloader 立即加载证书，失败时返回错误以便启动阶段暴露配置问题。
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	c := &certReloader{certFile: certFile, keyFile: keyFile, interval: interval}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate 实现 tls.Config.GetCertificate，按需检查文件是否更新。
+func (c *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.interval > 0 && time.Since(c.checked) >= c.interval {
+		c.checked = time.Now()
+		if mod := c.latestModTime(); mod.After(c.modTime) {
+			if err := c.loadLocked(mod); err != nil {
+				log.Printf("tls: reload certificate: %v", err)
+			} else {
+				log.Printf("tls: reloaded certificate %s", c.certFile)
+			}
+		}
+	}
+	return c.cert, nil
+}
+
+// Reload 无条件重新加载证书（如收到 SIGHUP 时），失败时保留旧证书。
+func (c *certReloader) Reload() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked = time.Now()
+	return c.loadLocked(c.latestModTime())
+}
+
+func (c *certReloader) loadLocked(mod time.Time) error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+	c.cert, c.modTime = &cert, mod
+	return nil
+}
+
+// latestModTime 返回证书与私钥文件中较新的修改时间；stat 失败的文件忽略。
+func (c *certReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, p := range []string{c.certFile, c.keyFile} {
+		if fi, err := os.Stat(p); err == nil && fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertPair 在 certFile/keyFile 写入 CommonName 为 cn 的自签名证书，并把修改时间设为 mod。
+func writeCertPair(t *testing.T, certFile, keyFile, cn string, mod time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{certFile, keyFile} {
+		if err := os.Chtimes(p, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// servedCN 返回 GetCertificate 当前提供的证书的 CommonName。
+func servedCN(t *testing.T, c *certReloader) string {
+	t.Helper()
+	cert, err := c.GetCertificate(nil)
+	if err != nil || cert == nil {
+		t.Fatalf("GetCertificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	t0 := time.Now().Add(-time.Hour)
+	writeCertPair(t, certFile, keyFile, "v1", t0)
+
+	c, err := newCertReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := servedCN(t, c); cn != "v1" {
+		t.Fatalf("Expected initial certificate v1, got %s", cn)
+	}
+
+	// interval 为 0 时不轮询，只有 Reload（SIGHUP）才换证书
+	writeCertPair(t, certFile, keyFile, "v2", t0.Add(time.Minute))
+	if cn := servedCN(t, c); cn != "v1" {
+		t.Errorf("Expected no polling with a zero interval, got %s", cn)
+	}
+	if err := c.Reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if cn := servedCN(t, c); cn != "v2" {
+		t.Errorf("Expected Reload to serve v2, got %s", cn)
+	}
+
+	// 轮询：修改时间变新后 GetCertificate 自动加载
+	c.interval = time.Nanosecond
+	writeCertPair(t, certFile, keyFile, "v3", t0.Add(2*time.Minute))
+	if cn := servedCN(t, c); cn != "v3" {
+		t.Errorf("Expected polling to pick up v3, got %s", cn)
+	}
+
+	// 损坏的证书对：Reload 报错，轮询也继续提供旧证书
+	if err := os.WriteFile(keyFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	mod := t0.Add(3 * time.Minute)
+	_ = os.Chtimes(keyFile, mod, mod)
+	if err := c.Reload(); err == nil {
+		t.Error("Expected Reload to fail for a broken key")
+	}
+	if cn := servedCN(t, c); cn != "v3" {
+		t.Errorf("Expected the old certificate to stay in service, got %s", cn)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+)
+
+// webAssets 返回内嵌资源中的 web 子目录；子目录不存在或为空时返回错误，
+// 调用方据此跳过 /web/ 路由，而不是在首个请求时才因资源缺失失败。
+func webAssets(embedded fs.FS) (fs.FS, error) {
+	sub, err := fs.Sub(embedded, "web")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fs.ReadDir(sub, ".")
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("web directory is empty")
+	}
+	return sub, nil
+}
+
+// notFoundPage 返回以 web/404.html 响应 404 的处理器；页面不存在时返回 nil，沿用纯文本 404。
+func notFoundPage(static fs.FS) http.Handler {
+	if static == nil {
+		return nil
+	}
+	page, err := fs.ReadFile(static, "404.html")
+	if err != nil {
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(http.StatusNotFound)
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(page)
+		}
+	})
+}
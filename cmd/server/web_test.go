@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestWebAssets(t *testing.T) {
+	tests := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr bool
+	}{
+		{"no web dir", fstest.MapFS{"other.txt": {Data: []byte("x")}}, true},
+		{"empty web dir", fstest.MapFS{"web": {Mode: fs.ModeDir}}, true},
+		{"populated", fstest.MapFS{"web/player.html": {Data: []byte("<html>")}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sub, err := webAssets(test.fsys)
+			if test.wantErr {
+				if err == nil || sub != nil {
+					t.Errorf("Expected an error and nil FS, got %v, %v", sub, err)
+				}
+				return
+			}
+			if err != nil || sub == nil {
+				t.Fatalf("Expected the web sub-FS, got %v, %v", sub, err)
+			}
+			if b, err := fs.ReadFile(sub, "player.html"); err != nil || string(b) != "<html>" {
+				t.Errorf("Expected player.html at the sub-FS root, got %q (%v)", b, err)
+			}
+		})
+	}
+}
+
+func TestNotFoundPage(t *testing.T) {
+	if notFoundPage(nil) != nil {
+		t.Error("Expected no handler without embedded assets")
+	}
+	if notFoundPage(fstest.MapFS{"player.html": {Data: []byte("<html>")}}) != nil {
+		t.Error("Expected no handler without 404.html")
+	}
+
+	nf := notFoundPage(fstest.MapFS{"404.html": {Data: []byte("<h1>gone</h1>")}})
+	if nf == nil {
+		t.Fatal("Expected a handler for 404.html")
+	}
+	w := httptest.NewRecorder()
+	nf.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != http.StatusNotFound || w.Body.String() != "<h1>gone</h1>" {
+		t.Errorf("Expected the 404 page, got %d %q", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected an HTML content type, got %q", ct)
+	}
+	w = httptest.NewRecorder()
+	nf.ServeHTTP(w, httptest.NewRequest("HEAD", "/missing", nil))
+	if w.Code != http.StatusNotFound || w.Body.Len() != 0 {
+		t.Errorf("Expected an empty 404 for HEAD, got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,135 @@
+package api
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// roomPrefixes 是路径中带房间名的路由前缀，访问日志据此提取 room 字段。
+var roomPrefixes = []string{"/api/whip/publish/", "/api/whep/play/", "/api/rooms/", "/api/admin/rooms/", "/ws/"}
+
+// accessWriter 记录处理函数写出的状态码与拒绝原因（见 reject），供访问日志使用。
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	reason string // reject 的 reason，未被拒绝时为空
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush 透传给底层 ResponseWriter，等候室（SSE）依赖它逐条推送事件。
+func (w *accessWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack 透传给底层 ResponseWriter，WebSocket 信令升级连接时需要。
+func (w *accessWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack not supported")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hj.Hijack()
+}
+
+// Unwrap 供 http.ResponseController 访问底层 ResponseWriter。
+func (w *accessWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// newAccessLogger 按 LOG_FORMAT（json 或 text）与 LOG_LEVEL 创建写到 out 的访问日志记录器。
+func newAccessLogger(out io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.EqualFold(format, "text") {
+		return slog.New(slog.NewTextHandler(out, opts))
+	}
+	return slog.New(slog.NewJSONHandler(out, opts))
+}
+
+// AccessLog 包装 next，在每个请求结束后向 out 输出一行访问日志：方法、路径、房间、状态码、
+// 耗时、客户端 IP 与鉴权结果。只记录路径，不含查询串（可能携带 ?token=）与请求体（SDP）。
+// 5xx 以 ERROR、4xx 以 WARN、其余以 INFO 级别输出，低于 LOG_LEVEL 的不输出。
+func (h *HTTPHandlers) AccessLog(next http.Handler, out io.Writer) http.Handler {
+	logger := newAccessLogger(out, h.cfg.LogFormat, h.cfg.LogLevel)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		aw := &accessWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		status := aw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		lvl := slog.LevelInfo
+		switch {
+		case status >= 500:
+			lvl = slog.LevelError
+		case status >= 400:
+			lvl = slog.LevelWarn
+		}
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("room", roomFromPath(r.URL.Path)),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", h.clientHost(r)),
+			slog.String("auth", authResult(r, aw.reason)),
+		}
+		if aw.reason != "" {
+			attrs = append(attrs, slog.String("reason", aw.reason))
+		}
+		logger.LogAttrs(r.Context(), lvl, "access", attrs...)
+	})
+}
+
+// roomFromPath 从带房间名的路由中取出房间，其他路径返回空串。
+func roomFromPath(path string) string {
+	for _, p := range roomPrefixes {
+		if rest, ok := strings.CutPrefix(path, p); ok {
+			room, _, _ := strings.Cut(rest, "/")
+			return room
+		}
+	}
+	return ""
+}
+
+// authResult 概括请求的鉴权结果：denied 表示因鉴权被拒（401/403），ok 表示携带了凭据且未被拒绝，
+// none 表示未携带凭据。
+func authResult(r *http.Request, reason string) string {
+	switch {
+	case reason == "unauthorized" || reason == "forbidden":
+		return "denied"
+	case hasCredentials(r):
+		return "ok"
+	}
+	return "none"
+}
+
+// hasCredentials 报告请求是否携带了 Token 或 JWT（请求头或 ?token=）。
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("X-Auth-Token") != "" || r.URL.Query().Has("token")
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// authCheckResult 是 GET /api/auth/check 的响应。
+type authCheckResult struct {
+	OK      bool   `json:"ok"`
+	Room    string `json:"room"`
+	Reason  string `json:"reason,omitempty"`  // 失败原因，取值同 webrtc_http_rejections_total 的 reason
+	Message string `json:"message,omitempty"` // 面向用户的说明
+}
+
+// ServeAuthCheck 处理 GET /api/auth/check?room={room}：按推拉流相同的来源、预置房间与鉴权规则
+// （authOKRoom）检查请求携带的凭据能否访问该房间，结果以 JSON 返回（200 表示通过，401/403/404 表示
+// 推拉流会被拒绝及原因）。只做检查，不创建房间或连接，前端可据此在发起 WHIP/WHEP 前提示用户。
+func (h *HTTPHandlers) ServeAuthCheck(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "auth_check", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "auth_check", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	room := r.URL.Query().Get("room")
+	if !validRoom(w, "auth_check", room) {
+		return
+	}
+	res := authCheckResult{OK: true, Room: room}
+	code := http.StatusOK
+	switch {
+	case !h.originOK(r):
+		res.Reason, res.Message, code = "origin", "origin not allowed", http.StatusForbidden
+	case !h.roomAllowed(room):
+		res.Reason, res.Message, code = "room_not_found", "room not found", http.StatusNotFound
+	case !h.authOKRoom(r, room):
+		res.Reason, res.Message, code = "unauthorized", "invalid credentials", http.StatusUnauthorized
+		if !hasCredentials(r) {
+			res.Message = "missing credentials"
+		}
+	}
+	if code != http.StatusOK {
+		res.OK = false
+		noteRejection(w, "auth_check", res.Reason)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(res)
+}
@@ -2,12 +2,23 @@
 package api
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,15 +26,66 @@
 	jwt "github.com/golang-jwt/jwt/v5"
 	"golang.org/x/time/rate"
 	"live-webrtc-go/internal/config"
+	"live-webrtc-go/internal/metrics"
 	"live-webrtc-go/internal/sfu"
+	"live-webrtc-go/internal/uploader"
 )
 
+// RoomManager 是 HTTP 层依赖的房间管理能力，由 *sfu.Manager 实现；
+// 单元测试可注入返回固定 Answer 的假实现，以覆盖推拉流成功路径。
+type RoomManager interface {
+	PublishWithID(ctx context.Context, room, offerSDP string) (string, string, error)
+	CloseSession(id string) error
+	TrickleICE(id, frag string) (string, error)
+	MigratePublisher(ctx context.Context, room, offerSDP string) (string, error)
+	SubscribeWith(ctx context.Context, room, offerSDP string, opts sfu.SubscribeOptions) (sfu.SubscribeResult, error)
+	RequestKeyframe(room, subscriberID string) error
+	JoinQueue(room string) (*sfu.Waiter, error)
+	ListRooms() []sfu.RoomInfo
+	RoomHealth(room string, maxAge time.Duration) sfu.RoomHealth
+	RoomDetail(room string) (sfu.RoomDetail, bool)
+	RoomDetails() []sfu.RoomDetail
+	CloseRoom(room string) bool
+	CloseRoomGraceful(room string, grace time.Duration) bool
+	StartRelay(ctx context.Context, room, whipURL, token string) error
+	ProvisionRoom(room, token string, meta map[string]string) sfu.RoomState
+	RoomToken(room string) (string, bool)
+	IsProvisioned(room string) bool
+	DeleteRecording(name string) error
+	Stats() sfu.ServerStats
+}
+
+var _ RoomManager = (*sfu.Manager)(nil)
+
 // HTTPHandlers 聚合了房间管理器与配置，负责对外暴露 WHIP/WHEP/管理等 API。
 type HTTPHandlers struct {
-	mgr     *sfu.Manager
-	cfg     *config.Config
-	mu      sync.Mutex
-	limiter map[string]*rate.Limiter // per-IP 限流器
+	mgr   RoomManager
+	cfg   *config.Config
+	limit ipLimiter // 全局 per-IP 限流
+	// 端点专属限流（RATE_LIMIT_PUBLISH_*/RATE_LIMIT_PLAY_*），为 nil 时回退到全局限流
+	publishLimit *ipLimiter
+	playLimit    *ipLimiter
+	notFound     http.Handler  // 根路由兜底的 404 响应，nil 时使用 http.NotFound
+	started      time.Time     // 创建时间，GET /api/stats 据此计算运行时长
+	sessionKey   []byte        // 会话资源名的 HMAC 密钥，见 sessionResource
+	stop         chan struct{} // Close 时关闭，结束后台的限流器回收
+	stopOnce     sync.Once
+}
+
+// SetNotFoundHandler 设置根路由（ServeRoot）对未匹配路径及 ROOT_MODE=404 返回的 404 页面，
+// 例如内嵌的 web/404.html；/api/ 下的路径与 API 端点的错误响应不受影响。nf 为 nil 时恢复纯文本的 http.NotFound。
+func (h *HTTPHandlers) SetNotFoundHandler(nf http.Handler) {
+	h.notFound = nf
+}
+
+// serveNotFound 使用自定义 404 页面（若已设置）响应；/api/ 下的未知路径始终返回纯文本 404，
+// 保持 API 客户端看到的错误响应不变。
+func (h *HTTPHandlers) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if h.notFound != nil && !strings.HasPrefix(r.URL.Path, "/api/") {
+		h.notFound.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
 }
 
 // ServeRooms handles GET /api/rooms
@@ -33,28 +95,143 @@ func (h *HTTPHandlers) ServeRooms(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	if !isGet(r) {
+		reject(w, "rooms", "method", "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	if !h.allowRate(r) {
-		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		reject(w, "rooms", "rate_limited", "too many requests", http.StatusTooManyRequests)
 		return
 	}
 	rooms := h.mgr.ListRooms()
+	if r.URL.Query().Get("active") == "1" {
+		rooms = activeRooms(rooms)
+	}
+	if wantsCSV(r) {
+		rows := [][]string{{"name", "has_publisher", "tracks", "subscribers", "stalled_tracks", "relays"}}
+		for _, info := range rooms {
+			rows = append(rows, []string{
+				info.Name,
+				strconv.FormatBool(info.HasPublisher),
+				strconv.Itoa(info.Tracks),
+				strconv.Itoa(info.Subscribers),
+				strconv.Itoa(info.StalledTracks),
+				strconv.Itoa(len(info.Relays)),
+			})
+		}
+		writeCSV(w, rows)
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(rooms)
 }
 
-// NewHTTPHandlers 组合房间管理器与配置，并在启用速率限制时初始化每 IP 的限流器。
-func NewHTTPHandlers(m *sfu.Manager, c *config.Config) *HTTPHandlers {
-	h := &HTTPHandlers{mgr: m, cfg: c}
-	if c.RateLimitRPS > 0 {
-		h.limiter = make(map[string]*rate.Limiter)
+// isGet 判断请求是否为 GET 或 HEAD。HEAD 与 GET 走同一处理逻辑，
+// 响应体由 net/http 丢弃，只返回状态码与响应头，便于健康检查等客户端探测。
+func isGet(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// activeRooms 只保留正在直播的房间：有发布者，且并非所有轨道都已判定卡顿。
+func activeRooms(rooms []sfu.RoomInfo) []sfu.RoomInfo {
+	out := make([]sfu.RoomInfo, 0, len(rooms))
+	for _, info := range rooms {
+		if !info.HasPublisher || (info.Tracks > 0 && info.StalledTracks == info.Tracks) {
+			continue
+		}
+		out = append(out, info)
+	}
+	return out
+}
+
+// ServeRoomHealth 处理 GET /api/rooms/{room}/health：房间有发布者且最近 max_age 秒内
+// 收到过 RTP 时返回 200，否则返回 503，响应体均为 JSON 详情。
+func (h *HTTPHandlers) ServeRoomHealth(w http.ResponseWriter, r *http.Request, room string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "room_health", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "room_health", room) {
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "room_health", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	maxAge := h.cfg.RoomHealthMaxAge
+	if v := r.URL.Query().Get("max_age"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			reject(w, "room_health", "bad_request", "invalid max_age", http.StatusBadRequest)
+			return
+		}
+		maxAge = time.Duration(n) * time.Second
+	}
+	st := h.mgr.RoomHealth(room, maxAge)
+	w.Header().Set("Content-Type", "application/json")
+	if !st.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(st)
+}
+
+// ServeRoomDetail 返回单个房间的详细状态：GET /api/rooms/{room}
+// 包括各轨道编码/SSRC、订阅者列表、发布者 ICE 状态、创建时间与累计字节数；房间不存在时返回 404。
+func (h *HTTPHandlers) ServeRoomDetail(w http.ResponseWriter, r *http.Request, room string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "room_detail", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "room_detail", room) {
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "room_detail", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	d, ok := h.mgr.RoomDetail(room)
+	if !ok {
+		reject(w, "room_detail", "room_not_found", "room not found", http.StatusNotFound)
+		return
+	}
+	if !h.adminReadOK(r) {
+		for i := range d.SubscriberList {
+			d.SubscriberList[i].ID = "" // 订阅者 ID 只对管理员可见
+		}
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(d)
+}
+
+// NewHTTPHandlers 组合房间管理器与配置，并在启用速率限制时初始化每 IP 的限流器。
+func NewHTTPHandlers(m RoomManager, c *config.Config) *HTTPHandlers {
+	h := &HTTPHandlers{mgr: m, cfg: c, started: time.Now(), sessionKey: newSessionKey(), stop: make(chan struct{})}
+	h.ReloadRateLimit(c.RateLimitRPS, c.RateLimitBurst)
+	h.publishLimit = newIPLimiter(c.RateLimitPublishRPS, c.RateLimitPublishBurst)
+	h.playLimit = newIPLimiter(c.RateLimitPlayRPS, c.RateLimitPlayBurst)
+	go h.evictIdleLimiters()
 	return h
 }
 
+// Close 停止 NewHTTPHandlers 启动的后台 goroutine，可重复调用。
+func (h *HTTPHandlers) Close() {
+	h.stopOnce.Do(func() {
+		if h.stop != nil {
+			close(h.stop)
+		}
+	})
+}
+
 // ServeWHIPPublish 处理 WHIP 推流：POST /api/whip/publish/{room}
 // 请求体为 SDP Offer，返回 SDP Answer（201 Created）。
 func (h *HTTPHandlers) ServeWHIPPublish(w http.ResponseWriter, r *http.Request, room string) {
@@ -64,22 +241,171 @@ func (h *HTTPHandlers) ServeWHIPPublish(w http.ResponseWriter, r *http.Request,
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		reject(w, "whip", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "whip", room) {
+		return
+	}
+	if !h.allowEndpointRate(r, h.publishLimit) {
+		reject(w, "whip", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "whip", "origin", "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.roomAllowed(room) {
+		reject(w, "whip", "room_not_found", "room not found", http.StatusNotFound)
+		return
+	}
+	if !h.authOKRoom(r, room) {
+		reject(w, "whip", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	offerSDP, ok := h.readSDP(w, r, "whip")
+	if !ok {
+		return
+	}
+	ctx, cancel := h.answerContext(r)
+	defer cancel()
+	answer, id, err := h.mgr.PublishWithID(ctx, room, offerSDP)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		reject(w, "whip", "timeout", "answer timeout", http.StatusGatewayTimeout)
+		return
+	case errors.Is(err, sfu.ErrRoomClosed), errors.Is(err, sfu.ErrPublisherExists):
+		// 409 让客户端区分"房间已有推流"与可重试的瞬时错误
+		reject(w, "whip", "conflict", err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, sfu.ErrInvalidSDP):
+		reject(w, "whip", "bad_sdp", err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		reject(w, "whip", "internal", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/api/whip/session/"+h.sessionResource(id))
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte(answer))
+}
+
+// ServeSession 处理会话资源：/api/whip/session/{res}（WHIP 返回的 Location）
+// 与 /api/whep/play/{room}/{res}（WHEP 返回的 Location）。
+// DELETE 结束会话：发布者会话关闭推流连接，订阅者会话只断开该观众；成功返回 204，会话不存在时返回 404。
+// PATCH 交换 trickle ICE 候选（application/trickle-ice-sdpfrag），响应体为服务端目前收集到的候选。
+// res 须为 sessionResource 签发的 "{id}.{hmac}"：会话 ID 本身在房间列表中公开，只凭 ID 会返回 404。
+func (h *HTTPHandlers) ServeSession(w http.ResponseWriter, r *http.Request, res string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodDelete && r.Method != http.MethodPatch {
+		reject(w, "session", "method", "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	if !h.allowRate(r) {
-		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		reject(w, "session", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "session", "origin", "origin not allowed", http.StatusForbidden)
+		return
+	}
+	id, ok := h.sessionID(res)
+	if !ok {
+		reject(w, "session", "not_found", sfu.ErrSessionNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodPatch {
+		h.serveTrickle(w, r, id)
+		return
+	}
+	if err := h.mgr.CloseSession(id); err != nil {
+		if errors.Is(err, sfu.ErrSessionNotFound) {
+			reject(w, "session", "not_found", err.Error(), http.StatusNotFound)
+			return
+		}
+		reject(w, "session", "internal", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// serveTrickle 把 PATCH 请求体中的客户端候选交给会话连接，并返回服务端候选。
+func (h *HTTPHandlers) serveTrickle(w http.ResponseWriter, r *http.Request, id string) {
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, "application/trickle-ice-sdpfrag") {
+		reject(w, "session", "content_type", "expected application/trickle-ice-sdpfrag", http.StatusUnsupportedMediaType)
+		return
+	}
+	frag, ok := h.readSDP(w, r, "session")
+	if !ok {
+		return
+	}
+	local, err := h.mgr.TrickleICE(id, frag)
+	switch {
+	case errors.Is(err, sfu.ErrSessionNotFound):
+		reject(w, "session", "not_found", err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		reject(w, "session", "bad_candidate", err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/trickle-ice-sdpfrag")
+	_, _ = w.Write([]byte(local))
+}
+
+// ServeWHIPMigrate 处理发布者迁移：POST /api/whip/publish/{room}/migrate
+// 请求体为新发布连接的 SDP Offer，新连接接管现有轨道后旧连接被关闭，观众无需重新协商。
+func (h *HTTPHandlers) ServeWHIPMigrate(w http.ResponseWriter, r *http.Request, room string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		reject(w, "whip_migrate", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "whip_migrate", room) {
+		return
+	}
+	if !h.allowEndpointRate(r, h.publishLimit) {
+		reject(w, "whip_migrate", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "whip_migrate", "origin", "origin not allowed", http.StatusForbidden)
 		return
 	}
 	if !h.authOKRoom(r, room) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		reject(w, "whip_migrate", "unauthorized", "unauthorized", http.StatusUnauthorized)
 		return
 	}
-	defer r.Body.Close()
-	offerSDP, _ := io.ReadAll(r.Body)
-	answer, err := h.mgr.Publish(r.Context(), room, string(offerSDP))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	offerSDP, ok := h.readSDP(w, r, "whip_migrate")
+	if !ok {
+		return
+	}
+	ctx, cancel := h.answerContext(r)
+	defer cancel()
+	answer, err := h.mgr.MigratePublisher(ctx, room, offerSDP)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		reject(w, "whip_migrate", "timeout", "answer timeout", http.StatusGatewayTimeout)
+		return
+	case errors.Is(err, sfu.ErrRoomNotFound):
+		reject(w, "whip_migrate", "room_not_found", err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, sfu.ErrNoPublisher), errors.Is(err, sfu.ErrMultiplePublishers), errors.Is(err, sfu.ErrRoomClosed):
+		reject(w, "whip_migrate", "conflict", err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, sfu.ErrInvalidSDP):
+		reject(w, "whip_migrate", "bad_sdp", err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		reject(w, "whip_migrate", "internal", err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/sdp")
@@ -87,6 +413,9 @@ func (h *HTTPHandlers) ServeWHIPPublish(w http.ResponseWriter, r *http.Request,
 	_, _ = w.Write([]byte(answer))
 }
 
+// maxClientIDLen 是 WHEP 客户端标识（X-Client-ID / ?client_id=）的最大长度。
+const maxClientIDLen = 128
+
 // ServeWHEPPlay 处理 WHEP 播放：POST /api/whep/play/{room}
 // 请求体为 SDP Offer，返回 SDP Answer（201 Created）。
 func (h *HTTPHandlers) ServeWHEPPlay(w http.ResponseWriter, r *http.Request, room string) {
@@ -96,44 +425,260 @@ func (h *HTTPHandlers) ServeWHEPPlay(w http.ResponseWriter, r *http.Request, roo
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		reject(w, "whep", "method", "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if !h.allowRate(r) {
-		http.Error(w, "too many requests", http.StatusTooManyRequests)
+	if !validRoom(w, "whep", room) {
+		return
+	}
+	if !h.allowEndpointRate(r, h.playLimit) {
+		reject(w, "whep", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "whep", "origin", "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.roomAllowed(room) {
+		reject(w, "whep", "room_not_found", "room not found", http.StatusNotFound)
 		return
 	}
 	if !h.authOKRoom(r, room) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		reject(w, "whep", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	offerSDP, ok := h.readSDP(w, r, "whep")
+	if !ok {
+		return
+	}
+	ctx, cancel := h.answerContext(r)
+	defer cancel()
+	resume := r.Header.Get("X-Resume-Token")
+	if resume == "" {
+		resume = r.URL.Query().Get("resume")
+	}
+	client := r.Header.Get("X-Client-ID")
+	if client == "" {
+		client = r.URL.Query().Get("client_id")
+	}
+	if len(client) > maxClientIDLen {
+		reject(w, "whep", "bad_request", "client id too long", http.StatusBadRequest)
+		return
+	}
+	q := r.URL.Query()
+	opts := sfu.SubscribeOptions{ResumeToken: resume, Publisher: q.Get("publisher"), ClientID: client, Exclude: q.Get("exclude")}
+	res, err := h.mgr.SubscribeWith(ctx, room, offerSDP, opts)
+	if errors.Is(err, context.DeadlineExceeded) {
+		reject(w, "whep", "timeout", "answer timeout", http.StatusGatewayTimeout)
+		return
+	}
+	if errors.Is(err, sfu.ErrPublisherNotFound) {
+		reject(w, "whep", "not_found", err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, sfu.ErrRoomClosed) {
+		reject(w, "whep", "conflict", err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, sfu.ErrEgressLimit) || errors.Is(err, sfu.ErrRoomFull) {
+		reject(w, "whep", "capacity", err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, sfu.ErrInvalidSDP) {
+		reject(w, "whep", "bad_sdp", err.Error(), http.StatusBadRequest)
 		return
 	}
-	defer r.Body.Close()
-	offerSDP, _ := io.ReadAll(r.Body)
-	answer, err := h.mgr.Subscribe(r.Context(), room, string(offerSDP))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		reject(w, "whep", "internal", err.Error(), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/api/whep/play/"+room+"/"+h.sessionResource(res.ID))
+	if res.ResumeToken != "" {
+		w.Header().Set("X-Resume-Token", res.ResumeToken)
+	}
 	w.WriteHeader(http.StatusCreated)
-	_, _ = w.Write([]byte(answer))
+	_, _ = w.Write([]byte(res.Answer))
+}
+
+// ServeWHEPKeyframe 处理订阅者主动请求关键帧：POST /api/whep/play/{room}/{id}/pli
+// id 为订阅者 ID，或 WHEP 播放成功后 Location 头中的会话资源名（即请求 {Location}/pli），成功时返回 204。
+func (h *HTTPHandlers) ServeWHEPKeyframe(w http.ResponseWriter, r *http.Request, room, id string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		reject(w, "whep_pli", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "whep_pli", room) {
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "whep_pli", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "whep_pli", "origin", "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.authOKRoom(r, room) {
+		reject(w, "whep_pli", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if sid, ok := h.sessionID(id); ok {
+		id = sid // {Location}/pli：Location 的最后一段是会话资源名
+	}
+	err := h.mgr.RequestKeyframe(room, id)
+	switch {
+	case errors.Is(err, sfu.ErrRoomNotFound), errors.Is(err, sfu.ErrSubscriberNotFound):
+		reject(w, "whep_pli", "not_found", err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, sfu.ErrNoPublisher):
+		reject(w, "whep_pli", "conflict", err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		reject(w, "whep_pli", "internal", err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeWHEPQueue 处理满员房间的等候室：GET /api/whep/play/{room}/queue。
+// 以 Server-Sent Events 推送排队位置（event: queued），出现空位时推送 event: slot 并结束，
+// 观众收到后重新发起 WHEP 请求；房间未满时直接推送 slot。
+func (h *HTTPHandlers) ServeWHEPQueue(w http.ResponseWriter, r *http.Request, room string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodGet {
+		reject(w, "whep_queue", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "whep_queue", room) {
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "whep_queue", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "whep_queue", "origin", "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.roomAllowed(room) {
+		reject(w, "whep_queue", "room_not_found", "room not found", http.StatusNotFound)
+		return
+	}
+	if !h.authOKRoom(r, room) {
+		reject(w, "whep_queue", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		reject(w, "whep_queue", "internal", "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	waiter, err := h.mgr.JoinQueue(room)
+	if err != nil {
+		reject(w, "whep_queue", "queue_full", err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer waiter.Leave()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if waiter.Position > 0 {
+		fmt.Fprintf(w, "event: queued\ndata: {\"position\":%d}\n\n", waiter.Position)
+		flusher.Flush()
+	}
+	// 定期发送注释行保活，避免代理因长时间无数据断开
+	ping := time.NewTicker(15 * time.Second)
+	defer ping.Stop()
+	for {
+		select {
+		case <-waiter.Ready():
+			fmt.Fprint(w, "event: slot\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case <-ping.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Reject 供路由层（cmd/server）拒绝无法分派到具体接口的请求（如路径中的会话 ID 格式错误），
+// 响应与计数方式同接口内部的拒绝。
+func Reject(w http.ResponseWriter, endpoint, reason, msg string, code int) {
+	reject(w, endpoint, reason, msg, code)
+}
+
+// reject 返回错误响应，并按接口与原因计入 webrtc_http_rejections_total，
+// 以便区分鉴权失败、限流、SDP 错误与容量不足等失败的请求。
+func reject(w http.ResponseWriter, endpoint, reason, msg string, code int) {
+	noteRejection(w, endpoint, reason)
+	http.Error(w, msg, code)
+}
+
+// noteRejection 计入 webrtc_http_rejections_total 并把原因记到访问日志，供自行写响应体的接口使用。
+func noteRejection(w http.ResponseWriter, endpoint, reason string) {
+	metrics.IncHTTPRejection(endpoint, reason)
+	if aw, ok := w.(*accessWriter); ok {
+		aw.reason = reason
+	}
+}
+
+// answerContext 为协商过程设置 ANSWER_TIMEOUT 上限，超时后 SFU 会关闭未完成的连接，
+// 处理函数返回 504，客户端可以尽快重试而不是挂起。
+func (h *HTTPHandlers) answerContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if h.cfg.AnswerTimeout > 0 {
+		return context.WithTimeout(r.Context(), h.cfg.AnswerTimeout)
+	}
+	return context.WithCancel(r.Context())
 }
 
 // allowCORS 设置基础跨域响应头，适配示例页面与教学演示。
+// 请求不带 Origin（同源或非浏览器客户端）时不输出任何 CORS 头。
 func (h *HTTPHandlers) allowCORS(w http.ResponseWriter, r *http.Request) {
 	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
 	ao := h.cfg.AllowedOrigin
 	if ao == "*" {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-	} else if origin != "" && (ao == origin || hostMatch(ao, origin)) {
+	} else if ao == origin || hostMatch(ao, origin) {
 		w.Header().Set("Access-Control-Allow-Origin", origin)
 		w.Header().Set("Vary", "Origin")
 	}
-	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Auth-Token")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Auth-Token, X-Resume-Token, X-Client-ID")
+	w.Header().Set("Access-Control-Expose-Headers", "Location, X-Resume-Token")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 }
 
+// originOK 在开启 REQUIRE_ORIGIN 时要求请求携带 ALLOWED_ORIGIN 允许的 Origin 头。
+// CORS 只约束浏览器，此检查可阻止携带 Token 的非浏览器客户端绕过来源限制。
+func (h *HTTPHandlers) originOK(r *http.Request) bool {
+	if !h.cfg.RequireOrigin {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	ao := h.cfg.AllowedOrigin
+	return ao == "*" || ao == origin || hostMatch(ao, origin)
+}
+
 // authOKRoom 校验访问权限：优先房间级 Token，再回退到全局 Token 或 JWT；
 // JWT 可包含 room 声明以限制访问到指定房间。
 func (h *HTTPHandlers) authOKRoom(r *http.Request, room string) bool {
@@ -143,7 +688,16 @@ func (h *HTTPHandlers) authOKRoom(r *http.Request, room string) bool {
 		if tokenMatch(r, tok) {
 			return true
 		}
-		if h.cfg.JWTSecret != "" && jwtOKRoom(r, room, h.cfg.JWTSecret) {
+		if h.jwtEnabled() && h.jwtOKRoom(r, room) {
+			return true
+		}
+		return false
+	}
+	if tok, ok := h.mgr.RoomToken(room); ok {
+		if tokenMatch(r, tok) {
+			return true
+		}
+		if h.jwtEnabled() && h.jwtOKRoom(r, room) {
 			return true
 		}
 		return false
@@ -152,13 +706,13 @@ func (h *HTTPHandlers) authOKRoom(r *http.Request, room string) bool {
 		if tokenMatch(r, h.cfg.AuthToken) {
 			return true
 		}
-		if h.cfg.JWTSecret != "" && jwtOKRoom(r, room, h.cfg.JWTSecret) {
+		if h.jwtEnabled() && h.jwtOKRoom(r, room) {
 			return true
 		}
 		return false
 	}
-	if h.cfg.JWTSecret != "" {
-		if jwtOKRoom(r, room, h.cfg.JWTSecret) {
+	if h.jwtEnabled() {
+		if h.jwtOKRoom(r, room) {
 			return true
 		}
 		return false
@@ -166,7 +720,31 @@ func (h *HTTPHandlers) authOKRoom(r *http.Request, room string) bool {
 	return true
 }
 
-// tokenMatch 从 X-Auth-Token 或 Authorization: Bearer 中读取并比对令牌。
+// authRequired 报告访问房间是否需要凭据，即 authOKRoom 是否会校验请求：房间配置了房间级 Token，
+// 或配置了全局 Token 或 JWT。返回 false 时 authOKRoom 对任何请求都放行。
+func (h *HTTPHandlers) authRequired(room string) bool {
+	if tok := h.cfg.RoomTokens[room]; tok != "" {
+		return true
+	}
+	if _, ok := h.mgr.RoomToken(room); ok {
+		return true
+	}
+	return h.cfg.AuthToken != "" || h.jwtEnabled()
+}
+
+// roomAllowed 在开启 REQUIRE_PROVISIONED_ROOMS 时，只放行 ROOM_TOKENS 中配置或
+// 管理接口预置过的房间，避免任意请求自动创建新房间。
+func (h *HTTPHandlers) roomAllowed(room string) bool {
+	if !h.cfg.RequireProvisionedRooms {
+		return true
+	}
+	if _, ok := h.cfg.RoomTokens[room]; ok {
+		return true
+	}
+	return h.mgr.IsProvisioned(room)
+}
+
+// tokenMatch 从 X-Auth-Token 或 Authorization: Bearer 中读取并比对令牌。
 func tokenMatch(r *http.Request, expect string) bool {
 	if t := r.Header.Get("X-Auth-Token"); t != "" {
 		return t == expect
@@ -178,29 +756,115 @@ func tokenMatch(r *http.Request, expect string) bool {
 	return false
 }
 
-// jwtOKRoom 验证 HMAC JWT 并（可选）校验 claims.room 与目标房间一致。
-// 为简化演示，不强制验证 exp/iat/aud。
-func jwtOKRoom(r *http.Request, room, secret string) bool {
+// jwtOKRoom 验证 JWT 并（可选）校验 claims.room 与目标房间一致。
+func (h *HTTPHandlers) jwtOKRoom(r *http.Request, room string) bool {
+	claims, ok := h.jwtClaims(r)
+	if !ok {
+		return false
+	}
+	if v, ok := claims["room"].(string); ok && v != "" && v != room {
+		return false
+	}
+	return true
+}
+
+// jwtClaims 从 Authorization: Bearer 中解析 JWT（HMAC，或配置公钥后的 RS*/ES*）并返回其 claims。
+// 默认要求 exp 且未过期、nbf（如有）已生效；配置了 JWT_EXPECTED_AUDIENCE 时 aud 须包含该值，
+// 配置了 JWT_EXPECTED_ISSUER 时 iss 须与之相等，防止签发给其他服务的令牌被重放。
+// JWT_SKIP_EXPIRY=1 时不校验时间声明，仅供教学演示。
+func (h *HTTPHandlers) jwtClaims(r *http.Request) (jwt.MapClaims, bool) {
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
-		return false
+		return nil, false
 	}
 	tokenString := strings.TrimSpace(auth[7:])
-	parsed, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrInvalidKeyType
+	opts := []jwt.ParserOption{jwt.WithValidMethods(h.jwtMethods())}
+	if h.cfg.JWTSkipExpiry {
+		opts = append(opts, jwt.WithoutClaimsValidation())
+	} else {
+		opts = append(opts, jwt.WithExpirationRequired())
+		if h.cfg.JWTAudience != "" {
+			opts = append(opts, jwt.WithAudience(h.cfg.JWTAudience))
 		}
-		return []byte(secret), nil
-	})
+		if h.cfg.JWTIssuer != "" {
+			opts = append(opts, jwt.WithIssuer(h.cfg.JWTIssuer))
+		}
+	}
+	parsed, err := jwt.Parse(tokenString, h.jwtKey, opts...)
 	if err != nil || !parsed.Valid {
-		return false
+		return nil, false
 	}
-	if claims, ok := parsed.Claims.(jwt.MapClaims); ok {
-		if v, ok := claims["room"].(string); ok && v != "" && v != room {
-			return false
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, false
+	}
+	if h.cfg.JWTSkipExpiry {
+		// WithoutClaimsValidation 同时跳过了 aud/iss 校验，这里单独检查
+		if h.cfg.JWTAudience != "" {
+			aud, err := claims.GetAudience()
+			if err != nil || !slices.Contains(aud, h.cfg.JWTAudience) {
+				return nil, false
+			}
+		}
+		if h.cfg.JWTIssuer != "" {
+			if iss, err := claims.GetIssuer(); err != nil || iss != h.cfg.JWTIssuer {
+				return nil, false
+			}
 		}
 	}
-	return true
+	return claims, true
+}
+
+// errUnknownKID 表示 JWT 头部的 kid 不在 JWT_SECRETS 中。
+var errUnknownKID = errors.New("unknown jwt kid")
+
+// jwtEnabled 报告是否配置了 JWT 密钥（JWT_SECRET、JWT_SECRETS 或 JWT_PUBLIC_KEY_FILE）。
+func (h *HTTPHandlers) jwtEnabled() bool {
+	return h.cfg.JWTEnabled()
+}
+
+// jwtMethods 返回可接受的签名算法：配置了 HMAC 密钥时接受 HS*，配置了公钥时按密钥类型接受 RS* 或 ES*。
+// 算法与密钥类型一一对应，避免用公钥充当 HMAC 密钥的算法混淆攻击。
+func (h *HTTPHandlers) jwtMethods() []string {
+	var methods []string
+	if h.cfg.JWTSecret != "" || len(h.cfg.JWTSecrets) > 0 {
+		methods = append(methods, "HS256", "HS384", "HS512")
+	}
+	switch h.cfg.JWTPublicKey.(type) {
+	case *rsa.PublicKey:
+		methods = append(methods, "RS256", "RS384", "RS512")
+	case *ecdsa.PublicKey:
+		methods = append(methods, "ES256", "ES384", "ES512")
+	}
+	return methods
+}
+
+// jwtKey 选择验证 JWT 签名的密钥：RS*/ES* 令牌使用 JWT_PUBLIC_KEY_FILE 的公钥；HMAC 令牌头部带 kid 时
+// 只用 JWT_SECRETS 中对应的密钥，否则依次尝试 JWT_SECRET 与 JWT_SECRETS 中的全部密钥，便于轮换期间新旧令牌并存。
+func (h *HTTPHandlers) jwtKey(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		return h.cfg.JWTPublicKey, nil
+	}
+	if kid, _ := t.Header["kid"].(string); kid != "" {
+		secret, ok := h.cfg.JWTSecrets[kid]
+		if !ok {
+			return nil, errUnknownKID
+		}
+		return []byte(secret), nil
+	}
+	var keys jwt.VerificationKeySet
+	if h.cfg.JWTSecret != "" {
+		keys.Keys = append(keys.Keys, []byte(h.cfg.JWTSecret))
+	}
+	kids := make([]string, 0, len(h.cfg.JWTSecrets))
+	for kid := range h.cfg.JWTSecrets {
+		kids = append(kids, kid)
+	}
+	slices.Sort(kids)
+	for _, kid := range kids {
+		keys.Keys = append(keys.Keys, []byte(h.cfg.JWTSecrets[kid]))
+	}
+	return keys, nil
 }
 
 // hostMatch 简单比对来源主机名是否与配置相符。
@@ -219,7 +883,40 @@ func hostMatch(expect, origin string) bool {
 	return host == expect || origin == expect
 }
 
-// ServeRecordsList 列出 RECORD_DIR 下的 ivf/ogg 文件并返回元数据。
+// ServeRoot 处理根路径 "/"：按 ROOT_MODE 跳转到示例页面、返回 JSON 服务描述或 404，
+// 便于关闭网页或部署在反向代理路径前缀之后的场景。
+func (h *HTTPHandlers) ServeRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		h.serveNotFound(w, r)
+		return
+	}
+	switch h.cfg.RootMode {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"service": "live-webrtc-go",
+			"endpoints": map[string]string{
+				"publish": "/api/whip/publish/{room}",
+				"play":    "/api/whep/play/{room}",
+				"rooms":   "/api/rooms",
+				"records": "/api/records",
+				"health":  "/healthz",
+				"metrics": "/metrics",
+			},
+		})
+	case "404":
+		h.serveNotFound(w, r)
+	default:
+		target := h.cfg.RootRedirect
+		if target == "" {
+			target = "/web/index.html"
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	}
+}
+
+// ServeRecordsList 列出 RECORD_DIR 下的录制文件（ivf/ogg/webm/h264/mp4）并返回元数据；
+// 带 ?meta=1 时附带录制旁路 JSON 中的统计信息（若存在）。
 func (h *HTTPHandlers) ServeRecordsList(w http.ResponseWriter, r *http.Request) {
 	// 查询本地录制目录，将 IVF/OGG 文件以 JSON 返回
 	h.allowCORS(w, r)
@@ -227,52 +924,151 @@ func (h *HTTPHandlers) ServeRecordsList(w http.ResponseWriter, r *http.Request)
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	if !isGet(r) {
+		reject(w, "records", "method", "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 	if !h.allowRate(r) {
-		http.Error(w, "too many requests", http.StatusTooManyRequests)
+		reject(w, "records", "rate_limited", "too many requests", http.StatusTooManyRequests)
 		return
 	}
 	dir := h.cfg.RecordDir
 	entries, err := os.ReadDir(dir)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		reject(w, "records", "internal", err.Error(), http.StatusInternalServerError)
 		return
 	}
 	type rec struct {
-		Name    string `json:"name"`
-		Size    int64  `json:"size"`
-		ModTime string `json:"modTime"`
-		URL     string `json:"url"`
+		Name      string              `json:"name"`
+		Size      int64               `json:"size"`
+		ModTime   string              `json:"modTime"`
+		URL       string              `json:"url"`
+		Meta      *sfu.RecordingStats `json:"meta,omitempty"`
+		Recording string              `json:"recording,omitempty"` // 分段录制所属的录制（去掉 _seg{n} 的文件名）
+		Segment   int                 `json:"segment,omitempty"`   // 段序号，非分段文件为 0
 	}
+	withMeta := r.URL.Query().Get("meta") == "1"
 	var list []rec
 	for _, e := range entries {
 		if e.IsDir() {
 			continue
 		}
 		name := e.Name()
-		ext := strings.ToLower(filepath.Ext(name))
-		if ext != ".ivf" && ext != ".ogg" {
+		if !sfu.IsRecordingName(name) {
 			continue
 		}
 		fi, err := e.Info()
 		if err != nil {
 			continue
 		}
-		list = append(list, rec{
+		item := rec{
 			Name:    name,
 			Size:    fi.Size(),
 			ModTime: fi.ModTime().UTC().Format(time.RFC3339),
 			URL:     "/records/" + name,
-		})
+		}
+		if recording, n := sfu.ParseSegmentName(name); n > 0 {
+			item.Recording, item.Segment = recording, n
+		}
+		if withMeta {
+			if st, err := sfu.ReadSidecar(filepath.Join(dir, name)); err == nil {
+				item.Meta = st
+			}
+		}
+		list = append(list, item)
+	}
+	// 同一录制的各段相邻排列并按段序号排序（seg10 排在 seg9 之后）
+	sort.SliceStable(list, func(i, j int) bool {
+		ki, kj := list[i].Name, list[j].Name
+		if list[i].Segment > 0 {
+			ki = list[i].Recording
+		}
+		if list[j].Segment > 0 {
+			kj = list[j].Recording
+		}
+		if ki != kj {
+			return ki < kj
+		}
+		return list[i].Segment < list[j].Segment
+	})
+	if wantsCSV(r) {
+		header := []string{"name", "size", "mod_time", "url"}
+		if withMeta {
+			header = append(header, "codec", "bytes", "packets", "peak_bitrate_bps")
+		}
+		rows := [][]string{header}
+		for _, item := range list {
+			row := []string{item.Name, strconv.FormatInt(item.Size, 10), item.ModTime, item.URL}
+			if withMeta {
+				if m := item.Meta; m != nil {
+					row = append(row, m.Codec, strconv.FormatInt(m.Bytes, 10), strconv.FormatInt(m.Packets, 10),
+						strconv.FormatFloat(m.PeakBitrateBps, 'f', 0, 64))
+				} else {
+					row = append(row, "", "", "", "")
+				}
+			}
+			rows = append(rows, row)
+		}
+		writeCSV(w, rows)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(list)
 }
 
-// ServeAdminCloseRoom 管理接口：关闭指定房间，释放资源并返回 200。
+// wantsCSV 按 Accept 头中先出现的受支持类型选择输出格式：text/csv 返回 true，
+// application/json、未携带 Accept 或都不匹配时默认输出 JSON。
+func wantsCSV(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(part, ";")
+		switch strings.ToLower(strings.TrimSpace(mt)) {
+		case "text/csv":
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
+
+// writeCSV 以 text/csv 输出表格，首行为列名。
+func writeCSV(w http.ResponseWriter, rows [][]string) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	_ = cw.WriteAll(rows)
+}
+
+// ServeRecord 管理接口：DELETE /api/records/{name} 删除 RECORD_DIR 下的录制文件（及其旁路 JSON），
+// 成功返回 204；文件名不合法返回 400，不存在返回 404，仍在录制中返回 409。
+func (h *HTTPHandlers) ServeRecord(w http.ResponseWriter, r *http.Request, name string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodDelete {
+		reject(w, "record_delete", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.adminWriteOK(w, r, "record_delete") {
+		return
+	}
+	switch err := h.mgr.DeleteRecording(name); {
+	case errors.Is(err, sfu.ErrInvalidRecordingName):
+		reject(w, "record_delete", "bad_request", err.Error(), http.StatusBadRequest)
+	case errors.Is(err, sfu.ErrRecordingNotFound):
+		reject(w, "record_delete", "not_found", err.Error(), http.StatusNotFound)
+	case errors.Is(err, sfu.ErrRecordingActive):
+		reject(w, "record_delete", "conflict", err.Error(), http.StatusConflict)
+	case err != nil:
+		reject(w, "record_delete", "internal", err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ServeAdminCloseRoom 管理接口：关闭指定房间，释放资源并返回 200。带 ?grace=2s 时先向订阅者发送
+// RTCP BYE 并等待该时长再断开（?grace 不带值时为 defaultCloseGrace），响应在拆除完成后返回。
 func (h *HTTPHandlers) ServeAdminCloseRoom(w http.ResponseWriter, r *http.Request, room string) {
 	h.allowCORS(w, r)
 	if r.Method == http.MethodOptions {
@@ -280,72 +1076,487 @@ func (h *HTTPHandlers) ServeAdminCloseRoom(w http.ResponseWriter, r *http.Reques
 		return
 	}
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		reject(w, "admin_close", "method", "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	if !h.adminOK(r) {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	if !validRoom(w, "admin_close", room) {
 		return
 	}
-	ok := h.mgr.CloseRoom(room)
+	if !h.adminWriteOK(w, r, "admin_close") {
+		return
+	}
+	var grace time.Duration
+	if q := r.URL.Query(); q.Has("grace") {
+		grace = defaultCloseGrace
+		if v := q.Get("grace"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 || d > maxCloseGrace {
+				reject(w, "admin_close", "bad_request", "invalid grace", http.StatusBadRequest)
+				return
+			}
+			grace = d
+		}
+	}
+	ok := h.mgr.CloseRoomGraceful(room, grace)
 	if !ok {
-		http.Error(w, "not found", http.StatusNotFound)
+		reject(w, "admin_close", "not_found", "not found", http.StatusNotFound)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 }
 
+// defaultCloseGrace 是管理接口关闭房间时 ?grace 不带值的默认宽限期，maxCloseGrace 为允许的上限。
+const (
+	defaultCloseGrace = 2 * time.Second
+	maxCloseGrace     = time.Minute
+)
+
+// ServeAdminRelayRoom 管理接口：把房间当前轨道级联推送到另一个 SFU（POST /api/admin/rooms/{room}/relay）。
+// 请求体为 JSON：{"server": "https://sfu-2:8080", "room": "demo", "token": "..."}，room 为空时沿用本房间名。
+func (h *HTTPHandlers) ServeAdminRelayRoom(w http.ResponseWriter, r *http.Request, room string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPost {
+		reject(w, "admin_relay", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "admin_relay", room) {
+		return
+	}
+	if !h.adminWriteOK(w, r, "admin_relay") {
+		return
+	}
+	var req struct {
+		Server string `json:"server"`
+		Room   string `json:"room"`
+		Token  string `json:"token"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reject(w, "admin_relay", "bad_request", "invalid json", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(req.Server, "http://") && !strings.HasPrefix(req.Server, "https://") {
+		reject(w, "admin_relay", "bad_request", "invalid server", http.StatusBadRequest)
+		return
+	}
+	if req.Room == "" {
+		req.Room = room
+	}
+	target := strings.TrimRight(req.Server, "/") + "/api/whip/publish/" + url.PathEscape(req.Room)
+	err := h.mgr.StartRelay(r.Context(), room, target, req.Token)
+	switch {
+	case errors.Is(err, sfu.ErrRoomNotFound):
+		reject(w, "admin_relay", "not_found", err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, sfu.ErrNoPublisher), errors.Is(err, sfu.ErrRelayExists):
+		reject(w, "admin_relay", "conflict", err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		reject(w, "admin_relay", "upstream", err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]string{"target": target})
+}
+
+// ServeAdminProvisionRoom 管理接口：预置房间 Token 与元数据（PUT /api/admin/rooms/{room}）。
+// 请求体为 JSON：{"token": "...", "metadata": {"k": "v"}}；配置 ROOM_STATE_FILE 后可跨重启保留。
+func (h *HTTPHandlers) ServeAdminProvisionRoom(w http.ResponseWriter, r *http.Request, room string) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Method != http.MethodPut {
+		reject(w, "admin_provision", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "admin_provision", room) {
+		return
+	}
+	if !h.adminWriteOK(w, r, "admin_provision") {
+		return
+	}
+	var req struct {
+		Token    string            `json:"token"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		reject(w, "admin_provision", "bad_request", "invalid json", http.StatusBadRequest)
+		return
+	}
+	if f, ok := req.Metadata[sfu.MetaRecordFormat]; ok && !config.ValidRecordFormat(f) {
+		reject(w, "admin_provision", "bad_request", "invalid record_format", http.StatusBadRequest)
+		return
+	}
+	st := h.mgr.ProvisionRoom(room, req.Token, req.Metadata)
+	st.Token = "" // 不回显 Token
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(st)
+}
+
+// ServeAdminUploads 管理接口：列出排队/上传中的录制文件及最近的上传失败（GET /api/admin/uploads）。
+func (h *HTTPHandlers) ServeAdminUploads(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "admin_uploads", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.adminReadOK(r) {
+		reject(w, "admin_uploads", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(uploader.Jobs())
+}
+
+// debugState 是 GET /api/admin/debug/state 的响应体。
+type debugState struct {
+	Time       time.Time                   `json:"time"`
+	Goroutines int                         `json:"goroutines"`
+	Rooms      []sfu.RoomDetail            `json:"rooms"`
+	Metrics    map[string][]metrics.Sample `json:"metrics"`
+	Config     config.Config               `json:"config"` // 密钥类配置已脱敏
+}
+
+// ServeAdminDebugState 管理接口：把全部房间（发布者、订阅者、轨道与编码）、指标快照、
+// goroutine 数量与脱敏后的配置导出为一个 JSON 文档，便于附在问题报告中（GET /api/admin/debug/state）。
+func (h *HTTPHandlers) ServeAdminDebugState(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "admin_debug", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.adminReadOK(r) {
+		reject(w, "admin_debug", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(debugState{
+		Time:       time.Now(),
+		Goroutines: runtime.NumGoroutine(),
+		Rooms:      h.mgr.RoomDetails(),
+		Metrics:    metrics.Snapshot(),
+		Config:     h.cfg.Redacted(),
+	})
+}
+
+// defaultMaxSDPBytes 是未配置 MAX_SDP_BYTES 时 SDP 请求体的上限。
+const defaultMaxSDPBytes = 256 << 10
+
+// readSDP 读取 SDP（或 sdpfrag）请求体，长度受 MAX_SDP_BYTES 限制。超限时返回 413、
+// 读取失败时返回 400，并报告 ok=false，避免把截断的 SDP 交给协商流程。
+func (h *HTTPHandlers) readSDP(w http.ResponseWriter, r *http.Request, endpoint string) (string, bool) {
+	limit := int64(defaultMaxSDPBytes)
+	if h.cfg.MaxSDPBytes > 0 {
+		limit = int64(h.cfg.MaxSDPBytes)
+	}
+	defer r.Body.Close()
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		reject(w, endpoint, "too_large", "request body too large", http.StatusRequestEntityTooLarge)
+		return "", false
+	case err != nil:
+		reject(w, endpoint, "bad_request", "read body: "+err.Error(), http.StatusBadRequest)
+		return "", false
+	}
+	return string(b), true
+}
+
+// validRoom 按 config.ValidateRoomName 校验路径中的房间名，不合法时返回 400 并报告 false。
+// 在处理函数内校验，直接调用处理函数（如测试）时也不会绕过。
+func validRoom(w http.ResponseWriter, endpoint, room string) bool {
+	if err := config.ValidateRoomName(room); err != nil {
+		reject(w, endpoint, "bad_request", "invalid room: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 // allowRate 根据请求 IP 进行限流，避免单个客户端耗尽资源。
 func (h *HTTPHandlers) allowRate(r *http.Request) bool {
-	if h.limiter == nil || h.cfg.RateLimitRPS <= 0 {
-		return true
+	return h.allowKey(&h.limit, r)
+}
+
+// allowEndpointRate 使用端点专属的限流器 l（独立的 per-IP 令牌桶表），未配置时回退到全局限流。
+func (h *HTTPHandlers) allowEndpointRate(r *http.Request, l *ipLimiter) bool {
+	if l == nil {
+		return h.allowRate(r)
 	}
-	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	return h.allowKey(l, r)
+}
+
+// unknownClientKey 是无法识别客户端 IP（如 Unix socket 连接）的请求共用的令牌桶键，
+// 不会与任何 IP 冲突。
+const unknownClientKey = "unknown"
+
+// allowKey 以客户端 IP 为键在 l 中限流。无法识别客户端时按 RATE_LIMIT_UNKNOWN_CLIENT 处理：
+// shared（默认）共用 unknownClientKey 令牌桶，skip 不限流。
+func (h *HTTPHandlers) allowKey(l *ipLimiter, r *http.Request) bool {
+	host := h.clientHost(r)
 	if host == "" {
-		host = r.RemoteAddr
+		if h.cfg != nil && h.cfg.RateLimitUnknownClient == config.UnknownClientSkip {
+			return true
+		}
+		host = unknownClientKey
 	}
-	h.mu.Lock()
-	limiter, ok := h.limiter[host]
-	if !ok {
-		burst := h.cfg.RateLimitBurst
-		if burst <= 0 {
-			burst = 1
+	return l.allow(host)
+}
+
+// ReloadRateLimit 在运行时替换全局限流参数。整个限流器 map 在同一把锁下整体替换，
+// 并发的 allowRate 只会看到旧表或新表；已有客户端的令牌桶随之重置。rps<=0 关闭限流。
+func (h *HTTPHandlers) ReloadRateLimit(rps float64, burst int) {
+	h.limit.reload(rps, burst)
+}
+
+// defaultTrustedProxies 是未配置 TRUSTED_PROXIES 时的可信代理网段：回环与私有地址。
+var defaultTrustedProxies = func() []*net.IPNet {
+	var out []*net.IPNet
+	for _, s := range []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "::1/128", "fc00::/7"} {
+		_, n, _ := net.ParseCIDR(s)
+		out = append(out, n)
+	}
+	return out
+}()
+
+// clientHost 返回用于限流的客户端 IP，见 clientIP。
+func (h *HTTPHandlers) clientHost(r *http.Request) string {
+	if h.cfg == nil || !h.cfg.TrustProxyHeaders {
+		return clientIP(r, nil)
+	}
+	trusted := h.cfg.TrustedProxies
+	if len(trusted) == 0 {
+		trusted = defaultTrustedProxies
+	}
+	return clientIP(r, trusted)
+}
+
+// clientIP 识别请求的客户端 IP。只有 RemoteAddr 落在 trusted 网段内（来自可信代理）时才采信代理头：
+// 从右向左遍历 X-Forwarded-For，跳过可信代理，返回第一个不可信的地址；客户端自带的最左侧条目
+// 无法冒充这一位置。全部为可信代理时取最左侧的一跳；遇到无效条目即停止。没有可用的
+// X-Forwarded-For 时取 X-Real-IP，都无效时回退到 RemoteAddr。trusted 为空时只看 RemoteAddr。
+// RemoteAddr 可以是 host:port 或不带端口的 IP（含 [IPv6]）；Unix socket 连接的 RemoteAddr
+// 为空或 "@"，此时无法识别客户端，返回空串。
+func clientIP(r *http.Request, trusted []*net.IPNet) string {
+	remote := remoteIP(r.RemoteAddr)
+	if len(trusted) == 0 || !inNets(net.ParseIP(remote), trusted) {
+		return remote
+	}
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	addr := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break
 		}
-		limiter = rate.NewLimiter(rate.Limit(h.cfg.RateLimitRPS), burst)
-		h.limiter[host] = limiter
+		if !inNets(ip, trusted) {
+			return ip.String()
+		}
+		addr = ip.String()
+	}
+	if addr != "" {
+		return addr
 	}
-	h.mu.Unlock()
-	return limiter.Allow()
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return remote
 }
 
-// adminOK 校验管理接口调用方，默认使用 ADMIN_TOKEN，也支持 JWT 指定管理员角色。
-func (h *HTTPHandlers) adminOK(r *http.Request) bool {
-	if h.cfg.AdminToken != "" && tokenMatch(r, h.cfg.AdminToken) {
+// remoteIP 从 RemoteAddr 中取出 IP，无法识别时返回空串。
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		return host
+	}
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
+
+// inNets 报告 ip 是否落在 nets 中的任一网段内。
+func inNets(ip net.IP, nets []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	limiterIdleTTL    = 10 * time.Minute // 令牌桶闲置超过该时长即被回收
+	limiterSweepEvery = time.Minute      // 回收闲置令牌桶的周期
+)
+
+// evictIdleLimiters 周期性回收各限流器中闲置的令牌桶，避免 NAT 后频繁变化的地址或扫描流量
+// 让 per-IP 表无限增长。运行到 Close 为止。
+func (h *HTTPHandlers) evictIdleLimiters() {
+	t := time.NewTicker(limiterSweepEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-t.C:
+		}
+		h.limit.evictIdle(limiterIdleTTL)
+		h.publishLimit.evictIdle(limiterIdleTTL)
+		h.playLimit.evictIdle(limiterIdleTTL)
+	}
+}
+
+// ipLimiter 是按客户端 IP 划分的一组令牌桶。
+type ipLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*ipBucket // 为 nil 表示不限流，与 rps/burst 一起受 mu 保护
+	rps     float64
+	burst   int
+	now     func() time.Time // 时钟，nil 时使用 time.Now；测试可替换
+}
+
+// ipBucket 是单个客户端的令牌桶及其最近一次访问时间。
+type ipBucket struct {
+	lim  *rate.Limiter
+	last time.Time
+}
+
+// newIPLimiter 创建独立的限流器；rps<=0 时返回 nil，表示未单独配置。
+func newIPLimiter(rps float64, burst int) *ipLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	l := &ipLimiter{}
+	l.reload(rps, burst)
+	return l
+}
+
+func (l *ipLimiter) allow(host string) bool {
+	l.mu.Lock()
+	if l.buckets == nil {
+		l.mu.Unlock()
 		return true
 	}
-	if h.cfg.JWTSecret != "" && jwtAdmin(r, h.cfg.JWTSecret) {
+	b, ok := l.buckets[host]
+	if !ok {
+		b = &ipBucket{lim: rate.NewLimiter(rate.Limit(l.rps), l.burst)}
+		l.buckets[host] = b
+	}
+	b.last = l.clock()
+	l.mu.Unlock()
+	return b.lim.Allow()
+}
+
+// evictIdle 移除最近 idle 时长内未被访问的令牌桶；l 为 nil 时不做任何事。
+func (l *ipLimiter) evictIdle(idle time.Duration) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := l.clock().Add(-idle)
+	for host, b := range l.buckets {
+		if b.last.Before(cutoff) {
+			delete(l.buckets, host)
+		}
+	}
+}
+
+// clock 返回当前时间，调用方需持有 l.mu。
+func (l *ipLimiter) clock() time.Time {
+	if l.now != nil {
+		return l.now()
+	}
+	return time.Now()
+}
+
+func (l *ipLimiter) reload(rps float64, burst int) {
+	var buckets map[string]*ipBucket
+	if rps > 0 {
+		buckets = make(map[string]*ipBucket)
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	l.mu.Lock()
+	l.buckets = buckets
+	l.rps = rps
+	l.burst = burst
+	l.mu.Unlock()
+}
+
+// adminReadOK 校验只读管理接口（上传队列、调试状态）的调用方：管理员或只读管理员
+// （VIEWER_ADMIN_TOKEN 或 JWT role=viewer）均可访问。
+func (h *HTTPHandlers) adminReadOK(r *http.Request) bool {
+	return h.adminOK(r) || h.viewerOK(r)
+}
+
+// adminWriteOK 校验会改变服务状态的管理接口（关闭房间、转推、预置房间、删除录制），
+// 未通过时写入拒绝响应：只读管理员返回 403，其余返回 401。
+func (h *HTTPHandlers) adminWriteOK(w http.ResponseWriter, r *http.Request, endpoint string) bool {
+	if h.adminOK(r) {
 		return true
 	}
+	if h.viewerOK(r) {
+		reject(w, endpoint, "forbidden", "read-only admin", http.StatusForbidden)
+		return false
+	}
+	reject(w, endpoint, "unauthorized", "unauthorized", http.StatusUnauthorized)
 	return false
 }
 
-// jwtAdmin 验证 HMAC JWT 并判断是否具备管理员权限（role=admin 或 admin=true/1）。
-func jwtAdmin(r *http.Request, secret string) bool {
-	auth := r.Header.Get("Authorization")
-	if !strings.HasPrefix(strings.ToLower(auth), "bearer ") {
+// viewerOK 判断调用方是否为只读管理员（VIEWER_ADMIN_TOKEN 或 JWT role=viewer）。
+func (h *HTTPHandlers) viewerOK(r *http.Request) bool {
+	if h.cfg.ViewerAdminToken != "" && tokenMatch(r, h.cfg.ViewerAdminToken) {
+		return true
+	}
+	if !h.jwtEnabled() {
 		return false
 	}
-	tokenString := strings.TrimSpace(auth[7:])
-	parsed, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, jwt.ErrInvalidKeyType
-		}
-		return []byte(secret), nil
-	})
-	if err != nil || !parsed.Valid {
+	claims, ok := h.jwtClaims(r)
+	if !ok {
 		return false
 	}
-	claims, ok := parsed.Claims.(jwt.MapClaims)
+	role, _ := claims["role"].(string)
+	return strings.EqualFold(role, "viewer")
+}
+
+// adminOK 校验管理接口调用方，默认使用 ADMIN_TOKEN，也支持 JWT 指定管理员角色。
+func (h *HTTPHandlers) adminOK(r *http.Request) bool {
+	if h.cfg.AdminToken != "" && tokenMatch(r, h.cfg.AdminToken) {
+		return true
+	}
+	if h.jwtEnabled() && h.jwtAdmin(r) {
+		return true
+	}
+	return false
+}
+
+// jwtAdmin 验证 JWT 并判断是否具备管理员权限（role=admin 或 admin=true/1）。
+func (h *HTTPHandlers) jwtAdmin(r *http.Request) bool {
+	claims, ok := h.jwtClaims(r)
 	if !ok {
 		return false
 	}
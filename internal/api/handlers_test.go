@@ -2,14 +2,32 @@
 
 import (
 	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/net/websocket"
 	"live-webrtc-go/internal/config"
+	"live-webrtc-go/internal/metrics"
 	"live-webrtc-go/internal/sfu"
 )
 
@@ -89,6 +107,31 @@ func TestServeRooms_OptionsMethod(t *testing.T) {
 	}
 }
 
+func TestServeGetEndpoints_Head(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.RecordDir = t.TempDir()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/rooms", h.ServeRooms)
+	mux.HandleFunc("/api/records", h.ServeRecordsList)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, path := range []string{"/api/rooms", "/api/records"} {
+		resp, err := http.Head(srv.URL + path)
+		if err != nil {
+			t.Fatalf("HEAD %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("HEAD %s: expected status 200, got %d", path, resp.StatusCode)
+		}
+		if resp.Header.Get("Content-Type") != "application/json" || len(body) != 0 {
+			t.Errorf("HEAD %s: expected JSON headers and no body, got %q %q", path, resp.Header.Get("Content-Type"), body)
+		}
+	}
+}
+
 func TestServeRooms_InvalidMethod(t *testing.T) {
 	h, _ := setupTestHandlers()
 	
@@ -221,6 +264,11 @@ func TestServeRecordsList_Success(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to create test file: %v", err)
 	}
+	for _, other := range []string{"notes.txt", "camera.h264"} {
+		if err := os.WriteFile(tempDir+"/"+other, []byte("x"), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
 	
 	req := httptest.NewRequest("GET", "/api/records", nil)
 	w := httptest.NewRecorder()
@@ -238,15 +286,79 @@ func TestServeRecordsList_Success(t *testing.T) {
 		t.Errorf("Failed to decode response: %v", err)
 	}
 	
-	if len(records) != 1 {
-		t.Errorf("Expected 1 record, got %d", len(records))
+	if len(records) != 2 {
+		t.Fatalf("Expected ivf and h264 records, got %d", len(records))
 	}
 	
-	if records[0]["name"] != testFile {
+	if records[0]["name"] != "camera.h264" || records[1]["name"] != testFile {
 		t.Errorf("Expected record name to be %s, got %v", testFile, records[0]["name"])
 	}
 }
 
+func TestServeRecordsList_WithMeta(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	tempDir := t.TempDir()
+	cfg.RecordDir = tempDir
+	
+	if err := os.WriteFile(tempDir+"/demo.ogg", []byte("ogg"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	sidecar := `{"room":"demo","codec":"audio/opus","bytes":42,"packets":3}`
+	if err := os.WriteFile(tempDir+"/demo.json", []byte(sidecar), 0644); err != nil {
+		t.Fatalf("Failed to create sidecar: %v", err)
+	}
+	
+	req := httptest.NewRequest("GET", "/api/records?meta=1", nil)
+	w := httptest.NewRecorder()
+	h.ServeRecordsList(w, req)
+	
+	var records []map[string]interface{}
+	if err := json.NewDecoder(w.Result().Body).Decode(&records); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("Expected sidecar to be hidden from list, got %d records", len(records))
+	}
+	meta, ok := records[0]["meta"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected meta in record, got %v", records[0])
+	}
+	if meta["codec"] != "audio/opus" || meta["bytes"] != float64(42) {
+		t.Errorf("Unexpected meta: %v", meta)
+	}
+}
+
+func TestServeRecordsList_Segments(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.RecordDir = t.TempDir()
+	for _, name := range []string{"demo_cam_1_seg10.ivf", "demo_cam_1_seg2.ivf", "demo_cam_1_seg1.ivf", "demo_mic_1.ogg", "alpha.ivf"} {
+		if err := os.WriteFile(filepath.Join(cfg.RecordDir, name), []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w := httptest.NewRecorder()
+	h.ServeRecordsList(w, httptest.NewRequest("GET", "/api/records", nil))
+	var records []struct {
+		Name      string `json:"name"`
+		Recording string `json:"recording"`
+		Segment   int    `json:"segment"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&records); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Name)
+	}
+	want := []string{"alpha.ivf", "demo_cam_1_seg1.ivf", "demo_cam_1_seg2.ivf", "demo_cam_1_seg10.ivf", "demo_mic_1.ogg"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("Expected segments listed in order %v, got %v", want, names)
+	}
+	if records[3].Recording != "demo_cam_1.ivf" || records[3].Segment != 10 || records[0].Segment != 0 {
+		t.Errorf("Unexpected segment fields: %+v", records)
+	}
+}
+
 func TestServeRecordsList_InvalidMethod(t *testing.T) {
 	h, _ := setupTestHandlers()
 	
@@ -304,6 +416,243 @@ func TestServeAdminCloseRoom_NoAuth(t *testing.T) {
 	}
 }
 
+func TestServeAdminUploads(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin-token"
+
+	w := httptest.NewRecorder()
+	h.ServeAdminUploads(w, httptest.NewRequest("GET", "/api/admin/uploads", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without admin token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/uploads", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w = httptest.NewRecorder()
+	h.ServeAdminUploads(w, req)
+	if w.Code != http.StatusOK || strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("Expected 200 with empty list, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestServeAdminCloseRoom_Grace(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin-token"
+	fm := &fakeManager{}
+	h := NewHTTPHandlers(fm, cfg)
+
+	for _, tc := range []struct {
+		query string
+		code  int
+	}{{"", http.StatusOK}, {"?grace", http.StatusOK}, {"?grace=500ms", http.StatusOK}, {"?grace=soon", http.StatusBadRequest}, {"?grace=1h", http.StatusBadRequest}} {
+		req := httptest.NewRequest("POST", "/api/admin/rooms/demo/close"+tc.query, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		w := httptest.NewRecorder()
+		h.ServeAdminCloseRoom(w, req, "demo")
+		if w.Code != tc.code {
+			t.Errorf("%q: expected %d, got %d", tc.query, tc.code, w.Code)
+		}
+	}
+	want := []time.Duration{0, defaultCloseGrace, 500 * time.Millisecond}
+	if fmt.Sprint(fm.grace) != fmt.Sprint(want) {
+		t.Errorf("Expected grace periods %v, got %v", want, fm.grace)
+	}
+}
+
+func TestServeRecord_Delete(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin-token"
+	cfg.RecordDir = t.TempDir()
+	if err := os.WriteFile(cfg.RecordDir+"/demo_1.ogg", []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	del := func(name, token string) int {
+		req := httptest.NewRequest("DELETE", "/api/records/"+name, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeRecord(w, req, name)
+		return w.Code
+	}
+	if code := del("demo_1.ogg", ""); code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without admin token, got %d", code)
+	}
+	if code := del("demo_1.ogg", "admin-token"); code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", code)
+	}
+	if code := del("demo_1.ogg", "admin-token"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 after delete, got %d", code)
+	}
+	if code := del("../secret.ogg", "admin-token"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for path traversal, got %d", code)
+	}
+
+	h = NewHTTPHandlers(&fakeManager{}, cfg)
+	if code := del("live.ivf", "admin-token"); code != http.StatusConflict {
+		t.Errorf("Expected 409 for recording in progress, got %d", code)
+	}
+}
+
+func TestServeWHEPKeyframe_UnknownSubscriber(t *testing.T) {
+	h, _ := setupTestHandlers()
+	
+	req := httptest.NewRequest("POST", "/api/whep/play/test-room/abc/pli", nil)
+	w := httptest.NewRecorder()
+	
+	h.ServeWHEPKeyframe(w, req, "test-room", "abc")
+	
+	resp := w.Result()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeWHEPKeyframe_InvalidMethod(t *testing.T) {
+	h, _ := setupTestHandlers()
+	
+	req := httptest.NewRequest("GET", "/api/whep/play/test-room/abc/pli", nil)
+	w := httptest.NewRecorder()
+	
+	h.ServeWHEPKeyframe(w, req, "test-room", "abc")
+	
+	resp := w.Result()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405, got %d", resp.StatusCode)
+	}
+}
+
+func TestServeAdminProvisionRoom_TokenRequired(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin-token"
+	
+	body := `{"token":"room-secret","metadata":{"title":"demo"}}`
+	req := httptest.NewRequest("PUT", "/api/admin/rooms/provisioned", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	
+	h.ServeAdminProvisionRoom(w, req, "provisioned")
+	
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Result().StatusCode)
+	}
+	if strings.Contains(w.Body.String(), "room-secret") {
+		t.Error("Expected token not to be echoed in response")
+	}
+	
+	// 预置 Token 后，未携带 Token 的推流请求应被拒绝
+	req = httptest.NewRequest("POST", "/api/whip/publish/provisioned", strings.NewReader("v=0"))
+	w = httptest.NewRecorder()
+	h.ServeWHIPPublish(w, req, "provisioned")
+	if w.Result().StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401, got %d", w.Result().StatusCode)
+	}
+}
+
+func TestServeWHIPPublish_RequireProvisionedRooms(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.RequireProvisionedRooms = true
+	cfg.RoomTokens["configured"] = "tok"
+	
+	req := httptest.NewRequest("POST", "/api/whip/publish/unknown", strings.NewReader("v=0"))
+	w := httptest.NewRecorder()
+	h.ServeWHIPPublish(w, req, "unknown")
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unprovisioned room, got %d", w.Result().StatusCode)
+	}
+	if n := len(h.mgr.ListRooms()); n != 0 {
+		t.Errorf("Expected no room to be created, got %d", n)
+	}
+	
+	req = httptest.NewRequest("POST", "/api/whep/play/configured", strings.NewReader("v=0"))
+	w = httptest.NewRecorder()
+	h.ServeWHEPPlay(w, req, "configured")
+	if w.Result().StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected configured room to reach auth check (401), got %d", w.Result().StatusCode)
+	}
+	
+	h.mgr.ProvisionRoom("admin-created", "", nil)
+	req = httptest.NewRequest("POST", "/api/whep/play/admin-created", strings.NewReader("invalid"))
+	w = httptest.NewRecorder()
+	h.ServeWHEPPlay(w, req, "admin-created")
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected provisioned room to reach SFU (400 bad SDP), got %d", w.Result().StatusCode)
+	}
+}
+
+func TestServeRoot_Modes(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	
+	tests := []struct {
+		mode     string
+		redirect string
+		status   int
+		location string
+	}{
+		{mode: "", status: http.StatusFound, location: "/web/index.html"},
+		{mode: "redirect", redirect: "/live/web/index.html", status: http.StatusFound, location: "/live/web/index.html"},
+		{mode: "json", status: http.StatusOK},
+		{mode: "404", status: http.StatusNotFound},
+	}
+	
+	for _, test := range tests {
+		cfg.RootMode = test.mode
+		cfg.RootRedirect = test.redirect
+		w := httptest.NewRecorder()
+		h.ServeRoot(w, httptest.NewRequest("GET", "/", nil))
+		
+		if w.Code != test.status {
+			t.Errorf("Mode %q: expected status %d, got %d", test.mode, test.status, w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != test.location {
+			t.Errorf("Mode %q: expected Location %q, got %q", test.mode, test.location, loc)
+		}
+	}
+	
+	w := httptest.NewRecorder()
+	h.ServeRoot(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown path, got %d", w.Code)
+	}
+}
+
+func TestServeRoot_CustomNotFound(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	h.SetNotFoundHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("<h1>custom</h1>"))
+	}))
+
+	for _, mode := range []string{"redirect", "404"} {
+		cfg.RootMode = mode
+		path := "/unknown"
+		if mode == "404" {
+			path = "/"
+		}
+		w := httptest.NewRecorder()
+		h.ServeRoot(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound || w.Body.String() != "<h1>custom</h1>" {
+			t.Errorf("Mode %q: expected custom 404 page, got %d %q", mode, w.Code, w.Body.String())
+		}
+	}
+
+	// API 路径下的未知地址仍返回纯文本 404
+	cfg.RootMode = "redirect"
+	w := httptest.NewRecorder()
+	h.ServeRoot(w, httptest.NewRequest("GET", "/api/unknown", nil))
+	if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "custom") {
+		t.Errorf("Expected plain 404 for an unknown API path, got %d %q", w.Code, w.Body.String())
+	}
+
+	h.SetNotFoundHandler(nil)
+	w = httptest.NewRecorder()
+	h.ServeRoot(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound || strings.Contains(w.Body.String(), "custom") {
+		t.Errorf("Expected plain 404 after reset, got %d %q", w.Code, w.Body.String())
+	}
+}
+
 func TestTokenMatch(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -416,4 +765,1627 @@ func TestAllowCORS(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// fakeManager 实现 RoomManager，返回固定的 Answer，用于覆盖推拉流成功路径。
+type fakeManager struct {
+	answer    string
+	err       error
+	block     bool // 为 true 时 Publish 阻塞直到 ctx 结束，模拟协商卡住
+	published []string
+	closed    []string
+	relays    []string
+	rooms     []sfu.RoomInfo // 非空时作为 ListRooms 的返回值
+	sessions  []string       // CloseSession 关闭的会话 ID
+	grace     []time.Duration
+	clients   []string // SubscribeWith 收到的客户端标识
+}
+
+func (f *fakeManager) PublishWithID(ctx context.Context, room, _ string) (string, string, error) {
+	if f.block {
+		<-ctx.Done()
+		return "", "", ctx.Err()
+	}
+	if f.err != nil {
+		return "", "", f.err
+	}
+	f.published = append(f.published, room)
+	return f.answer, "pub1", nil
+}
+
+func (f *fakeManager) TrickleICE(id, frag string) (string, error) {
+	if id != "pub1" && id != "sub1" {
+		return "", sfu.ErrSessionNotFound
+	}
+	if frag != "" && !strings.Contains(frag, "a=candidate:") {
+		return "", errors.New("bad candidate")
+	}
+	return "a=candidate:1 1 udp 2130706431 10.0.0.1 5000 typ host\r\n", nil
+}
+
+func (f *fakeManager) CloseSession(id string) error {
+	if id != "pub1" && id != "sub1" {
+		return sfu.ErrSessionNotFound
+	}
+	f.sessions = append(f.sessions, id)
+	return nil
+}
+
+func (f *fakeManager) MigratePublisher(_ context.Context, room, _ string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if room != "demo" {
+		return "", sfu.ErrNoPublisher
+	}
+	return f.answer, nil
+}
+
+func (f *fakeManager) SubscribeWith(_ context.Context, _, _ string, opts sfu.SubscribeOptions) (sfu.SubscribeResult, error) {
+	if f.err != nil {
+		return sfu.SubscribeResult{}, f.err
+	}
+	if opts.Publisher != "" && opts.Publisher != "pub1" {
+		return sfu.SubscribeResult{}, sfu.ErrPublisherNotFound
+	}
+	f.clients = append(f.clients, opts.ClientID)
+	return sfu.SubscribeResult{Answer: f.answer, ID: "sub1", ResumeToken: "tok1", Resumed: opts.ResumeToken == "tok1"}, nil
+}
+
+func (f *fakeManager) RequestKeyframe(_, _ string) error { return f.err }
+
+func (f *fakeManager) JoinQueue(room string) (*sfu.Waiter, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return sfu.NewManager(&config.Config{}).JoinQueue(room) // 未满的房间：立即就绪
+}
+
+func (f *fakeManager) ListRooms() []sfu.RoomInfo {
+	if f.rooms != nil {
+		return f.rooms
+	}
+	return []sfu.RoomInfo{{Name: "demo"}}
+}
+
+func (f *fakeManager) RoomHealth(room string, _ time.Duration) sfu.RoomHealth {
+	return sfu.RoomHealth{Room: room, Healthy: room == "demo"}
+}
+
+func (f *fakeManager) RoomDetail(room string) (sfu.RoomDetail, bool) {
+	if room != "demo" {
+		return sfu.RoomDetail{}, false
+	}
+	return sfu.RoomDetail{Name: room, Subscribers: 1, Tracks: []sfu.TrackDetail{{ID: "v", Codec: "video/VP8", SSRC: 42}},
+		SubscriberList: []sfu.SubscriberDetail{{ID: "sub1"}}}, true
+}
+
+func (f *fakeManager) RoomDetails() []sfu.RoomDetail {
+	d, _ := f.RoomDetail("demo")
+	return []sfu.RoomDetail{d}
+}
+
+func (f *fakeManager) Stats() sfu.ServerStats {
+	return sfu.ServerStats{Rooms: 1, Subscribers: 2, Publishers: 1, RTPBytes: 1200, RTPPackets: 10}
+}
+
+func (f *fakeManager) DeleteRecording(name string) error {
+	if !sfu.IsRecordingName(name) {
+		return sfu.ErrInvalidRecordingName
+	}
+	return sfu.ErrRecordingActive
+}
+
+func (f *fakeManager) CloseRoom(room string) bool {
+	f.closed = append(f.closed, room)
+	return true
+}
+
+func (f *fakeManager) CloseRoomGraceful(room string, grace time.Duration) bool {
+	f.closed = append(f.closed, room)
+	f.grace = append(f.grace, grace)
+	return true
+}
+
+func (f *fakeManager) StartRelay(_ context.Context, room, whipURL, _ string) error {
+	if room != "demo" {
+		return sfu.ErrRoomNotFound
+	}
+	f.relays = append(f.relays, whipURL)
+	return nil
+}
+
+func (f *fakeManager) ProvisionRoom(room, token string, meta map[string]string) sfu.RoomState {
+	return sfu.RoomState{Name: room, Provisioned: true, Token: token, Metadata: meta}
+}
+
+func (f *fakeManager) RoomToken(string) (string, bool) { return "", false }
+
+func (f *fakeManager) IsProvisioned(string) bool { return false }
+
+func TestServeWHIPPublish_SuccessWithFake(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	fm := &fakeManager{answer: "v=0 answer"}
+	h := NewHTTPHandlers(fm, cfg)
+
+	req := httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader("v=0 offer"))
+	w := httptest.NewRecorder()
+	h.ServeWHIPPublish(w, req, "demo")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/sdp" {
+		t.Errorf("Expected Content-Type application/sdp, got %q", ct)
+	}
+	if w.Body.String() != "v=0 answer" {
+		t.Errorf("Expected canned answer, got %q", w.Body.String())
+	}
+	if len(fm.published) != 1 || fm.published[0] != "demo" {
+		t.Errorf("Expected publish to room demo, got %v", fm.published)
+	}
+	if loc, want := w.Header().Get("Location"), "/api/whip/session/"+h.sessionResource("pub1"); loc != want {
+		t.Errorf("Expected Location %s, got %q", want, loc)
+	}
+}
+
+func TestServeWHIPPublish_ErrorStatus(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{sfu.ErrPublisherExists, http.StatusConflict},
+		{fmt.Errorf("%w: populate from SDP: bad", sfu.ErrInvalidSDP), http.StatusBadRequest},
+		{errors.New("create peer connection"), http.StatusInternalServerError},
+	} {
+		h := NewHTTPHandlers(&fakeManager{err: tc.err}, cfg)
+		w := httptest.NewRecorder()
+		h.ServeWHIPPublish(w, httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader("v=0")), "demo")
+		if w.Code != tc.code {
+			t.Errorf("%v: expected status %d, got %d", tc.err, tc.code, w.Code)
+		}
+	}
+}
+
+func TestServeWHEPPlay_ErrorStatus(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{sfu.ErrRoomFull, http.StatusServiceUnavailable},
+		{sfu.ErrEgressLimit, http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: bad offer", sfu.ErrInvalidSDP), http.StatusBadRequest},
+		{errors.New("create peer connection"), http.StatusInternalServerError},
+	} {
+		h := NewHTTPHandlers(&fakeManager{err: tc.err}, cfg)
+		w := httptest.NewRecorder()
+		h.ServeWHEPPlay(w, httptest.NewRequest("POST", "/api/whep/play/demo", strings.NewReader("v=0")), "demo")
+		if w.Code != tc.code {
+			t.Errorf("%v: expected status %d, got %d", tc.err, tc.code, w.Code)
+		}
+	}
+}
+
+func TestServeWHIPMigrate_ErrorStatus(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{sfu.ErrMultiplePublishers, http.StatusConflict},
+		{fmt.Errorf("%w: bad offer", sfu.ErrInvalidSDP), http.StatusBadRequest},
+		{errors.New("create peer connection"), http.StatusInternalServerError},
+	} {
+		h := NewHTTPHandlers(&fakeManager{err: tc.err}, cfg)
+		w := httptest.NewRecorder()
+		h.ServeWHIPMigrate(w, httptest.NewRequest("POST", "/api/whip/publish/demo/migrate", strings.NewReader("v=0")), "demo")
+		if w.Code != tc.code {
+			t.Errorf("%v: expected status %d, got %d", tc.err, tc.code, w.Code)
+		}
+	}
+}
+
+func TestServeWHIPPublish_MaxSDPBytes(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.MaxSDPBytes = 16
+	fm := &fakeManager{answer: "v=0"}
+	h := NewHTTPHandlers(fm, cfg)
+
+	w := httptest.NewRecorder()
+	h.ServeWHIPPublish(w, httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader(strings.Repeat("a", 17))), "demo")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413, got %d", w.Code)
+	}
+	if len(fm.published) != 0 {
+		t.Errorf("Expected truncated offer not to reach Publish, got %v", fm.published)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeWHEPPlay(w, httptest.NewRequest("POST", "/api/whep/play/demo", strings.NewReader(strings.Repeat("a", 17))), "demo")
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for WHEP, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest("PATCH", "/api/whip/session/x", strings.NewReader(strings.Repeat("a", 17)))
+	req.Header.Set("Content-Type", "application/trickle-ice-sdpfrag")
+	h.ServeSession(w, req, h.sessionResource("pub1"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status 413 for trickle PATCH, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeWHIPPublish(w, httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader(strings.Repeat("a", 16))), "demo")
+	if w.Code != http.StatusCreated {
+		t.Errorf("Expected offer at the limit to be accepted, got %d", w.Code)
+	}
+}
+
+func TestServeWHEPPlay_ClientID(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	fm := &fakeManager{answer: "v=0"}
+	h := NewHTTPHandlers(fm, cfg)
+
+	req := httptest.NewRequest("POST", "/api/whep/play/demo?client_id=from-query", strings.NewReader("v=0"))
+	req.Header.Set("X-Client-ID", "from-header")
+	h.ServeWHEPPlay(httptest.NewRecorder(), req, "demo")
+	h.ServeWHEPPlay(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/whep/play/demo?client_id=from-query", strings.NewReader("v=0")), "demo")
+	h.ServeWHEPPlay(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/whep/play/demo", strings.NewReader("v=0")), "demo")
+	if want := []string{"from-header", "from-query", ""}; !slices.Equal(fm.clients, want) {
+		t.Errorf("Expected client IDs %v, got %v", want, fm.clients)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeWHEPPlay(w, httptest.NewRequest("POST", "/api/whep/play/demo?client_id="+strings.Repeat("x", maxClientIDLen+1), strings.NewReader("v=0")), "demo")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an overlong client ID, got %d", w.Code)
+	}
+}
+
+func TestServeSession_Delete(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	fm := &fakeManager{}
+	h := NewHTTPHandlers(fm, cfg)
+
+	// 只凭公开的会话 ID（或伪造的 HMAC）不能结束会话
+	for _, res := range []string{"sub1", "sub1.", "sub1." + strings.Repeat("0", 32), NewHTTPHandlers(fm, cfg).sessionResource("sub1")} {
+		w := httptest.NewRecorder()
+		h.ServeSession(w, httptest.NewRequest("DELETE", "/api/whip/session/"+res, nil), res)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%q: expected status 404 without a valid session resource, got %d", res, w.Code)
+		}
+	}
+	if len(fm.sessions) != 0 {
+		t.Fatalf("Expected no session closed, got %v", fm.sessions)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeSession(w, httptest.NewRequest("DELETE", "/api/whip/session/x", nil), h.sessionResource("sub1"))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d", w.Code)
+	}
+	if len(fm.sessions) != 1 || fm.sessions[0] != "sub1" {
+		t.Errorf("Expected session sub1 to be closed, got %v", fm.sessions)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeSession(w, httptest.NewRequest("DELETE", "/api/whip/session/x", nil), h.sessionResource("nope"))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown session, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeSession(w, httptest.NewRequest("GET", "/api/whip/session/pub1", nil), h.sessionResource("pub1"))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestServeSession_PatchTrickle(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+
+	req := httptest.NewRequest("PATCH", "/api/whip/session/pub1", strings.NewReader("a=mid:0\r\na=candidate:1 1 udp 1 10.0.0.2 6000 typ host\r\n"))
+	req.Header.Set("Content-Type", "application/trickle-ice-sdpfrag")
+	w := httptest.NewRecorder()
+	h.ServeSession(w, req, h.sessionResource("pub1"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/trickle-ice-sdpfrag" || !strings.Contains(w.Body.String(), "a=candidate:") {
+		t.Errorf("Expected server candidates as sdpfrag, got %q %q", ct, w.Body.String())
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/whip/session/pub1", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	h.ServeSession(w, req, h.sessionResource("pub1"))
+	if w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected status 415 for wrong content type, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("PATCH", "/api/whip/session/pub1", strings.NewReader("a=ice-ufrag:x"))
+	req.Header.Set("Content-Type", "application/trickle-ice-sdpfrag")
+	w = httptest.NewRecorder()
+	h.ServeSession(w, req, h.sessionResource("pub1"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for bad candidates, got %d", w.Code)
+	}
+}
+
+func TestServeWHEPPlay_SuccessWithFake(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{answer: "v=0 answer"}, cfg)
+
+	req := httptest.NewRequest("POST", "/api/whep/play/demo", strings.NewReader("v=0 offer"))
+	w := httptest.NewRecorder()
+	h.ServeWHEPPlay(w, req, "demo")
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	if loc, want := w.Header().Get("Location"), "/api/whep/play/demo/"+h.sessionResource("sub1"); loc != want {
+		t.Errorf("Expected Location %s, got %q", want, loc)
+	}
+	if tok := w.Header().Get("X-Resume-Token"); tok != "tok1" {
+		t.Errorf("Expected X-Resume-Token tok1, got %q", tok)
+	}
+	if w.Body.String() != "v=0 answer" {
+		t.Errorf("Expected canned answer, got %q", w.Body.String())
+	}
+}
+
+func TestServeWHEPPlay_PublisherPin(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{answer: "v=0 answer"}, cfg)
+
+	for pin, want := range map[string]int{"pub1": http.StatusCreated, "other": http.StatusNotFound} {
+		req := httptest.NewRequest("POST", "/api/whep/play/demo?publisher="+pin, strings.NewReader("v=0 offer"))
+		w := httptest.NewRecorder()
+		h.ServeWHEPPlay(w, req, "demo")
+		if w.Code != want {
+			t.Errorf("publisher=%s: expected status %d, got %d", pin, want, w.Code)
+		}
+	}
+}
+
+func TestServeWHIPMigrate(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{answer: "v=0 answer"}, cfg)
+
+	for room, want := range map[string]int{"demo": http.StatusCreated, "idle": http.StatusConflict} {
+		req := httptest.NewRequest("POST", "/api/whip/publish/"+room+"/migrate", strings.NewReader("v=0 offer"))
+		w := httptest.NewRecorder()
+		h.ServeWHIPMigrate(w, req, room)
+		if w.Code != want {
+			t.Errorf("%s: expected status %d, got %d", room, want, w.Code)
+		}
+	}
+
+	h = NewHTTPHandlers(&fakeManager{err: sfu.ErrRoomNotFound}, cfg)
+	w := httptest.NewRecorder()
+	h.ServeWHIPMigrate(w, httptest.NewRequest("POST", "/api/whip/publish/none/migrate", strings.NewReader("v=0 offer")), "none")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown room, got %d", w.Code)
+	}
+}
+
+func TestServeWHIPPublish_ManagerErrorWithFake(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{err: fmt.Errorf("%w: boom", sfu.ErrInvalidSDP)}, cfg)
+
+	req := httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader("v=0 offer"))
+	w := httptest.NewRecorder()
+	h.ServeWHIPPublish(w, req, "demo")
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestServeWHIPPublish_RequireOrigin(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.RequireOrigin = true
+	cfg.AllowedOrigin = "https://example.com"
+	h := NewHTTPHandlers(&fakeManager{answer: "v=0 answer"}, cfg)
+
+	tests := []struct {
+		name   string
+		origin string
+		want   int
+	}{
+		{"missing origin", "", http.StatusForbidden},
+		{"disallowed origin", "https://evil.com", http.StatusForbidden},
+		{"allowed origin", "https://example.com", http.StatusCreated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader("v=0 offer"))
+			if test.origin != "" {
+				req.Header.Set("Origin", test.origin)
+			}
+			w := httptest.NewRecorder()
+			h.ServeWHIPPublish(w, req, "demo")
+			if w.Code != test.want {
+				t.Errorf("Expected status %d, got %d", test.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestAllowRate_Limits(t *testing.T) {
+	h, _ := setupTestHandlers()
+	h.ReloadRateLimit(1, 2)
+
+	req := httptest.NewRequest("GET", "/api/rooms", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if !h.allowRate(req) || !h.allowRate(req) {
+		t.Fatal("Expected burst of 2 requests to be allowed")
+	}
+	if h.allowRate(req) {
+		t.Error("Expected third request to be rate limited")
+	}
+
+	h.ReloadRateLimit(0, 0)
+	if !h.allowRate(req) {
+		t.Error("Expected requests to be allowed after disabling rate limit")
+	}
+}
+
+func TestIPLimiter_EvictsIdle(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := &ipLimiter{now: func() time.Time { return now }}
+	l.reload(10, 1)
+	for i := 0; i < 100; i++ {
+		l.allow(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+	}
+	now = now.Add(5 * time.Minute)
+	l.allow("10.0.0.0") // 仍活跃的客户端不应被回收
+	now = now.Add(6 * time.Minute)
+	l.evictIdle(limiterIdleTTL)
+
+	l.mu.Lock()
+	n := len(l.buckets)
+	_, kept := l.buckets["10.0.0.0"]
+	l.mu.Unlock()
+	if n != 1 || !kept {
+		t.Errorf("Expected only the recently used limiter to remain, got %d (kept=%v)", n, kept)
+	}
+	var nilLimiter *ipLimiter
+	nilLimiter.evictIdle(limiterIdleTTL)
+}
+
+func TestHTTPHandlers_CloseStopsLimiterSweep(t *testing.T) {
+	h := &HTTPHandlers{stop: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		h.evictIdleLimiters()
+		close(done)
+	}()
+	h.Close()
+	h.Close() // 可重复调用
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected Close to stop the limiter sweep goroutine")
+	}
+}
+
+// TestAllowRate_ConcurrentReload 在 -race 下验证运行时替换限流器与并发限流检查互不干扰。
+func TestAllowRate_ConcurrentReload(t *testing.T) {
+	h, _ := setupTestHandlers()
+	h.ReloadRateLimit(1000, 10)
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := httptest.NewRequest("GET", "/api/rooms", nil)
+			req.RemoteAddr = fmt.Sprintf("10.0.0.%d:1234", i)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					h.allowRate(req)
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 100; i++ {
+		h.ReloadRateLimit(float64(i%3), i%5)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		xff    []string
+		realIP string
+		trust  bool
+		want   string
+	}{
+		{"remote addr", nil, "", true, "10.0.0.1"},
+		{"headers ignored without trust", []string{"203.0.113.7"}, "198.51.100.2", false, "10.0.0.1"},
+		{"first untrusted hop from the right", []string{"203.0.113.7, 10.1.1.1"}, "198.51.100.2", true, "203.0.113.7"},
+		{"spoofed leftmost entry ignored", []string{"192.0.2.66, 203.0.113.7"}, "", true, "203.0.113.7"},
+		{"stop at invalid entry", []string{"unknown, 203.0.113.8"}, "", true, "203.0.113.8"},
+		{"multiple headers", []string{"192.0.2.66", "2001:db8::1, 10.1.1.1"}, "", true, "2001:db8::1"},
+		{"only trusted hops", []string{"10.1.1.1, 10.2.2.2"}, "", true, "10.1.1.1"},
+		{"real ip fallback", []string{"garbage"}, " 198.51.100.2 ", true, "198.51.100.2"},
+		{"invalid headers", []string{"garbage"}, "nope", true, "10.0.0.1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/rooms", nil)
+			req.RemoteAddr = "10.0.0.1:1234"
+			for _, v := range test.xff {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			if test.realIP != "" {
+				req.Header.Set("X-Real-IP", test.realIP)
+			}
+			var trusted []*net.IPNet
+			if test.trust {
+				trusted = defaultTrustedProxies
+			}
+			if got := clientIP(req, trusted); got != test.want {
+				t.Errorf("Expected %s, got %s", test.want, got)
+			}
+		})
+	}
+
+	// 直接来自不可信地址的请求不采信代理头
+	req := httptest.NewRequest("GET", "/api/rooms", nil)
+	req.RemoteAddr = "198.51.100.50:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7")
+	req.Header.Set("X-Real-IP", "203.0.113.8")
+	if got := clientIP(req, defaultTrustedProxies); got != "198.51.100.50" {
+		t.Errorf("Expected headers from an untrusted peer ignored, got %s", got)
+	}
+}
+
+func TestClientHost_TrustedProxies(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.TrustProxyHeaders = true
+	_, proxy, _ := net.ParseCIDR("198.51.100.0/24")
+	cfg.TrustedProxies = []*net.IPNet{proxy}
+
+	req := httptest.NewRequest("GET", "/api/rooms", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.0.2.66, 203.0.113.7")
+	if got := h.clientHost(req); got != "203.0.113.7" {
+		t.Errorf("Expected client behind configured proxy, got %s", got)
+	}
+	req.RemoteAddr = "10.0.0.1:1234" // 不在 TRUSTED_PROXIES 内，默认的私有网段不再生效
+	if got := h.clientHost(req); got != "10.0.0.1" {
+		t.Errorf("Expected headers ignored from a peer outside TRUSTED_PROXIES, got %s", got)
+	}
+}
+
+func TestAllowRate_TrustProxyHeaders(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.TrustProxyHeaders = true
+	h.ReloadRateLimit(1, 1)
+
+	for _, ip := range []string{"203.0.113.1", "203.0.113.2"} {
+		req := httptest.NewRequest("GET", "/api/rooms", nil)
+		req.RemoteAddr = "10.0.0.1:1234" // 同一反向代理
+		req.Header.Set("X-Forwarded-For", ip)
+		if !h.allowRate(req) {
+			t.Errorf("Expected client %s behind proxy to have its own limiter", ip)
+		}
+	}
+}
+
+func TestClientIP_RemoteAddrForms(t *testing.T) {
+	for addr, want := range map[string]string{
+		"10.0.0.1:1234":       "10.0.0.1",
+		"[2001:db8::1]:443":   "2001:db8::1",
+		"2001:db8::1":         "2001:db8::1",
+		"[2001:db8::2]":       "2001:db8::2",
+		"192.0.2.9":           "192.0.2.9",
+		"":                    "",
+		"@":                   "",
+		"/run/liveforge.sock": "",
+	} {
+		req := httptest.NewRequest("GET", "/api/rooms", nil)
+		req.RemoteAddr = addr
+		if got := clientIP(req, nil); got != want {
+			t.Errorf("RemoteAddr %q: expected %q, got %q", addr, want, got)
+		}
+	}
+}
+
+func TestAllowRate_UnknownClient(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	h.ReloadRateLimit(1, 1)
+	unix := func() *http.Request {
+		req := httptest.NewRequest("GET", "/api/rooms", nil)
+		req.RemoteAddr = "@"
+		return req
+	}
+	if !h.allowRate(unix()) || h.allowRate(unix()) {
+		t.Error("Expected unidentified clients to share one limiter by default")
+	}
+	ip := httptest.NewRequest("GET", "/api/rooms", nil)
+	ip.RemoteAddr = "10.0.0.3:1234"
+	if !h.allowRate(ip) {
+		t.Error("Expected identified client not to share the unknown-client bucket")
+	}
+
+	cfg.RateLimitUnknownClient = config.UnknownClientSkip
+	for i := 0; i < 3; i++ {
+		if !h.allowRate(unix()) {
+			t.Fatal("Expected unidentified clients not limited with RATE_LIMIT_UNKNOWN_CLIENT=skip")
+		}
+	}
+}
+
+func TestAllowEndpointRate_SeparateLimiters(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.RateLimitRPS = 100
+	cfg.RateLimitBurst = 100
+	cfg.RateLimitPublishRPS = 1
+	cfg.RateLimitPublishBurst = 1
+	h = NewHTTPHandlers(h.mgr, cfg)
+
+	req := httptest.NewRequest("POST", "/api/whip/publish/room1", nil)
+	req.RemoteAddr = "10.0.0.2:1234"
+	if !h.allowEndpointRate(req, h.publishLimit) {
+		t.Fatal("Expected first publish to be allowed")
+	}
+	if h.allowEndpointRate(req, h.publishLimit) {
+		t.Error("Expected second publish to be rate limited")
+	}
+	// 发布限流不消耗全局令牌桶，房间列表仍可频繁访问
+	for i := 0; i < 10; i++ {
+		if !h.allowRate(req) {
+			t.Fatalf("Expected room listing %d to use the global limiter", i)
+		}
+	}
+	// 未单独配置的播放端点回退到全局限流
+	if h.playLimit != nil || !h.allowEndpointRate(req, h.playLimit) {
+		t.Error("Expected play endpoint to fall back to the global limiter")
+	}
+}
+
+func TestAllowCORS_NoOrigin(t *testing.T) {
+	h, _ := setupTestHandlers()
+
+	req := httptest.NewRequest("GET", "/api/rooms", nil)
+	w := httptest.NewRecorder()
+	h.allowCORS(w, req)
+
+	for k := range w.Header() {
+		if strings.HasPrefix(k, "Access-Control-") {
+			t.Errorf("Expected no CORS headers without Origin, got %s", k)
+		}
+	}
+}
+
+func TestServeWHIPPublish_AnswerTimeout(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AnswerTimeout = 20 * time.Millisecond
+	h := NewHTTPHandlers(&fakeManager{block: true}, cfg)
+
+	req := httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader("v=0 offer"))
+	w := httptest.NewRecorder()
+	h.ServeWHIPPublish(w, req, "demo")
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected status 504, got %d", w.Code)
+	}
+}
+
+func TestServeAdminProvisionRoom_InvalidRecordFormat(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin-token"
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+
+	body := `{"metadata":{"record_format":"mkv"}}`
+	req := httptest.NewRequest("PUT", "/api/admin/rooms/demo", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+	h.ServeAdminProvisionRoom(w, req, "demo")
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestServeRoomHealth(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+
+	tests := []struct {
+		room  string
+		query string
+		want  int
+	}{
+		{"demo", "", http.StatusOK},
+		{"idle", "", http.StatusServiceUnavailable},
+		{"demo", "?max_age=abc", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/api/rooms/"+test.room+"/health"+test.query, nil)
+		w := httptest.NewRecorder()
+		h.ServeRoomHealth(w, req, test.room)
+		if w.Code != test.want {
+			t.Errorf("room %s%s: expected status %d, got %d", test.room, test.query, test.want, w.Code)
+		}
+	}
+}
+
+func TestServeRoomDetail(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+
+	w := httptest.NewRecorder()
+	h.ServeRoomDetail(w, httptest.NewRequest("GET", "/api/rooms/demo", nil), "demo")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var d sfu.RoomDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if d.Name != "demo" || len(d.Tracks) != 1 || d.Tracks[0].SSRC != 42 || d.Subscribers != 1 {
+		t.Errorf("Unexpected room detail: %+v", d)
+	}
+	if len(d.SubscriberList) != 1 || d.SubscriberList[0].ID != "" {
+		t.Errorf("Expected subscriber IDs hidden from anonymous callers, got %+v", d.SubscriberList)
+	}
+
+	cfg.AdminToken = "admin"
+	req := httptest.NewRequest("GET", "/api/rooms/demo", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	h.ServeRoomDetail(w, req, "demo")
+	d = sfu.RoomDetail{}
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(d.SubscriberList) != 1 || d.SubscriberList[0].ID != "sub1" {
+		t.Errorf("Expected subscriber IDs for admins, got %+v", d.SubscriberList)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeRoomDetail(w, httptest.NewRequest("GET", "/api/rooms/missing", nil), "missing")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown room, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeRoomDetail(w, httptest.NewRequest("POST", "/api/rooms/demo", nil), "demo")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status 405 for POST, got %d", w.Code)
+	}
+}
+
+func TestServeAdminDebugState(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin"
+	cfg.JWTSecret = "jwt-secret"
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+
+	w := httptest.NewRecorder()
+	h.ServeAdminDebugState(w, httptest.NewRequest("GET", "/api/admin/debug/state", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 without admin token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/admin/debug/state", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	h.ServeAdminDebugState(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if body := w.Body.String(); strings.Contains(body, "jwt-secret") || strings.Contains(body, `"admin"`) {
+		t.Errorf("Expected secrets redacted, got %s", body)
+	}
+	var st debugState
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(st.Rooms) != 1 || st.Rooms[0].Name != "demo" || st.Goroutines == 0 || st.Config.AdminToken != "[redacted]" {
+		t.Errorf("Unexpected debug state: rooms=%+v goroutines=%d", st.Rooms, st.Goroutines)
+	}
+}
+
+func TestServeStats(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin"
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+
+	w := httptest.NewRecorder()
+	h.ServeStats(w, httptest.NewRequest("GET", "/api/stats", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 without admin token, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/api/stats", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	h.ServeStats(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var st statsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if st.Rooms != 1 || st.Subscribers != 2 || st.Publishers != 1 || st.RTPBytes != 1200 || st.RTPPackets != 10 {
+		t.Errorf("Unexpected stats: %+v", st.ServerStats)
+	}
+	if st.Goroutines == 0 || st.HeapBytes == 0 || st.MemoryBytes == 0 {
+		t.Errorf("Expected runtime stats, got %+v", st)
+	}
+}
+
+// wsManager 在 CloseSession 时通知测试，避免跨 goroutine 读取 fakeManager 的字段。
+type wsManager struct {
+	*fakeManager
+	closed chan string
+}
+
+func (m *wsManager) CloseSession(id string) error {
+	m.closed <- id
+	return nil
+}
+
+func TestServeWebSocket(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AuthToken = "secret"
+	cfg.TrickleICE = true
+	mgr := &wsManager{fakeManager: &fakeManager{answer: "v=0\r\n"}, closed: make(chan string, 1)}
+	h := NewHTTPHandlers(mgr, cfg)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeWebSocket(w, r, "demo")
+	}))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/demo"
+
+	if _, err := websocket.Dial(url+"?token=wrong", "", srv.URL); err == nil {
+		t.Fatal("Expected handshake to fail with a wrong token")
+	}
+	// 升级请求未带凭据：首条消息必须携带有效 token
+	for _, tok := range []string{"", "wrong"} {
+		ws, err := websocket.Dial(url, "", srv.URL)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = websocket.JSON.Send(ws, wsMessage{Type: "publish", SDP: "offer", Token: tok})
+		var msg wsMessage
+		if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Type != "error" || msg.Error != "unauthorized" {
+			t.Errorf("token %q: expected unauthorized error, got %+v (%v)", tok, msg, err)
+		}
+		ws.Close()
+	}
+	// 未带凭据且不发送首条消息的连接在 wsAuthTimeout 后被关闭
+	defer func(d time.Duration) { wsAuthTimeout = d }(wsAuthTimeout)
+	wsAuthTimeout = 100 * time.Millisecond
+	idle, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	_ = idle.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var none wsMessage
+	if err := websocket.JSON.Receive(idle, &none); err != io.EOF {
+		t.Errorf("Expected an idle unauthenticated socket to be closed, got %+v (%v)", none, err)
+	}
+	idle.Close()
+
+	ws, err := websocket.Dial(url, "", srv.URL)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	// 鉴权通过后清除期限：空闲超过 wsAuthTimeout 的已鉴权连接仍可继续使用
+	if err := websocket.JSON.Send(ws, wsMessage{Type: "publish", SDP: "offer", Token: "secret"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var msg wsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Type != "answer" || msg.ID != "pub1" || msg.SDP != "v=0\r\n" {
+		t.Fatalf("Expected answer for pub1, got %+v (%v)", msg, err)
+	}
+	time.Sleep(2 * wsAuthTimeout)
+	if err := websocket.JSON.Send(ws, wsMessage{Type: "bogus"}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	var cand, errMsg bool
+	for !cand || !errMsg {
+		msg = wsMessage{}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		switch msg.Type {
+		case "candidate":
+			cand = strings.HasPrefix(msg.Candidate, "candidate:1 ") && msg.SDPMLineIndex != nil
+		case "error":
+			errMsg = true
+		}
+	}
+
+	ws.Close()
+	select {
+	case id := <-mgr.closed:
+		if id != "pub1" {
+			t.Errorf("Expected session pub1 closed, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected socket close to tear down the session")
+	}
+
+	// ?token= 仍兼容；推流消息套用 WHIP 的专属限流
+	cfg.RateLimitPublishRPS, cfg.RateLimitPublishBurst = 0.001, 1
+	h2 := NewHTTPHandlers(mgr, cfg)
+	srv2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h2.ServeWebSocket(w, r, "demo")
+	}))
+	defer srv2.Close()
+	url2 := "ws" + strings.TrimPrefix(srv2.URL, "http") + "/ws/demo?token=secret"
+	for i, want := range []string{"answer", "error"} {
+		ws, err := websocket.Dial(url2, "", srv2.URL)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = websocket.JSON.Send(ws, wsMessage{Type: "publish", SDP: "offer"})
+		msg = wsMessage{}
+		if err := websocket.JSON.Receive(ws, &msg); err != nil || msg.Type != want {
+			t.Errorf("publish %d: expected %s, got %+v (%v)", i, want, msg, err)
+		}
+		ws.Close()
+		if want == "answer" {
+			<-mgr.closed
+		}
+	}
+}
+
+func TestServeAdminRelayRoom(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin-token"
+	fm := &fakeManager{}
+	h := NewHTTPHandlers(fm, cfg)
+
+	tests := []struct {
+		name string
+		room string
+		body string
+		want int
+	}{
+		{"invalid server", "demo", `{"server":"ftp://x"}`, http.StatusBadRequest},
+		{"unknown room", "missing", `{"server":"http://sfu-2:8080"}`, http.StatusNotFound},
+		{"ok", "demo", `{"server":"http://sfu-2:8080/","room":"edge"}`, http.StatusCreated},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/admin/rooms/"+test.room+"/relay", strings.NewReader(test.body))
+			req.Header.Set("Authorization", "Bearer admin-token")
+			w := httptest.NewRecorder()
+			h.ServeAdminRelayRoom(w, req, test.room)
+			if w.Code != test.want {
+				t.Errorf("Expected status %d, got %d", test.want, w.Code)
+			}
+		})
+	}
+	if len(fm.relays) != 1 || fm.relays[0] != "http://sfu-2:8080/api/whip/publish/edge" {
+		t.Errorf("Expected relay to target WHIP URL, got %v", fm.relays)
+	}
+}
+
+func TestServeRooms_ActiveFilter(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	fm := &fakeManager{rooms: []sfu.RoomInfo{
+		{Name: "live", HasPublisher: true, Tracks: 2},
+		{Name: "empty"},
+		{Name: "stalled", HasPublisher: true, Tracks: 1, StalledTracks: 1},
+	}}
+	h := NewHTTPHandlers(fm, cfg)
+
+	for query, want := range map[string]int{"": 3, "?active=1": 1} {
+		w := httptest.NewRecorder()
+		h.ServeRooms(w, httptest.NewRequest("GET", "/api/rooms"+query, nil))
+		var rooms []sfu.RoomInfo
+		if err := json.NewDecoder(w.Body).Decode(&rooms); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if len(rooms) != want {
+			t.Errorf("Query %q: expected %d rooms, got %v", query, want, rooms)
+		}
+		if query != "" && len(rooms) == 1 && rooms[0].Name != "live" {
+			t.Errorf("Expected only the live room, got %v", rooms)
+		}
+	}
+}
+
+func TestRejections_CountedByEndpointAndReason(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AuthToken = "required-token"
+	h := NewHTTPHandlers(&fakeManager{err: sfu.ErrRoomFull}, cfg)
+	counter := func(endpoint, reason string) float64 {
+		return testutil.ToFloat64(metrics.HTTPRejections.WithLabelValues(endpoint, reason))
+	}
+	unauthorized, method, capacity := counter("whip", "unauthorized"), counter("rooms", "method"), counter("whep", "capacity")
+
+	h.ServeWHIPPublish(httptest.NewRecorder(), httptest.NewRequest("POST", "/api/whip/publish/demo", strings.NewReader("offer")), "demo")
+	h.ServeRooms(httptest.NewRecorder(), httptest.NewRequest("DELETE", "/api/rooms", nil))
+	req := httptest.NewRequest("POST", "/api/whep/play/demo", strings.NewReader("offer"))
+	req.Header.Set("X-Auth-Token", "required-token")
+	h.ServeWHEPPlay(httptest.NewRecorder(), req, "demo")
+
+	if got := counter("whip", "unauthorized") - unauthorized; got != 1 {
+		t.Errorf("Expected 1 whip/unauthorized rejection, got %v", got)
+	}
+	if got := counter("rooms", "method") - method; got != 1 {
+		t.Errorf("Expected 1 rooms/method rejection, got %v", got)
+	}
+	if got := counter("whep", "capacity") - capacity; got != 1 {
+		t.Errorf("Expected 1 whep/capacity rejection, got %v", got)
+	}
+}
+
+func TestReject_CountsRouteErrors(t *testing.T) {
+	before := testutil.ToFloat64(metrics.HTTPRejections.WithLabelValues("session", "bad_request"))
+	w := httptest.NewRecorder()
+	Reject(w, "session", "bad_request", "invalid session", http.StatusBadRequest)
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid session") {
+		t.Errorf("Expected 400 invalid session, got %d %q", w.Code, w.Body.String())
+	}
+	if got := testutil.ToFloat64(metrics.HTTPRejections.WithLabelValues("session", "bad_request")) - before; got != 1 {
+		t.Errorf("Expected 1 session/bad_request rejection, got %v", got)
+	}
+}
+
+func TestServeWHEPQueue(t *testing.T) {
+	_, cfg := setupTestHandlers()
+
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+	w := httptest.NewRecorder()
+	h.ServeWHEPQueue(w, httptest.NewRequest("GET", "/api/whep/play/demo/queue", nil), "demo")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected event stream, got %d %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	if body := w.Body.String(); strings.Contains(body, "event: queued") || !strings.Contains(body, "event: slot") {
+		t.Errorf("Expected an immediate slot event for a room with space, got %q", body)
+	}
+
+	h = NewHTTPHandlers(&fakeManager{err: sfu.ErrQueueFull}, cfg)
+	w = httptest.NewRecorder()
+	h.ServeWHEPQueue(w, httptest.NewRequest("GET", "/api/whep/play/demo/queue", nil), "demo")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when the queue is full, got %d", w.Code)
+	}
+}
+
+func TestServeRooms_AcceptCSV(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	h := NewHTTPHandlers(&fakeManager{rooms: []sfu.RoomInfo{{Name: "live", HasPublisher: true, Tracks: 2, Subscribers: 3}}}, cfg)
+
+	req := httptest.NewRequest("GET", "/api/rooms", nil)
+	req.Header.Set("Accept", "text/csv, application/json;q=0.5")
+	w := httptest.NewRecorder()
+	h.ServeRooms(w, req)
+
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/csv") {
+		t.Errorf("Expected text/csv content type, got %q", ct)
+	}
+	want := "name,has_publisher,tracks,subscribers,stalled_tracks,relays\nlive,true,2,3,0,0\n"
+	if w.Body.String() != want {
+		t.Errorf("Expected CSV %q, got %q", want, w.Body.String())
+	}
+}
+
+func TestServeRecordsList_AcceptCSV(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	tempDir := t.TempDir()
+	cfg.RecordDir = tempDir
+	if err := os.WriteFile(tempDir+"/demo.ogg", []byte("ogg"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	for accept, csvWanted := range map[string]bool{"text/csv": true, "application/json": false, "": false} {
+		req := httptest.NewRequest("GET", "/api/records", nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		w := httptest.NewRecorder()
+		h.ServeRecordsList(w, req)
+		isCSV := strings.HasPrefix(w.Body.String(), "name,size,mod_time,url\ndemo.ogg,3,")
+		if isCSV != csvWanted {
+			t.Errorf("Accept %q: expected CSV=%v, got %q", accept, csvWanted, w.Body.String())
+		}
+	}
+}
+
+// signTestJWT 用 HS256 签发测试令牌。
+func signTestJWT(t *testing.T, secret string, claims jwt.MapClaims) string {
+	t.Helper()
+	s, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestJWTOKRoom_TimeClaims(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.JWTSecret = "jwt-secret"
+	now := time.Now()
+	hour := time.Hour
+	cases := map[string]struct {
+		claims jwt.MapClaims
+		want   bool
+	}{
+		"valid":         {jwt.MapClaims{"room": "demo", "exp": now.Add(hour).Unix()}, true},
+		"expired":       {jwt.MapClaims{"room": "demo", "exp": now.Add(-hour).Unix()}, false},
+		"not yet valid": {jwt.MapClaims{"room": "demo", "exp": now.Add(2 * hour).Unix(), "nbf": now.Add(hour).Unix()}, false},
+		"missing exp":   {jwt.MapClaims{"room": "demo"}, false},
+		"other room":    {jwt.MapClaims{"room": "other", "exp": now.Add(hour).Unix()}, false},
+	}
+	for name, c := range cases {
+		req := httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+		req.Header.Set("Authorization", "Bearer "+signTestJWT(t, cfg.JWTSecret, c.claims))
+		if got := h.jwtOKRoom(req, "demo"); got != c.want {
+			t.Errorf("%s: expected %v, got %v", name, c.want, got)
+		}
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"exp": now.Add(hour).Unix()})
+	unsigned, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	req := httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+	req.Header.Set("Authorization", "Bearer "+unsigned)
+	if h.jwtOKRoom(req, "demo") {
+		t.Error("Expected alg=none token to be rejected")
+	}
+
+	cfg.JWTSkipExpiry = true
+	req = httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+	req.Header.Set("Authorization", "Bearer "+signTestJWT(t, cfg.JWTSecret, jwt.MapClaims{"exp": now.Add(-hour).Unix()}))
+	if !h.jwtOKRoom(req, "demo") {
+		t.Error("Expected expired token to pass with JWT_SKIP_EXPIRY")
+	}
+}
+
+func TestJWTKeyRotation(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.JWTSecret = "legacy"
+	cfg.JWTSecrets = map[string]string{"k1": "old", "k2": "new"}
+	exp := time.Now().Add(time.Hour).Unix()
+	sign := func(kid, secret string) string {
+		tok := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"exp": exp})
+		if kid != "" {
+			tok.Header["kid"] = kid
+		}
+		s, err := tok.SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	cases := []struct {
+		name, kid, secret string
+		want              bool
+	}{
+		{"kid selects new key", "k2", "new", true},
+		{"kid selects old key", "k1", "old", true},
+		{"kid with another key", "k1", "new", false},
+		{"unknown kid", "k3", "new", false},
+		{"no kid tries all keys", "", "old", true},
+		{"no kid legacy secret", "", "legacy", true},
+		{"no kid unknown secret", "", "other", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+		req.Header.Set("Authorization", "Bearer "+sign(c.kid, c.secret))
+		if got := h.jwtOKRoom(req, "demo"); got != c.want {
+			t.Errorf("%s: expected %v, got %v", c.name, c.want, got)
+		}
+	}
+
+	cfg.JWTSecret = ""
+	req := httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+	req.Header.Set("Authorization", "Bearer "+sign("k2", "new"))
+	if !h.authOKRoom(req, "demo") {
+		t.Error("Expected JWT_SECRETS alone to enable JWT auth")
+	}
+}
+
+func TestJWTAsymmetricKeys(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherRSA, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	exp := time.Now().Add(time.Hour).Unix()
+	sign := func(m jwt.SigningMethod, key interface{}) string {
+		tok := jwt.NewWithClaims(m, jwt.MapClaims{"exp": exp, "room": "demo"})
+		tok.Header["kid"] = "idp-1"
+		s, err := tok.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	check := func(name, token string, want bool) {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if got := h.authOKRoom(req, "demo"); got != want {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+
+	cfg.JWTPublicKey = &rsaKey.PublicKey
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	check("RS256 with configured key", sign(jwt.SigningMethodRS256, rsaKey), true)
+	check("RS512 with configured key", sign(jwt.SigningMethodRS512, rsaKey), true)
+	check("RS256 with another key", sign(jwt.SigningMethodRS256, otherRSA), false)
+	check("ES256 with RSA key configured", sign(jwt.SigningMethodES256, ecKey), false)
+	check("HS256 signed with the public key", sign(jwt.SigningMethodHS256, der), false)
+
+	cfg.JWTPublicKey = &ecKey.PublicKey
+	check("ES256 with configured key", sign(jwt.SigningMethodES256, ecKey), true)
+	check("RS256 with ECDSA key configured", sign(jwt.SigningMethodRS256, rsaKey), false)
+
+	cfg.JWTSecret = "hmac"
+	check("HMAC still accepted alongside public key", signTestJWT(t, "hmac", jwt.MapClaims{"exp": exp}), true)
+}
+
+func TestJWTClaims_Audience(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.JWTSecret = "jwt-secret"
+	cfg.JWTAudience = "liveforge"
+	exp := time.Now().Add(time.Hour).Unix()
+	for _, skip := range []bool{false, true} {
+		cfg.JWTSkipExpiry = skip
+		for aud, want := range map[string]bool{"liveforge": true, "other": false, "": false} {
+			claims := jwt.MapClaims{"exp": exp, "role": "admin"}
+			if aud != "" {
+				claims["aud"] = aud
+			}
+			req := httptest.NewRequest("GET", "/api/admin/rooms", nil)
+			req.Header.Set("Authorization", "Bearer "+signTestJWT(t, cfg.JWTSecret, claims))
+			if got := h.jwtAdmin(req); got != want {
+				t.Errorf("skip=%v aud=%q: expected %v, got %v", skip, aud, want, got)
+			}
+		}
+	}
+}
+
+func TestJWTClaims_Issuer(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.JWTSecret = "jwt-secret"
+	cfg.JWTAudience = "liveforge"
+	cfg.JWTIssuer = "https://idp.example.com"
+	exp := time.Now().Add(time.Hour).Unix()
+	cases := []struct {
+		name, aud, iss string
+		want           bool
+	}{
+		{"matching aud and iss", "liveforge", "https://idp.example.com", true},
+		{"other issuer", "liveforge", "https://evil.example.com", false},
+		{"missing issuer", "liveforge", "", false},
+		{"token for another service", "billing", "https://idp.example.com", false},
+	}
+	for _, skip := range []bool{false, true} {
+		cfg.JWTSkipExpiry = skip
+		for _, c := range cases {
+			claims := jwt.MapClaims{"exp": exp, "aud": c.aud}
+			if c.iss != "" {
+				claims["iss"] = c.iss
+			}
+			req := httptest.NewRequest("POST", "/api/whip/publish/demo", nil)
+			req.Header.Set("Authorization", "Bearer "+signTestJWT(t, cfg.JWTSecret, claims))
+			if got := h.jwtOKRoom(req, "demo"); got != c.want {
+				t.Errorf("skip=%v %s: expected %v, got %v", skip, c.name, c.want, got)
+			}
+		}
+	}
+}
+
+func TestAdminViewerToken(t *testing.T) {
+	_, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin"
+	cfg.ViewerAdminToken = "viewer"
+	cfg.JWTSecret = "jwt-secret"
+	h := NewHTTPHandlers(&fakeManager{}, cfg)
+	viewerJWT := signTestJWT(t, cfg.JWTSecret, jwt.MapClaims{"role": "viewer", "exp": time.Now().Add(time.Hour).Unix()})
+
+	for _, tok := range []string{"viewer", viewerJWT} {
+		req := httptest.NewRequest("GET", "/api/admin/debug/state", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		h.ServeAdminDebugState(w, req)
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected viewer to read debug state, got %d", w.Code)
+		}
+
+		req = httptest.NewRequest("POST", "/api/admin/rooms/demo/close", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w = httptest.NewRecorder()
+		h.ServeAdminCloseRoom(w, req, "demo")
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for viewer closing a room, got %d", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeAdminCloseRoom(w, httptest.NewRequest("POST", "/api/admin/rooms/demo/close", nil), "demo")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", w.Code)
+	}
+	req := httptest.NewRequest("POST", "/api/admin/rooms/demo/close", nil)
+	req.Header.Set("Authorization", "Bearer admin")
+	w = httptest.NewRecorder()
+	h.ServeAdminCloseRoom(w, req, "demo")
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected admin to close the room, got %d", w.Code)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.LogFormat, cfg.LogLevel = "json", "info"
+	var buf bytes.Buffer
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" {
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		reject(w, "whip", "unauthorized", "unauthorized", http.StatusUnauthorized)
+	})
+	logged := h.AccessLog(next, &buf)
+
+	req := httptest.NewRequest("POST", "/api/whip/publish/demo?token=secret-token", strings.NewReader("v=0\r\nsecret-sdp"))
+	logged.ServeHTTP(httptest.NewRecorder(), req)
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if entry["room"] != "demo" || entry["status"] != float64(401) || entry["auth"] != "denied" ||
+		entry["method"] != "POST" || entry["level"] != "WARN" || entry["client_ip"] != "192.0.2.1" {
+		t.Errorf("Unexpected access log entry: %v", entry)
+	}
+	if s := buf.String(); strings.Contains(s, "secret-token") || strings.Contains(s, "secret-sdp") {
+		t.Errorf("Expected query string and body not logged, got %s", s)
+	}
+
+	buf.Reset()
+	cfg.LogLevel = "warn"
+	logged = h.AccessLog(next, &buf)
+	logged.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if buf.Len() != 0 {
+		t.Errorf("Expected successful request below LOG_LEVEL=warn to be skipped, got %s", buf.String())
+	}
+
+	var w http.ResponseWriter = &accessWriter{ResponseWriter: httptest.NewRecorder()}
+	if _, ok := w.(http.Flusher); !ok {
+		t.Error("Expected access writer to support http.Flusher for SSE")
+	}
+	if _, ok := w.(http.Hijacker); !ok {
+		t.Error("Expected access writer to support http.Hijacker for WebSocket")
+	}
+}
+
+func TestServeTURNCredentials(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	w := httptest.NewRecorder()
+	h.ServeTURNCredentials(w, httptest.NewRequest("GET", "/api/turn-credentials", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 without TURN_STATIC_SECRET, got %d", w.Code)
+	}
+
+	cfg.TURNStaticSecret, cfg.TURNCredentialTTL = "north", time.Hour
+	cfg.TURN = []string{"turn:turn.test:3478"}
+	w = httptest.NewRecorder()
+	h.ServeTURNCredentials(w, httptest.NewRequest("GET", "/api/turn-credentials", nil))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("Expected 403 when no credential is configured, got %d", w.Code)
+	}
+
+	// 只配置了房间 Token 时，未指定房间或房间无 Token 同样拒绝
+	cfg.RoomTokens = map[string]string{"vip": "vip-token"}
+	for url, code := range map[string]int{
+		"/api/turn-credentials":            http.StatusForbidden,
+		"/api/turn-credentials?room=open":  http.StatusForbidden,
+		"/api/turn-credentials?room=vip":   http.StatusUnauthorized,
+		"/api/turn-credentials?room=a%2Fb": http.StatusBadRequest,
+	} {
+		w = httptest.NewRecorder()
+		h.ServeTURNCredentials(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != code {
+			t.Errorf("%s: expected %d, got %d", url, code, w.Code)
+		}
+	}
+	req := httptest.NewRequest("GET", "/api/turn-credentials?room=vip&user=spoofed", nil)
+	req.Header.Set("Authorization", "Bearer vip-token")
+	w = httptest.NewRecorder()
+	h.ServeTURNCredentials(w, req)
+	var resp turnCredentials
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &resp) != nil || strings.Contains(resp.Username, "spoofed") {
+		t.Fatalf("Expected room token to get credentials without the query user, got %d %s", w.Code, w.Body.String())
+	}
+	cfg.RequireProvisionedRooms = true
+	w = httptest.NewRecorder()
+	h.ServeTURNCredentials(w, httptest.NewRequest("GET", "/api/turn-credentials?room=open", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unprovisioned room, got %d", w.Code)
+	}
+	cfg.RoomTokens, cfg.RequireProvisionedRooms = nil, false
+
+	cfg.JWTSecret = "jwt-secret"
+	w = httptest.NewRecorder()
+	h.ServeTURNCredentials(w, httptest.NewRequest("GET", "/api/turn-credentials", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected 401 without token, got %d", w.Code)
+	}
+
+	req = httptest.NewRequest("GET", "/api/turn-credentials?user=spoofed", nil)
+	req.Header.Set("Authorization", "Bearer "+signTestJWT(t, cfg.JWTSecret, jwt.MapClaims{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}))
+	w = httptest.NewRecorder()
+	h.ServeTURNCredentials(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	resp = turnCredentials{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(resp.Username, ":alice") || resp.TTL != 3600 || len(resp.ICEServers) != 2 {
+		t.Fatalf("Unexpected credentials response: %+v", resp)
+	}
+	if turn := resp.ICEServers[1]; turn.URLs[0] != "turn:turn.test:3478" || turn.Username != resp.Username || turn.Credential != resp.Credential {
+		t.Errorf("Expected TURN entry to carry the credentials, got %+v", turn)
+	}
+}
+
+func TestServeICEServers(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	get := func() []iceServer {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeICEServers(w, httptest.NewRequest("GET", "/api/ice-servers", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		var servers []iceServer
+		if err := json.Unmarshal(w.Body.Bytes(), &servers); err != nil {
+			t.Fatal(err)
+		}
+		return servers
+	}
+
+	cfg.TURN = []string{"turn:turn.test:3478"}
+	cfg.TURNUsername, cfg.TURNPassword = "user", "pass"
+	servers := get()
+	if len(servers) != 2 || servers[0].URLs[0] != cfg.STUN[0] || servers[1].Username != "user" || servers[1].Credential != "pass" {
+		t.Errorf("Expected STUN and TURN with static credentials, got %+v", servers)
+	}
+
+	cfg.TURNUsername, cfg.TURNPassword = "", ""
+	cfg.TURNStaticSecret = "north"
+	servers = get()
+	if len(servers) != 1 || servers[0].Credential != "" {
+		t.Errorf("Expected TURN omitted with ephemeral credentials, got %+v", servers)
+	}
+
+	cfg.STUN, cfg.TURN = nil, nil
+	if servers = get(); len(servers) != 1 || servers[0].URLs[0] != config.DefaultSTUNServer {
+		t.Errorf("Expected default STUN when nothing is configured, got %+v", servers)
+	}
+
+	// 启用鉴权后不再匿名返回（可能含静态 TURN 密码）
+	cfg.AuthToken = "secret"
+	w := httptest.NewRecorder()
+	h.ServeICEServers(w, httptest.NewRequest("GET", "/api/ice-servers", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without credentials, got %d", w.Code)
+	}
+	req := httptest.NewRequest("GET", "/api/ice-servers?room=bad.name", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeICEServers(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid room, got %d", w.Code)
+	}
+	req = httptest.NewRequest("GET", "/api/ice-servers", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	w = httptest.NewRecorder()
+	h.ServeICEServers(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with a valid token, got %d", w.Code)
+	}
+}
+
+func TestHandlers_InvalidRoomName(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.AdminToken = "admin"
+	mgr := &fakeManager{answer: "v=0\r\n"}
+	h.mgr = mgr
+	calls := map[string]func(w http.ResponseWriter, r *http.Request, room string){
+		"whip":            h.ServeWHIPPublish,
+		"whip_migrate":    h.ServeWHIPMigrate,
+		"whep":            h.ServeWHEPPlay,
+		"whep_queue":      h.ServeWHEPQueue,
+		"whep_pli":        func(w http.ResponseWriter, r *http.Request, room string) { h.ServeWHEPKeyframe(w, r, room, "sub1") },
+		"ws":              h.ServeWebSocket,
+		"room_detail":     h.ServeRoomDetail,
+		"room_health":     h.ServeRoomHealth,
+		"admin_close":     h.ServeAdminCloseRoom,
+		"admin_relay":     h.ServeAdminRelayRoom,
+		"admin_provision": h.ServeAdminProvisionRoom,
+	}
+	methods := map[string]string{"whep_queue": "GET", "ws": "GET", "room_detail": "GET", "room_health": "GET", "admin_provision": "PUT"}
+	for name, serve := range calls {
+		for _, room := range []string{"", "../etc", "a/b", "bad\x00room", strings.Repeat("r", 65)} {
+			method := methods[name]
+			if method == "" {
+				method = "POST"
+			}
+			req := httptest.NewRequest(method, "/", strings.NewReader("v=0\r\n"))
+			req.Header.Set("Authorization", "Bearer admin")
+			w := httptest.NewRecorder()
+			serve(w, req, room)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s %q: expected 400, got %d", name, room, w.Code)
+			}
+		}
+	}
+	if len(mgr.published) != 0 || len(mgr.closed) != 0 {
+		t.Errorf("Expected no manager calls for invalid rooms, got published=%v closed=%v", mgr.published, mgr.closed)
+	}
+}
+
+func TestServeAuthCheck(t *testing.T) {
+	h, cfg := setupTestHandlers()
+	cfg.AuthToken = "global"
+	cfg.RoomTokens = map[string]string{"vip": "vip-token"}
+	check := func(url, token string) (int, authCheckResult) {
+		t.Helper()
+		req := httptest.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeAuthCheck(w, req)
+		var res authCheckResult
+		if w.Code != http.StatusBadRequest {
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatalf("%s: decode response: %v", url, err)
+			}
+		}
+		return w.Code, res
+	}
+
+	cases := []struct {
+		name, url, token string
+		code             int
+		reason, message  string
+	}{
+		{"global token", "/api/auth/check?room=demo", "global", http.StatusOK, "", ""},
+		{"room token", "/api/auth/check?room=vip", "vip-token", http.StatusOK, "", ""},
+		{"global token on room with own token", "/api/auth/check?room=vip", "global", http.StatusUnauthorized, "unauthorized", "invalid credentials"},
+		{"no credentials", "/api/auth/check?room=demo", "", http.StatusUnauthorized, "unauthorized", "missing credentials"},
+		{"missing room", "/api/auth/check", "global", http.StatusBadRequest, "", ""},
+		{"invalid room", "/api/auth/check?room=a%2F..%2Fb", "global", http.StatusBadRequest, "", ""},
+	}
+	for _, c := range cases {
+		code, res := check(c.url, c.token)
+		if code != c.code || res.Reason != c.reason || res.Message != c.message || res.OK != (c.code == http.StatusOK) {
+			t.Errorf("%s: expected %d %q %q, got %d %+v", c.name, c.code, c.reason, c.message, code, res)
+		}
+	}
+
+	cfg.RequireProvisionedRooms = true
+	if code, res := check("/api/auth/check?room=demo", "global"); code != http.StatusNotFound || res.Reason != "room_not_found" {
+		t.Errorf("Expected unprovisioned room reported, got %d %+v", code, res)
+	}
+	if code, _ := check("/api/auth/check?room=vip", "vip-token"); code != http.StatusOK {
+		t.Errorf("Expected room from ROOM_TOKENS allowed, got %d", code)
+	}
+}
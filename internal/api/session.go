@@ -0,0 +1,43 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// newSessionKey 生成本进程签发会话资源名使用的随机 HMAC 密钥。会话只存在于当前进程，
+// 重启后旧的资源名随会话一起失效，无需持久化。
+func newSessionKey() []byte {
+	k := make([]byte, 32)
+	_, _ = rand.Read(k)
+	return k
+}
+
+// sessionMAC 返回会话 id 的 HMAC（十六进制，截取 128 位）。
+func (h *HTTPHandlers) sessionMAC(id string) string {
+	m := hmac.New(sha256.New, h.sessionKey)
+	m.Write([]byte(id))
+	return hex.EncodeToString(m.Sum(nil)[:16])
+}
+
+// sessionResource 返回会话 id 对外的资源名，即 WHIP/WHEP Location 的最后一段："{id}.{hmac}"。
+// 会话 ID 会出现在房间列表（Publishers）等公开接口中，HMAC 只随 Location 返回给会话的创建者，
+// 结束会话或交换候选时必须出示。
+func (h *HTTPHandlers) sessionResource(id string) string {
+	return id + "." + h.sessionMAC(id)
+}
+
+// sessionID 校验资源名中的 HMAC 并取回会话 ID；格式不符或 HMAC 不匹配时 ok 为 false。
+func (h *HTTPHandlers) sessionID(res string) (id string, ok bool) {
+	id, mac, found := strings.Cut(res, ".")
+	if !found || id == "" {
+		return "", false
+	}
+	if !hmac.Equal([]byte(mac), []byte(h.sessionMAC(id))) {
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	rtmetrics "runtime/metrics"
+	"time"
+
+	"live-webrtc-go/internal/sfu"
+)
+
+// statsResponse 是 GET /api/stats 的响应体。
+type statsResponse struct {
+	sfu.ServerStats
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+	Goroutines    int    `json:"goroutines"`
+	HeapBytes     uint64 `json:"heapBytes"`   // 堆上存活与尚未回收对象占用的字节数
+	MemoryBytes   uint64 `json:"memoryBytes"` // Go 运行时向操作系统申请的内存总量
+}
+
+// memSamples 是 ServeStats 读取的运行时内存指标。runtime/metrics 不会像 ReadMemStats 那样暂停程序。
+var memSamples = []string{"/memory/classes/heap/objects:bytes", "/memory/classes/total:bytes"}
+
+// readMemory 返回堆对象字节数与运行时内存总量。
+func readMemory() (heap, total uint64) {
+	s := make([]rtmetrics.Sample, len(memSamples))
+	for i, name := range memSamples {
+		s[i].Name = name
+	}
+	rtmetrics.Read(s)
+	if s[0].Value.Kind() == rtmetrics.KindUint64 {
+		heap = s[0].Value.Uint64()
+	}
+	if s[1].Value.Kind() == rtmetrics.KindUint64 {
+		total = s[1].Value.Uint64()
+	}
+	return heap, total
+}
+
+// ServeStats 管理接口：返回房间、订阅者、发布者总数与入站 RTP 字节/包数，以及运行时长、goroutine
+// 数量与内存占用（GET /api/stats）。只读取计数快照，可供监控面板频繁轮询；因暴露房间拓扑，需要管理员鉴权。
+func (h *HTTPHandlers) ServeStats(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "stats", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.adminReadOK(r) {
+		reject(w, "stats", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	heap, total := readMemory()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(statsResponse{
+		ServerStats:   h.mgr.Stats(),
+		UptimeSeconds: int64(time.Since(h.started).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		HeapBytes:     heap,
+		MemoryBytes:   total,
+	})
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"live-webrtc-go/internal/config"
+	"live-webrtc-go/internal/sfu"
+)
+
+// maxTURNUserLen 是临时 TURN 凭据中 user 部分的最大长度。
+const maxTURNUserLen = 64
+
+// iceServer 与浏览器 RTCIceServer 的字段一致，可直接传给 new RTCPeerConnection({iceServers})。
+type iceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// turnCredentials 是 GET /api/turn-credentials 的响应。
+type turnCredentials struct {
+	Username   string      `json:"username"`
+	Credential string      `json:"credential"`
+	TTL        int64       `json:"ttl"` // 有效期（秒）
+	ICEServers []iceServer `json:"iceServers"`
+}
+
+// ServeTURNCredentials 处理 GET /api/turn-credentials：通过鉴权（同推拉流，?room= 指定房间时
+// 按房间 Token 校验）后，用 TURN_STATIC_SECRET 按 coturn REST API 约定签发临时 TURN 凭据，
+// 并返回含 STUN 与 TURN 的 ICE 服务器列表。凭据中的 user 取已验证 JWT 的 sub 声明，没有时为空。
+// 未配置 TURN_STATIC_SECRET 时返回 404；该房间未配置任何凭据（无 AUTH_TOKEN、JWT 与房间 Token）时
+// 返回 403，避免任何人都能领取凭据、把 TURN 服务器变成开放中继。
+func (h *HTTPHandlers) ServeTURNCredentials(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "turn_credentials", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "turn_credentials", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if h.cfg.TURNStaticSecret == "" {
+		reject(w, "turn_credentials", "disabled", "turn credentials not configured", http.StatusNotFound)
+		return
+	}
+	room := r.URL.Query().Get("room")
+	if room != "" {
+		if !validRoom(w, "turn_credentials", room) {
+			return
+		}
+		if !h.roomAllowed(room) {
+			reject(w, "turn_credentials", "room_not_found", "room not found", http.StatusNotFound)
+			return
+		}
+	}
+	if !h.authRequired(room) {
+		reject(w, "turn_credentials", "forbidden", "turn credentials require authentication", http.StatusForbidden)
+		return
+	}
+	if !h.authOKRoom(r, room) {
+		reject(w, "turn_credentials", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var user string
+	if h.jwtEnabled() {
+		if claims, ok := h.jwtClaims(r); ok {
+			if sub, err := claims.GetSubject(); err == nil && sub != "" {
+				user = sub
+			}
+		}
+	}
+	if len(user) > maxTURNUserLen {
+		reject(w, "turn_credentials", "bad_request", "user too long", http.StatusBadRequest)
+		return
+	}
+	ttl := h.cfg.TURNCredentialTTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+	username, credential := sfu.TURNCredentials(h.cfg.TURNStaticSecret, user, ttl, time.Now())
+	resp := turnCredentials{
+		Username:   username,
+		Credential: credential,
+		TTL:        int64(ttl / time.Second),
+		ICEServers: h.iceServerList(true, username, credential),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// ServeICEServers 处理 GET /api/ice-servers：返回与 SFU 相同的 STUN/TURN 服务器（RTCIceServer 数组），
+// 供网页构造 RTCPeerConnection。TURN 使用静态账号（TURN_USERNAME/TURN_PASSWORD）时附带该账号；
+// 使用临时凭据（TURN_STATIC_SECRET）时不返回 TURN，客户端需经鉴权调用 /api/turn-credentials 获取。
+// 启用鉴权时与推拉流一样校验凭据（?room= 指定房间时按房间 Token），避免静态 TURN 密码被匿名领取。
+func (h *HTTPHandlers) ServeICEServers(w http.ResponseWriter, r *http.Request) {
+	h.allowCORS(w, r)
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if !isGet(r) {
+		reject(w, "ice_servers", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "ice_servers", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	room := r.URL.Query().Get("room")
+	if room != "" {
+		if !validRoom(w, "ice_servers", room) {
+			return
+		}
+		if !h.roomAllowed(room) {
+			reject(w, "ice_servers", "room_not_found", "room not found", http.StatusNotFound)
+			return
+		}
+	}
+	if h.authRequired(room) && !h.authOKRoom(r, room) {
+		reject(w, "ice_servers", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	static := h.cfg.TURNUsername != "" || h.cfg.TURNPassword != ""
+	servers := h.iceServerList(static || h.cfg.TURNStaticSecret == "", h.cfg.TURNUsername, h.cfg.TURNPassword)
+	if len(h.cfg.STUN) == 0 && len(h.cfg.TURN) == 0 && len(h.cfg.TURNFallback) == 0 {
+		servers = []iceServer{{URLs: []string{config.DefaultSTUNServer}}}
+	}
+	if servers == nil {
+		servers = []iceServer{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(servers)
+}
+
+// iceServerList 按配置列出 STUN 与 TURN（含备用 TURN）服务器；withTURN 为 false 时不含 TURN，
+// TURN 条目携带给定的凭据。
+func (h *HTTPHandlers) iceServerList(withTURN bool, username, credential string) []iceServer {
+	var out []iceServer
+	if len(h.cfg.STUN) > 0 {
+		out = append(out, iceServer{URLs: h.cfg.STUN})
+	}
+	if turn := append(append([]string{}, h.cfg.TURN...), h.cfg.TURNFallback...); withTURN && len(turn) > 0 {
+		out = append(out, iceServer{URLs: turn, Username: username, Credential: credential})
+	}
+	return out
+}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"live-webrtc-go/internal/metrics"
+	"live-webrtc-go/internal/sfu"
+)
+
+// wsCandidatePoll 是 WebSocket 信令向客户端推送服务端 ICE 候选时的轮询间隔。
+const wsCandidatePoll = 100 * time.Millisecond
+
+// wsAuthTimeout 是未带凭据的升级连接发送首条（带 token 的）消息的期限。连接被接管后
+// http.Server 的超时不再生效，超时未鉴权的连接被关闭，避免匿名客户端无限占用连接与 goroutine。
+var wsAuthTimeout = 10 * time.Second
+
+var (
+	// errWSMessage 表示客户端发送了当前阶段不支持的消息类型。
+	errWSMessage = errors.New("unexpected message type")
+	// errWSClientID 表示 subscribe 消息的 clientId 超过 maxClientIDLen。
+	errWSClientID = errors.New("client id too long")
+	// errWSUnauthorized 表示首条消息的 token 缺失或无效。
+	errWSUnauthorized = errors.New("unauthorized")
+	// errWSRateLimited 表示首条消息触发了推流或播放的专属限流。
+	errWSRateLimited = errors.New("too many requests")
+)
+
+// wsMessage 是 WebSocket 信令的 JSON 消息。客户端先发送 publish 或 subscribe（sdp 为 Offer），
+// 服务端回复 answer（含会话 ID）；之后双方以 candidate 消息交换 ICE 候选，服务端候选收集结束时
+// 发送 end-of-candidates。任何失败都以 error 消息告知。
+type wsMessage struct {
+	Type          string  `json:"type"`
+	SDP           string  `json:"sdp,omitempty"`
+	ID            string  `json:"id,omitempty"`
+	Candidate     string  `json:"candidate,omitempty"`     // 不含 "a=" 前缀，与浏览器 RTCIceCandidate.candidate 相同
+	SDPMid        string  `json:"sdpMid,omitempty"`        // 客户端候选所属的 mid，为空时归属第一个 m 段
+	SDPMLineIndex *uint16 `json:"sdpMLineIndex,omitempty"` // 服务端候选固定为 0（BUNDLE）
+	Publisher     string  `json:"publisher,omitempty"`     // subscribe：只订阅指定发布者，同 WHEP 的 ?publisher=
+	ClientID      string  `json:"clientId,omitempty"`      // subscribe：客户端标识，同 WHEP 的 X-Client-ID
+	Exclude       string  `json:"exclude,omitempty"`       // subscribe：不订阅该发布者，同 WHEP 的 ?exclude=
+	Token         string  `json:"token,omitempty"`         // publish/subscribe：鉴权 Token 或 JWT，升级请求未携带凭据时必填
+	Error         string  `json:"error,omitempty"`
+}
+
+// ServeWebSocket 处理 WebSocket 信令：GET /ws/{room} 升级为 WebSocket 后以 JSON 消息交换
+// Offer/Answer 与 trickle ICE 候选，供不便使用 HTTP POST 交换 SDP 的客户端使用，底层与 WHIP/WHEP
+// 共用同一套推拉流逻辑。浏览器无法为 WebSocket 设置请求头，升级请求不带凭据时在首条消息的 token
+// 字段中鉴权，避免 Token 出现在 URL（进而出现在代理与访问日志）中；?token= 仍兼容但不推荐。
+// 连接断开时关闭对应的推流或播放会话，效果与 ICE 失败相同。
+func (h *HTTPHandlers) ServeWebSocket(w http.ResponseWriter, r *http.Request, room string) {
+	if r.Method != http.MethodGet {
+		reject(w, "ws", "method", "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !validRoom(w, "ws", room) {
+		return
+	}
+	if !h.allowRate(r) {
+		reject(w, "ws", "rate_limited", "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	if !h.originOK(r) {
+		reject(w, "ws", "origin", "origin not allowed", http.StatusForbidden)
+		return
+	}
+	if !h.roomAllowed(room) {
+		reject(w, "ws", "room_not_found", "room not found", http.StatusNotFound)
+		return
+	}
+	if tok := r.URL.Query().Get("token"); tok != "" && r.Header.Get("Authorization") == "" {
+		r.Header.Set("Authorization", "Bearer "+tok)
+	}
+	// 升级请求带了凭据则立即校验；未带凭据且需要鉴权时，推迟到首条消息的 token 字段
+	authed := h.authOKRoom(r, room)
+	if !authed && hasCredentials(r) {
+		reject(w, "ws", "unauthorized", "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	srv := websocket.Server{
+		// Origin 已由 originOK 按 ALLOWED_ORIGIN/REQUIRE_ORIGIN 校验，这里不再使用库的默认检查
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler:   func(ws *websocket.Conn) { h.serveWSConn(ws, room, authed) },
+	}
+	srv.ServeHTTP(w, r)
+}
+
+// serveWSConn 完成一次协商并在连接存续期间转发候选；返回时关闭会话。authed 为 false 时
+// 首条消息需携带有效的 token。
+func (h *HTTPHandlers) serveWSConn(ws *websocket.Conn, room string, authed bool) {
+	defer ws.Close()
+	ws.MaxPayloadBytes = defaultMaxSDPBytes
+	if h.cfg.MaxSDPBytes > 0 {
+		ws.MaxPayloadBytes = h.cfg.MaxSDPBytes
+	}
+	if !authed {
+		_ = ws.SetReadDeadline(time.Now().Add(wsAuthTimeout))
+	}
+	var msg wsMessage
+	if err := websocket.JSON.Receive(ws, &msg); err != nil {
+		return // 客户端断开，或未带凭据且在 wsAuthTimeout 内没有发送首条消息
+	}
+	r := ws.Request()
+	if !authed {
+		r = r.Clone(r.Context())
+		r.Header.Set("Authorization", "Bearer "+msg.Token)
+		if msg.Token == "" || !h.authOKRoom(r, room) {
+			metrics.IncHTTPRejection("ws", "unauthorized")
+			_ = websocket.JSON.Send(ws, wsMessage{Type: "error", Error: errWSUnauthorized.Error()})
+			return
+		}
+		_ = ws.SetReadDeadline(time.Time{})
+	}
+	id, answer, err := h.negotiateWS(r, room, msg)
+	if err != nil {
+		metrics.IncHTTPRejection("ws", wsReason(err))
+		_ = websocket.JSON.Send(ws, wsMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	defer func() { _ = h.mgr.CloseSession(id) }()
+	if err := websocket.JSON.Send(ws, wsMessage{Type: "answer", SDP: answer, ID: id}); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	if h.cfg.TrickleICE {
+		go h.pushWSCandidates(ws, id, done)
+	}
+	for {
+		var m wsMessage
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			return // 客户端断开或发送了无法解析的消息
+		}
+		switch {
+		case m.Type != "candidate":
+			_ = websocket.JSON.Send(ws, wsMessage{Type: "error", Error: errWSMessage.Error() + ": " + m.Type})
+		case m.Candidate == "":
+			// 客户端候选收集结束（浏览器的空候选），无需处理
+		default:
+			frag := "a=" + strings.TrimPrefix(m.Candidate, "a=") + "\r\n"
+			if m.SDPMid != "" {
+				frag = "a=mid:" + m.SDPMid + "\r\n" + frag
+			}
+			if _, err := h.mgr.TrickleICE(id, frag); err != nil {
+				_ = websocket.JSON.Send(ws, wsMessage{Type: "error", Error: err.Error()})
+			}
+		}
+	}
+}
+
+// negotiateWS 按首条消息的类型推流或播放，返回会话 ID 与 SDP Answer。
+func (h *HTTPHandlers) negotiateWS(r *http.Request, room string, msg wsMessage) (string, string, error) {
+	// 升级前已按全局限流计过；类型确定后再套用推流/播放的专属限流（未单独配置时不再重复计数）
+	if l := h.wsLimiter(msg.Type); l != nil && !h.allowEndpointRate(r, l) {
+		return "", "", errWSRateLimited
+	}
+	ctx, cancel := h.answerContext(r)
+	defer cancel()
+	switch msg.Type {
+	case "publish":
+		answer, id, err := h.mgr.PublishWithID(ctx, room, msg.SDP)
+		return id, answer, err
+	case "subscribe":
+		if len(msg.ClientID) > maxClientIDLen {
+			return "", "", errWSClientID
+		}
+		res, err := h.mgr.SubscribeWith(ctx, room, msg.SDP, sfu.SubscribeOptions{Publisher: msg.Publisher, ClientID: msg.ClientID, Exclude: msg.Exclude})
+		return res.ID, res.Answer, err
+	}
+	return "", "", errWSMessage
+}
+
+// wsLimiter 返回首条消息类型对应的专属限流器：publish 同 WHIP，subscribe 同 WHEP。
+func (h *HTTPHandlers) wsLimiter(typ string) *ipLimiter {
+	switch typ {
+	case "publish":
+		return h.publishLimit
+	case "subscribe":
+		return h.playLimit
+	}
+	return nil
+}
+
+// pushWSCandidates 在开启 TRICKLE_ICE 时轮询会话的本地候选，把新增候选推送给客户端，
+// 收集结束后发送 end-of-candidates 并退出；done 关闭（连接结束）时提前退出。
+func (h *HTTPHandlers) pushWSCandidates(ws *websocket.Conn, id string, done <-chan struct{}) {
+	t := time.NewTicker(wsCandidatePoll)
+	defer t.Stop()
+	var zero uint16
+	sent := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		frag, err := h.mgr.TrickleICE(id, "")
+		if err != nil {
+			return
+		}
+		var cands []string
+		end := false
+		for _, line := range strings.Split(frag, "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "a=candidate:"):
+				cands = append(cands, strings.TrimPrefix(line, "a="))
+			case line == "a=end-of-candidates":
+				end = true
+			}
+		}
+		for ; sent < len(cands); sent++ {
+			if websocket.JSON.Send(ws, wsMessage{Type: "candidate", Candidate: cands[sent], SDPMLineIndex: &zero}) != nil {
+				return
+			}
+		}
+		if end {
+			_ = websocket.JSON.Send(ws, wsMessage{Type: "end-of-candidates"})
+			return
+		}
+	}
+}
+
+// wsReason 把协商错误映射为拒绝指标的 reason，取值与 WHIP/WHEP 一致。
+func wsReason(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, sfu.ErrRoomClosed), errors.Is(err, sfu.ErrPublisherExists):
+		return "conflict"
+	case errors.Is(err, sfu.ErrRoomFull), errors.Is(err, sfu.ErrEgressLimit):
+		return "capacity"
+	case errors.Is(err, sfu.ErrPublisherNotFound):
+		return "not_found"
+	case errors.Is(err, sfu.ErrInvalidSDP):
+		return "bad_sdp"
+	case errors.Is(err, errWSMessage), errors.Is(err, errWSClientID):
+		return "bad_request"
+	case errors.Is(err, errWSRateLimited):
+		return "rate_limited"
+	}
+	return "internal"
+}
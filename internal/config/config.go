@@ -2,28 +2,55 @@
 package config
 
 import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
 )
 
 // Config 汇总 HTTP 服务、SFU、录制、上传、鉴权等配置项。
 type Config struct {
     HTTPAddr          string            // HTTP 服务监听地址，例如 ":8080"
     AllowedOrigin     string            // 允许的跨域来源，"*" 表示全部
+    RequireOrigin     bool              // WHIP/WHEP 请求必须携带被允许的 Origin 头
     AuthToken         string            // 全局访问 Token（房间级优先）
     STUN              []string          // STUN 服务器 URL 列表
     TURN              []string          // TURN 服务器 URL 列表
+    TURNFallback      []string          // 备用 TURN 服务器 URL，主服务器分配失败时依次切换
     TLSCertFile       string            // TLS 证书文件路径（可选）
     TLSKeyFile        string            // TLS 私钥文件路径（可选）
+    TLSNextProtos     []string          // TLS ALPN 协议列表，例如仅 "http/1.1" 以禁用 HTTP/2；为空使用 Go 默认
+    TLSReloadInterval time.Duration     // 检查证书文件是否更新的间隔，0 表示仅在 SIGHUP 时重新加载
     RecordEnabled     bool              // 是否开启录制
     RecordDir         string            // 录制文件存储目录
-    MaxSubsPerRoom    int               // 每房间最大订阅者数（0 表示不限）
+    RecordFormat      string            // 录制格式：separate（音视频分别写 OGG/IVF）、audio（仅音频）或 webm（单个 WebM 文件），可按房间覆盖
+    MaxSubsPerRoom    int               // 每房间最大订阅者数（0 表示不限），按加权订阅者数计
+    MaxPublishersPerRoom int            // 每房间可同时推流的发布者数，默认 1
+    AudioOnlySubWeight float64         // 纯音频订阅者在 MAX_SUBS_PER_ROOM 中所占权重，(0,1]，默认 1
+    MaxEgressMbps     float64           // 全局出站码率上限（Mbit/s），超出时丢弃视频包并拒绝新订阅者，0 表示不限
+    WaitQueueSize     int               // 房间满员时等候室（SSE）的最大排队人数，0 表示关闭等候室
     RoomTokens        map[string]string // 房间级 Token 映射：room->token
     TURNUsername      string            // TURN 用户名
     TURNPassword      string            // TURN 密码
+    TURNStaticSecret  string            // 与 coturn static-auth-secret 相同的共享密钥，用于签发临时 TURN 凭据
+    TURNCredentialTTL time.Duration     // 临时 TURN 凭据的有效期，默认 24h
     UploadEnabled     bool              // 是否开启录制文件上传
     DeleteAfterUpload bool              // 上传成功后是否删除本地文件
+    StorageBackend    string            // 对象存储后端：s3（默认）、gcs 或 azure
+    UploadConcurrency int               // 同时进行的上传数上限
+    UploadSerialPerRoom bool            // 同一房间的录制文件是否按顺序逐个上传
+    UploadMaxAttempts int               // 单个文件的最大上传尝试次数，用尽后移入 failed/ 子目录
+    UploadRetryBackoff time.Duration    // 首次重试前的等待时间，之后每次翻倍
+    UploadShutdownTimeout time.Duration // 退出时等待排队与进行中上传完成的最长时间，0 表示不等待
     S3Endpoint        string            // 对象存储端点
     S3Region          string            // 对象存储区域（可选）
     S3Bucket          string            // 对象存储桶名
@@ -32,69 +59,576 @@ type Config struct {
     S3UseSSL          bool              // 是否使用 SSL 访问对象存储
     S3PathStyle       bool              // 是否使用 Path-Style 访问
     S3Prefix          string            // 上传时的对象名前缀
+    GCSCredentialsFile string           // GCS 服务账号 JSON 密钥文件（为空则不鉴权，适用于模拟器）
+    AzureAccount      string            // Azure 存储账户名
+    AzureKey          string            // Azure 存储账户共享密钥（Base64）
+    AzureSASToken     string            // Azure SAS 令牌（未配置共享密钥时使用）
     AdminToken        string            // 管理接口的 Token
+    ViewerAdminToken  string            // 只读管理接口的 Token：可查看上传队列与调试状态，不能关闭房间等
     RateLimitRPS      float64           // 每 IP 的速率限制（每秒请求数）
     RateLimitBurst    int               // 速率限制突发值
+    RateLimitPublishRPS   float64       // WHIP 推流（含迁移）专属的每 IP 限流，0 表示沿用全局限流
+    RateLimitPublishBurst int           // WHIP 推流限流突发值，默认同 RateLimitBurst
+    RateLimitPlayRPS      float64       // WHEP 播放专属的每 IP 限流，0 表示沿用全局限流
+    RateLimitPlayBurst    int           // WHEP 播放限流突发值，默认同 RateLimitBurst
+    TrustProxyHeaders     bool          // 限流时是否信任 X-Forwarded-For/X-Real-IP 识别客户端 IP（仅在反向代理后开启）
+    TrustedProxies        []*net.IPNet  // 可信反向代理的网段，只有来自这些地址的请求才采信代理头；为空时为回环与私有网段
+    RateLimitUnknownClient string       // 无法识别客户端 IP（如 Unix socket）时的限流方式：shared（共用一个令牌桶）或 skip（不限流）
+    MaxSDPBytes           int           // WHIP/WHEP 请求体（SDP Offer、sdpfrag）的最大字节数，超出返回 413
     JWTSecret         string            // JWT HMAC 密钥
+    JWTSecrets        map[string]string // 按 kid 区分的多个 JWT HMAC 密钥（kid->secret），用于密钥轮换
+    JWTPublicKeyFile  string            // 验证 RS*/ES* 签名 JWT 的 PEM 公钥文件路径，为空时只接受 HMAC
+    JWTPublicKey      crypto.PublicKey  `json:"-"` // 从 JWTPublicKeyFile 加载的 *rsa.PublicKey 或 *ecdsa.PublicKey
+    JWTAudience       string            // JWT 的 aud 须包含该值，为空时不校验
+    JWTIssuer         string            // JWT 的 iss 须等于该值，为空时不校验
+    JWTSkipExpiry     bool              // 不校验 JWT 的 exp/nbf（仅供教学演示），默认要求 exp 且未过期
     PprofEnabled      bool              // 是否启用 pprof 调试端点
+    EnableREDFEC      bool              // 是否协商音频 RED 与视频 ULPFEC 以增强抗丢包
+    SRTPProfiles      []string          // 允许协商的 DTLS-SRTP 保护配置（SRTP_* 名称），为空使用 pion 默认
+    DropCandidateCIDRs []*net.IPNet    // 从 Answer 与 trickle 候选中剔除地址落在这些网段内的 host 候选（如容器内网地址）
+    EnableRTCPRsize   bool              // Offer 支持时在 Answer 中声明 a=rtcp-rsize（reduced-size RTCP）
+    TrickleICE        bool              // Answer 不等待候选收集完成，其余候选经 PATCH 会话资源交换
+    E2EEPassthrough   bool              // 端到端加密媒体透传：不检查负载、不录制，按订阅者协商改写头扩展 ID
+    PublisherCNAME    bool              // 同一发布者的全部轨道在订阅者 SDP 中共用由会话 ID 派生的 CNAME（流 ID）
+    OpusMaxBitrate    int               // 发布者 Answer 中 Opus 的 maxaveragebitrate（bps，6000~510000），0 表示不限制
+    OpusPtime         int               // 发布者 Answer 中 Opus 的 ptime（毫秒，3~120），0 表示不写
+    OpusMaxPtime      int               // 发布者 Answer 中 Opus 的 maxptime（毫秒，3~120），0 表示不写
+    MaxFramerate      int               // 发布者 Answer 视频段的 a=framerate 上限（1~120），仅为协商提示，0 表示不写
+    MaxVideoWidth     int               // Answer 视频段 a=imageattr 的宽度上限（像素），需与 MaxVideoHeight 同时设置，0 表示不写
+    MaxVideoHeight    int               // Answer 视频段 a=imageattr 的高度上限（像素）
+    StrictSDP         bool              // 是否拒绝含 a=inactive 或 a=bundle-only m-line 的 Offer
+    AnswerAudioFirst  bool              // 是否在 Answer 中把音频 m-line 排在最前（兼容挑剔的客户端）
+    MidScheme         string            // 发布者轨道的服务端 mid 命名：kind（audio/video）、index（0/1）；为空沿用客户端的 mid
+    AnswerTimeout     time.Duration     // 推拉流协商（Offer 到 Answer）的最长等待时间，超时返回 504（0 表示不限）
+    ICEDisconnectGrace time.Duration    // 发布者 ICE 断开后等待恢复的宽限期，超时才关闭（0 表示立即关闭）
+    AllowPublisherTakeover bool         // 现有发布者 ICE 为 Disconnected/Failed 时允许新推流直接顶替
+    SessionMaxLifetime time.Duration    // 发布者/订阅者会话的最长存续时间，到期强制关闭（0 表示不限）
+    SubscriberWriteTimeout time.Duration // 订阅者单次 RTP 写入阻塞超过该时长即判定卡死并移除（0 表示不检测）
+    SubscriberResumeTTL time.Duration   // 断线订阅者会话的保留时长，期间可凭恢复令牌重连（0 表示不保留）
+    RoomHealthMaxAge  time.Duration     // 房间健康检查允许的最长无 RTP 时长
+    TrackStallTimeout time.Duration     // 轨道超过该时长未收到 RTP 即判定卡顿（0 表示不检测）
+    PLIInterval       time.Duration     // 有订阅者时周期性请求关键帧（PLI）的间隔，0 表示只在观众加入时请求
+    MetricsLogInterval time.Duration    // 周期性输出 JSON 指标汇总日志的间隔，0 表示关闭
+    StallClosePublisher bool            // 检测到卡顿时是否关闭发布者以促使其重新推流
+    ConnectBuckets    []float64         // 建连耗时直方图的桶（秒），为空使用默认值
+    MetricsRoomAllowlist []string       // 指标中保留独立 room 标签的房间，其余聚合为 "__other__"；为空不限制
+    RoomStateFile     string            // 房间状态持久化文件路径（为空则不持久化）
+    RequireProvisionedRooms bool        // 仅允许向已配置 Token 或管理员预置的房间推拉流
+    RecordDirectUpload bool             // 录制直接以分片上传写入对象存储（仅 S3），不在本地落盘
+    RecordSidecar     bool              // 录制结束时是否写出 .json 统计旁路文件
+    RecordSegmentDuration time.Duration // 分段录制：每段的最长时长，0 表示不按时长切分（仅 separate/audio 格式）
+    RecordSegmentSizeMB   int           // 分段录制：每段的最大大小（MB），0 表示不按大小切分
+    RecordTrimStart   bool              // 录制从首个关键帧（视频）或首个非静音包（音频）开始，跳过开头的黑屏与静音
+    RootMode          string            // 根路径 "/" 的行为：redirect、json 或 404
+    RootRedirect      string            // RootMode=redirect 时的跳转目标
+    ServerIdleExit    time.Duration     // 无请求且无活跃房间持续该时长后进程自动退出（0 表示不退出）
+    ShutdownDrainDelay time.Duration    // 退出时 /readyz 转为 503 后等待该时长再停止 HTTP，留给负载均衡摘除（0 表示不等待）
+    LogFile           string            // 日志文件路径（为空输出到 stderr），SIGHUP 时重新打开
+    LogFormat         string            // 访问日志格式：json（默认）或 text，输出到 stdout
+    LogLevel          string            // 访问日志最低级别：debug、info（默认）、warn 或 error
+}
+
+// 录制格式取值。
+const (
+	RecordFormatSeparate = "separate" // 音频写 OGG、视频写 IVF
+	RecordFormatAudio    = "audio"    // 仅录制音频
+	RecordFormatWebM     = "webm"     // 音视频封装进同一个 WebM 文件（Opus + VP8/VP9）
+)
+
+// ValidRecordFormat 报告 f 是否为支持的录制格式。
+func ValidRecordFormat(f string) bool {
+	return f == RecordFormatSeparate || f == RecordFormatAudio || f == RecordFormatWebM
+}
+
+// DefaultSTUNServer 是未配置 STUN_URLS 时使用的 STUN 服务器；SFU 与 /api/ice-servers 在
+// STUN/TURN 均被清空时也回退到它。
+const DefaultSTUNServer = "stun:stun.l.google.com:19302"
+
+// MaxRoomNameLen 是房间名的最大长度（字节）。
+const MaxRoomNameLen = 64
+
+var roomNameRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ValidateRoomName 校验房间名：非空、不超过 MaxRoomNameLen，且只含字母、数字、"-" 与 "_"。
+// 房间名会出现在 URL 路径、录制文件名与指标标签中，各 HTTP 处理函数统一据此拒绝不合法的名称。
+func ValidateRoomName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("room name is empty")
+	case len(name) > MaxRoomNameLen:
+		return fmt.Errorf("room name longer than %d bytes", MaxRoomNameLen)
+	case !roomNameRe.MatchString(name):
+		return errors.New("room name may only contain letters, digits, '-' and '_'")
+	}
+	return nil
+}
+
+// SRTPProfileNames 是 SRTP_PROFILES 支持的 DTLS-SRTP 保护配置名称（RFC 5764 / RFC 7714）。
+var SRTPProfileNames = []string{
+	"SRTP_AEAD_AES_256_GCM",
+	"SRTP_AEAD_AES_128_GCM",
+	"SRTP_AES128_CM_HMAC_SHA1_80",
+	"SRTP_AES128_CM_HMAC_SHA1_32",
 }
 
+// 发布者轨道的稳定 mid 命名方案。
+const (
+	MidSchemeKind  = "kind"  // 按媒体类型命名：audio、video，同类多路依次为 audio1、video1
+	MidSchemeIndex = "index" // 按 m-line 顺序命名：0、1、2
+)
+
+// RATE_LIMIT_UNKNOWN_CLIENT 取值：无法识别客户端 IP 的请求如何限流。
+const (
+	UnknownClientShared = "shared" // 共用一个令牌桶
+	UnknownClientSkip   = "skip"   // 不限流
+)
+
 // Load 会读取环境变量并填充 Config，使用合理的默认值。
-// Load 从环境变量读取配置项并设置默认值，适合教学演示环境。
+// Load 从环境变量读取配置项并设置默认值，适合教学演示环境；无法解析的值会被忽略并回退到默认值。
 func Load() *Config {
+	c, _ := load()
+	return c
+}
+
+// LoadStrict 与 Load 相同，但会报告所有无法解析或取值非法的环境变量，
+// 便于启动时快速失败，而不是带着意料之外的默认值运行。
+func LoadStrict() (*Config, error) {
+	c, errs := load()
+	return c, errors.Join(errs...)
+}
+
+// redactedValue 替换 Redacted 输出中已配置的密钥。
+const redactedValue = "[redacted]"
+
+// Redacted 返回配置的副本，其中 Token、密码与密钥等已配置的敏感项替换为 "[redacted]"，
+// 未配置的保持为空，便于调试接口输出而不泄露凭据。
+func (c *Config) Redacted() Config {
+	out := *c
+	for _, s := range []*string{&out.AuthToken, &out.TURNPassword, &out.TURNStaticSecret, &out.S3SecretKey, &out.AzureKey,
+		&out.AzureSASToken, &out.AdminToken, &out.ViewerAdminToken, &out.JWTSecret} {
+		if *s != "" {
+			*s = redactedValue
+		}
+	}
+	if c.RoomTokens != nil {
+		out.RoomTokens = make(map[string]string, len(c.RoomTokens))
+		for room := range c.RoomTokens {
+			out.RoomTokens[room] = redactedValue
+		}
+	}
+	if c.JWTSecrets != nil {
+		out.JWTSecrets = make(map[string]string, len(c.JWTSecrets))
+		for kid := range c.JWTSecrets {
+			out.JWTSecrets[kid] = redactedValue
+		}
+	}
+	return out
+}
+
+// JWTEnabled 报告是否配置了验证 JWT 的密钥（JWT_SECRET、JWT_SECRETS 或 JWT_PUBLIC_KEY_FILE）。
+func (c *Config) JWTEnabled() bool {
+	return c.JWTSecret != "" || len(c.JWTSecrets) > 0 || c.JWTPublicKey != nil
+}
+
+func load() (*Config, []error) {
+    var errs []error
     c := &Config{
         HTTPAddr:      getEnv("HTTP_ADDR", ":8080"),
         AllowedOrigin: getEnv("ALLOWED_ORIGIN", "*"),
-        AuthToken:     getEnv("AUTH_TOKEN", ""),
+        AuthToken:     envSecret(&errs, "AUTH_TOKEN"),
     }
     if v := os.Getenv("STUN_URLS"); v != "" {
         c.STUN = splitCSV(v)
     } else {
-        c.STUN = []string{"stun:stun.l.google.com:19302"}
+        c.STUN = []string{DefaultSTUNServer}
     }
 	if v := os.Getenv("TURN_URLS"); v != "" {
 		c.TURN = splitCSV(v)
 	}
+	if v := os.Getenv("TURN_FALLBACK_URLS"); v != "" {
+		c.TURNFallback = splitCSV(v)
+	}
+	c.RequireOrigin = getEnv("REQUIRE_ORIGIN", "") == "1"
 	c.TURNUsername = getEnv("TURN_USERNAME", "")
-	c.TURNPassword = getEnv("TURN_PASSWORD", "")
+	c.TURNPassword = envSecret(&errs, "TURN_PASSWORD")
+	c.TURNStaticSecret = envSecret(&errs, "TURN_STATIC_SECRET")
+	c.TURNCredentialTTL = envDuration(&errs, "TURN_CREDENTIAL_TTL", 24*time.Hour)
+	if c.TURNCredentialTTL <= 0 {
+		errs = append(errs, envError("TURN_CREDENTIAL_TTL", c.TURNCredentialTTL.String(), errors.New("must be positive")))
+		c.TURNCredentialTTL = 24 * time.Hour
+	}
 	c.TLSCertFile = getEnv("TLS_CERT_FILE", "")
 	c.TLSKeyFile = getEnv("TLS_KEY_FILE", "")
+	c.TLSReloadInterval = envDuration(&errs, "TLS_RELOAD_INTERVAL", time.Minute)
+	if c.TLSReloadInterval < 0 {
+		errs = append(errs, envError("TLS_RELOAD_INTERVAL", c.TLSReloadInterval.String(), errors.New("must not be negative")))
+		c.TLSReloadInterval = time.Minute
+	}
+	if v := os.Getenv("TLS_NEXT_PROTOS"); v != "" {
+		c.TLSNextProtos = splitCSV(v)
+	}
 	c.RecordEnabled = getEnv("RECORD_ENABLED", "") == "1"
 	c.RecordDir = getEnv("RECORD_DIR", "records")
-	if v := getEnv("MAX_SUBS_PER_ROOM", "0"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			c.MaxSubsPerRoom = n
-		}
+	c.RecordFormat = strings.ToLower(getEnv("RECORD_FORMAT", RecordFormatSeparate))
+	if !ValidRecordFormat(c.RecordFormat) {
+		errs = append(errs, envError("RECORD_FORMAT", c.RecordFormat, errors.New("must be separate, audio or webm")))
+		c.RecordFormat = RecordFormatSeparate
+	}
+	c.RecordSidecar = getEnv("RECORD_SIDECAR", "") == "1"
+	c.RecordDirectUpload = getEnv("RECORD_DIRECT_UPLOAD", "") == "1"
+	c.RecordTrimStart = getEnv("RECORD_TRIM_START", "") == "1"
+	c.RecordSegmentDuration = envDuration(&errs, "RECORD_SEGMENT_DURATION", 0)
+	if c.RecordSegmentDuration < 0 {
+		errs = append(errs, envError("RECORD_SEGMENT_DURATION", c.RecordSegmentDuration.String(), errors.New("must not be negative")))
+		c.RecordSegmentDuration = 0
 	}
+	c.RecordSegmentSizeMB = envInt(&errs, "RECORD_SEGMENT_SIZE_MB", 0)
+	if c.RecordSegmentSizeMB < 0 {
+		errs = append(errs, envError("RECORD_SEGMENT_SIZE_MB", strconv.Itoa(c.RecordSegmentSizeMB), errors.New("must not be negative")))
+		c.RecordSegmentSizeMB = 0
+	}
+	c.MaxSubsPerRoom = envInt(&errs, "MAX_SUBS_PER_ROOM", 0)
+	c.MaxPublishersPerRoom = envInt(&errs, "MAX_PUBLISHERS_PER_ROOM", 1)
+	if c.MaxPublishersPerRoom < 1 {
+		errs = append(errs, envError("MAX_PUBLISHERS_PER_ROOM", strconv.Itoa(c.MaxPublishersPerRoom), errors.New("must be at least 1")))
+		c.MaxPublishersPerRoom = 1
+	}
+	c.AudioOnlySubWeight = envFloat(&errs, "AUDIO_ONLY_SUB_WEIGHT", 1)
+	if c.AudioOnlySubWeight <= 0 || c.AudioOnlySubWeight > 1 {
+		errs = append(errs, envError("AUDIO_ONLY_SUB_WEIGHT", strconv.FormatFloat(c.AudioOnlySubWeight, 'g', -1, 64), errors.New("must be in (0, 1]")))
+		c.AudioOnlySubWeight = 1
+	}
+	c.MaxEgressMbps = envFloat(&errs, "MAX_EGRESS_MBPS", 0)
+	if c.MaxEgressMbps < 0 {
+		errs = append(errs, envError("MAX_EGRESS_MBPS", strconv.FormatFloat(c.MaxEgressMbps, 'g', -1, 64), errors.New("must not be negative")))
+		c.MaxEgressMbps = 0
+	}
+	c.WaitQueueSize = envInt(&errs, "WAIT_QUEUE_SIZE", 100)
 	if v := os.Getenv("ROOM_TOKENS"); v != "" {
 		c.RoomTokens = parseRoomTokens(v)
 	} else {
 		c.RoomTokens = map[string]string{}
 	}
+	if v := os.Getenv("ROOM_TOKENS_JSON"); v != "" {
+		if m, err := parseRoomTokensJSON(v); err == nil {
+			for k, tok := range m {
+				c.RoomTokens[k] = tok
+			}
+		} else {
+			errs = append(errs, fmt.Errorf("ROOM_TOKENS_JSON: %w", err))
+		}
+	}
+	// 不合法的房间名永远无法通过 ValidateRoomName，对应的 Token 不会生效
+	for room := range c.RoomTokens {
+		if err := ValidateRoomName(room); err != nil {
+			errs = append(errs, envError("ROOM_TOKENS", room, err))
+			delete(c.RoomTokens, room)
+		}
+	}
 	c.UploadEnabled = getEnv("UPLOAD_RECORDINGS", "") == "1"
 	c.DeleteAfterUpload = getEnv("DELETE_RECORDING_AFTER_UPLOAD", "") == "1"
 	c.S3Endpoint = getEnv("S3_ENDPOINT", "")
 	c.S3Region = getEnv("S3_REGION", "")
 	c.S3Bucket = getEnv("S3_BUCKET", "")
 	c.S3AccessKey = getEnv("S3_ACCESS_KEY", "")
-	c.S3SecretKey = getEnv("S3_SECRET_KEY", "")
+	c.S3SecretKey = envSecret(&errs, "S3_SECRET_KEY")
 	c.S3UseSSL = getEnv("S3_USE_SSL", "1") == "1"
 	c.S3PathStyle = getEnv("S3_PATH_STYLE", "") == "1"
 	c.S3Prefix = getEnv("S3_PREFIX", "")
-	c.AdminToken = getEnv("ADMIN_TOKEN", "")
-	if v := getEnv("RATE_LIMIT_RPS", "0"); v != "" {
-		if f, err := strconv.ParseFloat(v, 64); err == nil {
-			c.RateLimitRPS = f
+	c.StorageBackend = strings.ToLower(getEnv("STORAGE_BACKEND", "s3"))
+	if c.StorageBackend != "s3" && c.StorageBackend != "gcs" && c.StorageBackend != "azure" {
+		errs = append(errs, envError("STORAGE_BACKEND", c.StorageBackend, errors.New("must be s3, gcs or azure")))
+	}
+	c.UploadConcurrency = envInt(&errs, "UPLOAD_CONCURRENCY", 4)
+	if c.UploadConcurrency <= 0 {
+		errs = append(errs, envError("UPLOAD_CONCURRENCY", strconv.Itoa(c.UploadConcurrency), errors.New("must be positive")))
+		c.UploadConcurrency = 4
+	}
+	c.UploadSerialPerRoom = getEnv("UPLOAD_SERIAL_PER_ROOM", "") == "1"
+	c.UploadMaxAttempts = envInt(&errs, "UPLOAD_MAX_ATTEMPTS", 3)
+	if c.UploadMaxAttempts <= 0 {
+		errs = append(errs, envError("UPLOAD_MAX_ATTEMPTS", strconv.Itoa(c.UploadMaxAttempts), errors.New("must be positive")))
+		c.UploadMaxAttempts = 3
+	}
+	c.UploadRetryBackoff = envDuration(&errs, "UPLOAD_RETRY_BACKOFF", 2*time.Second)
+	if c.UploadRetryBackoff <= 0 {
+		errs = append(errs, envError("UPLOAD_RETRY_BACKOFF", c.UploadRetryBackoff.String(), errors.New("must be positive")))
+		c.UploadRetryBackoff = 2 * time.Second
+	}
+	c.UploadShutdownTimeout = envDuration(&errs, "UPLOAD_SHUTDOWN_TIMEOUT", 30*time.Second)
+	if c.UploadShutdownTimeout < 0 {
+		errs = append(errs, envError("UPLOAD_SHUTDOWN_TIMEOUT", c.UploadShutdownTimeout.String(), errors.New("must not be negative")))
+		c.UploadShutdownTimeout = 30 * time.Second
+	}
+	c.GCSCredentialsFile = getEnv("GCS_CREDENTIALS_FILE", "")
+	c.AzureAccount = getEnv("AZURE_STORAGE_ACCOUNT", "")
+	c.AzureKey = envSecret(&errs, "AZURE_STORAGE_KEY")
+	c.AzureSASToken = getEnv("AZURE_STORAGE_SAS_TOKEN", "")
+	c.AdminToken = envSecret(&errs, "ADMIN_TOKEN")
+	c.ViewerAdminToken = envSecret(&errs, "VIEWER_ADMIN_TOKEN")
+	c.RateLimitRPS = envFloat(&errs, "RATE_LIMIT_RPS", 0)
+	c.RateLimitBurst = envInt(&errs, "RATE_LIMIT_BURST", 0)
+	c.RateLimitPublishRPS = envFloat(&errs, "RATE_LIMIT_PUBLISH_RPS", 0)
+	c.RateLimitPublishBurst = envInt(&errs, "RATE_LIMIT_PUBLISH_BURST", c.RateLimitBurst)
+	c.RateLimitPlayRPS = envFloat(&errs, "RATE_LIMIT_PLAY_RPS", 0)
+	c.RateLimitPlayBurst = envInt(&errs, "RATE_LIMIT_PLAY_BURST", c.RateLimitBurst)
+	c.TrustProxyHeaders = getEnv("TRUST_PROXY_HEADERS", "") == "1"
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		for _, s := range splitCSV(v) {
+			n, err := parseCIDR(s)
+			if err != nil {
+				errs = append(errs, envError("TRUSTED_PROXIES", s, errors.New("invalid CIDR or IP")))
+				continue
+			}
+			c.TrustedProxies = append(c.TrustedProxies, n)
 		}
 	}
-	if v := getEnv("RATE_LIMIT_BURST", "0"); v != "" {
-		if n, err := strconv.Atoi(v); err == nil {
-			c.RateLimitBurst = n
+	c.RateLimitUnknownClient = getEnv("RATE_LIMIT_UNKNOWN_CLIENT", UnknownClientShared)
+	if c.RateLimitUnknownClient != UnknownClientShared && c.RateLimitUnknownClient != UnknownClientSkip {
+		errs = append(errs, envError("RATE_LIMIT_UNKNOWN_CLIENT", c.RateLimitUnknownClient, errors.New("must be shared or skip")))
+		c.RateLimitUnknownClient = UnknownClientShared
+	}
+	c.MaxSDPBytes = envInt(&errs, "MAX_SDP_BYTES", 256<<10)
+	if c.MaxSDPBytes <= 0 {
+		errs = append(errs, envError("MAX_SDP_BYTES", strconv.Itoa(c.MaxSDPBytes), errors.New("must be positive")))
+		c.MaxSDPBytes = 256 << 10
+	}
+	c.JWTSecret = envSecret(&errs, "JWT_SECRET")
+	if v := envSecret(&errs, "JWT_SECRETS"); v != "" {
+		m, err := parseJWTSecrets(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("JWT_SECRETS: %w", err))
 		}
+		c.JWTSecrets = m
 	}
-	c.JWTSecret = getEnv("JWT_SECRET", "")
+	c.JWTPublicKeyFile = getEnv("JWT_PUBLIC_KEY_FILE", "")
+	if c.JWTPublicKeyFile != "" {
+		key, err := loadJWTPublicKey(c.JWTPublicKeyFile)
+		if err != nil {
+			errs = append(errs, envError("JWT_PUBLIC_KEY_FILE", c.JWTPublicKeyFile, err))
+		}
+		c.JWTPublicKey = key
+	}
+	c.JWTAudience = getEnv("JWT_EXPECTED_AUDIENCE", getEnv("JWT_AUDIENCE", ""))
+	c.JWTIssuer = getEnv("JWT_EXPECTED_ISSUER", "")
+	c.JWTSkipExpiry = getEnv("JWT_SKIP_EXPIRY", "") == "1"
 	c.PprofEnabled = getEnv("PPROF", "") == "1"
-	return c
+	c.RootMode = strings.ToLower(getEnv("ROOT_MODE", "redirect"))
+	if c.RootMode != "redirect" && c.RootMode != "json" && c.RootMode != "404" {
+		errs = append(errs, envError("ROOT_MODE", c.RootMode, errors.New("must be redirect, json or 404")))
+	}
+	c.RootRedirect = getEnv("ROOT_REDIRECT", "/web/index.html")
+	c.LogFile = getEnv("LOG_FILE", "")
+	c.LogFormat = strings.ToLower(getEnv("LOG_FORMAT", "json"))
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		errs = append(errs, envError("LOG_FORMAT", c.LogFormat, errors.New("must be json or text")))
+		c.LogFormat = "json"
+	}
+	c.LogLevel = strings.ToLower(getEnv("LOG_LEVEL", "info"))
+	switch c.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		errs = append(errs, envError("LOG_LEVEL", c.LogLevel, errors.New("must be debug, info, warn or error")))
+		c.LogLevel = "info"
+	}
+	c.EnableREDFEC = getEnv("ENABLE_RED_FEC", "") == "1"
+	c.AnswerAudioFirst = getEnv("ANSWER_AUDIO_FIRST", "") == "1"
+	c.StrictSDP = getEnv("STRICT_SDP", "") == "1"
+	c.EnableRTCPRsize = getEnv("ENABLE_RTCP_RSIZE", "") == "1"
+	c.TrickleICE = getEnv("TRICKLE_ICE", "") == "1"
+	c.E2EEPassthrough = getEnv("E2EE_PASSTHROUGH", "") == "1"
+	c.PublisherCNAME = getEnv("PUBLISHER_CNAME", "") == "1"
+	if v := os.Getenv("SRTP_PROFILES"); v != "" {
+		for _, p := range splitCSV(strings.ToUpper(v)) {
+			if !slices.Contains(SRTPProfileNames, p) {
+				errs = append(errs, envError("SRTP_PROFILES", p, errors.New("unknown SRTP protection profile")))
+				continue
+			}
+			c.SRTPProfiles = append(c.SRTPProfiles, p)
+		}
+	}
+	if v := os.Getenv("DROP_CANDIDATE_CIDRS"); v != "" {
+		for _, s := range splitCSV(v) {
+			n, err := parseCIDR(s)
+			if err != nil {
+				errs = append(errs, envError("DROP_CANDIDATE_CIDRS", s, errors.New("invalid CIDR or IP")))
+				continue
+			}
+			c.DropCandidateCIDRs = append(c.DropCandidateCIDRs, n)
+		}
+	}
+	c.OpusMaxBitrate = envInt(&errs, "OPUS_MAX_BITRATE", 0)
+	if c.OpusMaxBitrate != 0 && (c.OpusMaxBitrate < 6000 || c.OpusMaxBitrate > 510000) {
+		errs = append(errs, envError("OPUS_MAX_BITRATE", strconv.Itoa(c.OpusMaxBitrate), errors.New("must be between 6000 and 510000")))
+		c.OpusMaxBitrate = 0
+	}
+	c.OpusPtime = envInt(&errs, "OPUS_PTIME", 0)
+	if c.OpusPtime != 0 && (c.OpusPtime < 3 || c.OpusPtime > 120) {
+		errs = append(errs, envError("OPUS_PTIME", strconv.Itoa(c.OpusPtime), errors.New("must be between 3 and 120")))
+		c.OpusPtime = 0
+	}
+	c.OpusMaxPtime = envInt(&errs, "OPUS_MAXPTIME", 0)
+	if c.OpusMaxPtime != 0 && (c.OpusMaxPtime < 3 || c.OpusMaxPtime > 120 || c.OpusMaxPtime < c.OpusPtime) {
+		errs = append(errs, envError("OPUS_MAXPTIME", strconv.Itoa(c.OpusMaxPtime), errors.New("must be between 3 and 120 and not below OPUS_PTIME")))
+		c.OpusMaxPtime = 0
+	}
+	c.MaxFramerate = envInt(&errs, "MAX_FRAMERATE", 0)
+	if c.MaxFramerate < 0 || c.MaxFramerate > 120 {
+		errs = append(errs, envError("MAX_FRAMERATE", strconv.Itoa(c.MaxFramerate), errors.New("must be between 0 and 120")))
+		c.MaxFramerate = 0
+	}
+	c.MaxVideoWidth = envInt(&errs, "MAX_VIDEO_WIDTH", 0)
+	c.MaxVideoHeight = envInt(&errs, "MAX_VIDEO_HEIGHT", 0)
+	switch {
+	case c.MaxVideoWidth < 0 || c.MaxVideoWidth > 65535:
+		errs = append(errs, envError("MAX_VIDEO_WIDTH", strconv.Itoa(c.MaxVideoWidth), errors.New("must be between 0 and 65535")))
+		c.MaxVideoWidth, c.MaxVideoHeight = 0, 0
+	case c.MaxVideoHeight < 0 || c.MaxVideoHeight > 65535:
+		errs = append(errs, envError("MAX_VIDEO_HEIGHT", strconv.Itoa(c.MaxVideoHeight), errors.New("must be between 0 and 65535")))
+		c.MaxVideoWidth, c.MaxVideoHeight = 0, 0
+	case (c.MaxVideoWidth == 0) != (c.MaxVideoHeight == 0):
+		errs = append(errs, envError("MAX_VIDEO_HEIGHT", strconv.Itoa(c.MaxVideoHeight), errors.New("must be set together with MAX_VIDEO_WIDTH")))
+		c.MaxVideoWidth, c.MaxVideoHeight = 0, 0
+	}
+	c.MidScheme = strings.ToLower(getEnv("MID_SCHEME", ""))
+	if c.MidScheme != "" && c.MidScheme != MidSchemeKind && c.MidScheme != MidSchemeIndex {
+		errs = append(errs, envError("MID_SCHEME", c.MidScheme, errors.New("must be kind or index")))
+		c.MidScheme = ""
+	}
+	// pion v3 只记录 bundle 策略，每个连接始终只建立一个 ICE/DTLS 传输：max-bundle 即实际行为，
+	// 接受为空操作；balanced 与 max-compat 无法实现，报错而不是静默忽略
+	switch v := strings.ToLower(getEnv("BUNDLE_POLICY", "")); v {
+	case "", "max-bundle":
+	case "balanced", "max-compat":
+		errs = append(errs, envError("BUNDLE_POLICY", v, errors.New("not supported, every connection uses a single bundled transport")))
+	default:
+		errs = append(errs, envError("BUNDLE_POLICY", v, errors.New("must be max-bundle")))
+	}
+	c.AnswerTimeout = envDuration(&errs, "ANSWER_TIMEOUT", 0)
+	c.ICEDisconnectGrace = envDuration(&errs, "ICE_DISCONNECT_GRACE", 5*time.Second)
+	c.AllowPublisherTakeover = getEnv("ALLOW_PUBLISHER_TAKEOVER", "") == "1"
+	c.SessionMaxLifetime = envDuration(&errs, "SESSION_MAX_LIFETIME", 0)
+	c.SubscriberResumeTTL = envDuration(&errs, "SUBSCRIBER_RESUME_TTL", 0)
+	c.SubscriberWriteTimeout = envDuration(&errs, "SUBSCRIBER_WRITE_TIMEOUT", 0)
+	c.RoomHealthMaxAge = envDuration(&errs, "ROOM_HEALTH_MAX_AGE", 5*time.Second)
+	c.TrackStallTimeout = envDuration(&errs, "TRACK_STALL_TIMEOUT", 0)
+	c.PLIInterval = envDuration(&errs, "PLI_INTERVAL", 2*time.Second)
+	if c.PLIInterval < 0 {
+		errs = append(errs, envError("PLI_INTERVAL", c.PLIInterval.String(), errors.New("must not be negative")))
+		c.PLIInterval = 2 * time.Second
+	}
+	c.MetricsLogInterval = envDuration(&errs, "METRICS_LOG_INTERVAL", 0)
+	if c.MetricsLogInterval < 0 {
+		errs = append(errs, envError("METRICS_LOG_INTERVAL", c.MetricsLogInterval.String(), errors.New("must not be negative")))
+		c.MetricsLogInterval = 0
+	}
+	c.ServerIdleExit = envDuration(&errs, "SERVER_IDLE_EXIT", 0)
+	c.ShutdownDrainDelay = envDuration(&errs, "SHUTDOWN_DRAIN_DELAY", 5*time.Second)
+	if c.ShutdownDrainDelay < 0 {
+		errs = append(errs, envError("SHUTDOWN_DRAIN_DELAY", c.ShutdownDrainDelay.String(), errors.New("must not be negative")))
+		c.ShutdownDrainDelay = 5 * time.Second
+	}
+	c.StallClosePublisher = getEnv("STALL_CLOSE_PUBLISHER", "") == "1"
+	if v := os.Getenv("METRICS_ROOM_ALLOWLIST"); v != "" {
+		for _, room := range splitCSV(v) {
+			if err := ValidateRoomName(room); err != nil {
+				errs = append(errs, envError("METRICS_ROOM_ALLOWLIST", room, err))
+				continue
+			}
+			c.MetricsRoomAllowlist = append(c.MetricsRoomAllowlist, room)
+		}
+	}
+	if v := os.Getenv("METRICS_CONNECT_BUCKETS"); v != "" {
+		var bad []string
+		c.ConnectBuckets, bad = parseFloats(v)
+		for _, b := range bad {
+			errs = append(errs, envError("METRICS_CONNECT_BUCKETS", b, errors.New("invalid number")))
+		}
+		// 直方图要求桶边界严格递增，否则注册时 panic；非法时回退为默认桶
+		for i := 1; i < len(c.ConnectBuckets); i++ {
+			if c.ConnectBuckets[i] <= c.ConnectBuckets[i-1] {
+				errs = append(errs, envError("METRICS_CONNECT_BUCKETS", v, errors.New("buckets must be strictly increasing")))
+				c.ConnectBuckets = nil
+				break
+			}
+		}
+	}
+	c.RoomStateFile = getEnv("ROOM_STATE_FILE", "")
+	c.RequireProvisionedRooms = getEnv("REQUIRE_PROVISIONED_ROOMS", "") == "1"
+	return c, errs
+}
+
+// parseCIDR 解析 CIDR 网段；单个 IP 视为仅含该地址的网段（/32 或 /128）。
+func parseCIDR(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 8 * net.IPv6len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// envError 描述一个无法使用的环境变量取值。
+func envError(k, v string, err error) error {
+	return fmt.Errorf("%s=%q: %w", k, v, err)
+}
+
+// envInt 读取整数环境变量；未设置时返回默认值，解析失败时同样回退默认值并记录错误。
+func envInt(errs *[]error, k string, d int) int {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		*errs = append(*errs, envError(k, v, errors.New("invalid integer")))
+		return d
+	}
+	return n
+}
+
+// envFloat 读取浮点数环境变量，规则同 envInt。
+func envFloat(errs *[]error, k string, d float64) float64 {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		*errs = append(*errs, envError(k, v, errors.New("invalid number")))
+		return d
+	}
+	return f
+}
+
+// envDuration 读取时长环境变量（如 "10s"），规则同 envInt。
+func envDuration(errs *[]error, k string, d time.Duration) time.Duration {
+	v := os.Getenv(k)
+	if v == "" {
+		return d
+	}
+	dur, err := time.ParseDuration(v)
+	if err != nil {
+		*errs = append(*errs, envError(k, v, errors.New("invalid duration")))
+		return d
+	}
+	return dur
+}
+
+// envSecret 读取密钥类环境变量：设置了 k_FILE 时从该文件读取（如 Docker/K8s secret 挂载）并去掉
+// 末尾换行，优先于 k 本身；文件读取失败时记录错误并回退到 k。
+func envSecret(errs *[]error, k string) string {
+	if path := os.Getenv(k + "_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err == nil {
+			return strings.TrimRight(string(b), "\r\n")
+		}
+		*errs = append(*errs, envError(k+"_FILE", path, err))
+	}
+	return os.Getenv(k)
 }
 
 func getEnv(k, d string) string {
@@ -117,6 +651,18 @@ func splitCSV(s string) []string {
 	return out
 }
 
+// parseFloats 解析逗号分隔的浮点数列表，跳过并返回无法解析的项。
+func parseFloats(s string) (out []float64, bad []string) {
+	for _, p := range splitCSV(s) {
+		if f, err := strconv.ParseFloat(p, 64); err == nil {
+			out = append(out, f)
+		} else {
+			bad = append(bad, p)
+		}
+	}
+	return out, bad
+}
+
 // parseRoomTokens 支持 "room1:token1;room2:token2" 风格的配置。
 func parseRoomTokens(s string) map[string]string {
 	m := map[string]string{}
@@ -138,3 +684,54 @@ func parseRoomTokens(s string) map[string]string {
 	}
 	return m
 }
+
+// parseJWTSecrets 解析 JWT_SECRETS：逗号分隔的 kid:secret 列表（secret 可含冒号）。
+// 格式不对或 kid 重复的项被跳过并报告错误，其余项照常返回。
+func parseJWTSecrets(s string) (map[string]string, error) {
+	m := map[string]string{}
+	bad := 0
+	for _, it := range splitCSV(s) {
+		kid, secret, ok := strings.Cut(it, ":")
+		kid = strings.TrimSpace(kid)
+		if _, dup := m[kid]; !ok || kid == "" || secret == "" || dup {
+			bad++
+			continue
+		}
+		m[kid] = secret
+	}
+	if bad > 0 {
+		return m, fmt.Errorf("%d malformed or duplicate entries (want kid:secret)", bad)
+	}
+	return m, nil
+}
+
+// loadJWTPublicKey 从 PEM 文件加载验证 JWT 的公钥，支持 RSA（PKIX、PKCS#1 或证书）与 ECDSA。
+func loadJWTPublicKey(path string) (crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(b); err == nil {
+		return key, nil
+	}
+	return nil, errors.New("not a PEM encoded RSA or ECDSA public key")
+}
+
+// parseRoomTokensJSON 解析 {"room":"token"} 形式的 JSON，房间名与 Token 原样保留
+// （不去除空白、允许包含 ":" 与 ";"），适合 base64 等含特殊字符的 Token。
+func parseRoomTokensJSON(s string) (map[string]string, error) {
+	var raw map[string]string
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, err
+	}
+	m := make(map[string]string, len(raw))
+	for k, v := range raw {
+		if k != "" && v != "" {
+			m[k] = v
+		}
+	}
+	return m, nil
+}
@@ -1,7 +1,16 @@
 package config
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -294,4 +303,309 @@ func TestGetEnv(t *testing.T) {
 	if result != "default" {
 		t.Errorf("Expected getEnv to return 'default', got '%s'", result)
 	}
-}
\ No newline at end of file
+}
+func TestLoad_ConnectBuckets(t *testing.T) {
+	os.Setenv("METRICS_CONNECT_BUCKETS", "0.1, 0.5,bad,2")
+	defer os.Unsetenv("METRICS_CONNECT_BUCKETS")
+
+	cfg := Load()
+	expected := []float64{0.1, 0.5, 2}
+	if len(cfg.ConnectBuckets) != len(expected) {
+		t.Fatalf("Expected %d buckets, got %v", len(expected), cfg.ConnectBuckets)
+	}
+	for i, v := range expected {
+		if cfg.ConnectBuckets[i] != v {
+			t.Errorf("Expected bucket %d to be %v, got %v", i, v, cfg.ConnectBuckets[i])
+		}
+	}
+
+	os.Setenv("METRICS_CONNECT_BUCKETS", "0.5,0.1,2")
+	if _, err := LoadStrict(); err == nil || !strings.Contains(err.Error(), "strictly increasing") {
+		t.Errorf("Expected unordered buckets to be reported, got %v", err)
+	}
+	if cfg := Load(); cfg.ConnectBuckets != nil {
+		t.Errorf("Expected unordered buckets to fall back to the default, got %v", cfg.ConnectBuckets)
+	}
+}
+
+func TestLoad_RoomTokensJSON(t *testing.T) {
+	os.Setenv("ROOM_TOKENS", "room1:token1;room2:token2")
+	os.Setenv("ROOM_TOKENS_JSON", `{"room2":" padded==\t","a-b":"x;y:z"}`)
+	defer os.Unsetenv("ROOM_TOKENS")
+	defer os.Unsetenv("ROOM_TOKENS_JSON")
+
+	cfg := Load()
+	expected := map[string]string{
+		"room1": "token1",
+		"room2": " padded==\t",
+		"a-b":   "x;y:z",
+	}
+	if len(cfg.RoomTokens) != len(expected) {
+		t.Fatalf("Expected %d room tokens, got %v", len(expected), cfg.RoomTokens)
+	}
+	for k, v := range expected {
+		if cfg.RoomTokens[k] != v {
+			t.Errorf("Expected token for room %q to be %q, got %q", k, v, cfg.RoomTokens[k])
+		}
+	}
+}
+
+func TestLoad_DropCandidateCIDRs(t *testing.T) {
+	os.Setenv("DROP_CANDIDATE_CIDRS", "10.0.0.0/8, 192.168.1.5,fd00::/8,bogus")
+	defer os.Unsetenv("DROP_CANDIDATE_CIDRS")
+
+	cfg, err := LoadStrict()
+	if err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("Expected error mentioning the invalid entry, got %v", err)
+	}
+	if len(cfg.DropCandidateCIDRs) != 3 {
+		t.Fatalf("Expected 3 valid networks, got %v", cfg.DropCandidateCIDRs)
+	}
+	if !cfg.DropCandidateCIDRs[1].Contains(net.ParseIP("192.168.1.5")) || cfg.DropCandidateCIDRs[1].Contains(net.ParseIP("192.168.1.6")) {
+		t.Errorf("Expected bare IP to match only itself, got %v", cfg.DropCandidateCIDRs[1])
+	}
+}
+
+func TestParseRoomTokensJSON_Invalid(t *testing.T) {
+	if _, err := parseRoomTokensJSON("room1:token1"); err == nil {
+		t.Error("Expected error for non-JSON input")
+	}
+}
+
+func TestLoadStrict_ReportsMalformed(t *testing.T) {
+	bad := map[string]string{
+		"MAX_SUBS_PER_ROOM":         "ten",
+		"RATE_LIMIT_RPS":            "fast",
+		"ANSWER_TIMEOUT":            "10",
+		"METRICS_CONNECT_BUCKETS":   "0.1,bad",
+		"RECORD_FORMAT":             "mkv",
+		"OPUS_PTIME":                "1",
+		"AUDIO_ONLY_SUB_WEIGHT":     "2",
+		"TLS_RELOAD_INTERVAL":       "-1s",
+		"PLI_INTERVAL":              "-2s",
+		"MAX_VIDEO_HEIGHT":          "720",
+		"MAX_PUBLISHERS_PER_ROOM":   "0",
+		"METRICS_LOG_INTERVAL":      "-5s",
+		"LOG_FORMAT":                "xml",
+		"LOG_LEVEL":                 "verbose",
+		"TURN_CREDENTIAL_TTL":       "0s",
+		"JWT_PUBLIC_KEY_FILE":       "/nonexistent/jwt.pem",
+		"UPLOAD_MAX_ATTEMPTS":       "0",
+		"UPLOAD_RETRY_BACKOFF":      "-1s",
+		"UPLOAD_SHUTDOWN_TIMEOUT":   "-1s",
+		"RECORD_SEGMENT_DURATION":   "-1m",
+		"RECORD_SEGMENT_SIZE_MB":    "-5",
+		"RATE_LIMIT_UNKNOWN_CLIENT": "drop",
+		"BUNDLE_POLICY":             "single",
+		"TRUSTED_PROXIES":           "10.0.0.0/33",
+		"SHUTDOWN_DRAIN_DELAY":      "-1s",
+		"ROOM_TOKENS":               "demo:t1;bad.room:t2",
+		"METRICS_ROOM_ALLOWLIST":    "demo,直播",
+	}
+	for k, v := range bad {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	cfg, err := LoadStrict()
+	if err == nil {
+		t.Fatal("Expected error for malformed environment variables")
+	}
+	for k := range bad {
+		if !strings.Contains(err.Error(), k) {
+			t.Errorf("Expected error to mention %s, got %v", k, err)
+		}
+	}
+	if cfg.MaxSubsPerRoom != 0 || cfg.AnswerTimeout != 0 || cfg.RecordFormat != RecordFormatSeparate {
+		t.Errorf("Expected defaults for malformed values, got %+v", cfg)
+	}
+	if len(cfg.RoomTokens) != 1 || cfg.RoomTokens["demo"] != "t1" || len(cfg.MetricsRoomAllowlist) != 1 {
+		t.Errorf("Expected invalid room names dropped, got %v %v", cfg.RoomTokens, cfg.MetricsRoomAllowlist)
+	}
+}
+
+func TestLoadStrict_BundlePolicy(t *testing.T) {
+	defer os.Unsetenv("BUNDLE_POLICY")
+	for v, ok := range map[string]bool{"max-bundle": true, "MAX-BUNDLE": true, "balanced": false, "max-compat": false} {
+		os.Setenv("BUNDLE_POLICY", v)
+		if _, err := LoadStrict(); (err == nil) != ok {
+			t.Errorf("BUNDLE_POLICY=%s: expected ok=%v, got %v", v, ok, err)
+		}
+	}
+}
+
+func TestLoadStrict_Valid(t *testing.T) {
+	os.Setenv("MAX_SUBS_PER_ROOM", "5")
+	defer os.Unsetenv("MAX_SUBS_PER_ROOM")
+
+	cfg, err := LoadStrict()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.MaxSubsPerRoom != 5 {
+		t.Errorf("Expected MaxSubsPerRoom 5, got %d", cfg.MaxSubsPerRoom)
+	}
+}
+
+func TestLoad_SRTPProfiles(t *testing.T) {
+	os.Setenv("SRTP_PROFILES", "srtp_aead_aes_256_gcm, SRTP_AEAD_AES_128_GCM,SRTP_NULL")
+	defer os.Unsetenv("SRTP_PROFILES")
+
+	cfg, err := LoadStrict()
+	if err == nil || !strings.Contains(err.Error(), "SRTP_NULL") {
+		t.Errorf("Expected unknown profile to be reported, got %v", err)
+	}
+	want := []string{"SRTP_AEAD_AES_256_GCM", "SRTP_AEAD_AES_128_GCM"}
+	if len(cfg.SRTPProfiles) != len(want) || cfg.SRTPProfiles[0] != want[0] || cfg.SRTPProfiles[1] != want[1] {
+		t.Errorf("Expected %v, got %v", want, cfg.SRTPProfiles)
+	}
+}
+
+func TestLoad_EndpointRateLimits(t *testing.T) {
+	os.Setenv("RATE_LIMIT_BURST", "20")
+	os.Setenv("RATE_LIMIT_PUBLISH_RPS", "0.5")
+	os.Setenv("RATE_LIMIT_PLAY_RPS", "5")
+	os.Setenv("RATE_LIMIT_PLAY_BURST", "10")
+	defer func() {
+		for _, k := range []string{"RATE_LIMIT_BURST", "RATE_LIMIT_PUBLISH_RPS", "RATE_LIMIT_PLAY_RPS", "RATE_LIMIT_PLAY_BURST"} {
+			os.Unsetenv(k)
+		}
+	}()
+
+	cfg := Load()
+	if cfg.RateLimitPublishRPS != 0.5 || cfg.RateLimitPublishBurst != 20 {
+		t.Errorf("Expected publish limit 0.5/20 (burst from global), got %v/%d", cfg.RateLimitPublishRPS, cfg.RateLimitPublishBurst)
+	}
+	if cfg.RateLimitPlayRPS != 5 || cfg.RateLimitPlayBurst != 10 {
+		t.Errorf("Expected play limit 5/10, got %v/%d", cfg.RateLimitPlayRPS, cfg.RateLimitPlayBurst)
+	}
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	env := map[string]string{
+		"TURN_PASSWORD":      "inline",
+		"TURN_PASSWORD_FILE": write("turn", "from-file\n"),
+		"S3_SECRET_KEY_FILE": write("s3", "s3-secret\r\n"),
+		"JWT_SECRET":         "jwt-inline",
+		"AUTH_TOKEN_FILE":    filepath.Join(dir, "missing"),
+		"AUTH_TOKEN":         "auth-inline",
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	defer func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+	}()
+
+	cfg, err := LoadStrict()
+	if cfg.TURNPassword != "from-file" || cfg.S3SecretKey != "s3-secret" {
+		t.Errorf("Expected secrets from files without trailing newline, got %q %q", cfg.TURNPassword, cfg.S3SecretKey)
+	}
+	if cfg.JWTSecret != "jwt-inline" {
+		t.Errorf("Expected inline JWT secret without _FILE, got %q", cfg.JWTSecret)
+	}
+	if err == nil || !strings.Contains(err.Error(), "AUTH_TOKEN_FILE") || cfg.AuthToken != "auth-inline" {
+		t.Errorf("Expected unreadable AUTH_TOKEN_FILE to be reported and fall back, got %v %q", err, cfg.AuthToken)
+	}
+}
+
+func TestParseJWTSecrets(t *testing.T) {
+	m, err := parseJWTSecrets("k1:old, k2:new:with:colons")
+	if err != nil || len(m) != 2 || m["k1"] != "old" || m["k2"] != "new:with:colons" {
+		t.Errorf("Unexpected keys %v, err %v", m, err)
+	}
+	m, err = parseJWTSecrets("k1:a,nokey,:b,k1:c")
+	if err == nil || len(m) != 1 || m["k1"] != "a" {
+		t.Errorf("Expected malformed and duplicate entries reported, got %v, err %v", m, err)
+	}
+}
+
+func TestLoad_JWTExpectedClaims(t *testing.T) {
+	os.Setenv("JWT_AUDIENCE", "legacy")
+	os.Setenv("JWT_EXPECTED_ISSUER", "https://idp.example.com")
+	defer os.Unsetenv("JWT_AUDIENCE")
+	defer os.Unsetenv("JWT_EXPECTED_ISSUER")
+	if cfg := Load(); cfg.JWTAudience != "legacy" || cfg.JWTIssuer != "https://idp.example.com" {
+		t.Errorf("Unexpected audience %q issuer %q", cfg.JWTAudience, cfg.JWTIssuer)
+	}
+	os.Setenv("JWT_EXPECTED_AUDIENCE", "liveforge")
+	defer os.Unsetenv("JWT_EXPECTED_AUDIENCE")
+	if cfg := Load(); cfg.JWTAudience != "liveforge" {
+		t.Errorf("Expected JWT_EXPECTED_AUDIENCE to take precedence, got %q", cfg.JWTAudience)
+	}
+}
+
+func TestLoadJWTPublicKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, pub := range map[string]any{"rsa.pem": &rsaKey.PublicKey, "ec.pem": &ecKey.PublicKey} {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if key, err := loadJWTPublicKey(filepath.Join(dir, "rsa.pem")); err != nil || !rsaKey.PublicKey.Equal(key) {
+		t.Errorf("Expected RSA public key loaded, got %T, err %v", key, err)
+	}
+	if key, err := loadJWTPublicKey(filepath.Join(dir, "ec.pem")); err != nil || !ecKey.PublicKey.Equal(key) {
+		t.Errorf("Expected ECDSA public key loaded, got %T, err %v", key, err)
+	}
+	os.WriteFile(filepath.Join(dir, "bad.pem"), []byte("not a key"), 0o600)
+	if _, err := loadJWTPublicKey(filepath.Join(dir, "bad.pem")); err == nil {
+		t.Error("Expected error for a file without a PEM public key")
+	}
+
+	os.Setenv("JWT_PUBLIC_KEY_FILE", filepath.Join(dir, "ec.pem"))
+	defer os.Unsetenv("JWT_PUBLIC_KEY_FILE")
+	cfg, err := LoadStrict()
+	if err != nil || !cfg.JWTEnabled() {
+		t.Errorf("Expected JWT enabled by a public key alone, err %v", err)
+	}
+}
+
+func TestValidateRoomName(t *testing.T) {
+	for _, name := range []string{"demo", "test-room", "Room_1", strings.Repeat("a", MaxRoomNameLen)} {
+		if err := ValidateRoomName(name); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "..", "a/b", "a/../b", "a b", "demo\x00", "room\n", "房间", "a.b", strings.Repeat("a", MaxRoomNameLen+1)} {
+		if err := ValidateRoomName(name); err == nil {
+			t.Errorf("Expected %q to be rejected", name)
+		}
+	}
+}
+
+func TestRedacted(t *testing.T) {
+	cfg := &Config{HTTPAddr: ":8080", AdminToken: "admin", ViewerAdminToken: "viewer", JWTSecret: "jwt", RoomTokens: map[string]string{"demo": "t"}}
+	r := cfg.Redacted()
+	if r.AdminToken != "[redacted]" || r.ViewerAdminToken != "[redacted]" || r.JWTSecret != "[redacted]" || r.RoomTokens["demo"] != "[redacted]" {
+		t.Errorf("Expected secrets redacted, got %+v", r)
+	}
+	if r.HTTPAddr != ":8080" || r.AuthToken != "" {
+		t.Errorf("Expected other fields unchanged and empty secrets left empty, got %q %q", r.HTTPAddr, r.AuthToken)
+	}
+	if cfg.AdminToken != "admin" || cfg.RoomTokens["demo"] != "t" {
+		t.Error("Expected original config untouched")
+	}
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Summary 是周期指标日志（METRICS_LOG_INTERVAL）中的一行，供没有 Prometheus 抓取的环境
+// 仅凭日志观察服务状态。
+type Summary struct {
+	Rooms         int     `json:"rooms"`
+	Subscribers   int     `json:"subscribers"`
+	BytesPerSec   float64 `json:"bytesPerSec"`   // 全部房间的入站 RTP 字节速率
+	UploadBacklog int     `json:"uploadBacklog"` // 排队或上传中的录制文件数
+}
+
+// sum 返回 Snapshot 中某指标全部序列的值之和。
+func sum(samples []Sample) float64 {
+	var v float64
+	for _, s := range samples {
+		v += s.Value
+	}
+	return v
+}
+
+// summarize 由快照计算 Summary；字节速率取 webrtc_rtp_bytes_total 相对 prevBytes 的增量除以 elapsed。
+// 同时返回本次的字节总量，供下次计算。房间回收后其序列被删除，增量为负时按 0 计。
+func summarize(snap map[string][]Sample, prevBytes float64, elapsed time.Duration) (Summary, float64) {
+	bytes := sum(snap["webrtc_rtp_bytes_total"])
+	s := Summary{
+		Rooms:         int(sum(snap["webrtc_rooms"])),
+		Subscribers:   int(sum(snap["webrtc_subscribers"])),
+		UploadBacklog: int(sum(snap["webrtc_upload_queue_depth"])),
+	}
+	if elapsed > 0 && bytes > prevBytes {
+		s.BytesPerSec = (bytes - prevBytes) / elapsed.Seconds()
+	}
+	return s, bytes
+}
+
+// LogSummary 每 interval 以 JSON 输出一行指标汇总，随进程运行直至退出。
+func LogSummary(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	_, prevBytes := summarize(Snapshot(), 0, 0)
+	prev := time.Now()
+	for now := range ticker.C {
+		var s Summary
+		s, prevBytes = summarize(Snapshot(), prevBytes, now.Sub(prev))
+		prev = now
+		b, _ := json.Marshal(s)
+		log.Printf("metrics: %s", b)
+	}
+}
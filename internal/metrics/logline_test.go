@@ -0,0 +1,25 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	snap := map[string][]Sample{
+		"webrtc_rooms":              {{Value: 2}},
+		"webrtc_subscribers":        {{Labels: map[string]string{"room": "a"}, Value: 3}, {Labels: map[string]string{"room": "b"}, Value: 1}},
+		"webrtc_rtp_bytes_total":    {{Labels: map[string]string{"room": "a"}, Value: 6000}, {Labels: map[string]string{"room": "b"}, Value: 4000}},
+		"webrtc_upload_queue_depth": {{Value: 5}},
+	}
+	s, bytes := summarize(snap, 4000, 2*time.Second)
+	if s.Rooms != 2 || s.Subscribers != 4 || s.UploadBacklog != 5 || bytes != 10000 {
+		t.Errorf("Unexpected summary: %+v (bytes %v)", s, bytes)
+	}
+	if s.BytesPerSec != 3000 {
+		t.Errorf("Expected 3000 bytes/s, got %v", s.BytesPerSec)
+	}
+	if s, _ := summarize(snap, 20000, time.Second); s.BytesPerSec != 0 {
+		t.Errorf("Expected counter drop to report 0 bytes/s, got %v", s.BytesPerSec)
+	}
+}
@@ -1,14 +1,21 @@
 // Package metrics 暴露 Prometheus 指标，用于教学场景下的基础观测：
 // - 每房间 RTP 字节/包总量
-// - 当前订阅者数量（Gauge）
+// - 当前订阅者数量（Gauge）与订阅者加入/离开次数（Counter）
 // - 当前房间数量（Gauge）
+// - 每房间入站码率（Gauge）
 package metrics
 
 // 暴露 Prometheus 指标，方便排查每个房间的带宽与在线情况。
 
 import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
 )
 
 var (
@@ -27,14 +34,256 @@
 		Help: "Current subscribers per room",
 	}, []string{"room"})
 
+	SubscribersJoined = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webrtc_subscribers_joined_total",
+		Help: "Subscribers that joined per room, to tell churn apart from a stable audience",
+	}, []string{"room"})
+
+	SubscribersLeft = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webrtc_subscribers_left_total",
+		Help: "Subscribers that left or were removed per room",
+	}, []string{"room"})
+
     Rooms = promauto.NewGauge(prometheus.GaugeOpts{
         Name: "webrtc_rooms",
         Help: "Current rooms managed",
     })
+
+	UploadBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_upload_backlog",
+		Help: "Recordings queued or uploading per room",
+	}, []string{"room"})
+
+	UploadQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_upload_queue_depth",
+		Help: "Recordings currently queued or uploading across all rooms",
+	})
+
+	UploadFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "webrtc_upload_failures_total",
+		Help: "Recording uploads that failed",
+	})
+
+	Uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webrtc_uploads_total",
+		Help: "Recording uploads by final result (success or failure) after retries",
+	}, []string{"result"})
+
+	UploadsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "webrtc_uploads_in_flight",
+		Help: "Recording upload attempts currently transferring to object storage",
+	})
+
+	StuckSubscribers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webrtc_stuck_subscribers_removed_total",
+		Help: "Subscribers forcibly removed because an RTP write exceeded SUBSCRIBER_WRITE_TIMEOUT",
+	}, []string{"room"})
+
+	HTTPRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webrtc_http_rejections_total",
+		Help: "API requests answered with a non-2xx status, by endpoint and reason",
+	}, []string{"endpoint", "reason"})
+
+	RoomBitrate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_room_bitrate_bps",
+		Help: "Inbound RTP bitrate per room over the last second, zero when no publisher",
+	}, []string{"room"})
+
+	VideoFramerate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "webrtc_video_framerate",
+		Help: "Inbound video frames per second per room (highest video track) over the last second, zero when no publisher",
+	}, []string{"room"})
+
+	EgressRate = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "webrtc_egress_bps",
+		Help: "Outbound RTP bitrate to all subscribers over a sliding window, compared against MAX_EGRESS_MBPS",
+	}, func() float64 {
+		if f := egressRate.Load(); f != nil {
+			return (*f)()
+		}
+		return 0
+	})
+
+	TURNAllocations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "webrtc_turn_allocations_total",
+		Help: "TURN relay allocations per server, by result (success/failure) at the end of ICE gathering",
+	}, []string{"server", "result"})
 )
 
 func SetRooms(n float64)          { Rooms.Set(n) }
-func IncSubscribers(room string)  { Subscribers.WithLabelValues(room).Inc() }
-func DecSubscribers(room string)  { Subscribers.WithLabelValues(room).Dec() }
-func AddBytes(room string, n int) { RTPBytes.WithLabelValues(room).Add(float64(n)) }
-func IncPackets(room string)      { RTPPackets.WithLabelValues(room).Inc() }
+func AddBytes(room string, n int) { RTPBytes.WithLabelValues(roomLabel(room)).Add(float64(n)) }
+func IncPackets(room string)      { RTPPackets.WithLabelValues(roomLabel(room)).Inc() }
+func IncUploadBacklog(room string) { UploadBacklog.WithLabelValues(roomLabel(room)).Inc() }
+func DecUploadBacklog(room string) { UploadBacklog.WithLabelValues(roomLabel(room)).Dec() }
+func SetUploadQueueDepth(n int)  { UploadQueueDepth.Set(float64(n)) }
+func IncUploadFailures()          { UploadFailures.Inc() }
+func IncStuckSubscribers(room string) { StuckSubscribers.WithLabelValues(roomLabel(room)).Inc() }
+
+// IncSubscribers 在订阅者加入时增加房间的当前订阅者数，并累计 webrtc_subscribers_joined_total。
+func IncSubscribers(room string) {
+	l := roomLabel(room)
+	Subscribers.WithLabelValues(l).Inc()
+	SubscribersJoined.WithLabelValues(l).Inc()
+}
+
+// DecSubscribers 在订阅者离开或被移除时减少房间的当前订阅者数，并累计 webrtc_subscribers_left_total。
+// 仅看仪表盘无法区分稳定的 10 名观众与平均 10 名的频繁进出，两个计数器的速率可以。
+func DecSubscribers(room string) {
+	l := roomLabel(room)
+	Subscribers.WithLabelValues(l).Dec()
+	SubscribersLeft.WithLabelValues(l).Inc()
+}
+
+// IncUploadResult 按最终结果（重试用尽前成功为 success，否则为 failure）累计一次录制上传。
+func IncUploadResult(ok bool) {
+	result := "failure"
+	if ok {
+		result = "success"
+	}
+	Uploads.WithLabelValues(result).Inc()
+}
+
+// UploadStarted 在一次上传尝试开始时增加进行中的上传数，返回的函数在尝试结束时调用以减回。
+func UploadStarted() (done func()) {
+	UploadsInFlight.Inc()
+	return UploadsInFlight.Dec
+}
+
+// SetBitrate 设置房间最近一个采样周期的入站码率（bit/s）。
+// 未列入白名单的房间共用 OtherRoomLabel，彼此会相互覆盖，此时该值仅供参考。
+func SetBitrate(room string, bps float64) { RoomBitrate.WithLabelValues(roomLabel(room)).Set(bps) }
+
+// SetFramerate 设置房间最近一个采样周期的入站视频帧率（帧/秒）。
+func SetFramerate(room string, fps float64) { VideoFramerate.WithLabelValues(roomLabel(room)).Set(fps) }
+
+// egressRate 是 webrtc_egress_bps 采集时调用的取值函数。
+var egressRate atomic.Pointer[func() float64]
+
+// SetEgressRateFunc 设置 webrtc_egress_bps 的取值函数（出站码率，bit/s），采集时调用。
+func SetEgressRateFunc(f func() float64) { egressRate.Store(&f) }
+
+// IncHTTPRejection 记录一次被拒绝的 API 请求，reason 取值如 method、unauthorized、
+// rate_limited、origin、room_not_found、bad_sdp、capacity、timeout、internal 等。
+func IncHTTPRejection(endpoint, reason string) {
+	HTTPRejections.WithLabelValues(endpoint, reason).Inc()
+}
+
+func IncTURNAllocation(server, result string) {
+	TURNAllocations.WithLabelValues(server, result).Inc()
+}
+
+// OtherRoomLabel 是不在白名单内的房间聚合后使用的 room 标签值。
+const OtherRoomLabel = "__other__"
+
+var roomAllowlist atomic.Pointer[map[string]struct{}]
+
+// SetRoomAllowlist 设置按房间区分指标的白名单，其余房间统一计入 OtherRoomLabel，
+// 以限制大量临时房间带来的标签基数；传入空列表则恢复为每个房间独立标签。
+func SetRoomAllowlist(rooms []string) {
+	if len(rooms) == 0 {
+		roomAllowlist.Store(nil)
+		return
+	}
+	set := make(map[string]struct{}, len(rooms))
+	for _, r := range rooms {
+		set[r] = struct{}{}
+	}
+	roomAllowlist.Store(&set)
+}
+
+// roomLabel 返回房间在指标中使用的标签值。
+func roomLabel(room string) string {
+	set := roomAllowlist.Load()
+	if set == nil {
+		return room
+	}
+	if _, ok := (*set)[room]; ok {
+		return room
+	}
+	return OtherRoomLabel
+}
+
+// DefaultConnectBuckets 覆盖 WebRTC 建连的典型耗时区间（0.05s ~ 10s）。
+var DefaultConnectBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10}
+
+var (
+	// PublishDuration/SubscribeDuration 记录从收到 Offer 到返回 Answer 的耗时，由 Init 创建。
+	PublishDuration   prometheus.Histogram
+	SubscribeDuration prometheus.Histogram
+	initOnce          sync.Once
+)
+
+// Init 注册建连耗时直方图；buckets 为空时使用 DefaultConnectBuckets。
+// 直方图的桶在注册后不可变，因此只有第一次调用生效。
+func Init(buckets []float64) {
+	initOnce.Do(func() {
+		if len(buckets) == 0 {
+			buckets = DefaultConnectBuckets
+		}
+		PublishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "webrtc_publish_duration_seconds",
+			Help:    "Time from receiving a WHIP offer to returning the answer",
+			Buckets: buckets,
+		})
+		SubscribeDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "webrtc_subscribe_duration_seconds",
+			Help:    "Time from receiving a WHEP offer to returning the answer",
+			Buckets: buckets,
+		})
+	})
+}
+
+// ObservePublish/ObserveSubscribe 在 Init 之前调用时不做任何事。
+func ObservePublish(d time.Duration) {
+	if PublishDuration != nil {
+		PublishDuration.Observe(d.Seconds())
+	}
+}
+
+func ObserveSubscribe(d time.Duration) {
+	if SubscribeDuration != nil {
+		SubscribeDuration.Observe(d.Seconds())
+	}
+}
+
+// Sample 是 Snapshot 中的单个时间序列：计数器与仪表盘取其当前值，直方图取观测总和与次数。
+type Sample struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+	Count  uint64            `json:"count,omitempty"` // 仅直方图：观测次数
+}
+
+// Snapshot 采集默认注册表中本服务的 webrtc_* 指标，按指标名返回各序列的当前值，
+// 供调试接口以 JSON 输出；采集失败时返回已成功的部分。
+func Snapshot() map[string][]Sample {
+	families, _ := prometheus.DefaultGatherer.Gather()
+	out := make(map[string][]Sample, len(families))
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), "webrtc_") {
+			continue
+		}
+		samples := make([]Sample, 0, len(mf.GetMetric()))
+		for _, m := range mf.GetMetric() {
+			s := Sample{}
+			if len(m.GetLabel()) > 0 {
+				s.Labels = make(map[string]string, len(m.GetLabel()))
+				for _, l := range m.GetLabel() {
+					s.Labels[l.GetName()] = l.GetValue()
+				}
+			}
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				s.Value = m.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				s.Value = m.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				s.Value, s.Count = m.GetHistogram().GetSampleSum(), m.GetHistogram().GetSampleCount()
+			default:
+				s.Value = m.GetUntyped().GetValue()
+			}
+			samples = append(samples, s)
+		}
+		out[mf.GetName()] = samples
+	}
+	return out
+}
@@ -1,11 +1,13 @@
 package metrics
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestMetrics_InitialValues(t *testing.T) {
@@ -230,6 +232,57 @@ func TestMetrics_Labels(t *testing.T) {
 	}
 }
 
+func TestInit_ConnectBuckets(t *testing.T) {
+	ObservePublish(time.Second) // no-op before Init
+
+	Init([]float64{0.1, 1, 10})
+	Init([]float64{42}) // only the first call takes effect
+
+	ObservePublish(300 * time.Millisecond)
+	ObserveSubscribe(2 * time.Second)
+
+	var m dto.Metric
+	if err := PublishDuration.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("Failed to read histogram: %v", err)
+	}
+	h := m.GetHistogram()
+	if h.GetSampleCount() != 1 {
+		t.Errorf("Expected 1 publish sample, got %d", h.GetSampleCount())
+	}
+	want := map[float64]uint64{0.1: 0, 1: 1, 10: 1}
+	if len(h.GetBucket()) != len(want) {
+		t.Fatalf("Expected %d buckets, got %d", len(want), len(h.GetBucket()))
+	}
+	for _, b := range h.GetBucket() {
+		if c, ok := want[b.GetUpperBound()]; !ok || c != b.GetCumulativeCount() {
+			t.Errorf("Unexpected bucket le=%v count=%d", b.GetUpperBound(), b.GetCumulativeCount())
+		}
+	}
+	if n := testutil.CollectAndCount(SubscribeDuration); n != 1 {
+		t.Errorf("Expected subscribe histogram to be registered, got %d series", n)
+	}
+}
+
+func TestRoomAllowlist_AggregatesOthers(t *testing.T) {
+	SetRoomAllowlist([]string{"allow-vip"})
+	defer SetRoomAllowlist(nil)
+
+	before := testutil.ToFloat64(RTPBytes.WithLabelValues(OtherRoomLabel))
+	AddBytes("allow-vip", 100)
+	AddBytes("allow-random-1", 10)
+	AddBytes("allow-random-2", 20)
+
+	if v := testutil.ToFloat64(RTPBytes.WithLabelValues("allow-vip")); v != 100 {
+		t.Errorf("Expected allow-listed room to keep its label with 100 bytes, got %f", v)
+	}
+	if v := testutil.ToFloat64(RTPBytes.WithLabelValues(OtherRoomLabel)) - before; v != 30 {
+		t.Errorf("Expected other rooms aggregated to 30 bytes, got %f", v)
+	}
+	if v := testutil.ToFloat64(RTPBytes.WithLabelValues("allow-random-1")); v != 0 {
+		t.Errorf("Expected no per-room series for non-allow-listed room, got %f", v)
+	}
+}
+
 func BenchmarkIncSubscribers(b *testing.B) {
 	room := "benchmark-room"
 	b.ResetTimer()
@@ -255,4 +308,70 @@ func BenchmarkIncPackets(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		IncPackets(room)
 	}
-}
\ No newline at end of file
+}
+func TestSetBitrate(t *testing.T) {
+	SetBitrate("bitrate-room", 2500000)
+	if v := testutil.ToFloat64(RoomBitrate.WithLabelValues("bitrate-room")); v != 2500000 {
+		t.Errorf("Expected bitrate 2500000, got %f", v)
+	}
+	SetBitrate("bitrate-room", 0)
+	if v := testutil.ToFloat64(RoomBitrate.WithLabelValues("bitrate-room")); v != 0 {
+		t.Errorf("Expected bitrate reset to 0, got %f", v)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	SetBitrate("snapshot-room", 1234)
+	snap := Snapshot()
+	var found bool
+	for _, s := range snap["webrtc_room_bitrate_bps"] {
+		if s.Labels["room"] == "snapshot-room" && s.Value == 1234 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected snapshot-room bitrate in snapshot, got %+v", snap["webrtc_room_bitrate_bps"])
+	}
+	for name := range snap {
+		if !strings.HasPrefix(name, "webrtc_") {
+			t.Errorf("Expected only webrtc_ metrics, got %s", name)
+		}
+	}
+}
+
+func TestSubscriberChurn(t *testing.T) {
+	room := "churn-room"
+	for i := 0; i < 3; i++ {
+		IncSubscribers(room)
+		DecSubscribers(room)
+	}
+	IncSubscribers(room)
+	if v := testutil.ToFloat64(Subscribers.WithLabelValues(room)); v != 1 {
+		t.Errorf("Expected 1 subscriber, got %f", v)
+	}
+	if v := testutil.ToFloat64(SubscribersJoined.WithLabelValues(room)); v != 4 {
+		t.Errorf("Expected 4 joins, got %f", v)
+	}
+	if v := testutil.ToFloat64(SubscribersLeft.WithLabelValues(room)); v != 3 {
+		t.Errorf("Expected 3 leaves, got %f", v)
+	}
+}
+
+func TestUploadMetrics(t *testing.T) {
+	success, failure := Uploads.WithLabelValues("success"), Uploads.WithLabelValues("failure")
+	okBefore, failBefore := testutil.ToFloat64(success), testutil.ToFloat64(failure)
+	done := UploadStarted()
+	if v := testutil.ToFloat64(UploadsInFlight); v != 1 {
+		t.Errorf("Expected 1 upload in flight, got %f", v)
+	}
+	done()
+	if v := testutil.ToFloat64(UploadsInFlight); v != 0 {
+		t.Errorf("Expected no uploads in flight, got %f", v)
+	}
+	IncUploadResult(true)
+	IncUploadResult(false)
+	IncUploadResult(false)
+	if testutil.ToFloat64(success) != okBefore+1 || testutil.ToFloat64(failure) != failBefore+2 {
+		t.Errorf("Unexpected upload results: success=%f failure=%f", testutil.ToFloat64(success), testutil.ToFloat64(failure))
+	}
+}
@@ -0,0 +1,67 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"time"
+
+	"live-webrtc-go/internal/metrics"
+)
+
+// bitrateInterval 是房间码率采样周期。
+const bitrateInterval = time.Second
+
+// sampleBitrate 每 bitrateInterval 汇总房间内各 fanout 新接收的字节数，更新 webrtc_room_bitrate_bps；
+// 同时以各视频轨道新增帧数中的最大值更新 webrtc_video_framerate。每个房间只运行一个，
+// 由第一个发布者启动；房间内不再有发布者（迁移不受影响）后退出，码率由 closePublisher 归零。
+func (r *Room) sampleBitrate() {
+	ticker := time.NewTicker(bitrateInterval)
+	defer ticker.Stop()
+	type counters struct{ bytes, frames uint64 }
+	last := make(map[*trackFanout]counters)
+	prev := time.Now()
+	for now := range ticker.C {
+		// 持有读锁更新指标，保证与 closePublisher 中的归零有序，不会在归零后写回旧值
+		r.mu.RLock()
+		if len(r.publishers) == 0 {
+			r.mu.RUnlock()
+			r.mu.Lock()
+			stop := len(r.publishers) == 0
+			if stop {
+				r.sampling = false // 之后加入的发布者会重新启动采样
+			}
+			r.mu.Unlock()
+			if stop {
+				return
+			}
+			prev = now
+			continue
+		}
+		var delta, maxFrames uint64
+		seen := make(map[*trackFanout]counters, len(r.trackFeeds))
+		for _, f := range r.trackFeeds {
+			c := counters{f.rxBytes.Load(), f.frames.Load()}
+			delta += c.bytes - last[f].bytes
+			maxFrames = max(maxFrames, c.frames-last[f].frames)
+			seen[f] = c
+		}
+		last = seen
+		secs := now.Sub(prev).Seconds()
+		metrics.SetBitrate(r.name, float64(delta*8)/secs)
+		metrics.SetFramerate(r.name, float64(maxFrames)/secs)
+		r.mu.RUnlock()
+		prev = now
+	}
+}
+
+// countFrame 在视频包的 RTP 时间戳与上一个包不同时计为一帧（同一帧的各分片共用时间戳）。
+func (f *trackFanout) countFrame(raw []byte) {
+	if !f.video || len(raw) < 12 {
+		return
+	}
+	ts := binary.BigEndian.Uint32(raw[4:8])
+	if f.seenTS && ts == f.lastTS {
+		return
+	}
+	f.seenTS, f.lastTS = true, ts
+	f.frames.Add(1)
+}
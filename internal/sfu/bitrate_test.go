@@ -0,0 +1,70 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"live-webrtc-go/internal/metrics"
+)
+
+func TestRoom_SampleBitrate(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.STUN = nil
+	defer mgr.CloseAll()
+
+	pub, offer, send := newTestPublisher(t, 0xC)
+	answer, err := mgr.Publish(context.Background(), "bitrate-room", offer)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := pub.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	send()
+	gauge := metrics.RoomBitrate.WithLabelValues("bitrate-room")
+	waitFor(t, "bitrate sample", func() bool { return testutil.ToFloat64(gauge) > 0 })
+
+	room := mgr.getOrCreateRoom("bitrate-room")
+	room.mu.RLock()
+	pc := room.firstPublisherLocked().pc
+	room.mu.RUnlock()
+	fps := metrics.VideoFramerate.WithLabelValues("bitrate-room")
+	fps.Set(30)
+	room.closePublisher(pc)
+	if v := testutil.ToFloat64(gauge); v != 0 {
+		t.Errorf("Expected bitrate to drop to 0 after publisher left, got %f", v)
+	}
+	if v := testutil.ToFloat64(fps); v != 0 {
+		t.Errorf("Expected framerate to drop to 0 after publisher left, got %f", v)
+	}
+	// 轨道结束后服务器级的 RTP 累计不回退
+	if st := mgr.Stats(); st.RTPBytes == 0 || st.RTPPackets == 0 {
+		t.Errorf("Expected RTP totals to survive the publisher leaving, got %+v", st)
+	}
+}
+
+func TestTrackFanout_CountFrame(t *testing.T) {
+	pkt := func(ts uint32) []byte {
+		b := make([]byte, 12)
+		b[0] = 0x80
+		binary.BigEndian.PutUint32(b[4:8], ts)
+		return b
+	}
+	f := newTrackFanout(nil, "room")
+	f.countFrame(pkt(100))
+	if f.frames.Load() != 0 {
+		t.Fatal("Expected audio/unknown tracks not to count frames")
+	}
+	f.video = true
+	// 同一帧的多个分片共用时间戳，只计一次
+	for _, ts := range []uint32{100, 100, 3100, 3100, 3100, 6100} {
+		f.countFrame(pkt(ts))
+	}
+	if n := f.frames.Load(); n != 3 {
+		t.Errorf("Expected 3 frames, got %d", n)
+	}
+}
@@ -0,0 +1,23 @@
+package sfu
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// publisherCNAME 由发布者会话 ID 派生该发布者全部轨道共用的 CNAME。取哈希而非会话 ID 本身，
+// 避免订阅者从 SDP 中拿到可用于结束或修改推流会话的 ID。
+func publisherCNAME(publisher string) string {
+	sum := sha256.Sum256([]byte(publisher))
+	return "pub-" + hex.EncodeToString(sum[:8])
+}
+
+// streamID 返回转发给订阅者的本地轨道使用的流 ID，pion 同时以它作为 SDP 中 a=ssrc 的 cname。
+// 开启 PUBLISHER_CNAME 时同一发布者的音视频共用 publisherCNAME，录制与转推工具可据此关联同一路推流；
+// 否则沿用发布者轨道的流 ID（fallback）。
+func (f *trackFanout) streamID(fallback string) string {
+	if f.publisher != "" && f.mgr != nil && f.mgr.cfg != nil && f.mgr.cfg.PublisherCNAME {
+		return publisherCNAME(f.publisher)
+	}
+	return fallback
+}
@@ -0,0 +1,57 @@
+package sfu
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestPublisherCNAME_SharedAcrossTracks(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	defer mgr.CloseAll()
+	cfg.PublisherCNAME = true
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	tracks := map[string]webrtc.RTPCodecCapability{
+		"audio": {MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2},
+		"video": {MimeType: webrtc.MimeTypeVP8, ClockRate: 90000},
+	}
+	for id, codec := range tracks {
+		f := newTrackFanout(nil, "cname")
+		f.publisher, f.mgr = "session-1", mgr
+		local, err := webrtc.NewTrackLocalStaticRTP(codec, id, f.streamID(id+"-stream"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pc.AddTrack(local); err != nil {
+			t.Fatal(err)
+		}
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cnames := map[string]bool{}
+	for _, m := range regexp.MustCompile(`a=ssrc:\d+ cname:(\S+)`).FindAllStringSubmatch(offer.SDP, -1) {
+		cnames[m[1]] = true
+	}
+	want := publisherCNAME("session-1")
+	if len(cnames) != 1 || !cnames[want] {
+		t.Errorf("Expected all tracks to share cname %s, got %v", want, cnames)
+	}
+	if strings.Contains(offer.SDP, "session-1") {
+		t.Error("Expected the session ID not to appear in the SDP")
+	}
+
+	cfg.PublisherCNAME = false
+	f := &trackFanout{publisher: "session-1", mgr: mgr}
+	if got := f.streamID("browser-stream"); got != "browser-stream" {
+		t.Errorf("Expected publisher's stream ID when disabled, got %q", got)
+	}
+}
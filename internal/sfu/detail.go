@@ -0,0 +1,106 @@
+package sfu
+
+import (
+	"sort"
+	"time"
+)
+
+// RoomDetail 是单个房间的详细状态，供 GET /api/rooms/{room} 排查问题使用。
+type RoomDetail struct {
+	Name           string             `json:"name"`
+	Created        time.Time          `json:"created"`
+	HasPublisher   bool               `json:"hasPublisher"`
+	PublisherID    string             `json:"publisherId,omitempty"`       // 最早加入的发布者
+	PublisherICE   string             `json:"publisherIceState,omitempty"` // 发布者连接的 ICE 状态，如 connected、disconnected
+	Publishers     []PublisherDetail  `json:"publishers,omitempty"`        // 全部发布者，按加入时间排列
+	Tracks         []TrackDetail      `json:"tracks"`
+	Subscribers    int                `json:"subscribers"`
+	SubscriberList []SubscriberDetail `json:"subscriberList"`
+	BytesReceived  uint64             `json:"bytesReceived"` // 当前各轨道累计接收的 RTP 字节数
+}
+
+// TrackDetail 描述房间内的一条发布轨道。
+type TrackDetail struct {
+	ID            string `json:"id"`
+	Kind          string `json:"kind"`
+	Codec         string `json:"codec"`
+	SSRC          uint32 `json:"ssrc"`
+	Publisher     string `json:"publisher,omitempty"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Stalled       bool   `json:"stalled"`
+}
+
+// PublisherDetail 描述房间内的一个发布者会话。
+type PublisherDetail struct {
+	ID    string    `json:"id"`
+	ICE   string    `json:"iceState"`
+	Since time.Time `json:"since"`
+}
+
+// SubscriberDetail 描述房间内的一个订阅者会话。
+type SubscriberDetail struct {
+	ID        string    `json:"id,omitempty"`        // 订阅者 ID，公开的 GET /api/rooms/{room} 仅对管理员返回
+	Publisher string    `json:"publisher,omitempty"` // 固定订阅的发布者 ID
+	Since     time.Time `json:"since"`
+}
+
+// RoomDetail 返回房间的详细状态；房间不存在时返回 false，且不会创建房间。
+func (m *Manager) RoomDetail(name string) (RoomDetail, bool) {
+	m.mu.RLock()
+	r, ok := m.rooms[name]
+	m.mu.RUnlock()
+	if !ok {
+		return RoomDetail{}, false
+	}
+	return r.detail(), true
+}
+
+// RoomDetails 返回全部房间的详细状态，按房间名排序，供管理调试接口一次性导出。
+func (m *Manager) RoomDetails() []RoomDetail {
+	m.mu.RLock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.mu.RUnlock()
+	out := make([]RoomDetail, 0, len(rooms))
+	for _, r := range rooms {
+		out = append(out, r.detail())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+func (r *Room) detail() RoomDetail {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d := RoomDetail{
+		Name:           r.name,
+		Created:        r.created,
+		HasPublisher:   len(r.publishers) > 0,
+		Tracks:         make([]TrackDetail, 0, len(r.trackFeeds)),
+		Subscribers:    len(r.subs),
+		SubscriberList: make([]SubscriberDetail, 0, len(r.subs)),
+	}
+	for _, p := range r.publishersLocked() {
+		ice := p.pc.ICEConnectionState().String()
+		if d.PublisherID == "" {
+			d.PublisherID, d.PublisherICE = p.id, ice
+		}
+		d.Publishers = append(d.Publishers, PublisherDetail{ID: p.id, ICE: ice, Since: p.start})
+	}
+	for id, f := range r.trackFeeds {
+		t := TrackDetail{ID: id, Publisher: f.publisher, BytesReceived: f.rxBytes.Load(), Stalled: f.stalled.Load()}
+		if src := f.source(); src != nil {
+			t.ID, t.Kind, t.Codec, t.SSRC = src.ID(), src.Kind().String(), src.Codec().MimeType, uint32(src.SSRC())
+		}
+		d.BytesReceived += t.BytesReceived
+		d.Tracks = append(d.Tracks, t)
+	}
+	for _, s := range r.subs {
+		d.SubscriberList = append(d.SubscriberList, SubscriberDetail{ID: s.id, Publisher: s.publisher, Since: s.started})
+	}
+	sort.Slice(d.Tracks, func(i, j int) bool { return d.Tracks[i].ID < d.Tracks[j].ID })
+	sort.Slice(d.SubscriberList, func(i, j int) bool { return d.SubscriberList[i].Since.Before(d.SubscriberList[j].Since) })
+	return d
+}
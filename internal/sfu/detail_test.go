@@ -0,0 +1,58 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestManager_RoomDetail(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	if _, ok := mgr.RoomDetail("missing"); ok || len(mgr.ListRooms()) != 0 {
+		t.Fatal("Expected missing room to be reported without creating it")
+	}
+
+	room := mgr.getOrCreateRoom("live")
+	feed := newTrackFanout(nil, room.name)
+	feed.rxBytes.Add(1200)
+	feed.stalled.Store(true)
+	started := time.Now().Add(-time.Minute)
+	room.mu.Lock()
+	room.trackFeeds["t1"] = feed
+	room.subs[&webrtc.PeerConnection{}] = &subscriber{id: "s2", started: started.Add(time.Second)}
+	room.subs[&webrtc.PeerConnection{}] = &subscriber{id: "s1", started: started, publisher: "p1"}
+	room.mu.Unlock()
+
+	d, ok := mgr.RoomDetail("live")
+	if !ok {
+		t.Fatal("Expected room detail")
+	}
+	if d.Created.IsZero() || d.HasPublisher || d.PublisherICE != "" {
+		t.Errorf("Unexpected room state: %+v", d)
+	}
+	if len(d.Tracks) != 1 || d.Tracks[0].ID != "t1" || !d.Tracks[0].Stalled || d.BytesReceived != 1200 {
+		t.Errorf("Unexpected tracks: %+v (bytes %d)", d.Tracks, d.BytesReceived)
+	}
+	if d.Subscribers != 2 || d.SubscriberList[0].ID != "s1" || d.SubscriberList[0].Publisher != "p1" {
+		t.Errorf("Expected subscribers ordered by start time, got %+v", d.SubscriberList)
+	}
+
+	room.mu.Lock()
+	room.subs = make(map[*webrtc.PeerConnection]*subscriber) // 零值连接不可 Close，关闭房间前移除
+	room.mu.Unlock()
+}
+
+func TestManager_RoomDetails(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	mgr.getOrCreateRoom("b")
+	mgr.getOrCreateRoom("a")
+	all := mgr.RoomDetails()
+	if len(all) != 2 || all[0].Name != "a" || all[1].Name != "b" {
+		t.Errorf("Expected both rooms sorted by name, got %+v", all)
+	}
+}
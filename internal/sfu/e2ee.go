@@ -0,0 +1,115 @@
+package sfu
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pion/rtp"
+)
+
+// 端到端加密（insertable streams / SFrame）的媒体负载对 SFU 不透明：开启 E2EE_PASSTHROUGH 后
+// 服务端不录制（录制需要解析负载），只按 RTP 头转发，并保留客户端依赖的头扩展
+// （如 dependency descriptor），按 URI 把发布者连接上的扩展 ID 换成订阅者协商的 ID。
+
+// rtpExtProfileTwoByte 是 RFC 8285 两字节头扩展的 profile（ID 可超过 14）。
+const rtpExtProfileTwoByte = 0x1000
+
+// e2eePassthrough 报告是否开启了 E2EE_PASSTHROUGH。
+func (r *Room) e2eePassthrough() bool {
+	return r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.E2EEPassthrough
+}
+
+// headerExts 是 SDP 中按媒体类型协商的 RTP 头扩展：kind（audio/video）-> URI -> ID，
+// 取每种类型的第一个 m 段；会话级的 a=extmap 对所有类型生效。
+type headerExts map[string]map[string]uint8
+
+// parseHeaderExts 解析 SDP 中的 a=extmap 行。
+func parseHeaderExts(sdp string) headerExts {
+	_, session, sections := splitSections(sdp)
+	common := extmapLines(session)
+	out := headerExts{}
+	for _, sec := range sections {
+		kind, _, _ := strings.Cut(strings.TrimPrefix(sec[0], "m="), " ")
+		if _, ok := out[kind]; ok {
+			continue
+		}
+		exts := make(map[string]uint8, len(common))
+		for uri, id := range common {
+			exts[uri] = id
+		}
+		for uri, id := range extmapLines(sec) {
+			exts[uri] = id
+		}
+		out[kind] = exts
+	}
+	return out
+}
+
+// extmapLines 提取 a=extmap:<id>[/direction] <uri> 行中的 URI 与 ID。
+func extmapLines(lines []string) map[string]uint8 {
+	exts := map[string]uint8{}
+	for _, l := range lines {
+		v, ok := strings.CutPrefix(l, "a=extmap:")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(v)
+		if len(fields) < 2 {
+			continue
+		}
+		idStr, _, _ := strings.Cut(fields[0], "/")
+		id, err := strconv.ParseUint(idStr, 10, 8)
+		if err != nil || id == 0 {
+			continue
+		}
+		exts[fields[1]] = uint8(id)
+	}
+	return exts
+}
+
+// extRemap 把发布者连接上的头扩展 ID 换成订阅者连接为同一 URI 协商的 ID。
+type extRemap map[uint8]uint8
+
+// newExtRemap 按 URI 对应发布者与订阅者的扩展 ID；任一方没有扩展信息时返回 nil（原样转发）。
+func newExtRemap(pub, sub map[string]uint8) extRemap {
+	if pub == nil || sub == nil {
+		return nil
+	}
+	m := extRemap{}
+	for uri, from := range pub {
+		if to, ok := sub[uri]; ok {
+			m[from] = to
+		}
+	}
+	return m
+}
+
+// apply 改写 pkt 的头扩展，订阅者未协商的扩展被去掉，扩展内容保持不变。pkt 的 Extensions
+// 与其他订阅者的副本共用底层数组，因此只整体替换、不就地修改。
+func (m extRemap) apply(pkt *rtp.Packet) {
+	if m == nil || !pkt.Extension {
+		return
+	}
+	ids := pkt.GetExtensionIDs()
+	if len(ids) == 0 {
+		return // RFC 3550 通用扩展没有 ID，无法对应，原样保留
+	}
+	payloads := make([][]byte, len(ids))
+	for i, id := range ids {
+		payloads[i] = pkt.GetExtension(id)
+	}
+	pkt.Extensions = nil
+	for _, id := range ids {
+		if to, ok := m[id]; ok && to > 14 {
+			pkt.ExtensionProfile = rtpExtProfileTwoByte // 一字节扩展的 ID 上限为 14
+		}
+	}
+	for i, id := range ids {
+		if to, ok := m[id]; ok {
+			_ = pkt.SetExtension(to, payloads[i])
+		}
+	}
+	if len(pkt.Extensions) == 0 {
+		pkt.Extension = false
+	}
+}
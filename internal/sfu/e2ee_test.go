@@ -0,0 +1,67 @@
+package sfu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+)
+
+const (
+	extDD   = "https://aomediacodec.github.io/av1-rtp-spec/#dependency-descriptor-rtp-header-extension"
+	extMid  = "urn:ietf:params:rtp-hdrext:sdes:mid"
+	extTWCC = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+)
+
+func TestParseHeaderExts(t *testing.T) {
+	offer := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"a=extmap:9 " + extTWCC + "\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=extmap:4 " + extMid + "\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=extmap:4/sendonly " + extMid + "\r\na=extmap:11 " + extDD + "\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=extmap:12 " + extDD + "\r\n"
+	exts := parseHeaderExts(offer)
+	if exts["audio"][extMid] != 4 || exts["audio"][extTWCC] != 9 || exts["audio"][extDD] != 0 {
+		t.Errorf("Unexpected audio extensions: %v", exts["audio"])
+	}
+	if exts["video"][extMid] != 4 || exts["video"][extDD] != 11 || exts["video"][extTWCC] != 9 {
+		t.Errorf("Expected first video section's extensions, got %v", exts["video"])
+	}
+}
+
+func TestExtRemap_Apply(t *testing.T) {
+	pub := map[string]uint8{extMid: 1, extDD: 3, extTWCC: 5}
+	sub := map[string]uint8{extMid: 2, extDD: 15}
+	m := newExtRemap(pub, sub)
+
+	orig := &rtp.Packet{}
+	_ = orig.SetExtension(1, []byte("0"))
+	_ = orig.SetExtension(3, []byte{0xAA, 0xBB})
+	_ = orig.SetExtension(5, []byte{0x00, 0x01})
+	pkt := *orig
+	m.apply(&pkt)
+
+	if got := pkt.GetExtensionIDs(); len(got) != 2 {
+		t.Fatalf("Expected unnegotiated extension dropped, got ids %v", got)
+	}
+	if !bytes.Equal(pkt.GetExtension(2), []byte("0")) || !bytes.Equal(pkt.GetExtension(15), []byte{0xAA, 0xBB}) {
+		t.Errorf("Expected payloads moved to subscriber IDs unchanged")
+	}
+	if pkt.ExtensionProfile != rtpExtProfileTwoByte {
+		t.Errorf("Expected two-byte profile for ID above 14, got %#x", pkt.ExtensionProfile)
+	}
+	if _, err := pkt.Marshal(); err != nil {
+		t.Errorf("marshal remapped packet: %v", err)
+	}
+	if !bytes.Equal(orig.GetExtension(3), []byte{0xAA, 0xBB}) || len(orig.GetExtensionIDs()) != 3 {
+		t.Error("Expected the shared original packet untouched")
+	}
+
+	if newExtRemap(nil, sub) != nil {
+		t.Error("Expected nil remap without publisher extensions")
+	}
+	plain := rtp.Packet{Payload: []byte{1}}
+	m.apply(&plain)
+	if plain.Extension {
+		t.Error("Expected packet without extensions left alone")
+	}
+}
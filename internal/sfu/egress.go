@@ -0,0 +1,66 @@
+package sfu
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrEgressLimit 表示全局出站码率已达 MAX_EGRESS_MBPS 上限，暂不接受新的订阅者。
+var ErrEgressLimit = errors.New("egress bandwidth limit reached")
+
+// egressWindow 是统计出站码率的滑动窗口（按秒分桶）。
+const egressWindow = 5
+
+// egressMeter 统计写给全部订阅者的字节数，按最近 egressWindow 秒的滑动窗口估算出站码率，
+// 供 MAX_EGRESS_MBPS 限制与 webrtc_egress_bps 指标使用。每个转发包都会调用 add，
+// 因此全部使用原子操作而不加锁；换秒时与并发写入竞争可能丢失少量字节，只影响估算精度。零值可用。
+type egressMeter struct {
+	buckets [egressWindow]atomic.Uint64 // 每秒写出的字节数
+	seconds [egressWindow]atomic.Int64  // 各桶对应的 Unix 秒，用于识别过期桶
+	checked atomic.Int64                // 上次重算 over 的 Unix 秒
+	over    atomic.Bool                 // 最近一次重算时是否已达上限
+}
+
+// add 记录 n 字节出站数据。
+func (e *egressMeter) add(n int, now time.Time) {
+	sec := now.Unix()
+	i := sec % egressWindow
+	if old := e.seconds[i].Load(); old != sec && e.seconds[i].CompareAndSwap(old, sec) {
+		e.buckets[i].Store(0)
+	}
+	e.buckets[i].Add(uint64(n))
+}
+
+// rate 返回滑动窗口内的平均出站码率（bit/s），不含尚未结束的当前秒。
+func (e *egressMeter) rate(now time.Time) float64 {
+	sec := now.Unix()
+	var total uint64
+	for i := range e.seconds {
+		if s := e.seconds[i].Load(); s < sec && s >= sec-egressWindow+1 {
+			total += e.buckets[i].Load()
+		}
+	}
+	return float64(total*8) / (egressWindow - 1)
+}
+
+// limited 报告出站码率是否已达 limit（bit/s）。码率只按整秒变化，每秒至多重算一次，
+// 其余调用只读取缓存的结果。
+func (e *egressMeter) limited(now time.Time, limit float64) bool {
+	sec := now.Unix()
+	if old := e.checked.Load(); old != sec && e.checked.CompareAndSwap(old, sec) {
+		e.over.Store(e.rate(now) >= limit)
+	}
+	return e.over.Load()
+}
+
+// egressLimited 报告全局出站码率是否已达 MAX_EGRESS_MBPS；未配置时恒为 false。
+func (m *Manager) egressLimited(now time.Time) bool {
+	if m == nil || m.cfg == nil || m.cfg.MaxEgressMbps <= 0 {
+		return false
+	}
+	return m.egress.limited(now, m.cfg.MaxEgressMbps*1e6)
+}
+
+// EgressRate 返回当前全局出站码率（bit/s）。
+func (m *Manager) EgressRate() float64 { return m.egress.rate(time.Now()) }
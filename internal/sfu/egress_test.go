@@ -0,0 +1,91 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestEgressMeter_SlidingWindow(t *testing.T) {
+	var e egressMeter
+	start := time.Unix(1000, 0)
+	for i := 0; i < egressWindow-1; i++ {
+		e.add(125000, start.Add(time.Duration(i)*time.Second)) // 1 Mbit/s
+	}
+	e.add(1<<20, start.Add((egressWindow-1)*time.Second)) // 当前秒尚未结束，不计入
+	if got := e.rate(start.Add((egressWindow - 1) * time.Second)); got != 1e6 {
+		t.Errorf("Expected 1 Mbit/s, got %v", got)
+	}
+	if got := e.rate(start.Add(time.Hour)); got != 0 {
+		t.Errorf("Expected stale buckets to expire, got %v", got)
+	}
+}
+
+func TestEgressMeter_LimitedRecomputedPerSecond(t *testing.T) {
+	var e egressMeter
+	start := time.Unix(1000, 0)
+	e.add(1e6, start) // 8 Mbit/s，下一秒起计入
+	next := start.Add(time.Second)
+	if e.limited(start, 1e6) {
+		t.Fatal("Expected no limit before the busy second has ended")
+	}
+	if !e.limited(next, 1e6) {
+		t.Fatal("Expected limit once the busy second is in the window")
+	}
+	if !e.limited(next.Add(500*time.Millisecond), 1e12) {
+		t.Error("Expected cached result within the same second")
+	}
+	if e.limited(next.Add(time.Second), 1e12) {
+		t.Error("Expected result recomputed in the next second")
+	}
+}
+
+// countingSink 统计收到的包数。
+type countingSink struct{ n atomic.Int64 }
+
+func (s *countingSink) WriteRTP(*rtp.Packet) error { s.n.Add(1); return nil }
+
+func TestTrackFanout_DropsVideoOverEgressLimit(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.MaxEgressMbps = 1
+	now := time.Now()
+	for i := 1; i < egressWindow; i++ {
+		mgr.egress.add(1e6, now.Add(-time.Duration(i)*time.Second)) // 8 Mbit/s
+	}
+
+	raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1}, Payload: []byte{1}}).Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var scratch rtp.Packet
+	for _, video := range []bool{true, false} {
+		sink := &countingSink{}
+		f := newTrackFanout(nil, "room")
+		f.video, f.mgr = video, mgr
+		f.locals[&webrtc.PeerConnection{}] = newSubWriter(sink, nil)
+		f.forward(raw, &scratch)
+		f.close()
+		time.Sleep(10 * time.Millisecond)
+		want := int64(1) // 音频照常转发
+		if video {
+			want = 0
+		}
+		if sink.n.Load() != want {
+			t.Errorf("video=%v: expected %d packets forwarded, got %d", video, want, sink.n.Load())
+		}
+	}
+
+	room := mgr.getOrCreateRoom("egress")
+	if _, err := room.Subscribe(context.Background(), "v=0"); !errors.Is(err, ErrEgressLimit) {
+		t.Errorf("Expected ErrEgressLimit for new subscriber, got %v", err)
+	}
+	cfg.MaxEgressMbps = 0
+	if mgr.egressLimited(now) {
+		t.Error("Expected no limit when MAX_EGRESS_MBPS is 0")
+	}
+}
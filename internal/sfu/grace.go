@@ -0,0 +1,33 @@
+package sfu
+
+import (
+	"sync"
+	"time"
+)
+
+// graceTimer 在 ICE 进入 Disconnected 后延迟执行清理；期间恢复 Connected 时取消。
+// Disconnected 往往只是短暂的网络抖动，ICE 会自行恢复，不应立即断流。
+type graceTimer struct {
+	mu sync.Mutex
+	t  *time.Timer
+}
+
+// start 启动宽限计时，已在计时中则保持原有截止时间。
+func (g *graceTimer) start(d time.Duration, fn func()) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.t != nil {
+		return
+	}
+	g.t = time.AfterFunc(d, fn)
+}
+
+// stop 取消尚未触发的宽限计时。
+func (g *graceTimer) stop() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.t != nil {
+		g.t.Stop()
+		g.t = nil
+	}
+}
@@ -0,0 +1,24 @@
+package sfu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGraceTimer_CancelOnRecover(t *testing.T) {
+	var fired atomic.Bool
+	var g graceTimer
+	g.start(30*time.Millisecond, func() { fired.Store(true) })
+	g.stop()
+	time.Sleep(60 * time.Millisecond)
+	if fired.Load() {
+		t.Error("Expected grace callback not to fire after recovery")
+	}
+
+	g.start(10*time.Millisecond, func() { fired.Store(true) })
+	time.Sleep(60 * time.Millisecond)
+	if !fired.Load() {
+		t.Error("Expected grace callback to fire after the grace period")
+	}
+}
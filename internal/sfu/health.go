@@ -0,0 +1,47 @@
+package sfu
+
+import "time"
+
+// RoomHealth 描述房间媒体流是否在持续到达，供针对单个直播流的拨测使用。
+type RoomHealth struct {
+	Room            string `json:"room"`
+	HasPublisher    bool   `json:"hasPublisher"`
+	Tracks          int    `json:"tracks"`
+	LastPacketAgeMs int64  `json:"lastPacketAgeMs"` // 所有轨道中最近一次收到 RTP 距今的毫秒数，无轨道时为 -1
+	Healthy         bool   `json:"healthy"`
+	Reason          string `json:"reason,omitempty"`
+}
+
+// RoomHealth 检查房间是否有发布者，且在 maxAge 内收到过 RTP；不存在的房间视为不健康，且不会被创建。
+func (m *Manager) RoomHealth(name string, maxAge time.Duration) RoomHealth {
+	m.mu.RLock()
+	r, ok := m.rooms[name]
+	m.mu.RUnlock()
+	if !ok {
+		return RoomHealth{Room: name, LastPacketAgeMs: -1, Reason: "room not found"}
+	}
+	return r.health(time.Now(), maxAge)
+}
+
+func (r *Room) health(now time.Time, maxAge time.Duration) RoomHealth {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	h := RoomHealth{Room: r.name, HasPublisher: len(r.publishers) > 0, Tracks: len(r.trackFeeds), LastPacketAgeMs: -1}
+	for _, f := range r.trackFeeds {
+		age := f.idleFor(now).Milliseconds()
+		if h.LastPacketAgeMs < 0 || age < h.LastPacketAgeMs {
+			h.LastPacketAgeMs = age
+		}
+	}
+	switch {
+	case !h.HasPublisher:
+		h.Reason = "no publisher"
+	case h.Tracks == 0:
+		h.Reason = "no tracks"
+	case time.Duration(h.LastPacketAgeMs)*time.Millisecond > maxAge:
+		h.Reason = "no recent RTP"
+	default:
+		h.Healthy = true
+	}
+	return h
+}
@@ -0,0 +1,35 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestManager_RoomHealth(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	if st := mgr.RoomHealth("missing", time.Second); st.Healthy || st.Reason != "room not found" {
+		t.Errorf("Expected missing room to be unhealthy, got %+v", st)
+	}
+	if len(mgr.ListRooms()) != 0 {
+		t.Error("Expected health check not to create the room")
+	}
+
+	room := mgr.getOrCreateRoom("live")
+	if st := mgr.RoomHealth("live", time.Second); st.Healthy || st.Reason != "no publisher" {
+		t.Errorf("Expected room without publisher to be unhealthy, got %+v", st)
+	}
+
+	feed := newTrackFanout(nil, room.name)
+	room.mu.Lock()
+	room.trackFeeds["t1"] = feed
+	room.mu.Unlock()
+	now := time.Now()
+
+	feed.markRead(now.Add(-10 * time.Second))
+	st := room.health(now, 5*time.Second)
+	if st.Tracks != 1 || st.LastPacketAgeMs < 10000 {
+		t.Errorf("Expected stale track age, got %+v", st)
+	}
+}
@@ -0,0 +1,126 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+)
+
+// newIVFWriter 按轨道编码创建 IVF 写入器，保证文件头中的 FourCC 与实际编码一致。
+// VP8/AV1 使用 pion 的 ivfwriter.WithCodec；pion v3 的 ivfwriter 不支持 VP9，
+// 因此 VP9 使用本地的 vp9IVFWriter（FourCC "VP90"）。
+func newIVFWriter(path, mimeType string) (rtpWriter, error) {
+	if mimeType == webrtc.MimeTypeVP9 {
+		return newVP9IVFWriter(path)
+	}
+	return ivfwriter.New(path, ivfwriter.WithCodec(mimeType))
+}
+
+// newIVFWriterTo 与 newIVFWriter 相同，但写入任意 io.Writer（如对象存储直传流）。
+// 非文件输出无法回写文件头中的帧数，该字段保持为 0，主流播放器会忽略它。
+func newIVFWriterTo(out io.Writer, mimeType string) (rtpWriter, error) {
+	if mimeType == webrtc.MimeTypeVP9 {
+		return newVP9IVFWriterTo(out)
+	}
+	return ivfwriter.NewWith(out, ivfwriter.WithCodec(mimeType))
+}
+
+// writeIVFHeader 写入 32 字节 IVF 文件头，各字段取值与 pion ivfwriter 保持一致。
+func writeIVFHeader(f io.Writer, fourcc string) error {
+	header := make([]byte, 32)
+	copy(header[0:], "DKIF")
+	binary.LittleEndian.PutUint16(header[4:], 0)  // 版本
+	binary.LittleEndian.PutUint16(header[6:], 32) // 头长度
+	copy(header[8:], fourcc)
+	binary.LittleEndian.PutUint16(header[12:], 640) // 宽
+	binary.LittleEndian.PutUint16(header[14:], 480) // 高
+	binary.LittleEndian.PutUint32(header[16:], 30)  // 帧率分母
+	binary.LittleEndian.PutUint32(header[20:], 1)   // 帧率分子
+	binary.LittleEndian.PutUint32(header[24:], 0)   // 帧数，Close 时回填
+	_, err := f.Write(header)
+	return err
+}
+
+// vp9IVFWriter 把 VP9 RTP 包按帧重组后写入 IVF，等到首个关键帧才开始写入。
+type vp9IVFWriter struct {
+	out          io.Writer
+	count        uint64
+	frame        []byte
+	seenKeyFrame bool
+}
+
+func newVP9IVFWriter(path string) (*vp9IVFWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := newVP9IVFWriterTo(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func newVP9IVFWriterTo(out io.Writer) (*vp9IVFWriter, error) {
+	if err := writeIVFHeader(out, "VP90"); err != nil {
+		return nil, err
+	}
+	return &vp9IVFWriter{out: out}, nil
+}
+
+func (w *vp9IVFWriter) WriteRTP(pkt *rtp.Packet) error {
+	if w.out == nil || len(pkt.Payload) == 0 {
+		return nil
+	}
+	var vp9 codecs.VP9Packet
+	if _, err := vp9.Unmarshal(pkt.Payload); err != nil {
+		return err
+	}
+	if !w.seenKeyFrame {
+		if vp9.P || !vp9.B {
+			return nil
+		}
+		w.seenKeyFrame = true
+	}
+	if w.frame == nil && !vp9.B {
+		return nil
+	}
+	w.frame = append(w.frame, vp9.Payload...)
+	if !pkt.Marker {
+		return nil
+	}
+	frameHeader := make([]byte, 12)
+	binary.LittleEndian.PutUint32(frameHeader[0:], uint32(len(w.frame)))
+	binary.LittleEndian.PutUint64(frameHeader[4:], w.count)
+	w.count++
+	_, err := w.out.Write(append(frameHeader, w.frame...))
+	w.frame = nil
+	return err
+}
+
+// Close 回填帧数（仅文件输出）并关闭输出，可重复调用。
+func (w *vp9IVFWriter) Close() error {
+	if w.out == nil {
+		return nil
+	}
+	out := w.out
+	w.out = nil
+	if f, ok := out.(*os.File); ok {
+		buf := make([]byte, 4)
+		binary.LittleEndian.PutUint32(buf, uint32(w.count))
+		if _, err := f.WriteAt(buf, 24); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+	if c, ok := out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
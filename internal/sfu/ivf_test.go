@@ -0,0 +1,60 @@
+package sfu
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestNewIVFWriter_FourCC(t *testing.T) {
+	tests := []struct {
+		mime   string
+		fourcc string
+	}{
+		{webrtc.MimeTypeVP8, "VP80"},
+		{webrtc.MimeTypeVP9, "VP90"},
+		{webrtc.MimeTypeAV1, "AV01"},
+	}
+	dir := t.TempDir()
+	for _, test := range tests {
+		t.Run(test.mime, func(t *testing.T) {
+			p := filepath.Join(dir, test.fourcc+".ivf")
+			w, err := newIVFWriter(p, test.mime)
+			if err != nil {
+				t.Fatalf("Expected writer for %s, got %v", test.mime, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			data, err := os.ReadFile(p)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if len(data) < 32 || string(data[0:4]) != "DKIF" {
+				t.Fatalf("Expected IVF header, got %q", data)
+			}
+			if got := string(data[8:12]); got != test.fourcc {
+				t.Errorf("Expected FourCC %s, got %s", test.fourcc, got)
+			}
+		})
+	}
+}
+
+func TestNewIVFWriterTo_Stream(t *testing.T) {
+	for mime, fourcc := range map[string]string{webrtc.MimeTypeVP8: "VP80", webrtc.MimeTypeVP9: "VP90"} {
+		var buf bytes.Buffer
+		w, err := newIVFWriterTo(&buf, mime)
+		if err != nil {
+			t.Fatalf("Expected writer for %s, got %v", mime, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		if data := buf.Bytes(); len(data) != 32 || string(data[8:12]) != fourcc {
+			t.Errorf("Expected %s IVF header on stream, got %q", fourcc, data)
+		}
+	}
+}
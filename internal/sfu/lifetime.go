@@ -0,0 +1,63 @@
+package sfu
+
+import (
+	"log"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// EnforceSessionLifetime 按 maxAge 的十分之一周期（至少 1 秒）清理超过 SESSION_MAX_LIFETIME 的会话，
+// 客户端须重新鉴权后再推流或播放。阻塞运行，调用方应以 goroutine 启动。
+func (m *Manager) EnforceSessionLifetime(maxAge time.Duration) {
+	ticker := time.NewTicker(max(maxAge/10, time.Second))
+	defer ticker.Stop()
+	for now := range ticker.C {
+		m.ExpireSessions(now, maxAge)
+	}
+}
+
+// ExpireSessions 关闭所有在 now 时已存在超过 maxAge 的发布者与订阅者会话，返回关闭的数量。
+// 会话时长从首次建立算起，断线恢复与发布者迁移不会重新计时。
+func (m *Manager) ExpireSessions(now time.Time, maxAge time.Duration) int {
+	m.mu.RLock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.mu.RUnlock()
+	n := 0
+	for _, r := range rooms {
+		n += r.expireSessions(now, maxAge)
+	}
+	return n
+}
+
+func (r *Room) expireSessions(now time.Time, maxAge time.Duration) int {
+	r.mu.RLock()
+	var pubs []*webrtc.PeerConnection
+	for _, p := range r.publishers {
+		if now.Sub(p.start) >= maxAge {
+			pubs = append(pubs, p.pc)
+		}
+	}
+	var subs []*webrtc.PeerConnection
+	for pc, s := range r.subs {
+		if now.Sub(s.started) >= maxAge {
+			subs = append(subs, pc)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, pc := range pubs {
+		log.Printf("sfu: room %s publisher exceeded session max lifetime %s, closing", r.name, maxAge)
+		r.closePublisher(pc)
+	}
+	for _, pc := range subs {
+		r.removeSubscriber(pc)
+	}
+	if len(subs) > 0 {
+		log.Printf("sfu: room %s closed %d subscribers exceeding session max lifetime %s", r.name, len(subs), maxAge)
+	}
+	return len(pubs) + len(subs)
+}
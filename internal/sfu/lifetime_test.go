@@ -0,0 +1,31 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestManager_ExpireSessions(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+	ctx := context.Background()
+
+	if _, err := mgr.Publish(ctx, "lifetime-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if _, err := mgr.Subscribe(ctx, "lifetime-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	room := mgr.getOrCreateRoom("lifetime-room")
+
+	if n := mgr.ExpireSessions(time.Now(), time.Hour); n != 0 {
+		t.Errorf("Expected fresh sessions to be kept, closed %d", n)
+	}
+	if n := mgr.ExpireSessions(time.Now().Add(2*time.Hour), time.Hour); n != 2 {
+		t.Errorf("Expected publisher and subscriber to expire, closed %d", n)
+	}
+	if info := room.stats(); info.HasPublisher || info.Subscribers != 0 {
+		t.Errorf("Expected expired sessions to be closed, got %+v", info)
+	}
+}
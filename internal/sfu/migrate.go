@@ -0,0 +1,159 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// migrateTimeout 是发布者迁移时等待新连接接管全部轨道的最长时间，超时后关闭旧连接并移除未接管的轨道。
+const migrateTimeout = 10 * time.Second
+
+// migration 记录一次发布者迁移：旧连接与尚待新连接接管的 fanout（key 为 trackFeeds 中的键）。
+type migration struct {
+	old     *webrtc.PeerConnection
+	pending map[string]*trackFanout
+	once    sync.Once
+}
+
+func newMigration(old *webrtc.PeerConnection, feeds map[string]*trackFanout) *migration {
+	m := &migration{old: old, pending: make(map[string]*trackFanout, len(feeds))}
+	for k, f := range feeds {
+		m.pending[k] = f
+	}
+	return m
+}
+
+// start 在新连接就位后启动超时兜底；调用方不得持有 r.mu。
+func (m *migration) start(r *Room) {
+	time.AfterFunc(migrateTimeout, func() { r.finishMigration(m) })
+	r.mu.RLock()
+	done := len(m.pending) == 0
+	r.mu.RUnlock()
+	if done {
+		r.finishMigration(m)
+	}
+}
+
+// adoptTrack 让新连接的 remote 接管一个类型与编码相同、尚未被接管的旧 fanout。
+// 接管成功返回 true；否则调用方按新轨道处理。
+func (r *Room) adoptTrack(m *migration, pc *webrtc.PeerConnection, remote *webrtc.TrackRemote) bool {
+	r.mu.Lock()
+	var key string
+	var feed *trackFanout
+	for k, f := range m.pending {
+		src := f.source()
+		if r.trackFeeds[k] == f && src.Kind() == remote.Kind() && src.Codec().MimeType == remote.Codec().MimeType {
+			key, feed = k, f
+			break
+		}
+	}
+	if feed == nil {
+		r.mu.Unlock()
+		return false
+	}
+	delete(m.pending, key)
+	delete(r.trackFeeds, key)
+	r.trackFeeds[feedKey(feed.publisher, remote.ID())] = feed
+	feed.swapSource(remote)
+	done := len(m.pending) == 0
+	r.mu.Unlock()
+
+	if remote.Kind() == webrtc.RTPCodecTypeVideo {
+		_ = pc.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remote.SSRC())}})
+	}
+	if done {
+		go r.finishMigration(m)
+	}
+	return true
+}
+
+// finishMigration 关闭旧发布连接，并移除到期仍未被接管的旧 fanout。可重复调用。
+func (r *Room) finishMigration(m *migration) {
+	m.once.Do(func() {
+		r.mu.Lock()
+		var stale []*trackFanout
+		for k, f := range m.pending {
+			if r.trackFeeds[k] == f {
+				delete(r.trackFeeds, k)
+			}
+			stale = append(stale, f)
+		}
+		m.pending = nil
+		r.mu.Unlock()
+		for _, f := range stale {
+			f.close()
+		}
+		if len(stale) > 0 {
+			log.Printf("sfu: room %s publisher migration dropped %d track(s) not taken over", r.name, len(stale))
+		}
+		_ = m.old.Close()
+	})
+}
+
+// source 返回 fanout 当前的数据源。
+func (f *trackFanout) source() *webrtc.TrackRemote { return f.src.Load() }
+
+// swapSource 原子替换数据源；readLoop 读到的下一个新源数据包会重新计算序列号与时间戳偏移。
+func (f *trackFanout) swapSource(remote *webrtc.TrackRemote) {
+	f.rebase.Store(true)
+	f.src.Store(remote)
+}
+
+// continuity 改写 RTP 序列号与时间戳，使迁移前后的输出保持连续，订阅端解码器与录制不会因跳变而重置。
+type continuity struct {
+	seqOff  uint16
+	tsOff   uint32
+	lastSeq uint16
+	lastTS  uint32
+	started bool
+}
+
+// rewriteSeq 对从 remote 读到的 raw 做连续性改写，迁移后的首个包按源时钟频率取约 20ms 的时间戳步长。
+func (f *trackFanout) rewriteSeq(raw []byte, remote *webrtc.TrackRemote) {
+	rebase := f.rebase.Swap(false)
+	step := uint32(960)
+	if rebase {
+		if rate := remote.Codec().ClockRate; rate > 0 {
+			step = rate / 50
+		}
+	}
+	f.seq.apply(raw, rebase, step)
+}
+
+// apply 就地改写 raw 中的 RTP 头。rebase 为 true 时（新源的第一个包）重新计算偏移，
+// 新源首包的时间戳接在上一个包之后 step。
+func (c *continuity) apply(raw []byte, rebase bool, step uint32) {
+	if len(raw) < 12 {
+		return
+	}
+	seq := binary.BigEndian.Uint16(raw[2:4])
+	ts := binary.BigEndian.Uint32(raw[4:8])
+	if rebase && c.started {
+		c.seqOff = c.lastSeq + 1 - seq
+		c.tsOff = c.lastTS + step - ts
+	}
+	seq += c.seqOff
+	ts += c.tsOff
+	if c.seqOff != 0 || c.tsOff != 0 {
+		binary.BigEndian.PutUint16(raw[2:4], seq)
+		binary.BigEndian.PutUint32(raw[4:8], ts)
+	}
+	c.lastSeq, c.lastTS, c.started = seq, ts, true
+}
+
+// MigratePublisher 根据房间名以新连接接替现有发布者，房间不存在时返回 ErrRoomNotFound。
+func (m *Manager) MigratePublisher(ctx context.Context, roomName, offerSDP string) (string, error) {
+	m.mu.RLock()
+	r, ok := m.rooms[roomName]
+	m.mu.RUnlock()
+	if !ok {
+		return "", ErrRoomNotFound
+	}
+	return r.MigratePublisher(ctx, offerSDP)
+}
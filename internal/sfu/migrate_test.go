@@ -0,0 +1,176 @@
+package sfu
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestContinuity_RebaseAfterSwitch(t *testing.T) {
+	pkt := func(seq uint16, ts uint32) []byte {
+		b := make([]byte, 12)
+		b[0] = 0x80
+		binary.BigEndian.PutUint16(b[2:4], seq)
+		binary.BigEndian.PutUint32(b[4:8], ts)
+		return b
+	}
+	var c continuity
+	for i, in := range [][]byte{pkt(100, 5000), pkt(101, 5960)} {
+		c.apply(in, i == 0, 960)
+		if got := binary.BigEndian.Uint16(in[2:4]); got != uint16(100+i) {
+			t.Fatalf("Expected untouched seq before switch, got %d", got)
+		}
+	}
+
+	// 新源从完全不同的序列号/时间戳开始
+	next := pkt(40000, 123)
+	c.apply(next, true, 960)
+	if seq, ts := binary.BigEndian.Uint16(next[2:4]), binary.BigEndian.Uint32(next[4:8]); seq != 102 || ts != 5960+960 {
+		t.Errorf("Expected rebased seq 102 ts %d, got %d %d", 5960+960, seq, ts)
+	}
+	after := pkt(40001, 1083)
+	c.apply(after, false, 960)
+	if seq, ts := binary.BigEndian.Uint16(after[2:4]), binary.BigEndian.Uint32(after[4:8]); seq != 103 || ts != 5960+960+960 {
+		t.Errorf("Expected offsets kept after rebase, got %d %d", seq, ts)
+	}
+}
+
+// newTestPublisher 建立一个只含 Opus 轨道、持续发送以 tag 为负载的 RTP 的发布连接，返回 Offer。
+func newTestPublisher(t *testing.T, tag byte) (*webrtc.PeerConnection, string, func()) {
+	t.Helper()
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	track, _ := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2}, "audio", "pub")
+	if _, err := pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	offer, _ := pc.CreateOffer(nil)
+	g := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-g
+	stop := make(chan struct{})
+	send := func() {
+		go func() {
+			ticker := time.NewTicker(10 * time.Millisecond)
+			defer ticker.Stop()
+			seq := uint16(tag) << 8
+			for {
+				select {
+				case <-stop:
+					return
+				case <-ticker.C:
+				}
+				seq++
+				_ = track.WriteRTP(&rtp.Packet{Header: rtp.Header{Version: 2, SequenceNumber: seq, Timestamp: uint32(seq) * 480}, Payload: []byte{tag}})
+			}
+		}()
+	}
+	t.Cleanup(func() { close(stop) })
+	return pc, pc.LocalDescription().SDP, send
+}
+
+func TestRoom_MigratePublisher_KeepsSubscriberTrack(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.STUN = nil
+	defer mgr.CloseAll()
+	ctx := context.Background()
+
+	if _, err := mgr.MigratePublisher(ctx, "migrate-room", "v=0"); !errors.Is(err, ErrRoomNotFound) {
+		t.Fatalf("Expected ErrRoomNotFound, got %v", err)
+	}
+
+	pubA, offerA, sendA := newTestPublisher(t, 0xA)
+	answer, err := mgr.Publish(ctx, "migrate-room", offerA)
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	if err := pubA.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	sendA()
+	room := mgr.getOrCreateRoom("migrate-room")
+	waitFor(t, "publisher track", func() bool { return room.stats().Tracks == 1 })
+
+	// 订阅者只协商一次，迁移前后都应从同一个远端轨道收到数据
+	m := &webrtc.MediaEngine{}
+	_ = m.RegisterDefaultCodecs()
+	sub, err := webrtc.NewAPI(webrtc.WithMediaEngine(m)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	_, _ = sub.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly})
+	var tracks atomic.Int32
+	var lastTag atomic.Int32
+	sub.OnTrack(func(remote *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
+		tracks.Add(1)
+		for {
+			p, _, err := remote.ReadRTP()
+			if err != nil {
+				return
+			}
+			if len(p.Payload) > 0 {
+				lastTag.Store(int32(p.Payload[0]))
+			}
+		}
+	})
+	subOffer, _ := sub.CreateOffer(nil)
+	g := webrtc.GatheringCompletePromise(sub)
+	_ = sub.SetLocalDescription(subOffer)
+	<-g
+	subAnswer, err := mgr.Subscribe(ctx, "migrate-room", sub.LocalDescription().SDP)
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if err := sub.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: subAnswer}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "media from publisher A", func() bool { return lastTag.Load() == 0xA })
+
+	room.mu.RLock()
+	oldPC := room.firstPublisherLocked().pc
+	room.mu.RUnlock()
+	pubB, offerB, sendB := newTestPublisher(t, 0xB)
+	answer, err = mgr.MigratePublisher(ctx, "migrate-room", offerB)
+	if err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if err := pubB.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	sendB()
+	waitFor(t, "media from publisher B", func() bool { return lastTag.Load() == 0xB })
+	if n := tracks.Load(); n != 1 {
+		t.Errorf("Expected subscriber to keep a single track, got %d", n)
+	}
+	waitFor(t, "old publisher closed", func() bool { return oldPC.ConnectionState() == webrtc.PeerConnectionStateClosed })
+	if info := room.stats(); !info.HasPublisher || info.Tracks != 1 {
+		t.Errorf("Expected migrated room to keep one track, got %+v", info)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
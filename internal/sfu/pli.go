@@ -0,0 +1,59 @@
+package sfu
+
+import (
+	"time"
+
+	"github.com/pion/rtcp"
+)
+
+// pliMinGap 是同一轨道两次 PLI 的最小间隔：多名观众同时加入时只请求一次关键帧。
+const pliMinGap = 500 * time.Millisecond
+
+// pliInterval 返回周期性 PLI 的间隔（PLI_INTERVAL），0 表示只在观众加入时请求关键帧。
+func (r *Room) pliInterval() time.Duration {
+	if r.mgr == nil || r.mgr.cfg == nil {
+		return 2 * time.Second
+	}
+	return r.mgr.cfg.PLIInterval
+}
+
+// periodicPLI 按 interval 周期性向发布端请求关键帧，减轻画面马赛克；房间没有订阅者时跳过，
+// 节省上行带宽。fanout 关闭或其发布者离开时退出；迁移后跟随新的数据源与发布连接。
+func (r *Room) periodicPLI(feed *trackFanout, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-feed.closed:
+			return
+		case <-ticker.C:
+		}
+		r.mu.RLock()
+		gone, idle := r.publishers[feed.publisher] == nil, len(r.subs) == 0
+		r.mu.RUnlock()
+		if gone {
+			return
+		}
+		if !idle {
+			r.requestTrackKeyframe(feed)
+		}
+	}
+}
+
+// requestTrackKeyframe 向 feed 当前数据源所属的发布连接发送 PLI；距上次发送不足 pliMinGap 时跳过。
+// 需在未持有 r.mu 时调用。
+func (r *Room) requestTrackKeyframe(feed *trackFanout) {
+	now := time.Now().UnixNano()
+	last := feed.lastPLI.Load()
+	if now-last < int64(pliMinGap) || !feed.lastPLI.CompareAndSwap(last, now) {
+		return
+	}
+	r.mu.RLock()
+	pub := r.publisherPCLocked(feed.publisher)
+	r.mu.RUnlock()
+	src := feed.source()
+	if pub == nil || src == nil {
+		return
+	}
+	_ = pub.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(src.SSRC())}})
+}
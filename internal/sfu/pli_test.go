@@ -0,0 +1,49 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoom_RequestTrackKeyframe_Coalesces(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+	room := mgr.getOrCreateRoom("pli")
+	feed := newTrackFanout(nil, room.name)
+
+	room.requestTrackKeyframe(feed)
+	first := feed.lastPLI.Load()
+	if first == 0 {
+		t.Fatal("Expected keyframe request to be recorded")
+	}
+	room.requestTrackKeyframe(feed)
+	if feed.lastPLI.Load() != first {
+		t.Error("Expected a second request within pliMinGap to be coalesced")
+	}
+	feed.lastPLI.Store(first - int64(pliMinGap))
+	room.requestTrackKeyframe(feed)
+	if feed.lastPLI.Load() == first-int64(pliMinGap) {
+		t.Error("Expected a request after pliMinGap to be sent")
+	}
+}
+
+func TestRoom_PeriodicPLI_ExitsWithoutPublisher(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	defer mgr.CloseAll()
+	cfg.PLIInterval = 5 * time.Millisecond
+	room := mgr.getOrCreateRoom("pli")
+	if room.pliInterval() != 5*time.Millisecond {
+		t.Fatalf("Expected PLI_INTERVAL from config, got %s", room.pliInterval())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		room.periodicPLI(newTrackFanout(nil, room.name), room.pliInterval())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected periodic PLI to stop once the room has no publisher")
+	}
+}
@@ -0,0 +1,66 @@
+package sfu
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// publisherSession 是房间内的一路发布连接。房间默认只允许一个发布者，
+// MAX_PUBLISHERS_PER_ROOM 大于 1 时多名参与者可同时推流，各自的轨道都转发给房间内的订阅者。
+type publisherSession struct {
+	id    string                 // 发布者 ID，即 WHIP 会话 ID，也是 RoomInfo.Publishers 中的取值
+	pc    *webrtc.PeerConnection // 迁移后替换为新连接
+	ice   *localCandidates       // 发布连接的本地候选
+	start time.Time              // 会话建立时间（迁移时沿用）
+}
+
+// maxPublishers 返回房间允许同时存在的发布者数量，未配置时为 1。
+func (r *Room) maxPublishers() int {
+	if r.mgr == nil || r.mgr.cfg == nil || r.mgr.cfg.MaxPublishersPerRoom < 1 {
+		return 1
+	}
+	return r.mgr.cfg.MaxPublishersPerRoom
+}
+
+// publishersLocked 按会话建立时间返回全部发布者。调用方需持有 r.mu。
+func (r *Room) publishersLocked() []*publisherSession {
+	out := make([]*publisherSession, 0, len(r.publishers))
+	for _, p := range r.publishers {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].start.Equal(out[j].start) {
+			return out[i].start.Before(out[j].start)
+		}
+		return out[i].id < out[j].id
+	})
+	return out
+}
+
+// firstPublisherLocked 返回最早加入的发布者，没有时返回 nil。调用方需持有 r.mu。
+func (r *Room) firstPublisherLocked() *publisherSession {
+	if ps := r.publishersLocked(); len(ps) > 0 {
+		return ps[0]
+	}
+	return nil
+}
+
+// publisherByPCLocked 返回使用连接 pc 的发布者，没有时返回 nil。调用方需持有 r.mu。
+func (r *Room) publisherByPCLocked(pc *webrtc.PeerConnection) *publisherSession {
+	for _, p := range r.publishers {
+		if p.pc == pc {
+			return p
+		}
+	}
+	return nil
+}
+
+// publisherPCLocked 返回发布者 id 的当前连接，发布者已离开时返回 nil。调用方需持有 r.mu。
+func (r *Room) publisherPCLocked(id string) *webrtc.PeerConnection {
+	if p := r.publishers[id]; p != nil {
+		return p.pc
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestRoom_MultiplePublishers(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	defer mgr.CloseAll()
+	cfg.MaxPublishersPerRoom = 2
+	ctx := context.Background()
+
+	_, idA, err := mgr.PublishWithID(ctx, "multi", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected first publish to succeed, got %v", err)
+	}
+	_, idB, err := mgr.PublishWithID(ctx, "multi", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected second publish to succeed, got %v", err)
+	}
+	if _, err := mgr.Publish(ctx, "multi", newTestOffer(t, nil)); !errors.Is(err, ErrPublisherExists) {
+		t.Errorf("Expected ErrPublisherExists beyond MAX_PUBLISHERS_PER_ROOM, got %v", err)
+	}
+	if _, err := mgr.MigratePublisher(ctx, "multi", newTestOffer(t, nil)); !errors.Is(err, ErrMultiplePublishers) {
+		t.Errorf("Expected ErrMultiplePublishers for migration, got %v", err)
+	}
+
+	room := mgr.getOrCreateRoom("multi")
+	room.mu.Lock()
+	for _, pub := range []string{idA, idB} {
+		// 不同发布者的 track ID 可以相同，按发布者区分键
+		f := newTrackFanout(nil, room.name)
+		f.publisher = pub
+		room.trackFeeds[feedKey(pub, "video")] = f
+	}
+	pcA := room.publishers[idA].pc
+	room.mu.Unlock()
+
+	info := room.stats()
+	if !info.HasPublisher || info.Tracks != 2 || len(info.Publishers) != 2 || info.Publishers[0] != idA {
+		t.Fatalf("Expected both publishers aggregated in room info, got %+v", info)
+	}
+
+	room.closePublisher(pcA)
+	info = room.stats()
+	if !info.HasPublisher || info.Tracks != 1 || len(info.Publishers) != 1 || info.Publishers[0] != idB {
+		t.Errorf("Expected only the remaining publisher and its track, got %+v", info)
+	}
+	if _, err := mgr.Publish(ctx, "multi", newTestOffer(t, nil)); err != nil {
+		t.Errorf("Expected publish to succeed after a publisher left, got %v", err)
+	}
+}
+
+func TestSubscriber_WantsExclude(t *testing.T) {
+	a, b := &trackFanout{publisher: "a"}, &trackFanout{publisher: "b"}
+	s := &subscriber{exclude: "a"}
+	if s.wants(a) || !s.wants(b) {
+		t.Error("Expected subscriber to skip only the excluded publisher")
+	}
+}
+
+func TestHasIdleTransceiver(t *testing.T) {
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	if !hasIdleTransceiver(pc, webrtc.RTPCodecTypeVideo) || hasIdleTransceiver(pc, webrtc.RTPCodecTypeAudio) {
+		t.Fatal("Expected only the unbound video transceiver to be reusable")
+	}
+	track, _ := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "v", "s")
+	if _, err := pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+	if hasIdleTransceiver(pc, webrtc.RTPCodecTypeVideo) {
+		t.Error("Expected no idle video transceiver once a track is bound, later tracks would need a new m-line")
+	}
+}
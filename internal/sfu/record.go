@@ -0,0 +1,156 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidRecordingName 表示录制文件名含路径或扩展名不是录制格式，见 IsRecordingName。
+	ErrInvalidRecordingName = errors.New("invalid recording name")
+	// ErrRecordingNotFound 表示 RECORD_DIR 中不存在该录制文件。
+	ErrRecordingNotFound = errors.New("recording not found")
+	// ErrRecordingActive 表示录制文件仍在被写入。
+	ErrRecordingActive = errors.New("recording is still being written")
+)
+
+// RecordingStats 是录制文件旁路 JSON 的内容，让录制文件对下游处理流程自描述。
+type RecordingStats struct {
+	Room           string    `json:"room"`
+	TrackID        string    `json:"trackId"`
+	Codec          string    `json:"codec"`
+	StartTime      time.Time `json:"startTime"`
+	EndTime        time.Time `json:"endTime"`
+	Bytes          int64     `json:"bytes"`
+	Packets        int64     `json:"packets"`
+	PeakBitrateBps float64   `json:"peakBitrateBps"`
+}
+
+// recStats 在 readLoop 中累计录制统计，峰值码率按 1 秒窗口计算。
+type recStats struct {
+	RecordingStats
+	winStart time.Time
+	winBytes int64
+}
+
+func (s *recStats) add(n int, now time.Time) {
+	s.Bytes += int64(n)
+	s.Packets++
+	if s.winStart.IsZero() {
+		s.winStart = now
+	}
+	s.winBytes += int64(n)
+	if el := now.Sub(s.winStart); el >= time.Second {
+		if bps := float64(s.winBytes*8) / el.Seconds(); bps > s.PeakBitrateBps {
+			s.PeakBitrateBps = bps
+		}
+		s.winStart = now
+		s.winBytes = 0
+	}
+}
+
+// SidecarPath 返回录制文件对应的旁路 JSON 路径，例如 a.ivf -> a.json。
+func SidecarPath(recPath string) string {
+	return strings.TrimSuffix(recPath, filepath.Ext(recPath)) + ".json"
+}
+
+// ReadSidecar 读取录制文件的旁路统计信息。
+func ReadSidecar(recPath string) (*RecordingStats, error) {
+	data, err := os.ReadFile(SidecarPath(recPath))
+	if err != nil {
+		return nil, err
+	}
+	var st RecordingStats
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// writeSidecar 将统计信息写到录制文件旁，返回旁路文件路径。
+func writeSidecar(recPath string, st RecordingStats) (string, error) {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	p := SidecarPath(recPath)
+	return p, os.WriteFile(p, data, 0o644)
+}
+
+// IsRecordingName 报告 name 是否为 RECORD_DIR 下的录制文件名：不含路径分隔符或 ".."，
+// 扩展名为 .ivf、.ogg、.webm、.h264 或 .mp4（由 .h264 转封装而来的文件）。
+func IsRecordingName(name string) bool {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".ivf", ".ogg", ".webm", ".h264", ".mp4":
+		return true
+	}
+	return false
+}
+
+// beginRecording 登记正在写入的录制文件 path；同一文件（如 WebM 的多个轨道）可登记多次，需对应次数的 endRecording。
+func (m *Manager) beginRecording(path string) {
+	m.recMu.Lock()
+	if m.recording == nil {
+		m.recording = make(map[string]int)
+	}
+	m.recording[path]++
+	m.recMu.Unlock()
+}
+
+// endRecording 注销一次 beginRecording。
+func (m *Manager) endRecording(path string) {
+	m.recMu.Lock()
+	if m.recording[path]--; m.recording[path] <= 0 {
+		delete(m.recording, path)
+	}
+	m.recMu.Unlock()
+}
+
+// DeleteRecording 删除 RECORD_DIR 下的录制文件及其旁路 JSON。文件名不合法时返回 ErrInvalidRecordingName，
+// 仍在写入时返回 ErrRecordingActive，不存在时返回 ErrRecordingNotFound。
+func (m *Manager) DeleteRecording(name string) error {
+	if !IsRecordingName(name) {
+		return ErrInvalidRecordingName
+	}
+	p := filepath.Join(m.cfg.RecordDir, name)
+	// 持锁删除，避免与同名文件开始录制交错
+	m.recMu.Lock()
+	defer m.recMu.Unlock()
+	if m.recording[p] > 0 {
+		return ErrRecordingActive
+	}
+	if err := os.Remove(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrRecordingNotFound
+		}
+		return err
+	}
+	_ = os.Remove(SidecarPath(p))
+	return nil
+}
+
+// trackRecording 让 feed 接管录制文件 path 的写入中登记，并在 feed 关闭录制时注销。登记由
+// openRecorder/openRecordOutput 在创建文件前完成，避免 DeleteRecording 删掉刚创建的文件；
+// 直传（path 为空）不落盘，无需登记。
+func (r *Room) trackRecording(feed *trackFanout, path string) {
+	if path == "" || r.mgr == nil {
+		return
+	}
+	feed.mu.Lock()
+	if feed.rec == nil {
+		// 登记前 feed 已关闭录制
+		feed.mu.Unlock()
+		r.mgr.endRecording(path)
+		return
+	}
+	feed.recDone = func() { r.mgr.endRecording(path) }
+	feed.mu.Unlock()
+}
@@ -0,0 +1,166 @@
+package sfu
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+type fakeRecorder struct {
+	packets int
+	closed  bool
+}
+
+func (w *fakeRecorder) WriteRTP(*rtp.Packet) error { w.packets++; return nil }
+func (w *fakeRecorder) Close() error               { w.closed = true; return nil }
+
+func TestRecStats_PeakBitrate(t *testing.T) {
+	var s recStats
+	t0 := time.Unix(1000, 0)
+	s.add(1000, t0)
+	s.add(1000, t0.Add(500*time.Millisecond))
+	s.add(500, t0.Add(time.Second)) // 窗口结束：2500 字节 / 1 秒
+	s.add(100, t0.Add(2*time.Second))
+
+	if s.Bytes != 2600 || s.Packets != 4 {
+		t.Errorf("Expected 2600 bytes / 4 packets, got %d / %d", s.Bytes, s.Packets)
+	}
+	if s.PeakBitrateBps != 20000 {
+		t.Errorf("Expected peak bitrate 20000 bps, got %v", s.PeakBitrateBps)
+	}
+}
+
+func TestTrackFanout_CloseWritesSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "room_track_1.ivf")
+	w := &fakeRecorder{}
+	f := newTrackFanout(nil, "room")
+	f.setRecorder(w, path, true)
+	f.stats.add(1200, time.Now())
+	f.stats.add(800, time.Now())
+
+	f.close()
+
+	if !w.closed {
+		t.Error("Expected recorder to be closed")
+	}
+	st, err := ReadSidecar(path)
+	if err != nil {
+		t.Fatalf("Expected sidecar to be written: %v", err)
+	}
+	if st.Room != "room" || st.Bytes != 2000 || st.Packets != 2 {
+		t.Errorf("Unexpected sidecar contents: %+v", st)
+	}
+	if st.EndTime.Before(st.StartTime) {
+		t.Errorf("Expected end time after start time: %+v", st)
+	}
+}
+
+func TestTrackFanout_RecordOnlyFastPath(t *testing.T) {
+	w := &fakeRecorder{}
+	f := newTrackFanout(nil, "room")
+	f.setRecorder(w, "", false)
+	raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1}, Payload: make([]byte, 1000)}).Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var scratch rtp.Packet
+	f.forward(raw, &scratch) // 首次解析可能为 scratch 分配内部切片
+
+	allocs := testing.AllocsPerRun(100, func() { f.forward(raw, &scratch) })
+	if allocs != 0 {
+		t.Errorf("Expected no allocations without subscribers, got %v", allocs)
+	}
+	if w.packets != 102 || f.stats.Packets != 102 {
+		t.Errorf("Expected 102 recorded packets, got %d (stats %d)", w.packets, f.stats.Packets)
+	}
+}
+
+func TestManager_DeleteRecording(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.RecordDir = t.TempDir()
+	path := filepath.Join(cfg.RecordDir, "room_track_1.ivf")
+	for _, p := range []string{path, SidecarPath(path)} {
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 录制中的文件不能删除，关闭录制后才可以；文件在创建前就已登记，trackRecording 接管之前也不能删除
+	room := NewRoom("room", mgr)
+	w, p, err := room.openRecorder("room_track_1.ivf", webrtc.MimeTypeVP8)
+	if err != nil || p != path {
+		t.Fatalf("openRecorder: %q %v", p, err)
+	}
+	if err := mgr.DeleteRecording("room_track_1.ivf"); !errors.Is(err, ErrRecordingActive) {
+		t.Fatalf("Expected ErrRecordingActive right after the file is created, got %v", err)
+	}
+	f := newTrackFanout(nil, "room")
+	f.setRecorder(w, path, false)
+	room.trackRecording(f, path)
+	if err := mgr.DeleteRecording("room_track_1.ivf"); !errors.Is(err, ErrRecordingActive) {
+		t.Fatalf("Expected ErrRecordingActive, got %v", err)
+	}
+	f.close()
+	if err := mgr.DeleteRecording("room_track_1.ivf"); err != nil {
+		t.Fatalf("Expected delete to succeed, got %v", err)
+	}
+	for _, p := range []string{path, SidecarPath(path)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", p, err)
+		}
+	}
+	if err := mgr.DeleteRecording("room_track_1.ivf"); !errors.Is(err, ErrRecordingNotFound) {
+		t.Errorf("Expected ErrRecordingNotFound, got %v", err)
+	}
+	for _, name := range []string{"../room.ivf", "a/b.ogg", "notes.txt", ""} {
+		if err := mgr.DeleteRecording(name); !errors.Is(err, ErrInvalidRecordingName) {
+			t.Errorf("%q: expected ErrInvalidRecordingName, got %v", name, err)
+		}
+	}
+}
+
+func TestRoom_OpenRecorder_DiscardsOutputOnWriterError(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.RecordDir = t.TempDir()
+	if _, _, err := NewRoom("room", mgr).openRecorder("room_track_1.ivf", "video/unknown"); err == nil {
+		t.Fatal("Expected an error for an unsupported codec")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.RecordDir, "room_track_1.ivf")); !os.IsNotExist(err) {
+		t.Errorf("Expected the created file to be removed, got %v", err)
+	}
+	mgr.recMu.Lock()
+	n := len(mgr.recording)
+	mgr.recMu.Unlock()
+	if n != 0 {
+		t.Errorf("Expected the recording reservation to be released, got %d", n)
+	}
+}
+
+func TestRoom_OpenRecorder_H264(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.RecordDir = t.TempDir()
+	w, path, err := NewRoom("room", mgr).openRecorder("room_video_1.h264", webrtc.MimeTypeH264)
+	if err != nil {
+		t.Fatalf("Expected H.264 recorder, got %v", err)
+	}
+	// 单 NAL 的 SPS 包即视为关键帧起点，写出 Annex B 起始码
+	sps := []byte{0x67, 0x42, 0x00, 0x1f}
+	if err := w.WriteRTP(&rtp.Packet{Header: rtp.Header{Marker: true}, Payload: sps}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append([]byte{0, 0, 0, 1}, sps...); string(data) != string(want) {
+		t.Errorf("Expected Annex B SPS % x, got % x", want, data)
+	}
+}
@@ -0,0 +1,161 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// ErrRelayExists 表示房间已有指向同一目标的转推。
+var ErrRelayExists = errors.New("relay to this target already exists")
+
+// RelayInfo 描述房间向其他 SFU 的一路级联转推。
+type RelayInfo struct {
+	Target    string    `json:"target"`
+	State     string    `json:"state"`
+	StartedAt time.Time `json:"startedAt"`
+}
+
+// relay 是一路转推的运行时状态：本 SFU 作为 WHIP 发布者，把房间当前的轨道推送到目标 SFU。
+type relay struct {
+	pc   *webrtc.PeerConnection
+	info RelayInfo
+}
+
+var relayClient = &http.Client{Timeout: 10 * time.Second}
+
+// StartRelay 把房间当前的轨道以 WHIP 推送到另一个 SFU（whipURL 为目标的 WHIP 推流地址），
+// 实现多节点级联分发。只转推调用时已存在的轨道；发布者离开或房间关闭时转推随之结束。
+func (m *Manager) StartRelay(ctx context.Context, roomName, whipURL, token string) error {
+	m.mu.RLock()
+	r, ok := m.rooms[roomName]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrRoomNotFound
+	}
+	return r.startRelay(ctx, whipURL, token)
+}
+
+func (r *Room) startRelay(ctx context.Context, whipURL, token string) error {
+	r.mu.RLock()
+	_, exists := r.relays[whipURL]
+	hasTracks := len(r.publishers) > 0 && len(r.trackFeeds) > 0
+	r.mu.RUnlock()
+	if exists {
+		return ErrRelayExists
+	}
+	if !hasTracks {
+		return ErrNoPublisher
+	}
+
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		return err
+	}
+	pc, _, err := r.newPeerConnection(webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(r.settingEngine())))
+	if err != nil {
+		return err
+	}
+	rl := &relay{pc: pc, info: RelayInfo{Target: whipURL, State: webrtc.ICEConnectionStateNew.String(), StartedAt: time.Now()}}
+
+	r.mu.Lock()
+	if _, ok := r.relays[whipURL]; ok {
+		r.mu.Unlock()
+		_ = pc.Close()
+		return ErrRelayExists
+	}
+	for _, f := range r.trackFeeds {
+		f.attachToSubscriber(pc, nil)
+	}
+	r.relays[whipURL] = rl
+	r.mu.Unlock()
+
+	pc.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
+		r.mu.Lock()
+		rl.info.State = s.String()
+		r.mu.Unlock()
+		if s == webrtc.ICEConnectionStateFailed || s == webrtc.ICEConnectionStateClosed {
+			go r.stopRelay(whipURL, pc)
+		}
+	})
+
+	if err := r.negotiateRelay(ctx, pc, whipURL, token); err != nil {
+		r.stopRelay(whipURL, pc)
+		return err
+	}
+	return nil
+}
+
+// negotiateRelay 生成 Offer 并 POST 到目标 WHIP 地址，应用返回的 Answer。
+func (r *Room) negotiateRelay(ctx context.Context, pc *webrtc.PeerConnection, whipURL, token string) error {
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		return err
+	}
+	g := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		return err
+	}
+	select {
+	case <-g:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, whipURL, strings.NewReader(pc.LocalDescription().SDP))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/sdp")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := relayClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("relay: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("relay: target returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: string(body)})
+}
+
+// stopRelay 结束一路转推并从各轨道 fanout 上摘除。
+func (r *Room) stopRelay(target string, pc *webrtc.PeerConnection) {
+	r.mu.Lock()
+	if rl, ok := r.relays[target]; ok && rl.pc == pc {
+		delete(r.relays, target)
+	}
+	for _, f := range r.trackFeeds {
+		f.detachFromSubscriber(pc)
+	}
+	r.mu.Unlock()
+	_ = pc.Close()
+}
+
+// closeRelaysLocked 关闭房间的所有转推，在发布者离开或房间关闭时调用；调用方需持有 r.mu。
+func (r *Room) closeRelaysLocked() {
+	for target, rl := range r.relays {
+		delete(r.relays, target)
+		go rl.pc.Close()
+	}
+}
+
+// relayInfosLocked 返回房间当前的转推状态；调用方需持有 r.mu。
+func (r *Room) relayInfosLocked() []RelayInfo {
+	if len(r.relays) == 0 {
+		return nil
+	}
+	out := make([]RelayInfo, 0, len(r.relays))
+	for _, rl := range r.relays {
+		out = append(out, rl.info)
+	}
+	return out
+}
@@ -0,0 +1,22 @@
+package sfu
+
+import (
+	"context"
+	"testing"
+)
+
+func TestManager_StartRelay_Errors(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	if err := mgr.StartRelay(context.Background(), "missing", "http://127.0.0.1:1/api/whip/publish/x", ""); err != ErrRoomNotFound {
+		t.Errorf("Expected ErrRoomNotFound, got %v", err)
+	}
+	mgr.getOrCreateRoom("idle")
+	if err := mgr.StartRelay(context.Background(), "idle", "http://127.0.0.1:1/api/whip/publish/x", ""); err != ErrNoPublisher {
+		t.Errorf("Expected ErrNoPublisher, got %v", err)
+	}
+	if info := mgr.getOrCreateRoom("idle").stats(); len(info.Relays) != 0 {
+		t.Errorf("Expected no relays, got %v", info.Relays)
+	}
+}
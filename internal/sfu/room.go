@@ -3,42 +3,103 @@
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pion/dtls/v2"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v3"
-	"github.com/pion/webrtc/v3/pkg/media/ivfwriter"
+	"github.com/pion/webrtc/v3/pkg/media/h264writer"
 	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
 	"live-webrtc-go/internal/config"
 	"live-webrtc-go/internal/metrics"
 	"live-webrtc-go/internal/uploader"
 )
 
+var (
+	// ErrRoomNotFound 表示目标房间不存在。
+	ErrRoomNotFound = errors.New("room not found")
+	// ErrSubscriberNotFound 表示房间内不存在指定 ID 的订阅者。
+	ErrSubscriberNotFound = errors.New("subscriber not found")
+	// ErrNoPublisher 表示房间当前没有发布者。
+	ErrNoPublisher = errors.New("no publisher in this room")
+	// ErrRoomFull 表示房间订阅者数已达 MAX_SUBS_PER_ROOM 上限。
+	ErrRoomFull = errors.New("subscriber limit reached")
+	// ErrPublisherNotFound 表示 ?publisher= 指定的发布者不在房间内。
+	ErrPublisherNotFound = errors.New("publisher not found")
+	// ErrRoomClosed 表示房间在协商期间被关闭。
+	ErrRoomClosed = errors.New("room closed")
+	// ErrSessionNotFound 表示不存在指定 ID 的 WHIP/WHEP 会话。
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrPublisherExists 表示房间已有发布者（且不满足接管条件）。
+	ErrPublisherExists = errors.New("publisher already exists in this room")
+	// ErrInvalidSDP 表示客户端的 Offer 无法解析或协商，HTTP 层据此返回 400。
+	ErrInvalidSDP = errors.New("invalid SDP")
+	// ErrMultiplePublishers 表示房间内有多个发布者，无法确定迁移的对象。
+	ErrMultiplePublishers = errors.New("multiple publishers in this room")
+)
+
 // Manager 负责跟踪所有房间的生命周期，提供 Publish/Subscribe 入口。
 type Manager struct {
-	mu    sync.RWMutex
-	rooms map[string]*Room
-	cfg   *config.Config
+	mu      sync.RWMutex
+	rooms   map[string]*Room
+	closing map[string]chan struct{} // 正在关闭的房间，关闭完成时 close 对应通道
+	cfg     *config.Config
+	stateMu sync.Mutex // 串行化房间状态文件的写入
+
+	turnOnce sync.Once
+	turnPool *turnPool
+
+	sessMu   sync.Mutex
+	sessions map[string]*Room // 会话 ID -> 所在房间，见 CloseSession
+
+	recMu     sync.Mutex
+	recording map[string]int // 正在写入的录制文件路径 -> 写入者数，见 DeleteRecording
+
+	egress egressMeter // 全部订阅者的出站码率，见 MAX_EGRESS_MBPS
+
+	// 进程启动以来全部轨道接收的 RTP 字节数与包数，轨道结束后不回退，见 Stats
+	rxBytes atomic.Uint64
+	rxPkts  atomic.Uint64
 }
 
-// CloseRoom 主动关闭指定房间并更新房间数量指标。
+// CloseRoom 主动关闭指定房间并更新房间数量指标。关闭期间同名房间被标记为 closing，
+// getOrCreateRoom 会等待其完全拆除后再创建新房间，避免与并发的推流/播放交错出僵尸房间。
 func (m *Manager) CloseRoom(name string) bool {
+	return m.CloseRoomGraceful(name, 0)
+}
+
+// CloseRoomGraceful 与 CloseRoom 相同，但 grace > 0 时先停止转发并向订阅者发送 RTCP BYE，
+// 等待 grace 后再关闭连接，便于播放端显示"直播已结束"而不是 ICE 断开。调用会阻塞 grace。
+func (m *Manager) CloseRoomGraceful(name string, grace time.Duration) bool {
 	m.mu.Lock()
 	r, ok := m.rooms[name]
+	var done chan struct{}
 	if ok {
 		delete(m.rooms, name)
+		done = make(chan struct{})
+		m.closing[name] = done
 	}
 	n := len(m.rooms)
 	m.mu.Unlock()
 	if ok {
-		r.Close()
+		r.closeGraceful(grace)
+		m.mu.Lock()
+		delete(m.closing, name)
+		m.mu.Unlock()
+		close(done)
 		metrics.SetRooms(float64(n))
+		m.persist()
 	}
 	return ok
 }
@@ -58,21 +119,40 @@ func (m *Manager) CloseAll() {
 	metrics.SetRooms(0)
 }
 
-// NewManager 创建一个房间管理器。
+// NewManager 创建一个房间管理器；若配置了 ROOM_STATE_FILE，会恢复上次保存的房间状态。
 func NewManager(c *config.Config) *Manager {
-	return &Manager{rooms: make(map[string]*Room), cfg: c}
+	m := &Manager{rooms: make(map[string]*Room), closing: make(map[string]chan struct{}), cfg: c}
+	metrics.SetEgressRateFunc(m.EgressRate)
+	if c != nil && c.RoomStateFile != "" {
+		if err := m.loadState(); err != nil {
+			log.Printf("sfu: load room state: %v", err)
+		}
+	}
+	return m
 }
 
-// getOrCreateRoom 获取或创建房间，首次创建时更新房间计数指标。
+// getOrCreateRoom 获取或创建房间，首次创建时更新房间计数指标。同名房间正在关闭时先等待其拆除完成。
 func (m *Manager) getOrCreateRoom(name string) *Room {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	for {
+		done, closing := m.closing[name]
+		if !closing {
+			break
+		}
+		m.mu.Unlock()
+		<-done
+		m.mu.Lock()
+	}
 	r, ok := m.rooms[name]
 	if !ok {
 		r = NewRoom(name, m)
 		m.rooms[name] = r
 		metrics.SetRooms(float64(len(m.rooms)))
 	}
+	m.mu.Unlock()
+	if !ok {
+		m.persist()
+	}
 	return r
 }
 
@@ -82,17 +162,55 @@ func (m *Manager) Publish(ctx context.Context, roomName, offerSDP string) (strin
 	return r.Publish(ctx, offerSDP)
 }
 
+// PublishWithID 与 Publish 相同，额外返回发布者会话 ID。
+func (m *Manager) PublishWithID(ctx context.Context, roomName, offerSDP string) (string, string, error) {
+	r := m.getOrCreateRoom(roomName)
+	return r.PublishWithID(ctx, offerSDP)
+}
+
 // Subscribe 根据房间名将 SDP Offer 交给对应 Room 处理，返回 SDP Answer。
 func (m *Manager) Subscribe(ctx context.Context, roomName, offerSDP string) (string, error) {
 	r := m.getOrCreateRoom(roomName)
 	return r.Subscribe(ctx, offerSDP)
 }
 
+// SubscribeWithID 与 Subscribe 相同，但额外返回订阅者 ID，供后续按订阅者操作（如请求关键帧）。
+func (m *Manager) SubscribeWithID(ctx context.Context, roomName, offerSDP string) (string, string, error) {
+	r := m.getOrCreateRoom(roomName)
+	return r.SubscribeWithID(ctx, offerSDP)
+}
+
+// SubscribeResume 根据房间名订阅，resumeToken 有效时恢复断线前的订阅会话。
+func (m *Manager) SubscribeResume(ctx context.Context, roomName, offerSDP, resumeToken string) (SubscribeResult, error) {
+	return m.SubscribeWith(ctx, roomName, offerSDP, SubscribeOptions{ResumeToken: resumeToken})
+}
+
+// SubscribeWith 根据房间名按 opts 订阅。
+func (m *Manager) SubscribeWith(ctx context.Context, roomName, offerSDP string, opts SubscribeOptions) (SubscribeResult, error) {
+	r := m.getOrCreateRoom(roomName)
+	return r.SubscribeWith(ctx, offerSDP, opts)
+}
+
+// RequestKeyframe 代指定订阅者向房间发布者请求关键帧。
+func (m *Manager) RequestKeyframe(roomName, subscriberID string) error {
+	m.mu.RLock()
+	r, ok := m.rooms[roomName]
+	m.mu.RUnlock()
+	if !ok {
+		return ErrRoomNotFound
+	}
+	return r.RequestKeyframe(subscriberID)
+}
+
 type RoomInfo struct {
-	Name         string
-	HasPublisher bool
-	Tracks       int
-	Subscribers  int
+	Name                string
+	HasPublisher        bool
+	Tracks              int
+	Subscribers         int
+	WeightedSubscribers float64     // 加权订阅者数（纯音频按 AUDIO_ONLY_SUB_WEIGHT 计），与 MAX_SUBS_PER_ROOM 比较
+	StalledTracks       int         // 超过 TRACK_STALL_TIMEOUT 未收到 RTP 的轨道数
+	Relays              []RelayInfo `json:",omitempty"` // 向其他 SFU 的级联转推
+	Publishers          []string    `json:",omitempty"` // 发布者 ID，可通过 WHEP ?publisher= 固定订阅其中之一
 }
 
 func (m *Manager) ListRooms() []RoomInfo {
@@ -105,280 +223,1104 @@ func (m *Manager) ListRooms() []RoomInfo {
 	return out
 }
 
+// stats 汇总房间当前的发布者、轨道与订阅者情况。
+func (r *Room) stats() RoomInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	info := RoomInfo{
+		Name:                r.name,
+		HasPublisher:        len(r.publishers) > 0,
+		Tracks:              len(r.trackFeeds),
+		Subscribers:         len(r.subs),
+		Relays:              r.relayInfosLocked(),
+		WeightedSubscribers: r.subLoad,
+	}
+	for _, p := range r.publishersLocked() {
+		info.Publishers = append(info.Publishers, p.id)
+	}
+	for _, f := range r.trackFeeds {
+		if f.stalled.Load() {
+			info.StalledTracks++
+		}
+	}
+	return info
+}
+
 // Room 表示一个 SFU 房间，维护发布者、订阅者与轨道 fanout。
 type Room struct {
-	name       string
-	mu         sync.RWMutex
-	publisher  *webrtc.PeerConnection
-	trackFeeds map[string]*trackFanout // key: track ID
-	subs       map[*webrtc.PeerConnection]struct{}
-	mgr        *Manager
+	name        string
+	created     time.Time // 房间创建时间
+	mu          sync.RWMutex
+	publishers  map[string]*publisherSession // key: 发布者 ID
+	sampling    bool                         // 码率采样 goroutine 是否在运行
+	trackFeeds  map[string]*trackFanout      // key: feedKey(发布者 ID, track ID)
+	subs        map[*webrtc.PeerConnection]*subscriber
+	subLoad     float64 // 订阅者权重之和，见 subscriber.weight
+	mgr         *Manager
+	provisioned bool              // 是否由管理接口预置
+	token       string            // 管理接口预置的房间 Token
+	meta        map[string]string // 管理接口预置的房间元数据
+	relays      map[string]*relay // 级联转推，key: 目标 WHIP 地址
+	waiters     []*Waiter         // 满员时排队等待空位的观众，先进先出
+	closed      bool              // 已被 Close，之后完成协商的连接直接丢弃
+}
+
+// subscriber 记录单个订阅者的会话信息。
+type subscriber struct {
+	id      string
+	ice     *localCandidates // 当前连接的本地候选，会话恢复时随连接替换
+	started time.Time        // 会话建立时间，会话恢复时沿用
+	resume  string           // 断线重连时用于恢复会话的令牌
+	grace   graceTimer       // 断线后的会话保留计时
+	// 固定订阅的发布者 ID，为空表示订阅房间内全部发布者
+	publisher string
+	exclude   string     // 不订阅的发布者 ID（通常是参与者自己的推流），见 SubscribeOptions.Exclude
+	client    string     // 客户端提供的稳定标识，见 SubscribeOptions.ClientID
+	weight    float64    // 占用的容量：完整音视频为 1，纯音频为 AUDIO_ONLY_SUB_WEIGHT
+	ext       headerExts // E2EE_PASSTHROUGH：订阅者 Offer 协商的头扩展，会话恢复时随连接替换
+}
+
+// wants 判断订阅者是否应挂接该 fanout。
+func (s *subscriber) wants(f *trackFanout) bool {
+	if s.exclude != "" && s.exclude == f.publisher {
+		return false
+	}
+	return s.publisher == "" || s.publisher == f.publisher
+}
+
+// feedKey 返回轨道在 trackFeeds 中的键；不同发布者可能使用相同的 track ID。
+func feedKey(pubID, trackID string) string { return pubID + "/" + trackID }
+
+// hasIdleTransceiver 判断已协商的订阅连接中是否还有未绑定发送轨道的 kind 类收发器，
+// AddTrack 会复用它而无需新增 m-line。
+func hasIdleTransceiver(pc *webrtc.PeerConnection, kind webrtc.RTPCodecType) bool {
+	for _, t := range pc.GetTransceivers() {
+		if t.Kind() == kind && t.Sender() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newID 生成随机十六进制 ID，用于标识订阅会话。
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // NewRoom 初始化房间默认状态。
 func NewRoom(name string, m *Manager) *Room {
 	return &Room{
 		name:       name,
+		created:    time.Now(),
+		publishers: make(map[string]*publisherSession),
 		trackFeeds: make(map[string]*trackFanout),
-		subs:       make(map[*webrtc.PeerConnection]struct{}),
+		subs:       make(map[*webrtc.PeerConnection]*subscriber),
 		mgr:        m,
+		relays:     make(map[string]*relay),
 	}
 }
 
-// iceConfig 生成 ICE 配置，优先使用配置中的 STUN/TURN。
-func (r *Room) iceConfig() webrtc.Configuration {
+// iceConfig 生成 ICE 配置，优先使用配置中的 STUN/TURN。TURN 取自服务器池的当前组，
+// 同时返回该组下标（未配置 TURN 时为 -1）供统计分配结果。
+func (r *Room) iceConfig() (webrtc.Configuration, int) {
 	var servers []webrtc.ICEServer
+	turnGroup := -1
 	if r.mgr != nil && r.mgr.cfg != nil {
 		if len(r.mgr.cfg.STUN) > 0 {
 			servers = append(servers, webrtc.ICEServer{URLs: r.mgr.cfg.STUN})
 		}
-		if len(r.mgr.cfg.TURN) > 0 {
-			s := webrtc.ICEServer{URLs: r.mgr.cfg.TURN}
-			if r.mgr.cfg.TURNUsername != "" || r.mgr.cfg.TURNPassword != "" {
+		var urls []string
+		if turnGroup, urls = r.mgr.turn().current(); len(urls) > 0 {
+			s := webrtc.ICEServer{URLs: urls}
+			switch {
+			case r.mgr.cfg.TURNUsername != "" || r.mgr.cfg.TURNPassword != "":
 				s.Username = r.mgr.cfg.TURNUsername
 				s.Credential = r.mgr.cfg.TURNPassword
 				s.CredentialType = webrtc.ICECredentialTypePassword
+			case r.mgr.cfg.TURNStaticSecret != "":
+				// 未配置静态账号时，服务端自身也使用 TURN_STATIC_SECRET 生成的临时凭据
+				s.Username, s.Credential = TURNCredentials(r.mgr.cfg.TURNStaticSecret, "sfu", r.mgr.cfg.TURNCredentialTTL, time.Now())
+				s.CredentialType = webrtc.ICECredentialTypePassword
 			}
 			servers = append(servers, s)
 		}
 	}
 	if len(servers) == 0 {
-		servers = []webrtc.ICEServer{{URLs: []string{"stun:stun.l.google.com:19302"}}}
+		servers = []webrtc.ICEServer{{URLs: []string{config.DefaultSTUNServer}}}
 	}
-	return webrtc.Configuration{ICEServers: servers}
+	return webrtc.Configuration{ICEServers: servers}, turnGroup
 }
 
-// Publish 接收主播的 SDP Offer，创建 PeerConnection 并拉起 track fanout。
-func (r *Room) Publish(ctx context.Context, offerSDP string) (string, error) {
-	r.mu.Lock()
-	if r.publisher != nil {
-		r.mu.Unlock()
-		return "", errors.New("publisher already exists in this room")
+// newAPI 根据 Offer 构建 MediaEngine 与默认拦截器，并按配置追加可选编解码器。
+// 开启 STRICT_SDP 时先校验 Offer，拒绝含无效 m-line 的请求。
+func (r *Room) newAPI(offerSDP string) (*webrtc.API, error) {
+	if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.StrictSDP {
+		if err := validateStrictOffer(offerSDP); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSDP, err)
+		}
 	}
-	r.mu.Unlock()
-
 	m := &webrtc.MediaEngine{}
 	if err := m.PopulateFromSDP(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
-		return "", fmt.Errorf("populate from SDP: %w", err)
+		return nil, fmt.Errorf("%w: populate from SDP: %w", ErrInvalidSDP, err)
+	}
+	if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.EnableREDFEC {
+		if err := registerREDFEC(m, offerSDP); err != nil {
+			return nil, fmt.Errorf("register RED/FEC: %w", err)
+		}
 	}
 	i := &webrtc.InterceptorRegistry{}
 	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
-		return "", fmt.Errorf("register interceptors: %w", err)
+		return nil, fmt.Errorf("register interceptors: %w", err)
 	}
+	return webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i), webrtc.WithSettingEngine(r.settingEngine())), nil
+}
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
-	pc, err := api.NewPeerConnection(r.iceConfig())
+// srtpProfiles 把 SRTP_PROFILES 中的名称映射为 DTLS-SRTP 保护配置。
+var srtpProfiles = map[string]dtls.SRTPProtectionProfile{
+	"SRTP_AEAD_AES_256_GCM":       dtls.SRTP_AEAD_AES_256_GCM,
+	"SRTP_AEAD_AES_128_GCM":       dtls.SRTP_AEAD_AES_128_GCM,
+	"SRTP_AES128_CM_HMAC_SHA1_80": dtls.SRTP_AES128_CM_HMAC_SHA1_80,
+	"SRTP_AES128_CM_HMAC_SHA1_32": dtls.SRTP_AES128_CM_HMAC_SHA1_32,
+}
+
+// settingEngine 按 SRTP_PROFILES 限制 DTLS-SRTP 保护配置；对端不支持其中任何一种时
+// DTLS 握手失败，连接进入 Failed 后由 watchDTLS 清理。
+func (r *Room) settingEngine() webrtc.SettingEngine {
+	var s webrtc.SettingEngine
+	if r.mgr == nil || r.mgr.cfg == nil || len(r.mgr.cfg.SRTPProfiles) == 0 {
+		return s
+	}
+	profiles := make([]dtls.SRTPProtectionProfile, 0, len(r.mgr.cfg.SRTPProfiles))
+	for _, name := range r.mgr.cfg.SRTPProfiles {
+		if p, ok := srtpProfiles[name]; ok {
+			profiles = append(profiles, p)
+		}
+	}
+	s.SetSRTPProtectionProfiles(profiles...)
+	return s
+}
+
+// watchDTLS 在 ICE 已连通但 DTLS 握手失败（如无法就 SRTP 保护配置达成一致）时调用 onFail。
+// ICE 自身失败仍交给各自的 ICE 状态回调处理（宽限期、会话恢复等）。
+func (r *Room) watchDTLS(pc *webrtc.PeerConnection, role string, onFail func()) {
+	pc.OnConnectionStateChange(func(s webrtc.PeerConnectionState) {
+		if s != webrtc.PeerConnectionStateFailed {
+			return
+		}
+		switch pc.ICEConnectionState() {
+		case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+			log.Printf("sfu: room %s %s DTLS handshake failed, closing", r.name, role)
+			go onFail()
+		}
+	})
+}
+
+const (
+	mimeTypeRED    = "audio/red"
+	mimeTypeULPFEC = "video/ulpfec"
+)
+
+// 默认的 RED 与 ULPFEC 负载类型（与 Chrome 一致），Offer 已为其分配负载类型时沿用 Offer 的取值。
+const (
+	defaultREDPayloadType    = 63
+	defaultULPFECPayloadType = 116
+)
+
+// registerREDFEC 注册音频 RED（冗余编码）与视频 ULPFEC，客户端在 Offer 中支持时
+// 即会出现在 Answer 里，用于提升弱网下的抗丢包能力。
+func registerREDFEC(m *webrtc.MediaEngine, offerSDP string) error {
+	audio, video := redFECCodecs(offerSDP)
+	for _, c := range audio {
+		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeAudio); err != nil {
+			return err
+		}
+	}
+	for _, c := range video {
+		if err := m.RegisterCodec(c, webrtc.RTPCodecTypeVideo); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redFECCodecs 按 Offer 构建 RED 与 ULPFEC 编解码参数。RED 的 fmtp 指向被冗余的 Opus 负载类型，
+// 取自 Offer 中 Opus 的 rtpmap；Offer 不含 Opus 时不注册 RED，避免 Answer 声明指向不存在编码的 RED。
+func redFECCodecs(offerSDP string) (audio, video []webrtc.RTPCodecParameters) {
+	if opus, ok := offerPayloadType(offerSDP, "opus"); ok {
+		pt, ok := offerPayloadType(offerSDP, "red")
+		if !ok {
+			pt = defaultREDPayloadType
+		}
+		fmtp := fmt.Sprintf("%d/%d", opus, opus)
+		audio = append(audio, webrtc.RTPCodecParameters{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeRED, ClockRate: 48000, Channels: 2, SDPFmtpLine: fmtp},
+			PayloadType:        webrtc.PayloadType(pt),
+		})
+	}
+	pt, ok := offerPayloadType(offerSDP, "ulpfec")
+	if !ok {
+		pt = defaultULPFECPayloadType
+	}
+	video = append(video, webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: mimeTypeULPFEC, ClockRate: 90000},
+		PayloadType:        webrtc.PayloadType(pt),
+	})
+	return audio, video
+}
+
+// Publish 接收主播的 SDP Offer，创建 PeerConnection 并拉起 track fanout。
+func (r *Room) Publish(ctx context.Context, offerSDP string) (string, error) {
+	answer, _, err := r.publish(ctx, offerSDP, false)
+	return answer, err
+}
+
+// PublishWithID 与 Publish 相同，额外返回发布者会话 ID（即 RoomInfo.Publishers 中的 ID）。
+func (r *Room) PublishWithID(ctx context.Context, offerSDP string) (string, string, error) {
+	return r.publish(ctx, offerSDP, false)
+}
+
+// MigratePublisher 以新的发布连接接替房间现有发布者（如切换编码器）。新连接的轨道按类型与编码
+// 依次接管原有 fanout 的数据源，订阅者的本地 Track 保持不变、无需重新协商；旧连接在全部轨道
+// 接管后（或 migrateTimeout 到期时）关闭，未被接管的旧轨道随之移除。
+func (r *Room) MigratePublisher(ctx context.Context, offerSDP string) (string, error) {
+	answer, _, err := r.publish(ctx, offerSDP, true)
+	return answer, err
+}
+
+func (r *Room) publish(ctx context.Context, offerSDP string, migrate bool) (string, string, error) {
+	start := time.Now()
+	pubID := newID()
+	var mig *migration
+	var stale *webrtc.PeerConnection
+	r.mu.Lock()
+	switch {
+	case migrate && len(r.publishers) == 0:
+		r.mu.Unlock()
+		return "", "", ErrNoPublisher
+	case migrate && len(r.publishers) > 1:
+		r.mu.Unlock()
+		return "", "", ErrMultiplePublishers
+	case migrate:
+		old := r.firstPublisherLocked()
+		pubID = old.id // 沿用发布者 ID，固定订阅（?publisher=）的观众不受影响
+		mig = newMigration(old.pc, r.trackFeeds)
+	case len(r.publishers) >= r.maxPublishers():
+		// 发布者已满：允许顶替其中最早一个网络已断的发布者
+		for _, p := range r.publishersLocked() {
+			if r.takeoverAllowed(p.pc.ICEConnectionState()) {
+				stale = p.pc
+				break
+			}
+		}
+		if stale == nil {
+			r.mu.Unlock()
+			return "", "", ErrPublisherExists
+		}
+	}
+	r.mu.Unlock()
+	if stale != nil {
+		// 原发布者网络已断但 ICE 尚未超时：先关闭其录制与轨道，再由新发布者接管房间
+		log.Printf("sfu: room %s publisher ICE %s, taken over by new publisher", r.name, stale.ICEConnectionState())
+		r.closePublisher(stale)
+	}
+
+	clientOffer := offerSDP
+	webm := newWebMRecording(offerSDP)
+	offerSDP, mids := rewriteMids(offerSDP, r.midScheme())
+	api, err := r.newAPI(offerSDP)
+	if err != nil {
+		return "", "", err
+	}
+	pc, cands, err := r.newPeerConnection(api)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
+	var grace graceTimer
 	pc.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
-		if s == webrtc.ICEConnectionStateFailed || s == webrtc.ICEConnectionStateDisconnected || s == webrtc.ICEConnectionStateClosed {
+		switch s {
+		case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			grace.stop()
 			go r.closePublisher(pc)
+		case webrtc.ICEConnectionStateDisconnected:
+			// 短暂断开时先等待宽限期，期间恢复 Connected 则不断流
+			d := r.disconnectGrace()
+			if d <= 0 {
+				go r.closePublisher(pc)
+				return
+			}
+			grace.start(d, func() { r.closePublisher(pc) })
+		case webrtc.ICEConnectionStateConnected:
+			grace.stop()
 		}
 	})
 
+	r.watchDTLS(pc, "publisher", func() { r.closePublisher(pc) })
+
 	pc.OnTrack(func(remote *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		if mig != nil && r.adoptTrack(mig, pc, remote) {
+			return
+		}
 		feed := newTrackFanout(remote, r.name)
+		feed.publisher = pubID
+		feed.mgr = r.mgr
+		if r.e2eePassthrough() {
+			feed.ext = parseHeaderExts(clientOffer)[remote.Kind().String()]
+		}
+		if feed.video {
+			feed.onAttach = func() { go r.requestTrackKeyframe(feed) }
+		}
+		if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.SubscriberWriteTimeout > 0 {
+			feed.writeTimeout = r.mgr.cfg.SubscriberWriteTimeout
+			feed.onStuck = r.evictStuckSubscriber
+		}
 		r.mu.Lock()
-		r.trackFeeds[remote.ID()] = feed
-		// attach existing subscribers
-		for sub := range r.subs {
-			feed.attachToSubscriber(sub)
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		r.trackFeeds[feedKey(pubID, remote.ID())] = feed
+		// 已协商的订阅者不会重新协商：只有应答里仍空着的同类收发器能接收新轨道，
+		// 后加入发布者需要新增 m-line 的轨道不附加，观众需重新订阅才能收到
+		for pc, sub := range r.subs {
+			if sub.wants(feed) && hasIdleTransceiver(pc, remote.Kind()) {
+				feed.attachToSubscriber(pc, sub.ext)
+			}
 		}
 		r.mu.Unlock()
 
 		go feed.readLoop()
+		if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.TrackStallTimeout > 0 {
+			go r.watchStall(feed, r.mgr.cfg.TrackStallTimeout, r.mgr.cfg.StallClosePublisher)
+		}
 
-		go func() {
-			// 周期性发送 PLI，提醒发布端刷新关键帧，减轻画面马赛克
-			ticker := time.NewTicker(2 * time.Second)
-			defer ticker.Stop()
-			for range ticker.C {
-				r.mu.RLock()
-				pub := r.publisher
-				r.mu.RUnlock()
-				if pub == nil {
-					return
+		if feed.video && r.pliInterval() > 0 {
+			go r.periodicPLI(feed, r.pliInterval())
+		}
+
+		// 端到端加密的负载无法解码，E2EE_PASSTHROUGH 下不录制
+		// 房间状态只记录进行中的录制，录制实际开始后写入一次状态文件
+		record := r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.RecordEnabled && !r.e2eePassthrough()
+		started := false
+		if record && r.recordFormat() == config.RecordFormatWebM {
+			// 音视频封装进同一个 WebM 文件；共享文件不写旁路统计
+			if w, p := webm.track(r, remote); w != nil {
+				feed.setRecorder(w, p, false)
+				r.trackRecording(feed, p)
+				started = true
+			}
+		} else if record {
+			// 针对音频/视频分别创建 OGG/IVF（H.264 为 .h264）写入器做简单录制
+			// 启用稳定 mid 时按 mid 命名，便于关联同一路轨道在多次推流中的录制
+			name := remote.ID()
+			if mids != nil {
+				if mid := receiverMid(pc, receiver); mid != "" {
+					name = mid
 				}
-				_ = pub.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(remote.SSRC())}})
 			}
-		}()
-
-		if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.RecordEnabled {
-			// 针对音频/视频分别创建 OGG/IVF 写入器做简单录制
-			_ = os.MkdirAll(r.mgr.cfg.RecordDir, 0o755)
-			base := fmt.Sprintf("%s_%s_%d", r.name, remote.ID(), time.Now().Unix())
 			mime := remote.Codec().MimeType
+			audioOnly := r.recordFormat() == config.RecordFormatAudio
+			ext := ""
 			switch {
 			case mime == webrtc.MimeTypeOpus:
-				p := filepath.Join(r.mgr.cfg.RecordDir, base+".ogg")
-				if w, err := oggwriter.New(p, 48000, 2); err == nil {
-					feed.setRecorder(w, p)
-				}
-			case mime == webrtc.MimeTypeVP8 || mime == webrtc.MimeTypeVP9:
-				p := filepath.Join(r.mgr.cfg.RecordDir, base+".ivf")
-				if w, err := ivfwriter.New(p); err == nil {
-					feed.setRecorder(w, p)
-				}
+				ext = ".ogg"
+			case !audioOnly && (mime == webrtc.MimeTypeVP8 || mime == webrtc.MimeTypeVP9 || mime == webrtc.MimeTypeAV1):
+				ext = ".ivf"
+			case !audioOnly && mime == webrtc.MimeTypeH264:
+				ext = ".h264" // Annex B 裸流，可用 ffmpeg 直接封装为 MP4
 			}
+			if ext != "" && r.mgr.cfg.RecordTrimStart {
+				// 等到首个关键帧或非静音音频包再创建录制文件，跳过开头的黑屏与静音；
+				// 创建在后台进行，状态随之写入
+				feed.deferRecording(mime, func() {
+					r.startRecording(feed, name, mime, ext)
+					r.mgr.persist()
+				})
+			} else if ext != "" {
+				r.startRecording(feed, name, mime, ext)
+				started = true
+			}
+		}
+		if started {
+			r.mgr.persist()
 		}
 	})
 
 	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
 		_ = pc.Close()
-		return "", err
+		return "", "", fmt.Errorf("%w: %w", ErrInvalidSDP, err)
 	}
 	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
 		_ = pc.Close()
-		return "", err
+		return "", "", fmt.Errorf("%w: %w", ErrInvalidSDP, err)
 	}
 	g := webrtc.GatheringCompletePromise(pc)
 	if err := pc.SetLocalDescription(answer); err != nil {
 		_ = pc.Close()
-		return "", err
+		return "", "", err
+	}
+	if err := r.awaitGathering(ctx, g); err != nil {
+		_ = pc.Close()
+		return "", "", err
 	}
-	<-g
 
 	r.mu.Lock()
-	r.publisher = pc
+	if r.closed {
+		r.mu.Unlock()
+		_ = pc.Close()
+		return "", "", ErrRoomClosed
+	}
+	if mig != nil && r.publisherPCLocked(pubID) != mig.old {
+		// 协商期间原发布者已离开或被替换
+		r.mu.Unlock()
+		_ = pc.Close()
+		return "", "", ErrNoPublisher
+	}
+	if mig == nil && len(r.publishers) >= r.maxPublishers() {
+		// 协商期间其他发布者占满了房间
+		r.mu.Unlock()
+		_ = pc.Close()
+		return "", "", ErrPublisherExists
+	}
+	sample := false
+	if mig != nil {
+		p := r.publishers[pubID]
+		p.pc, p.ice = pc, cands
+	} else {
+		r.publishers[pubID] = &publisherSession{id: pubID, pc: pc, ice: cands, start: time.Now()}
+		r.trackSession(pubID)
+		sample, r.sampling = !r.sampling, true
+	}
 	r.mu.Unlock()
+	if mig != nil {
+		mig.start(r)
+	} else if sample {
+		go r.sampleBitrate()
+	}
+	metrics.ObservePublish(time.Since(start))
 
-	return pc.LocalDescription().SDP, nil
+	answerSDP := restoreMids(pc.LocalDescription().SDP, mids)
+	if r.mgr != nil && r.mgr.cfg != nil {
+		answerSDP = setOpusMaxBitrate(answerSDP, r.mgr.cfg.OpusMaxBitrate)
+		answerSDP = setOpusPtime(answerSDP, r.mgr.cfg.OpusPtime, r.mgr.cfg.OpusMaxPtime)
+		answerSDP = setMaxFramerate(answerSDP, r.mgr.cfg.MaxFramerate)
+		answerSDP = setImageAttr(answerSDP, "recv", r.mgr.cfg.MaxVideoWidth, r.mgr.cfg.MaxVideoHeight)
+	}
+	return r.finalizeAnswer(clientOffer, answerSDP), pubID, nil
 }
 
 // Subscribe 为观众创建 PeerConnection，并把已存在的 track fanout 到新订阅者。
 func (r *Room) Subscribe(ctx context.Context, offerSDP string) (string, error) {
-	if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.MaxSubsPerRoom > 0 {
+	answer, _, err := r.SubscribeWithID(ctx, offerSDP)
+	return answer, err
+}
+
+// SubscribeWithID 与 Subscribe 相同，额外返回新订阅者的 ID。
+func (r *Room) SubscribeWithID(ctx context.Context, offerSDP string) (string, string, error) {
+	res, err := r.SubscribeResume(ctx, offerSDP, "")
+	return res.Answer, res.ID, err
+}
+
+// SubscribeResult 是一次 WHEP 订阅（或会话恢复）的结果。
+type SubscribeResult struct {
+	Answer      string
+	ID          string // 订阅者 ID
+	ResumeToken string // 未开启 SUBSCRIBER_RESUME_TTL 时为空
+	Resumed     bool   // 是否沿用了断线前的订阅会话
+}
+
+// SubscribeOptions 是 WHEP 订阅的可选参数。
+type SubscribeOptions struct {
+	ResumeToken string // 断线前订阅会话的恢复令牌
+	Publisher   string // 仅挂接该发布者的轨道（见 RoomInfo.Publishers），为空表示全部
+	// 客户端提供的稳定标识：同一房间内携带相同 ClientID 的新订阅会先关闭旧连接，
+	// 避免重试风暴让同一观众占用多份 fanout；为空时不去重
+	ClientID string
+	// 不挂接该发布者的轨道，供同时推流的参与者（MAX_PUBLISHERS_PER_ROOM > 1）排除自己的推流
+	Exclude string
+}
+
+// SubscribeResume 为观众创建 PeerConnection 并挂接现有 track fanout。resumeToken 对应一个
+// 仍在宽限期内的订阅会话时，新连接接替旧连接、沿用原订阅者 ID，不计为新订阅者，也不受人数上限限制；
+// 令牌无效或已过期则按新订阅处理。
+func (r *Room) SubscribeResume(ctx context.Context, offerSDP, resumeToken string) (SubscribeResult, error) {
+	return r.SubscribeWith(ctx, offerSDP, SubscribeOptions{ResumeToken: resumeToken})
+}
+
+// SubscribeWith 与 SubscribeResume 相同，并支持 opts.Publisher 固定订阅某个发布者；
+// 该发布者不在房间内时返回 ErrPublisherNotFound。恢复的会话沿用原先固定的发布者。
+// opts.ClientID 非空时，先关闭同一客户端在房间内的旧订阅连接。
+func (r *Room) SubscribeWith(ctx context.Context, offerSDP string, opts SubscribeOptions) (SubscribeResult, error) {
+	start := time.Now()
+	prev, sub := r.findResumable(opts.ResumeToken)
+	pin, client, exclude := opts.Publisher, opts.ClientID, opts.Exclude
+	if sub != nil {
+		pin, client, exclude = sub.publisher, sub.client, sub.exclude
+	}
+	if sub == nil && r.mgr.egressLimited(time.Now()) {
+		return SubscribeResult{}, ErrEgressLimit
+	}
+	weight := r.subscriberWeight(offerSDP)
+	if sub == nil && r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.MaxSubsPerRoom > 0 {
 		r.mu.RLock()
-		if len(r.subs) >= r.mgr.cfg.MaxSubsPerRoom {
+		// 同一客户端的旧连接在新应答生成后才关闭，容量检查时先扣除它们占用的名额
+		if !r.hasCapacityLocked(weight - r.clientLoadLocked(client)) {
 			r.mu.RUnlock()
-			return "", fmt.Errorf("subscriber limit reached")
+			return SubscribeResult{}, ErrRoomFull
 		}
 		r.mu.RUnlock()
 	}
-	m := &webrtc.MediaEngine{}
-	if err := m.PopulateFromSDP(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
-		return "", fmt.Errorf("populate from SDP: %w", err)
-	}
-	i := &webrtc.InterceptorRegistry{}
-	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
-		return "", fmt.Errorf("register interceptors: %w", err)
+	api, err := r.newAPI(offerSDP)
+	if err != nil {
+		return SubscribeResult{}, err
 	}
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
 
-	pc, err := api.NewPeerConnection(r.iceConfig())
+	pc, cands, err := r.newPeerConnection(api)
 	if err != nil {
-		return "", err
+		return SubscribeResult{}, err
 	}
 
 	pc.OnICEConnectionStateChange(func(s webrtc.ICEConnectionState) {
-		if s == webrtc.ICEConnectionStateFailed || s == webrtc.ICEConnectionStateDisconnected || s == webrtc.ICEConnectionStateClosed {
-			go r.removeSubscriber(pc)
-		}
+		r.onSubscriberICE(pc, s)
 	})
+	r.watchDTLS(pc, "subscriber", func() { r.removeSubscriber(pc) })
 
 	r.mu.RLock()
+	if pin != "" && r.publishers[pin] == nil {
+		r.mu.RUnlock()
+		_ = pc.Close()
+		return SubscribeResult{}, ErrPublisherNotFound
+	}
+	var exts headerExts
+	if r.e2eePassthrough() {
+		exts = parseHeaderExts(offerSDP)
+	}
+	sel := &subscriber{publisher: pin, exclude: exclude}
 	for _, feed := range r.trackFeeds {
-		feed.attachToSubscriber(pc)
+		if sel.wants(feed) {
+			feed.attachToSubscriber(pc, exts)
+		}
 	}
 	r.mu.RUnlock()
 
 	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}); err != nil {
-		_ = pc.Close()
-		return "", err
+		r.discardSubscriber(pc)
+		return SubscribeResult{}, fmt.Errorf("%w: %w", ErrInvalidSDP, err)
 	}
 
 	answer, err := pc.CreateAnswer(nil)
 	if err != nil {
-		_ = pc.Close()
-		return "", err
+		r.discardSubscriber(pc)
+		return SubscribeResult{}, fmt.Errorf("%w: %w", ErrInvalidSDP, err)
 	}
 	g := webrtc.GatheringCompletePromise(pc)
 	if err := pc.SetLocalDescription(answer); err != nil {
-		_ = pc.Close()
-		return "", err
+		r.discardSubscriber(pc)
+		return SubscribeResult{}, err
+	}
+	if err := r.awaitGathering(ctx, g); err != nil {
+		r.discardSubscriber(pc)
+		return SubscribeResult{}, err
 	}
-	<-g
 
 	r.mu.Lock()
-	r.subs[pc] = struct{}{}
+	if r.closed {
+		r.mu.Unlock()
+		r.discardSubscriber(pc)
+		return SubscribeResult{}, ErrRoomClosed
+	}
+	if sub != nil {
+		if _, ok := r.subs[prev]; ok {
+			for _, f := range r.trackFeeds {
+				f.detachFromSubscriber(prev)
+			}
+			delete(r.subs, prev)
+		} else {
+			sub = nil // 等待协商期间旧会话已过期
+		}
+	}
+	resumed := sub != nil
+	if !resumed {
+		sub = &subscriber{id: newID(), publisher: pin, exclude: exclude, client: client, started: time.Now(), weight: weight}
+		if r.resumeTTL() > 0 {
+			sub.resume = newID()
+		}
+	}
+	sub.ice = cands
+	sub.ext = exts
+	r.subs[pc] = sub
+	var dups []*webrtc.PeerConnection
+	if !resumed {
+		r.subLoad += sub.weight
+		r.trackSession(sub.id)
+		dups = r.clientSubsLocked(client, pc) // 同一客户端的旧连接与并发的重复请求：只保留最后完成协商的连接
+	}
 	r.mu.Unlock()
-	metrics.IncSubscribers(r.name)
+	if resumed {
+		sub.grace.stop()
+		_ = prev.Close()
+	} else {
+		metrics.IncSubscribers(r.name)
+	}
+	for _, d := range dups {
+		r.removeSubscriber(d)
+	}
+	metrics.ObserveSubscribe(time.Since(start))
+
+	answerSDP := pc.LocalDescription().SDP
+	if r.mgr != nil && r.mgr.cfg != nil {
+		answerSDP = setImageAttr(answerSDP, "send", r.mgr.cfg.MaxVideoWidth, r.mgr.cfg.MaxVideoHeight)
+	}
+	return SubscribeResult{
+		Answer:      r.finalizeAnswer(offerSDP, answerSDP),
+		ID:          sub.id,
+		ResumeToken: sub.resume,
+		Resumed:     resumed,
+	}, nil
+}
+
+// clientSubsLocked 返回客户端标识为 client 的订阅连接（不含 except），client 为空时返回 nil。
+// 调用方需持有 r.mu。
+func (r *Room) clientSubsLocked(client string, except *webrtc.PeerConnection) []*webrtc.PeerConnection {
+	if client == "" {
+		return nil
+	}
+	var out []*webrtc.PeerConnection
+	for pc, s := range r.subs {
+		if s.client == client && pc != except {
+			out = append(out, pc)
+		}
+	}
+	return out
+}
 
-	return pc.LocalDescription().SDP, nil
+// clientLoadLocked 返回客户端标识为 client 的订阅连接的权重之和，调用方需持有 r.mu。
+func (r *Room) clientLoadLocked(client string) float64 {
+	load := 0.0
+	for _, pc := range r.clientSubsLocked(client, nil) {
+		load += r.subs[pc].weight
+	}
+	return load
+}
+
+// findResumable 按恢复令牌查找现有订阅会话及其当前连接。
+func (r *Room) findResumable(token string) (*webrtc.PeerConnection, *subscriber) {
+	if token == "" {
+		return nil, nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for pc, sub := range r.subs {
+		if sub.resume == token {
+			return pc, sub
+		}
+	}
+	return nil, nil
+}
+
+// onSubscriberICE 处理订阅者 ICE 状态变化。开启 SUBSCRIBER_RESUME_TTL 后，断开的订阅者
+// 会保留到 TTL 结束，期间 ICE 自行恢复或客户端携带恢复令牌重连都会继续沿用该会话。
+func (r *Room) onSubscriberICE(pc *webrtc.PeerConnection, s webrtc.ICEConnectionState) {
+	switch s {
+	case webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateClosed:
+		ttl := r.resumeTTL()
+		if ttl <= 0 {
+			go r.removeSubscriber(pc)
+			return
+		}
+		r.mu.RLock()
+		sub := r.subs[pc]
+		r.mu.RUnlock()
+		if sub != nil {
+			sub.grace.start(ttl, func() { r.removeSubscriber(pc) })
+		}
+	case webrtc.ICEConnectionStateConnected:
+		r.mu.RLock()
+		sub := r.subs[pc]
+		r.mu.RUnlock()
+		if sub != nil {
+			sub.grace.stop()
+		}
+	}
+}
+
+// resumeTTL 返回断线订阅者会话的保留时长。
+func (r *Room) resumeTTL() time.Duration {
+	if r.mgr != nil && r.mgr.cfg != nil {
+		return r.mgr.cfg.SubscriberResumeTTL
+	}
+	return 0
+}
+
+// MetaRecordFormat 是房间元数据中覆盖录制格式的键。
+const MetaRecordFormat = "record_format"
+
+// recordFormat 返回房间的录制格式：管理接口预置的元数据 record_format 优先，其次为全局 RECORD_FORMAT。
+func (r *Room) recordFormat() string {
+	r.mu.RLock()
+	f := r.meta[MetaRecordFormat]
+	r.mu.RUnlock()
+	if config.ValidRecordFormat(f) {
+		return f
+	}
+	if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.RecordFormat != "" {
+		return r.mgr.cfg.RecordFormat
+	}
+	return config.RecordFormatSeparate
+}
+
+// disconnectGrace 返回发布者 ICE 断开后的宽限时长。
+func (r *Room) disconnectGrace() time.Duration {
+	if r.mgr != nil && r.mgr.cfg != nil {
+		return r.mgr.cfg.ICEDisconnectGrace
+	}
+	return 0
+}
+
+// takeoverAllowed 判断 ICE 状态为 s 的现有发布者能否被新推流顶替（ALLOW_PUBLISHER_TAKEOVER）。
+func (r *Room) takeoverAllowed(s webrtc.ICEConnectionState) bool {
+	if r.mgr == nil || r.mgr.cfg == nil || !r.mgr.cfg.AllowPublisherTakeover {
+		return false
+	}
+	return s == webrtc.ICEConnectionStateDisconnected || s == webrtc.ICEConnectionStateFailed
+}
+
+// startRecording 为 feed 创建 separate/audio 格式的录制文件 {房间}_{track}_{时间戳}{ext}
+// （分段录制时为 {base}_seg{n}{ext}）并登记为写入中。
+func (r *Room) startRecording(feed *trackFanout, track, mime, ext string) {
+	base := fmt.Sprintf("%s_%s_%d", r.name, track, time.Now().Unix())
+	file := base + ext
+	seg := r.newSegmenter(feed, base, ext, mime)
+	if seg != nil {
+		file = segmentName(base, seg.n, ext)
+	}
+	w, p, err := r.openRecorder(file, mime)
+	if err != nil {
+		return
+	}
+	// 直传时没有本地文件，也就不写旁路统计文件
+	feed.setRecorder(w, p, p != "" && r.mgr.cfg.RecordSidecar)
+	feed.setSegmenter(seg)
+	r.trackRecording(feed, p)
+}
+
+// openRecordOutput 打开录制输出：开启 RECORD_DIRECT_UPLOAD 且后端支持直传时返回对象存储直传流
+// （路径为空），否则（含直传不可用时）创建 RECORD_DIR 下的文件，创建前即登记为写入中，
+// 调用方需交给 trackRecording 接管，或经 discardRecordOutput 放弃。
+func (r *Room) openRecordOutput(name string) (io.WriteCloser, string, error) {
+	cfg := r.mgr.cfg
+	if cfg.RecordDirectUpload {
+		s, err := uploader.OpenStream(name)
+		if err == nil {
+			return s, "", nil
+		}
+		log.Printf("sfu: direct upload unavailable for %s, recording to disk: %v", name, err)
+	}
+	_ = os.MkdirAll(cfg.RecordDir, 0o755)
+	p := filepath.Join(cfg.RecordDir, name)
+	r.mgr.beginRecording(p)
+	f, err := os.Create(p)
+	if err != nil {
+		r.mgr.endRecording(p)
+		return nil, "", err
+	}
+	return f, p, nil
+}
+
+// openRecorder 为轨道创建录制写入器，返回本地文件路径（直传时为空）。输出由 openRecordOutput 打开，
+// 再按编码包装为对应容器的写入器，登记规则同 openRecordOutput。与直传一样，本地文件也不回写 Ogg 结束页标志与 IVF 文件头中的帧数，
+// 主流播放器会忽略这两项。
+func (r *Room) openRecorder(name, mime string) (rtpWriter, string, error) {
+	out, p, err := r.openRecordOutput(name)
+	if err != nil {
+		return nil, "", err
+	}
+	var w rtpWriter
+	switch mime {
+	case webrtc.MimeTypeOpus:
+		w, err = oggwriter.NewWith(out, 48000, 2)
+	case webrtc.MimeTypeH264:
+		w = h264writer.NewWith(out)
+	default:
+		w, err = newIVFWriterTo(out, mime)
+	}
+	if err != nil {
+		r.discardRecordOutput(out, p, err)
+		return nil, "", err
+	}
+	return w, p, nil
+}
+
+// discardRecordOutput 放弃 openRecordOutput 打开的输出：直传流以 err 中止，本地文件删除并注销写入中登记。
+func (r *Room) discardRecordOutput(out io.WriteCloser, path string, err error) {
+	if s, ok := out.(*uploader.Stream); ok {
+		_ = s.Abort(err)
+		return
+	}
+	_ = out.Close()
+	if path != "" {
+		_ = os.Remove(path)
+		r.mgr.endRecording(path)
+	}
+}
+
+// midScheme 返回发布者轨道的稳定 mid 命名方案，为空表示沿用客户端的 mid。
+func (r *Room) midScheme() string {
+	if r.mgr != nil && r.mgr.cfg != nil {
+		return r.mgr.cfg.MidScheme
+	}
+	return ""
+}
+
+// receiverMid 返回 receiver 所属收发器协商出的 mid。
+func receiverMid(pc *webrtc.PeerConnection, receiver *webrtc.RTPReceiver) string {
+	for _, t := range pc.GetTransceivers() {
+		if t.Receiver() == receiver {
+			return t.Mid()
+		}
+	}
+	return ""
+}
+
+// finalizeAnswer 在返回给客户端前按配置调整 Answer；本地描述保持 pion 生成的原样。
+func (r *Room) finalizeAnswer(offer, sdp string) string {
+	if r.mgr == nil || r.mgr.cfg == nil {
+		return sdp
+	}
+	sdp = negotiateRTCPRsize(offer, sdp, r.mgr.cfg.EnableRTCPRsize)
+	sdp = dropHostCandidates(sdp, r.mgr.cfg.DropCandidateCIDRs)
+	if r.mgr.cfg.AnswerAudioFirst {
+		sdp = reorderAudioFirst(sdp)
+	}
+	return sdp
+}
+
+// RequestKeyframe 校验订阅者 ID 后立即向其订阅的各发布者的视频轨道发送 PLI，
+// 供解码异常（如标签页切回后画面冻结）的观众主动恢复，而无需重连。
+func (r *Room) RequestKeyframe(subscriberID string) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var sub *subscriber
+	for _, s := range r.subs {
+		if s.id == subscriberID {
+			sub = s
+			break
+		}
+	}
+	if sub == nil {
+		return ErrSubscriberNotFound
+	}
+	if len(r.publishers) == 0 {
+		return ErrNoPublisher
+	}
+	// PLI 只能发给轨道所属发布者的连接
+	pkts := map[*webrtc.PeerConnection][]rtcp.Packet{}
+	for _, f := range r.trackFeeds {
+		pub := r.publisherPCLocked(f.publisher)
+		if pub == nil || !sub.wants(f) {
+			continue
+		}
+		if src := f.source(); src != nil && src.Kind() == webrtc.RTPCodecTypeVideo {
+			pkts[pub] = append(pkts[pub], &rtcp.PictureLossIndication{MediaSSRC: uint32(src.SSRC())})
+		}
+	}
+	var firstErr error
+	for pub, p := range pkts {
+		if err := pub.WriteRTCP(p); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-// closePublisher 在发布者掉线时清理资源，并断开所有 fanout。
+// closePublisher 在发布者掉线时清理资源，并断开其轨道的 fanout；其他发布者的轨道不受影响。
+// 最后一个发布者离开时停止级联转推。
 func (r *Room) closePublisher(pc *webrtc.PeerConnection) {
 	r.mu.Lock()
-	if r.publisher == pc {
-		for _, f := range r.trackFeeds {
-			f.close()
+	pubID := ""
+	if p := r.publisherByPCLocked(pc); p != nil {
+		pubID = p.id
+		for k, f := range r.trackFeeds {
+			if f.publisher == pubID {
+				f.close()
+				delete(r.trackFeeds, k)
+			}
+		}
+		delete(r.publishers, pubID)
+		if len(r.publishers) == 0 {
+			metrics.SetBitrate(r.name, 0)
+			metrics.SetFramerate(r.name, 0)
+			r.closeRelaysLocked()
 		}
-		r.trackFeeds = make(map[string]*trackFanout)
-		r.publisher = nil
 	}
 	r.mu.Unlock()
 	_ = pc.Close()
+	if pubID != "" {
+		r.untrackSession(pubID)
+	}
+	if r.mgr != nil {
+		r.mgr.persist()
+	}
 }
 
 // removeSubscriber 在订阅者离线时解除与 track fanout 的绑定。
 func (r *Room) removeSubscriber(pc *webrtc.PeerConnection) {
 	r.mu.Lock()
-	if _, ok := r.subs[pc]; ok {
+	sub, ok := r.subs[pc]
+	if ok {
 		for _, f := range r.trackFeeds {
 			f.detachFromSubscriber(pc)
 		}
 		delete(r.subs, pc)
+		r.subLoad -= sub.weight
+		if len(r.subs) == 0 {
+			r.subLoad = 0 // 消除浮点累计误差
+		}
+		r.releaseWaitersLocked()
 	}
 	r.mu.Unlock()
 	_ = pc.Close()
-	metrics.DecSubscribers(r.name)
+	if ok {
+		r.untrackSession(sub.id)
+		metrics.DecSubscribers(r.name)
+	}
+}
+
+// discardSubscriber 关闭协商失败、尚未登记到 r.subs 的订阅连接，
+// 并与 removeSubscriber 一样把它从各 fanout 摘除，避免 fanout 继续向已关闭的连接写入。
+func (r *Room) discardSubscriber(pc *webrtc.PeerConnection) {
+	r.mu.RLock()
+	for _, f := range r.trackFeeds {
+		f.detachFromSubscriber(pc)
+	}
+	r.mu.RUnlock()
+	_ = pc.Close()
+}
+
+// evictStuckSubscriber 移除 RTP 写入卡死的订阅者，避免其占用资源并计入指标。
+func (r *Room) evictStuckSubscriber(pc *webrtc.PeerConnection) {
+	log.Printf("sfu: room %s removing subscriber stuck on RTP write", r.name)
+	metrics.IncStuckSubscribers(r.name)
+	r.removeSubscriber(pc)
 }
 
 // Close 主动关闭房间内所有连接。
-func (r *Room) Close() {
+func (r *Room) Close() { r.closeGraceful(0) }
+
+// closeGraceful 关闭房间；grace > 0 时先停止各轨道的读取与分发、向订阅者发送 RTCP BYE，
+// 等待 grace 后再关闭发布者与订阅者连接。
+func (r *Room) closeGraceful(grace time.Duration) {
 	r.mu.Lock()
-	pub := r.publisher
+	pubs := r.publishers
 	feeds := r.trackFeeds
 	subs := r.subs
-	r.publisher = nil
+	r.closed = true
+	r.publishers = make(map[string]*publisherSession)
 	r.trackFeeds = make(map[string]*trackFanout)
-	r.subs = make(map[*webrtc.PeerConnection]struct{})
+	r.subs = make(map[*webrtc.PeerConnection]*subscriber)
+	r.subLoad = 0
+	metrics.SetBitrate(r.name, 0)
+	metrics.SetFramerate(r.name, 0)
+	r.closeRelaysLocked()
+	r.releaseAllWaitersLocked()
 	r.mu.Unlock()
 
-	if pub != nil {
-		_ = pub.Close()
+	if grace > 0 {
+		for _, f := range feeds {
+			f.close()
+		}
+		for s := range subs {
+			sendGoodbye(s)
+		}
+		time.Sleep(grace)
+	}
+	for id, p := range pubs {
+		_ = p.pc.Close()
+		r.untrackSession(id)
 	}
 	for _, f := range feeds {
-		f.close()
+		f.close() // 已关闭时为空操作
 	}
-	for s := range subs {
+	for s, sub := range subs {
+		sub.grace.stop()
 		_ = s.Close()
+		r.untrackSession(sub.id)
+		metrics.DecSubscribers(r.name)
+	}
+}
+
+// sendGoodbye 向订阅连接发送 RTCP BYE，通知播放端其各发送轨道的 SSRC 已结束。
+func sendGoodbye(pc *webrtc.PeerConnection) {
+	var ssrcs []uint32
+	for _, sender := range pc.GetSenders() {
+		if sender.Track() == nil {
+			continue
+		}
+		for _, enc := range sender.GetParameters().Encodings {
+			ssrcs = append(ssrcs, uint32(enc.SSRC))
+		}
+	}
+	if len(ssrcs) > 0 {
+		_ = pc.WriteRTCP([]rtcp.Packet{&rtcp.Goodbye{Sources: ssrcs, Reason: "room closed"}})
 	}
 }
 
 // trackFanout 负责把单个远端 Track 分发给多个订阅者，并可选写盘上传。
 type trackFanout struct {
-	remote *webrtc.TrackRemote
-	mu     sync.RWMutex
+	src atomic.Pointer[webrtc.TrackRemote] // 数据源，发布者迁移时原子替换
+	mu  sync.RWMutex
 	// per-subscriber local tracks
-	locals  map[*webrtc.PeerConnection]*webrtc.TrackLocalStaticRTP
+	locals  map[*webrtc.PeerConnection]*subWriter
 	closed  chan struct{}
 	room    string
 	rec     rtpWriter
 	recPath string
+	sidecar bool     // 关闭录制时是否写出统计旁路文件
+	stats   recStats // 当前录制的累计统计
+	recDone func()   // 录制关闭后调用，注销 Manager 中的写入中登记
+	// 读取活性：最近一次成功读取的时间（UnixNano）与是否已被判定为卡顿
+	lastRead atomic.Int64
+	stalled  atomic.Bool
+	rxBytes  atomic.Uint64 // 累计接收字节数，供码率采样
+	// 视频轨道按 RTP 时间戳变化累计帧数，供帧率采样（lastTS/seenTS 仅 readLoop 访问）
+	video  bool
+	frames atomic.Uint64
+	lastTS uint32
+	seenTS bool
+	// 订阅者单次写入阻塞超过 writeTimeout 时回调 onStuck 将其移除（0 表示不检测）
+	writeTimeout time.Duration
+	onStuck      func(pc *webrtc.PeerConnection)
+	// 所属发布者 ID，用于按 ?publisher= 过滤订阅者
+	publisher string
+	// 迁移后首个包时重新计算序列号/时间戳偏移，保持输出连续（仅 readLoop 访问 seq）
+	seq    continuity
+	rebase atomic.Bool
+	// 所属 Manager，用于统计出站字节并在超出 MAX_EGRESS_MBPS 时丢弃视频包；为 nil 时不统计
+	mgr *Manager
+	// 新订阅者挂接后的回调（视频轨道用于立即请求关键帧）；调用时可能持有 r.mu，不得阻塞
+	onAttach func()
+	lastPLI  atomic.Int64 // 最近一次发送 PLI 的时间（UnixNano），用于合并短时间内的重复请求
+	// E2EE_PASSTHROUGH：发布者 Offer 中该媒体类型的头扩展（URI -> ID），为 nil 时不改写扩展 ID
+	ext map[string]uint8
+	// 分段录制状态，随录制写入器设置；其字段仅由 readLoop 访问，为 nil 时不分段
+	seg *segmenter
+	// RECORD_TRIM_START：尚未开始的录制，收到实际内容时在后台创建
+	pendingRec *pendingRecording
 }
 
 func newTrackFanout(remote *webrtc.TrackRemote, room string) *trackFanout {
-	return &trackFanout{
-		remote: remote,
-		locals: make(map[*webrtc.PeerConnection]*webrtc.TrackLocalStaticRTP),
+	f := &trackFanout{
+		locals: make(map[*webrtc.PeerConnection]*subWriter),
 		closed: make(chan struct{}),
 		room:   room,
 	}
+	f.src.Store(remote)
+	f.video = remote != nil && remote.Kind() == webrtc.RTPCodecTypeVideo
+	f.lastRead.Store(time.Now().UnixNano())
+	return f
 }
 
 type rtpWriter interface {
@@ -386,18 +1328,26 @@ type rtpWriter interface {
 	Close() error
 }
 
-// setRecorder 设置录制写入器与目标文件路径。
-func (f *trackFanout) setRecorder(w rtpWriter, path string) {
+// setRecorder 设置录制写入器与目标文件路径；sidecar 为 true 时在关闭录制时写出统计旁路文件。
+func (f *trackFanout) setRecorder(w rtpWriter, path string, sidecar bool) {
 	f.mu.Lock()
 	f.rec = w
 	f.recPath = path
+	f.sidecar = sidecar
+	f.stats = recStats{RecordingStats: RecordingStats{Room: f.room, StartTime: time.Now()}}
+	if src := f.source(); src != nil {
+		f.stats.TrackID = src.ID()
+		f.stats.Codec = src.Codec().MimeType
+	}
 	f.mu.Unlock()
 }
 
 // attachToSubscriber 为订阅者创建本地 Track，并启动读取循环以清理发送缓冲。
-func (f *trackFanout) attachToSubscriber(pc *webrtc.PeerConnection) {
-	codec := f.remote.Codec().RTPCodecCapability
-	local, err := webrtc.NewTrackLocalStaticRTP(codec, f.remote.ID(), f.remote.StreamID())
+// exts 为订阅者 Offer 协商的头扩展（仅 E2EE_PASSTHROUGH），用于把转发包的扩展 ID 换成订阅者的取值。
+func (f *trackFanout) attachToSubscriber(pc *webrtc.PeerConnection, exts headerExts) {
+	src := f.source()
+	codec := src.Codec().RTPCodecCapability
+	local, err := webrtc.NewTrackLocalStaticRTP(codec, src.ID(), f.streamID(src.StreamID()))
 	if err != nil {
 		return
 	}
@@ -405,23 +1355,28 @@ func (f *trackFanout) attachToSubscriber(pc *webrtc.PeerConnection) {
 	if err != nil {
 		return
 	}
-	go func() {
-		buf := make([]byte, 1500)
-		for {
-			if _, _, err := sender.Read(buf); err != nil {
-				return
-			}
-		}
-	}()
+	f.attach(pc, local, sender, newExtRemap(f.ext, exts[src.Kind().String()]))
+}
+
+// attach 登记订阅者的写入器并在后台清理其 RTCP；detachFromSubscriber 或 close 时两者一并停止。
+func (f *trackFanout) attach(pc *webrtc.PeerConnection, sink rtpSink, sender rtcpSender, ext extRemap) {
+	sw := newSubWriter(sink, ext)
+	go drainRTCP(sender, sw.done)
 
 	f.mu.Lock()
-	f.locals[pc] = local
+	f.locals[pc] = sw
 	f.mu.Unlock()
+	if f.onAttach != nil {
+		f.onAttach()
+	}
 }
 
 func (f *trackFanout) detachFromSubscriber(pc *webrtc.PeerConnection) {
 	f.mu.Lock()
-	delete(f.locals, pc)
+	if sw, ok := f.locals[pc]; ok {
+		sw.stop()
+		delete(f.locals, pc)
+	}
 	f.mu.Unlock()
 }
 
@@ -434,51 +1389,166 @@ func (f *trackFanout) close() {
 		close(f.closed)
 	}
 	f.mu.Lock()
-	if f.rec != nil {
-		_ = f.rec.Close()
-		if f.recPath != "" {
-			go func(p string) { _ = uploader.Upload(context.Background(), p) }(f.recPath)
-		}
-		f.rec = nil
-		f.recPath = ""
+	f.pendingRec = nil
+	f.stopRecordingLocked()
+	for pc, sw := range f.locals {
+		sw.stop()
+		delete(f.locals, pc)
 	}
 	f.mu.Unlock()
 }
 
+// stopRecordingLocked 结束当前录制并清空录制状态，调用方须持有 f.mu。
+func (f *trackFanout) stopRecordingLocked() {
+	if f.rec == nil {
+		return
+	}
+	f.finishRecording(f.rec, f.recPath, f.stats.RecordingStats, f.recDone)
+	f.rec = nil
+	f.recPath = ""
+	f.recDone = nil
+	f.seg = nil
+}
+
+// finishRecording 关闭录制写入器 w：写出旁路统计（如开启）、把文件 path 加入上传队列，最后调用 done
+// 注销写入中登记。共享录制文件（WebM）只由最后关闭的 fanout 上传；直传（path 为空）无需上传。
+func (f *trackFanout) finishRecording(w rtpWriter, path string, st RecordingStats, done func()) {
+	last, err := true, error(nil)
+	if sw, ok := w.(sharedWriter); ok {
+		last, err = sw.closeShared()
+	} else {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("sfu: room %s close recording: %v", f.room, err)
+	}
+	if path != "" && last {
+		paths := []string{path}
+		if f.sidecar {
+			st.EndTime = time.Now()
+			if p, err := writeSidecar(path, st); err == nil {
+				paths = append(paths, p)
+			}
+		}
+		for _, p := range paths {
+			uploader.Enqueue(f.room, p)
+		}
+	}
+	if done != nil {
+		done()
+	}
+}
+
 // readLoop 持续从远端 Track 读取 RTP，并同步写入录制和所有订阅者。
 func (f *trackFanout) readLoop() {
 	buf := make([]byte, 1500)
+	var scratch rtp.Packet
 	for {
 		select {
 		case <-f.closed:
 			return
 		default:
 		}
-		n, _, err := f.remote.Read(buf)
+		remote := f.source()
+		n, _, err := remote.Read(buf)
+		if f.source() != remote {
+			continue // 已迁移到新的发布连接：丢弃旧源的残留数据，改读新源
+		}
 		if err != nil {
 			return
 		}
+		f.rewriteSeq(buf[:n], remote)
+		f.markRead(time.Now())
+		f.rxBytes.Add(uint64(n))
+		if f.mgr != nil {
+			f.mgr.rxBytes.Add(uint64(n))
+			f.mgr.rxPkts.Add(1)
+		}
+		f.countFrame(buf[:n])
 		metrics.AddBytes(f.room, n)
 		metrics.IncPackets(f.room)
-		pkt := &rtp.Packet{}
-		if err := pkt.Unmarshal(buf[:n]); err != nil {
-			continue
+		f.forward(buf[:n], &scratch)
+	}
+}
+
+// forward 把一个 RTP 包写入录制并分发给订阅者。没有订阅者时走快速路径：
+// 复用 scratch 解析后直接写盘，不再逐包分配，降低纯录制（归档）场景的 CPU 开销。
+// 录制写入器必须在 WriteRTP 返回前用完包内容，不能持有其引用。
+func (f *trackFanout) forward(raw []byte, scratch *rtp.Packet) {
+	f.mu.RLock()
+	rec, seg, pending, fanout := f.rec, f.seg, f.pendingRec, len(f.locals) > 0
+	f.mu.RUnlock()
+	if !fanout {
+		if rec == nil && pending == nil {
+			return
+		}
+		if err := scratch.Unmarshal(raw); err != nil {
+			return
+		}
+		if pending != nil {
+			if f.deferPacket(pending, scratch) {
+				return
+			}
+			if rec, seg = f.recorder(); rec == nil {
+				return
+			}
+		}
+		f.record(rec, seg, scratch, len(raw))
+		return
+	}
+
+	pkt := &rtp.Packet{}
+	if err := pkt.Unmarshal(raw); err != nil {
+		return
+	}
+	if pending != nil {
+		if f.deferPacket(pending, pkt) {
+			rec = nil
+		} else {
+			rec, seg = f.recorder()
 		}
-		f.mu.RLock()
-		rec := f.rec
-		f.mu.RUnlock()
-		if rec != nil {
-			_ = rec.WriteRTP(pkt)
-		}
-		f.mu.RLock()
-		for _, local := range f.locals {
-			// clone packet for each subscriber to avoid mutation issues
-			clone := *pkt
-			if pkt.Payload != nil {
-				clone.Payload = append([]byte(nil), pkt.Payload...)
+	}
+	if rec != nil {
+		f.record(rec, seg, pkt, len(raw))
+	}
+	now := time.Now()
+	if f.video && f.mgr.egressLimited(now) {
+		return // 出站码率超限：只保留音频，录制不受影响；恢复后观众在下一个关键帧恢复画面
+	}
+	sent := 0
+	f.mu.RLock()
+	for pc, sw := range f.locals {
+		if f.writeTimeout > 0 && sw.stuckFor(now) > f.writeTimeout {
+			if f.onStuck != nil && sw.evicted.CompareAndSwap(false, true) {
+				go f.onStuck(pc)
 			}
-			_ = local.WriteRTP(&clone)
+			continue
+		}
+		// clone packet for each subscriber to avoid mutation issues
+		clone := *pkt
+		if pkt.Payload != nil {
+			clone.Payload = append([]byte(nil), pkt.Payload...)
+		}
+		if sw.send(&clone) {
+			sent++
 		}
-		f.mu.RUnlock()
 	}
+	f.mu.RUnlock()
+	if f.mgr != nil && sent > 0 {
+		f.mgr.egress.add(sent*len(raw), now)
+	}
+}
+
+func (f *trackFanout) record(rec rtpWriter, seg *segmenter, pkt *rtp.Packet, n int) {
+	now := time.Now()
+	if seg != nil {
+		if rec = f.segmentWriter(seg, rec, pkt, now); rec == nil {
+			return
+		}
+		seg.bytes += int64(n)
+	}
+	_ = rec.WriteRTP(pkt)
+	f.mu.Lock()
+	f.stats.add(n, now)
+	f.mu.Unlock()
 }
@@ -2,10 +2,14 @@
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/pion/dtls/v2"
+	"github.com/pion/webrtc/v3"
 	"live-webrtc-go/internal/config"
 )
 
@@ -241,8 +245,8 @@ func TestRoom_Publish_InvalidSDP(t *testing.T) {
 	invalidSDP := "invalid-sdp-content"
 	
 	_, err := room.Publish(ctx, invalidSDP)
-	if err == nil {
-		t.Error("Expected error for invalid SDP")
+	if !errors.Is(err, ErrInvalidSDP) {
+		t.Errorf("Expected ErrInvalidSDP for invalid SDP, got %v", err)
 	}
 }
 
@@ -339,6 +343,120 @@ func TestRoom_Close(t *testing.T) {
 	}
 }
 
+// newTestOffer 使用 pion 生成一个带音视频发送轨道的 Offer，register 可追加客户端支持的编解码器。
+func newTestOffer(t *testing.T, register func(*webrtc.MediaEngine) error) string {
+	t.Helper()
+	return newOrderedTestOffer(t, register, false)
+}
+
+// newOrderedTestOffer 生成包含音视频轨道的 Offer，videoFirst 为 true 时视频 m-line 在前。
+func newOrderedTestOffer(t *testing.T, register func(*webrtc.MediaEngine) error, videoFirst bool) string {
+	t.Helper()
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatalf("register default codecs: %v", err)
+	}
+	if register != nil {
+		if err := register(m); err != nil {
+			t.Fatalf("register codecs: %v", err)
+		}
+	}
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("new peer connection: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	audio, _ := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "test")
+	video, _ := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, "video", "test")
+	tracks := []webrtc.TrackLocal{audio, video}
+	if videoFirst {
+		tracks[0], tracks[1] = video, audio
+	}
+	for _, tr := range tracks {
+		if _, err := pc.AddTrack(tr); err != nil {
+			t.Fatalf("add %s track: %v", tr.Kind(), err)
+		}
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatalf("create offer: %v", err)
+	}
+	g := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatalf("set local description: %v", err)
+	}
+	<-g
+	return pc.LocalDescription().SDP
+}
+
+func TestRoom_Publish_REDFECInAnswer(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.EnableREDFEC = true
+	defer mgr.CloseAll()
+
+	// 客户端与 Chrome 一样在默认编解码器（Opus 为 111）之外声明 RED 与 ULPFEC
+	offer := newTestOffer(t, func(m *webrtc.MediaEngine) error {
+		return registerREDFEC(m, "a=rtpmap:111 opus/48000/2\r\n")
+	})
+	answer, err := mgr.Publish(context.Background(), "red-room", offer)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if !strings.Contains(answer, "red/48000") {
+		t.Errorf("Expected RED codec in answer, got:\n%s", answer)
+	}
+	if !strings.Contains(answer, "ulpfec/90000") {
+		t.Errorf("Expected ULPFEC codec in answer, got:\n%s", answer)
+	}
+}
+
+func TestRedFECCodecs(t *testing.T) {
+	offer := "m=audio 9 UDP/TLS/RTP/SAVPF 109 100\r\na=rtpmap:109 opus/48000/2\r\na=rtpmap:100 red/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96 118\r\na=rtpmap:96 VP8/90000\r\na=rtpmap:118 ulpfec/90000\r\n"
+	audio, video := redFECCodecs(offer)
+	if len(audio) != 1 || audio[0].PayloadType != 100 || audio[0].SDPFmtpLine != "109/109" {
+		t.Errorf("Expected RED on the offer's PT 100 pointing at Opus 109, got %+v", audio)
+	}
+	if len(video) != 1 || video[0].PayloadType != 118 {
+		t.Errorf("Expected ULPFEC on the offer's PT 118, got %+v", video)
+	}
+
+	audio, video = redFECCodecs("m=audio 9 UDP/TLS/RTP/SAVPF 110\r\na=rtpmap:110 OPUS/48000/2\r\n")
+	if len(audio) != 1 || audio[0].PayloadType != defaultREDPayloadType || audio[0].SDPFmtpLine != "110/110" {
+		t.Errorf("Expected default RED PT pointing at Opus 110, got %+v", audio)
+	}
+	if len(video) != 1 || video[0].PayloadType != defaultULPFECPayloadType {
+		t.Errorf("Expected default ULPFEC PT, got %+v", video)
+	}
+
+	if audio, _ = redFECCodecs("m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\n"); len(audio) != 0 {
+		t.Errorf("Expected no RED without Opus in the offer, got %+v", audio)
+	}
+}
+
+func TestManager_RequestKeyframe(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	if err := mgr.RequestKeyframe("missing-room", "id"); err != ErrRoomNotFound {
+		t.Errorf("Expected ErrRoomNotFound, got %v", err)
+	}
+
+	_, id, err := mgr.SubscribeWithID(context.Background(), "pli-room", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	if id == "" {
+		t.Fatal("Expected non-empty subscriber ID")
+	}
+	if err := mgr.RequestKeyframe("pli-room", "unknown"); err != ErrSubscriberNotFound {
+		t.Errorf("Expected ErrSubscriberNotFound, got %v", err)
+	}
+	if err := mgr.RequestKeyframe("pli-room", id); err != ErrNoPublisher {
+		t.Errorf("Expected ErrNoPublisher, got %v", err)
+	}
+}
+
 func BenchmarkGetOrCreateRoom(b *testing.B) {
 	mgr, _ := setupTestManager()
 	
@@ -360,4 +478,286 @@ func BenchmarkListRooms(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		mgr.ListRooms()
 	}
-}
\ No newline at end of file
+}
+
+// mediaKinds 返回 SDP 中各 m-line 的媒体类型，按出现顺序排列。
+func mediaKinds(sdp string) []string {
+	var kinds []string
+	for _, l := range strings.Split(sdp, "\r\n") {
+		if strings.HasPrefix(l, "m=") {
+			kinds = append(kinds, strings.Fields(strings.TrimPrefix(l, "m="))[0])
+		}
+	}
+	return kinds
+}
+
+func TestRoom_Publish_AnswerAudioFirst(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.AnswerAudioFirst = true
+	defer mgr.CloseAll()
+
+	offer := newOrderedTestOffer(t, nil, true)
+	if kinds := mediaKinds(offer); len(kinds) != 2 || kinds[0] != "video" {
+		t.Fatalf("Expected video-first offer, got %v", kinds)
+	}
+	answer, err := mgr.Publish(context.Background(), "audio-first-room", offer)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	kinds := mediaKinds(answer)
+	if len(kinds) != 2 || kinds[0] != "audio" || kinds[1] != "video" {
+		t.Errorf("Expected audio-first answer, got %v", kinds)
+	}
+	if !strings.Contains(answer, "a=group:BUNDLE 1 0") {
+		t.Errorf("Expected BUNDLE group reordered to audio mid first, got:\n%s", answer)
+	}
+}
+
+func TestRoom_SubscribeResume_ReusesSession(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.SubscriberResumeTTL = time.Minute
+	defer mgr.CloseAll()
+
+	first, err := mgr.SubscribeResume(context.Background(), "resume-room", newTestOffer(t, nil), "")
+	if err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	if first.ResumeToken == "" || first.Resumed {
+		t.Fatalf("Expected fresh session with resume token, got %+v", first)
+	}
+
+	second, err := mgr.SubscribeResume(context.Background(), "resume-room", newTestOffer(t, nil), first.ResumeToken)
+	if err != nil {
+		t.Fatalf("Expected resume to succeed, got %v", err)
+	}
+	if !second.Resumed || second.ID != first.ID {
+		t.Errorf("Expected resumed session with ID %s, got %+v", first.ID, second)
+	}
+	if n := mgr.getOrCreateRoom("resume-room").stats().Subscribers; n != 1 {
+		t.Errorf("Expected resumed subscriber not to be counted twice, got %d", n)
+	}
+
+	third, err := mgr.SubscribeResume(context.Background(), "resume-room", newTestOffer(t, nil), "unknown")
+	if err != nil {
+		t.Fatalf("Expected subscribe with unknown token to succeed, got %v", err)
+	}
+	if third.Resumed || third.ID == first.ID {
+		t.Errorf("Expected unknown token to create a new session, got %+v", third)
+	}
+}
+
+func TestRoom_SubscribeWith_PublisherPin(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	if _, err := mgr.Publish(context.Background(), "pin-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	info := mgr.getOrCreateRoom("pin-room").stats()
+	if len(info.Publishers) != 1 || info.Publishers[0] == "" {
+		t.Fatalf("Expected publisher ID in room info, got %+v", info.Publishers)
+	}
+
+	if _, err := mgr.SubscribeWith(context.Background(), "pin-room", newTestOffer(t, nil), SubscribeOptions{Publisher: info.Publishers[0]}); err != nil {
+		t.Fatalf("Expected pinned subscribe to succeed, got %v", err)
+	}
+	_, err := mgr.SubscribeWith(context.Background(), "pin-room", newTestOffer(t, nil), SubscribeOptions{Publisher: "unknown"})
+	if !errors.Is(err, ErrPublisherNotFound) {
+		t.Errorf("Expected ErrPublisherNotFound, got %v", err)
+	}
+	if n := mgr.getOrCreateRoom("pin-room").stats().Subscribers; n != 1 {
+		t.Errorf("Expected rejected subscriber not to be counted, got %d", n)
+	}
+}
+
+func TestRoom_TakeoverAllowed(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	r := NewRoom("takeover", mgr)
+	if r.takeoverAllowed(webrtc.ICEConnectionStateDisconnected) {
+		t.Error("Expected takeover to be disabled by default")
+	}
+
+	cfg.AllowPublisherTakeover = true
+	for s, want := range map[webrtc.ICEConnectionState]bool{
+		webrtc.ICEConnectionStateDisconnected: true,
+		webrtc.ICEConnectionStateFailed:       true,
+		webrtc.ICEConnectionStateConnected:    false,
+		webrtc.ICEConnectionStateChecking:     false,
+	} {
+		if got := r.takeoverAllowed(s); got != want {
+			t.Errorf("state %s: expected %v, got %v", s, want, got)
+		}
+	}
+
+	// 仍在协商中的发布者不会被顶替
+	if _, err := r.Publish(context.Background(), newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	defer r.Close()
+	if _, err := r.Publish(context.Background(), newTestOffer(t, nil)); !errors.Is(err, ErrPublisherExists) {
+		t.Errorf("Expected live publisher not to be taken over (ErrPublisherExists), got %v", err)
+	}
+}
+
+func TestSubscriber_Wants(t *testing.T) {
+	a, b := &trackFanout{publisher: "a"}, &trackFanout{publisher: "b"}
+	all, pinned := &subscriber{}, &subscriber{publisher: "a"}
+	if !all.wants(a) || !all.wants(b) {
+		t.Error("Expected unpinned subscriber to want every publisher")
+	}
+	if !pinned.wants(a) || pinned.wants(b) {
+		t.Error("Expected pinned subscriber to want only its publisher")
+	}
+}
+
+// 推流与关闭同名房间交错执行（配合 -race）：关闭后的房间不得再挂上发布者，
+// 关闭期间也不得把正在拆除的房间交给新的请求。
+func TestManager_CloseRoomRacesPublish(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	const name = "race-room"
+	for i := 0; i < 20; i++ {
+		offer := newTestOffer(t, nil)
+		var wg sync.WaitGroup
+		var rooms [2]*Room
+		for j := range rooms {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				r := mgr.getOrCreateRoom(name)
+				rooms[j] = r
+				_, _ = r.Publish(context.Background(), offer) // 失败（已关闭/已有发布者）均可接受
+			}(j)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mgr.CloseRoom(name)
+		}()
+		wg.Wait()
+
+		for _, r := range rooms {
+			r.mu.RLock()
+			if r.closed && len(r.publishers) > 0 {
+				t.Errorf("iteration %d: closed room still holds a publisher", i)
+			}
+			r.mu.RUnlock()
+		}
+		mgr.mu.RLock()
+		if r, ok := mgr.rooms[name]; ok && r.closed {
+			t.Errorf("iteration %d: closed room handed out by manager", i)
+		}
+		mgr.mu.RUnlock()
+	}
+}
+
+func TestRoom_Publish_RejectsDisallowedSRTPProfile(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.SRTPProfiles = []string{"SRTP_AEAD_AES_256_GCM"}
+	defer mgr.CloseAll()
+
+	// 发布端只支持较弱的 SHA1_80，无法与服务端达成一致
+	m := &webrtc.MediaEngine{}
+	if err := m.RegisterDefaultCodecs(); err != nil {
+		t.Fatal(err)
+	}
+	var s webrtc.SettingEngine
+	s.SetSRTPProtectionProfiles(dtls.SRTP_AES128_CM_HMAC_SHA1_80)
+	pc, err := webrtc.NewAPI(webrtc.WithMediaEngine(m), webrtc.WithSettingEngine(s)).NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	audio, _ := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "audio", "test")
+	if _, err := pc.AddTrack(audio); err != nil {
+		t.Fatal(err)
+	}
+	offer, _ := pc.CreateOffer(nil)
+	g := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-g
+
+	answer, err := mgr.Publish(context.Background(), "srtp-room", pc.LocalDescription().SDP)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer}); err != nil {
+		t.Fatal(err)
+	}
+	room := mgr.getOrCreateRoom("srtp-room")
+	deadline := time.Now().Add(10 * time.Second)
+	for room.stats().HasPublisher && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if room.stats().HasPublisher {
+		t.Error("Expected publisher removed after DTLS-SRTP negotiation failed")
+	}
+}
+
+func TestManager_CloseRoomGraceful(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+	ctx := context.Background()
+
+	if _, err := mgr.Publish(ctx, "grace-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if _, err := mgr.Subscribe(ctx, "grace-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	room := mgr.getOrCreateRoom("grace-room")
+	room.mu.RLock()
+	var sub *webrtc.PeerConnection
+	for pc := range room.subs {
+		sub = pc
+	}
+	room.mu.RUnlock()
+
+	done := make(chan bool)
+	start := time.Now()
+	go func() { done <- mgr.CloseRoomGraceful("grace-room", 200*time.Millisecond) }()
+	time.Sleep(50 * time.Millisecond)
+	if sub.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		t.Error("Expected subscriber connection kept open during grace period")
+	}
+	if ok := <-done; !ok {
+		t.Fatal("Expected room to be closed")
+	}
+	if time.Since(start) < 200*time.Millisecond {
+		t.Error("Expected close to wait for the grace period")
+	}
+	if sub.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		t.Error("Expected subscriber connection closed after grace period")
+	}
+}
+
+func TestRoom_DiscardSubscriberDetachesFanouts(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+	room := mgr.getOrCreateRoom("discard")
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("NewPeerConnection: %v", err)
+	}
+	feed := newTrackFanout(nil, room.name)
+	feed.locals[pc] = newSubWriter(&countingSink{}, nil)
+	room.mu.Lock()
+	room.trackFeeds[feedKey("p", "t1")] = feed
+	room.mu.Unlock()
+
+	room.discardSubscriber(pc)
+	feed.mu.RLock()
+	n := len(feed.locals)
+	feed.mu.RUnlock()
+	if n != 0 {
+		t.Errorf("Expected failed subscriber detached from fanouts, %d writer(s) left", n)
+	}
+	if pc.ConnectionState() != webrtc.PeerConnectionStateClosed {
+		t.Error("Expected failed subscriber connection closed")
+	}
+	feed.close()
+}
@@ -0,0 +1,463 @@
+package sfu
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"live-webrtc-go/internal/config"
+)
+
+// ErrStrictSDP 表示 Offer 未通过 STRICT_SDP 校验。
+var ErrStrictSDP = errors.New("offer rejected by strict SDP validation")
+
+// validateStrictOffer 拒绝含 a=inactive 或 a=bundle-only 的 Offer：这类 m-line 会协商出
+// 不承载媒体的收发器，连接看似成功却没有画面，不如直接失败让客户端修正。
+func validateStrictOffer(sdp string) error {
+	mline, kind := 0, "session"
+	for _, l := range strings.Split(sdp, "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		switch {
+		case strings.HasPrefix(l, "m="):
+			mline++
+			kind = ""
+			if f := strings.Fields(strings.TrimPrefix(l, "m=")); len(f) > 0 {
+				kind = f[0]
+			}
+		case l == "a=inactive":
+			return fmt.Errorf("%w: m-line %d (%s) is inactive", ErrStrictSDP, mline, kind)
+		case l == "a=bundle-only":
+			return fmt.Errorf("%w: m-line %d (%s) is bundle-only", ErrStrictSDP, mline, kind)
+		}
+	}
+	return nil
+}
+
+// reorderAudioFirst 把 SDP 中的音频 m-line 移到其他媒体之前（同类媒体保持原有相对顺序），
+// 并同步改写 a=group:BUNDLE 中的 mid 顺序。部分客户端只接受音频在前的 BUNDLE 组。
+func reorderAudioFirst(sdp string) string {
+	sep, session, sections := splitSections(sdp)
+	if len(sections) < 2 {
+		return sdp
+	}
+	sort.SliceStable(sections, func(i, j int) bool {
+		return isAudioSection(sections[i]) && !isAudioSection(sections[j])
+	})
+
+	var mids []string
+	for _, sec := range sections {
+		for _, l := range sec {
+			if strings.HasPrefix(l, "a=mid:") {
+				mids = append(mids, strings.TrimPrefix(l, "a=mid:"))
+				break
+			}
+		}
+	}
+	for i, l := range session {
+		if strings.HasPrefix(l, "a=group:BUNDLE ") {
+			session[i] = bundleInOrder(l, mids)
+		}
+	}
+
+	return joinSections(sep, session, sections)
+}
+
+// splitSections 把 SDP 拆分为会话级行与各 m-line 媒体段（每段首行为 m= 行）。
+func splitSections(sdp string) (sep string, session []string, sections [][]string) {
+	sep = lineSep(sdp)
+	for _, l := range strings.Split(strings.TrimSuffix(sdp, sep), sep) {
+		if strings.HasPrefix(l, "m=") {
+			sections = append(sections, []string{l})
+			continue
+		}
+		if len(sections) == 0 {
+			session = append(session, l)
+		} else {
+			sections[len(sections)-1] = append(sections[len(sections)-1], l)
+		}
+	}
+	return sep, session, sections
+}
+
+// joinSections 是 splitSections 的逆操作。
+func joinSections(sep string, session []string, sections [][]string) string {
+	out := session
+	for _, sec := range sections {
+		out = append(out, sec...)
+	}
+	return strings.Join(out, sep) + sep
+}
+
+func hasAttr(sec []string, attr string) bool {
+	for _, l := range sec {
+		if l == attr {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateRTCPRsize 按 Offer 协商 reduced-size RTCP（RFC 5506）。pion 无论 Offer 如何都会在 Answer 的
+// 每个媒体段写入 a=rtcp-rsize；未开启时统一移除，开启时仅保留 Offer 对应段（按 m-line 顺序一一对应）
+// 同样声明了的段。pion 的 PLI/NACK 等反馈本就单独发送、不组复合包，因此声明支持是安全的，
+// 对端随之也可发送精简的 RTCP，节省高丢包链路上的带宽。被拒绝（端口为 0）的段不声明。
+func negotiateRTCPRsize(offer, answer string, enabled bool) string {
+	_, _, offered := splitSections(offer)
+	sep, session, sections := splitSections(answer)
+	for i, sec := range sections {
+		kept := sec[:0]
+		at := -1
+		for _, l := range sec {
+			if l == "a=rtcp-rsize" {
+				continue
+			}
+			kept = append(kept, l)
+			if l == "a=rtcp-mux" {
+				at = len(kept)
+			}
+		}
+		sections[i] = kept
+		if !enabled || i >= len(offered) || !hasAttr(offered[i], "a=rtcp-rsize") {
+			continue
+		}
+		if f := strings.Fields(kept[0]); len(f) > 1 && f[1] == "0" {
+			continue
+		}
+		if at < 0 {
+			at = len(kept)
+		}
+		sections[i] = append(kept[:at], append([]string{"a=rtcp-rsize"}, kept[at:]...)...)
+	}
+	return joinSections(sep, session, sections)
+}
+
+func isAudioSection(sec []string) bool {
+	return strings.HasPrefix(sec[0], "m=audio ")
+}
+
+// bundleInOrder 按 mids 的顺序重写 BUNDLE 组，只保留原本就在组内的 mid。
+func bundleInOrder(line string, mids []string) string {
+	in := make(map[string]bool)
+	for _, m := range strings.Fields(strings.TrimPrefix(line, "a=group:BUNDLE ")) {
+		in[m] = true
+	}
+	ordered := []string{"a=group:BUNDLE"}
+	for _, m := range mids {
+		if in[m] {
+			ordered = append(ordered, m)
+		}
+	}
+	return strings.Join(ordered, " ")
+}
+
+// lineSep 返回 SDP 使用的换行符，兼容不规范地只用 \n 的客户端。
+func lineSep(sdp string) string {
+	if strings.Contains(sdp, "\r\n") {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// midExtURI 是在 RTP 头扩展中携带 mid 的扩展 URI。
+const midExtURI = "urn:ietf:params:rtp-hdrext:sdes:mid"
+
+// rewriteMids 按 scheme 把 Offer 中各 m-line 的 mid 改写为稳定值并同步 BUNDLE 组，
+// 返回改写后的 SDP 及“稳定 mid -> 原始 mid”映射。客户端发出的 RTP 仍携带原始 mid，
+// 因此一并去掉 sdes:mid 头扩展，让 pion 按 a=ssrc 关联轨道；simulcast 依赖该扩展分流，
+// 遇到时与 scheme 为空一样原样返回，映射为 nil。
+func rewriteMids(sdp, scheme string) (string, map[string]string) {
+	if scheme == "" || strings.Contains(sdp, "a=simulcast:") {
+		return sdp, nil
+	}
+	sep := lineSep(sdp)
+	toStable := make(map[string]string)
+	toOrig := make(map[string]string)
+	seen := make(map[string]int)
+	index, kind := -1, ""
+	var out []string
+	for _, l := range strings.Split(sdp, sep) {
+		switch {
+		case strings.HasPrefix(l, "m="):
+			index++
+			kind = ""
+			if f := strings.Fields(strings.TrimPrefix(l, "m=")); len(f) > 0 {
+				kind = f[0]
+			}
+		case strings.HasPrefix(l, "a=mid:"):
+			stable := strconv.Itoa(index)
+			if scheme == config.MidSchemeKind {
+				stable = kind
+				if n := seen[kind]; n > 0 {
+					stable += strconv.Itoa(n)
+				}
+				seen[kind]++
+			}
+			orig := strings.TrimPrefix(l, "a=mid:")
+			toStable[orig] = stable
+			toOrig[stable] = orig
+			l = "a=mid:" + stable
+		case strings.HasPrefix(l, "a=extmap:") && strings.HasSuffix(strings.TrimSpace(l), " "+midExtURI):
+			continue
+		}
+		out = append(out, l)
+	}
+	for i, l := range out {
+		if strings.HasPrefix(l, "a=group:BUNDLE ") {
+			out[i] = renameBundle(l, toStable)
+		}
+	}
+	return strings.Join(out, sep), toOrig
+}
+
+// restoreMids 把 Answer 中的稳定 mid 换回客户端 Offer 里的原始值，mids 为 nil 时原样返回。
+func restoreMids(sdp string, mids map[string]string) string {
+	if len(mids) == 0 {
+		return sdp
+	}
+	sep := lineSep(sdp)
+	lines := strings.Split(sdp, sep)
+	for i, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "a=mid:"):
+			if orig, ok := mids[strings.TrimPrefix(l, "a=mid:")]; ok {
+				lines[i] = "a=mid:" + orig
+			}
+		case strings.HasPrefix(l, "a=group:BUNDLE "):
+			lines[i] = renameBundle(l, mids)
+		}
+	}
+	return strings.Join(lines, sep)
+}
+
+// renameBundle 按 names 替换 BUNDLE 组中的 mid，未出现在 names 中的保持不变。
+func renameBundle(line string, names map[string]string) string {
+	fields := strings.Fields(strings.TrimPrefix(line, "a=group:BUNDLE "))
+	for i, m := range fields {
+		if n, ok := names[m]; ok {
+			fields[i] = n
+		}
+	}
+	return "a=group:BUNDLE " + strings.Join(fields, " ")
+}
+
+// setOpusMaxBitrate 在每个媒体段 Opus 负载的 a=fmtp 行中设置 maxaveragebitrate（bps），
+// 覆盖已有取值；没有 fmtp 行的 Opus 负载会在 rtpmap 之后补上一行。pion 的 Answer 沿用 Offer 中
+// 的 fmtp 参数，因此上限只能在生成 Answer 后写入，发布端编码器据此限制音频码率。
+func setOpusMaxBitrate(sdp string, bps int) string {
+	if bps <= 0 {
+		return sdp
+	}
+	return setOpusParam(sdp, "maxaveragebitrate", bps, false)
+}
+
+// setOpusPtime 在 Answer 中写入 Opus 打包时长（毫秒）：小的 ptime 降低互动语音延迟，大的 ptime
+// 减少包头开销、适合音乐。取值既写入 Opus 负载的 fmtp，也写成含 Opus 的媒体段的 a=ptime/a=maxptime
+// （RFC 4566 的媒体级属性，浏览器编码器按此选择帧长）；0 表示不写。
+func setOpusPtime(sdp string, ptime, maxptime int) string {
+	if ptime > 0 {
+		sdp = setOpusParam(sdp, "ptime", ptime, true)
+	}
+	if maxptime > 0 {
+		sdp = setOpusParam(sdp, "maxptime", maxptime, true)
+	}
+	return sdp
+}
+
+// setOpusParam 在每个含 Opus 的媒体段中把 fmtp 参数 key 设为 v；mediaAttr 为 true 时同时写入
+// 媒体级属性 a=key:v。
+func setOpusParam(sdp, key string, v int, mediaAttr bool) string {
+	sep := lineSep(sdp)
+	var out []string
+	var section []string
+	flush := func() {
+		out = append(out, capOpusSection(section, key, strconv.Itoa(v), mediaAttr)...)
+		section = nil
+	}
+	for _, l := range strings.Split(sdp, sep) {
+		if strings.HasPrefix(l, "m=") {
+			flush()
+		}
+		section = append(section, l)
+	}
+	flush()
+	return strings.Join(out, sep)
+}
+
+func capOpusSection(lines []string, key, value string, mediaAttr bool) []string {
+	param := key + "=" + value
+	rtpmap := make(map[string]int) // Opus 负载类型 -> rtpmap 所在行
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "a=rtpmap:") {
+			continue
+		}
+		pt, codec, _ := strings.Cut(strings.TrimPrefix(l, "a=rtpmap:"), " ")
+		if strings.HasPrefix(strings.ToLower(codec), "opus/") {
+			rtpmap[pt] = i
+		}
+	}
+	if len(rtpmap) == 0 {
+		return lines
+	}
+	if mediaAttr {
+		lines = setMediaAttr(lines, "a="+key+":"+value)
+	}
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "a=fmtp:") {
+			continue
+		}
+		pt, params, _ := strings.Cut(strings.TrimPrefix(l, "a=fmtp:"), " ")
+		if _, ok := rtpmap[pt]; !ok {
+			continue
+		}
+		var kept []string
+		for _, p := range strings.Split(params, ";") {
+			if p = strings.TrimSpace(p); p != "" && !strings.HasPrefix(strings.ToLower(p), key+"=") {
+				kept = append(kept, p)
+			}
+		}
+		lines[i] = "a=fmtp:" + pt + " " + strings.Join(append(kept, param), ";")
+		delete(rtpmap, pt)
+	}
+	// 从后往前插入缺失的 fmtp 行，避免挪动尚未处理的行号
+	missing := make([]int, 0, len(rtpmap))
+	for _, i := range rtpmap {
+		missing = append(missing, i)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(missing)))
+	for _, i := range missing {
+		pt, _, _ := strings.Cut(strings.TrimPrefix(lines[i], "a=rtpmap:"), " ")
+		fmtp := "a=fmtp:" + pt + " " + param
+		lines = append(lines[:i+1], append([]string{fmtp}, lines[i+1:]...)...)
+	}
+	return lines
+}
+
+// offerPayloadType 返回 SDP 中第一个编码名为 codec（不区分大小写）的 a=rtpmap 负载类型。
+func offerPayloadType(sdp, codec string) (uint8, bool) {
+	for _, l := range strings.Split(sdp, "\n") {
+		l = strings.TrimSuffix(l, "\r")
+		if !strings.HasPrefix(l, "a=rtpmap:") {
+			continue
+		}
+		pt, enc, _ := strings.Cut(strings.TrimPrefix(l, "a=rtpmap:"), " ")
+		name, _, _ := strings.Cut(enc, "/")
+		if !strings.EqualFold(name, codec) {
+			continue
+		}
+		if n, err := strconv.ParseUint(pt, 10, 7); err == nil {
+			return uint8(n), true
+		}
+	}
+	return 0, false
+}
+
+// setMediaAttr 把媒体段中与 attr 同名的属性行替换为 attr，没有时追加到段尾（末尾空行之前），
+// 不移动已有行的位置。
+func setMediaAttr(lines []string, attr string) []string {
+	name, _, _ := strings.Cut(attr, ":")
+	for i, l := range lines {
+		if strings.HasPrefix(l, name+":") {
+			lines[i] = attr
+			return lines
+		}
+	}
+	end := len(lines)
+	for end > 0 && lines[end-1] == "" {
+		end--
+	}
+	return append(lines[:end], append([]string{attr}, lines[end:]...)...)
+}
+
+// setMaxFramerate 在 Answer 每个启用的视频段写入 a=framerate（RFC 4566），请发布端把帧率限制在 fps 以内；
+// 这只是协商提示，实际帧率取决于发布端编码器是否遵守，0 表示不写。
+func setMaxFramerate(sdp string, fps int) string {
+	if fps <= 0 {
+		return sdp
+	}
+	return setVideoAttr(sdp, "a=framerate:"+strconv.Itoa(fps))
+}
+
+// setImageAttr 在 Answer 每个启用的视频段写入 a=imageattr（RFC 6236），把分辨率限制在 width×height 以内：
+// dir 为 recv 时约束对端发来的视频（发布者 Answer），为 send 时声明服务端发出的视频（订阅者 Answer）。
+// 与 a=framerate 一样只是协商提示，宽高任一为 0 时不写。
+func setImageAttr(sdp, dir string, width, height int) string {
+	if width <= 0 || height <= 0 {
+		return sdp
+	}
+	return setVideoAttr(sdp, fmt.Sprintf("a=imageattr:* %s [x=[1:%d],y=[1:%d]]", dir, width, height))
+}
+
+// setVideoAttr 把属性 attr 写入每个启用（端口非 0）的视频段，替换已有的同名属性。
+func setVideoAttr(sdp, attr string) string {
+	sep := lineSep(sdp)
+	var out, section []string
+	flush := func() {
+		if len(section) > 0 && strings.HasPrefix(section[0], "m=video ") && !strings.HasPrefix(section[0], "m=video 0 ") {
+			section = setMediaAttr(section, attr)
+		}
+		out = append(out, section...)
+		section = nil
+	}
+	for _, l := range strings.Split(sdp, sep) {
+		if strings.HasPrefix(l, "m=") {
+			flush()
+		}
+		section = append(section, l)
+	}
+	flush()
+	return strings.Join(out, sep)
+}
+
+// offerReceivesVideo 判断订阅者 Offer 是否有可接收视频的段：端口非 0 且未声明 sendonly/inactive。
+func offerReceivesVideo(sdp string) bool {
+	_, _, sections := splitSections(sdp)
+	for _, sec := range sections {
+		if !strings.HasPrefix(sec[0], "m=video ") || strings.HasPrefix(sec[0], "m=video 0 ") {
+			continue
+		}
+		if !hasAttr(sec, "a=sendonly") && !hasAttr(sec, "a=inactive") {
+			return true
+		}
+	}
+	return false
+}
+
+// dropHostCandidates 移除 SDP 中地址落在 nets 内的 host 候选（a=candidate 行），例如容器内网地址：
+// 外部客户端无法连通这些地址，保留只会拖慢 ICE。srflx/relay 候选及 mDNS 主机名不受影响。
+func dropHostCandidates(sdp string, nets []*net.IPNet) string {
+	if len(nets) == 0 {
+		return sdp
+	}
+	sep := lineSep(sdp)
+	lines := strings.Split(sdp, sep)
+	out := lines[:0]
+	for _, l := range lines {
+		if strings.HasPrefix(l, "a=candidate:") && droppedHostCandidate(strings.TrimPrefix(l, "a="), nets) {
+			continue
+		}
+		out = append(out, l)
+	}
+	return strings.Join(out, sep)
+}
+
+// droppedHostCandidate 判断候选（不含 "a=" 前缀）是否为地址落在 nets 内的 host 候选。
+func droppedHostCandidate(cand string, nets []*net.IPNet) bool {
+	// candidate:<foundation> <component> <transport> <priority> <address> <port> typ <type> ...
+	f := strings.Fields(cand)
+	if len(f) < 8 || f[6] != "typ" || f[7] != "host" {
+		return false
+	}
+	ip := net.ParseIP(f[4])
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
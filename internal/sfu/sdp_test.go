@@ -0,0 +1,308 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"live-webrtc-go/internal/config"
+)
+
+func TestValidateStrictOffer(t *testing.T) {
+	base := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=sendrecv\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\n"
+	tests := []struct {
+		name    string
+		sdp     string
+		wantErr string
+	}{
+		{"valid", base + "a=sendonly\r\n", ""},
+		{"inactive", base + "a=inactive\r\n", "m-line 2 (video) is inactive"},
+		{"bundle-only", base + "a=bundle-only\r\n", "m-line 2 (video) is bundle-only"},
+		{"bare m-line", base + "m=\r\na=inactive\r\n", "m-line 3 () is inactive"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateStrictOffer(test.sdp)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrStrictSDP) || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Expected %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRoom_Publish_StrictSDPRejectsInactive(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.StrictSDP = true
+	defer mgr.CloseAll()
+
+	offer := strings.Replace(newTestOffer(t, nil), "a=sendrecv", "a=inactive", 1)
+	if _, err := mgr.Publish(context.Background(), "strict-room", offer); !errors.Is(err, ErrStrictSDP) {
+		t.Errorf("Expected ErrStrictSDP, got %v", err)
+	}
+}
+
+func sdpMids(sdp string) []string {
+	var mids []string
+	for _, l := range strings.Split(sdp, "\r\n") {
+		if strings.HasPrefix(l, "a=mid:") {
+			mids = append(mids, strings.TrimPrefix(l, "a=mid:"))
+		}
+	}
+	return mids
+}
+
+func TestRewriteMids(t *testing.T) {
+	offer := "v=0\r\ns=-\r\na=group:BUNDLE x7 y2 z9\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=mid:x7\r\na=extmap:4 urn:ietf:params:rtp-hdrext:sdes:mid\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=mid:y2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=mid:z9\r\n"
+	tests := []struct {
+		scheme string
+		want   []string
+	}{
+		{config.MidSchemeKind, []string{"audio", "video", "video1"}},
+		{config.MidSchemeIndex, []string{"0", "1", "2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.scheme, func(t *testing.T) {
+			got, mids := rewriteMids(offer, test.scheme)
+			if strings.Join(sdpMids(got), " ") != strings.Join(test.want, " ") {
+				t.Errorf("Expected mids %v, got %v", test.want, sdpMids(got))
+			}
+			if !strings.Contains(got, "a=group:BUNDLE "+strings.Join(test.want, " ")+"\r\n") {
+				t.Errorf("Expected BUNDLE group to be rewritten, got %q", got)
+			}
+			if strings.Contains(got, "sdes:mid") {
+				t.Error("Expected sdes:mid header extension to be removed")
+			}
+			if restored := restoreMids(got, mids); strings.Join(sdpMids(restored), " ") != "x7 y2 z9" ||
+				!strings.Contains(restored, "a=group:BUNDLE x7 y2 z9\r\n") {
+				t.Errorf("Expected original mids after restore, got %q", restored)
+			}
+		})
+	}
+
+	if got, mids := rewriteMids(offer+"a=simulcast:send h;l\r\n", config.MidSchemeKind); mids != nil || strings.Contains(got, "a=mid:audio") {
+		t.Error("Expected simulcast offer to be left untouched")
+	}
+	if _, mids := rewriteMids(offer, ""); mids != nil {
+		t.Error("Expected no mapping without a scheme")
+	}
+}
+
+func TestRoom_Publish_StableMidsKeepClientMids(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.MidScheme = config.MidSchemeKind
+	defer mgr.CloseAll()
+
+	offer := newTestOffer(t, nil)
+	answer, err := mgr.Publish(context.Background(), "mid-room", offer)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if want, got := strings.Join(sdpMids(offer), " "), strings.Join(sdpMids(answer), " "); got != want {
+		t.Errorf("Expected answer mids %q to match offer, got %q", want, got)
+	}
+}
+
+func TestSetOpusMaxBitrate(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 109\r\na=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;maxaveragebitrate=64000\r\na=rtpmap:109 OPUS/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 VP8/90000\r\na=fmtp:111 x-google-start-bitrate=800\r\n"
+	got := setOpusMaxBitrate(sdp, 32000)
+	want := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111 109\r\na=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;maxaveragebitrate=32000\r\na=rtpmap:109 OPUS/48000/2\r\na=fmtp:109 maxaveragebitrate=32000\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 VP8/90000\r\na=fmtp:111 x-google-start-bitrate=800\r\n"
+	if got != want {
+		t.Errorf("Unexpected SDP:\n%q\nwant\n%q", got, want)
+	}
+	if setOpusMaxBitrate(sdp, 0) != sdp {
+		t.Error("Expected SDP to be unchanged without a cap")
+	}
+}
+
+func TestRoom_Publish_OpusMaxBitrate(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.OpusMaxBitrate = 32000
+	defer mgr.CloseAll()
+
+	answer, err := mgr.Publish(context.Background(), "opus-cap-room", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if !strings.Contains(answer, "maxaveragebitrate=32000") {
+		t.Errorf("Expected Opus fmtp to carry maxaveragebitrate, got %q", answer)
+	}
+}
+
+func TestSetOpusPtime(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;ptime=20\r\na=ptime:20\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\n"
+	got := setOpusPtime(sdp, 10, 40)
+	want := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\na=fmtp:111 minptime=10;ptime=10;maxptime=40\r\na=ptime:10\r\na=maxptime:40\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\n"
+	if got != want {
+		t.Errorf("Unexpected SDP:\n%q\nwant\n%q", got, want)
+	}
+	if setOpusPtime(sdp, 0, 0) != sdp {
+		t.Error("Expected SDP to be unchanged without ptime")
+	}
+}
+
+func TestRoom_Publish_OpusPtime(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.OpusPtime, cfg.OpusMaxPtime = 10, 60
+	defer mgr.CloseAll()
+
+	answer, err := mgr.Publish(context.Background(), "opus-ptime-room", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	for _, want := range []string{"ptime=10;maxptime=60", "a=ptime:10\r\n", "a=maxptime:60\r\n"} {
+		if !strings.Contains(answer, want) {
+			t.Errorf("Expected answer to contain %q, got %q", want, answer)
+		}
+	}
+}
+
+func TestSetMaxFramerate(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\na=framerate:60\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 96\r\na=inactive\r\n"
+	want := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\na=framerate:15\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 96\r\na=inactive\r\n"
+	if got := setMaxFramerate(sdp, 15); got != want {
+		t.Errorf("Unexpected SDP:\n%q\nwant\n%q", got, want)
+	}
+	if setMaxFramerate(sdp, 0) != sdp {
+		t.Error("Expected SDP to be unchanged without a cap")
+	}
+}
+
+func TestSetImageAttr(t *testing.T) {
+	sdp := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 96\r\na=inactive\r\n"
+	want := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtpmap:96 VP8/90000\r\na=imageattr:* recv [x=[1:1280],y=[1:720]]\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 96\r\na=inactive\r\n"
+	got := setImageAttr(sdp, "recv", 1280, 720)
+	if got != want {
+		t.Errorf("Unexpected SDP:\n%q\nwant\n%q", got, want)
+	}
+	if again := setImageAttr(got, "recv", 640, 360); strings.Count(again, "a=imageattr:") != 1 || !strings.Contains(again, "[x=[1:640],y=[1:360]]") {
+		t.Errorf("Expected existing imageattr replaced, got %q", again)
+	}
+	if setImageAttr(sdp, "send", 1280, 0) != sdp {
+		t.Error("Expected SDP to be unchanged without both dimensions")
+	}
+}
+
+func TestNegotiateRTCPRsize(t *testing.T) {
+	offer := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtcp-mux\r\na=rtcp-rsize\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtcp-mux\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtcp-rsize\r\n"
+	answer := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtcp-mux\r\na=rtcp-rsize\r\na=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtcp-mux\r\na=rtcp-rsize\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 0\r\n"
+	enabled := "v=0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=rtcp-mux\r\na=rtcp-rsize\r\na=rtpmap:111 opus/48000/2\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=rtcp-mux\r\n" +
+		"m=video 0 UDP/TLS/RTP/SAVPF 0\r\n"
+	if got := negotiateRTCPRsize(offer, answer, true); got != enabled {
+		t.Errorf("enabled:\n got %q\nwant %q", got, enabled)
+	}
+	if got := negotiateRTCPRsize(offer, enabled, true); got != enabled {
+		t.Errorf("Expected negotiation to be idempotent, got %q", got)
+	}
+	if got := negotiateRTCPRsize(offer, answer, false); strings.Contains(got, "a=rtcp-rsize") {
+		t.Errorf("Expected rtcp-rsize stripped while disabled, got %q", got)
+	}
+}
+
+func TestRoom_Publish_RTCPRsize(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	defer mgr.CloseAll()
+
+	// pion 生成的 Offer 自带 a=rtcp-rsize；另构造一份不支持的 Offer 作对照
+	offer := newTestOffer(t, nil)
+	plainOffer := strings.ReplaceAll(offer, "a=rtcp-rsize\r\n", "")
+	if offer == plainOffer {
+		t.Fatal("Expected test offer to declare rtcp-rsize")
+	}
+
+	answer, err := mgr.Publish(context.Background(), "rsize-off-room", offer)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if strings.Contains(answer, "a=rtcp-rsize") {
+		t.Errorf("Expected no rtcp-rsize while disabled, got %q", answer)
+	}
+
+	cfg.EnableRTCPRsize = true
+	answer, err = mgr.Publish(context.Background(), "rsize-room", offer)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if got, want := strings.Count(answer, "a=rtcp-rsize"), strings.Count(offer, "a=rtcp-rsize"); got != want {
+		t.Errorf("Expected rtcp-rsize in %d sections, got %d: %q", want, got, answer)
+	}
+
+	plain, err := mgr.Publish(context.Background(), "rsize-plain-room", plainOffer)
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	if strings.Contains(plain, "a=rtcp-rsize") {
+		t.Errorf("Expected no rtcp-rsize for offers without it, got %q", plain)
+	}
+}
+
+func TestOfferReceivesVideo(t *testing.T) {
+	cases := map[string]bool{
+		"v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=recvonly\r\n":                                   false,
+		"v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\nm=video 0 UDP/TLS/RTP/SAVPF 96\r\n":               false,
+		"v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=inactive\r\n": false,
+		"v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=recvonly\r\n": true,
+	}
+	for sdp, want := range cases {
+		if got := offerReceivesVideo(sdp); got != want {
+			t.Errorf("offerReceivesVideo(%q) = %v, want %v", sdp, got, want)
+		}
+	}
+}
+
+func TestDropHostCandidates(t *testing.T) {
+	_, private, _ := net.ParseCIDR("10.0.0.0/8")
+	sdp := "v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:1 1 udp 2130706431 10.1.2.3 50000 typ host\r\n" +
+		"a=candidate:2 1 udp 2130706431 203.0.113.5 50000 typ host\r\n" +
+		"a=candidate:3 1 udp 1694498815 10.1.2.3 50001 typ srflx raddr 0.0.0.0 rport 0\r\n" +
+		"a=candidate:4 1 udp 2130706431 abc.local 50000 typ host\r\n"
+	want := "v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\n" +
+		"a=candidate:2 1 udp 2130706431 203.0.113.5 50000 typ host\r\n" +
+		"a=candidate:3 1 udp 1694498815 10.1.2.3 50001 typ srflx raddr 0.0.0.0 rport 0\r\n" +
+		"a=candidate:4 1 udp 2130706431 abc.local 50000 typ host\r\n"
+	if got := dropHostCandidates(sdp, []*net.IPNet{private}); got != want {
+		t.Errorf("Unexpected SDP:\n%q\nwant\n%q", got, want)
+	}
+	if dropHostCandidates(sdp, nil) != sdp {
+		t.Error("Expected SDP to be unchanged without CIDRs")
+	}
+}
@@ -0,0 +1,180 @@
+package sfu
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// segmenter 记录分段录制（RECORD_SEGMENT_DURATION / RECORD_SEGMENT_SIZE_MB）的当前段。
+// 长时间直播按段切分成多个文件，已结束的段立即关闭并上传，不必等到推流结束。
+type segmenter struct {
+	base, ext, mime string        // 段文件名为 {base}_seg{n}{ext}
+	n               int           // 当前段序号，从 1 开始
+	start           time.Time     // 当前段开始时间
+	bytes           int64         // 当前段已写入的 RTP 字节数
+	maxDur          time.Duration // 段时长上限，0 表示不按时长切分
+	maxBytes        int64         // 段大小上限，0 表示不按大小切分
+	pliSent         bool          // 当前段到期后是否已请求关键帧
+
+	open     func(name, mime string) (rtpWriter, string, error) // 打开下一段的写入器
+	opened   func(path string)                                  // 下一段打开后调用，接管写入中的登记
+	release  func(path string)                                  // 丢弃已打开的下一段时注销其登记，可为 nil
+	keyframe func()                                             // 视频段到期后请求关键帧，为 nil 时不请求
+}
+
+// segmentName 返回第 n 段的文件名。
+func segmentName(base string, n int, ext string) string {
+	return fmt.Sprintf("%s_seg%d%s", base, n, ext)
+}
+
+var segmentNameRe = regexp.MustCompile(`^(.+)_seg(\d+)(\.[A-Za-z0-9]+)$`)
+
+// ParseSegmentName 解析分段录制的文件名，返回整段录制的名称（去掉 _seg{n}）与段序号；
+// 不是分段文件时返回 name 与 0。
+func ParseSegmentName(name string) (recording string, n int) {
+	m := segmentNameRe.FindStringSubmatch(name)
+	if m == nil {
+		return name, 0
+	}
+	n, err := strconv.Atoi(m[2])
+	if err != nil || n <= 0 {
+		return name, 0
+	}
+	return m[1] + m[3], n
+}
+
+// due 报告当前段是否已达到时长或大小上限。
+func (s *segmenter) due(now time.Time) bool {
+	return (s.maxDur > 0 && now.Sub(s.start) >= s.maxDur) || (s.maxBytes > 0 && s.bytes >= s.maxBytes)
+}
+
+// newSegmenter 为 feed 的录制创建分段状态，未开启分段时返回 nil。
+func (r *Room) newSegmenter(feed *trackFanout, base, ext, mime string) *segmenter {
+	cfg := r.mgr.cfg
+	if cfg.RecordSegmentDuration <= 0 && cfg.RecordSegmentSizeMB <= 0 {
+		return nil
+	}
+	s := &segmenter{
+		base: base, ext: ext, mime: mime, n: 1, start: time.Now(),
+		maxDur:   cfg.RecordSegmentDuration,
+		maxBytes: int64(cfg.RecordSegmentSizeMB) << 20,
+		open:     r.openRecorder,
+		release:  r.mgr.endRecording,
+	}
+	s.opened = func(path string) {
+		r.trackRecording(feed, path)
+		r.mgr.persist()
+	}
+	if feed.video {
+		s.keyframe = func() { go r.requestTrackKeyframe(feed) }
+	}
+	return s
+}
+
+// setSegmenter 为当前录制开启分段。
+func (f *trackFanout) setSegmenter(s *segmenter) {
+	f.mu.Lock()
+	f.seg = s
+	f.mu.Unlock()
+}
+
+// segmentWriter 在当前段到期时打开下一段、替换录制写入器，并关闭、上传上一段，返回本包应写入的写入器。
+// 视频等到关键帧的首个包才切换，保证每段都从关键帧开始、可独立播放；到期后先请求一次关键帧以免久等。
+// 下一段打开失败时继续写入当前段；录制已被关闭时返回 nil。
+func (f *trackFanout) segmentWriter(s *segmenter, rec rtpWriter, pkt *rtp.Packet, now time.Time) rtpWriter {
+	if !s.due(now) {
+		return rec
+	}
+	if f.video && !isKeyframeStart(s.mime, pkt.Payload) {
+		if !s.pliSent && s.keyframe != nil {
+			s.pliSent = true
+			s.keyframe()
+		}
+		return rec
+	}
+	next, path, err := s.open(segmentName(s.base, s.n+1, s.ext), s.mime)
+	s.start, s.bytes, s.pliSent = now, 0, false
+	if err != nil {
+		log.Printf("sfu: room %s open recording segment %d: %v", f.room, s.n+1, err)
+		return rec
+	}
+	s.n++
+
+	f.mu.Lock()
+	if f.rec != rec {
+		f.mu.Unlock()
+		_ = next.Close()
+		if path != "" {
+			_ = os.Remove(path)
+			if s.release != nil {
+				s.release(path)
+			}
+		}
+		return nil
+	}
+	old, oldPath, oldStats, oldDone := f.rec, f.recPath, f.stats.RecordingStats, f.recDone
+	f.rec, f.recPath, f.recDone = next, path, nil
+	f.stats = recStats{RecordingStats: RecordingStats{Room: f.room, TrackID: oldStats.TrackID, Codec: oldStats.Codec, StartTime: now}}
+	f.mu.Unlock()
+
+	if s.opened != nil {
+		s.opened(path)
+	}
+	f.finishRecording(old, oldPath, oldStats, oldDone)
+	return next
+}
+
+// isKeyframeStart 报告视频 RTP 负载是否为关键帧的首个包。
+func isKeyframeStart(mime string, payload []byte) bool {
+	if len(payload) == 0 {
+		return false
+	}
+	switch mime {
+	case webrtc.MimeTypeVP8:
+		var vp8 codecs.VP8Packet
+		p, err := vp8.Unmarshal(payload)
+		return err == nil && vp8.S == 1 && vp8.PID == 0 && len(p) > 0 && p[0]&0x01 == 0
+	case webrtc.MimeTypeVP9:
+		var vp9 codecs.VP9Packet
+		_, err := vp9.Unmarshal(payload)
+		return err == nil && vp9.B && !vp9.P
+	case webrtc.MimeTypeAV1:
+		var av1 codecs.AV1Packet
+		_, err := av1.Unmarshal(payload)
+		return err == nil && av1.N
+	case webrtc.MimeTypeH264:
+		return h264KeyframeStart(payload)
+	}
+	return false
+}
+
+// h264KeyframeStart 识别以 SPS 或 IDR 开头的 H.264 负载（单 NAL、STAP-A 或 FU-A 起始分片）。
+func h264KeyframeStart(payload []byte) bool {
+	const (
+		nalIDR  = 5
+		nalSPS  = 7
+		nalSTAP = 24
+		nalFUA  = 28
+	)
+	switch t := payload[0] & 0x1F; t {
+	case nalIDR, nalSPS:
+		return true
+	case nalSTAP:
+		if len(payload) < 4 {
+			return false
+		}
+		inner := payload[3] & 0x1F
+		return inner == nalSPS || inner == nalIDR
+	case nalFUA:
+		return len(payload) >= 2 && payload[1]&0x80 != 0 && payload[1]&0x1F == nalIDR
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package sfu
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestParseSegmentName(t *testing.T) {
+	cases := map[string]struct {
+		recording string
+		n         int
+	}{
+		"demo_cam_1700000000_seg12.ivf": {"demo_cam_1700000000.ivf", 12},
+		"demo_mic_1700000000_seg1.ogg":  {"demo_mic_1700000000.ogg", 1},
+		"demo_cam_1700000000.ivf":       {"demo_cam_1700000000.ivf", 0},
+		"demo_seg0.ivf":                 {"demo_seg0.ivf", 0},
+	}
+	for name, want := range cases {
+		if rec, n := ParseSegmentName(name); rec != want.recording || n != want.n {
+			t.Errorf("%s: expected %s/%d, got %s/%d", name, want.recording, want.n, rec, n)
+		}
+	}
+}
+
+func TestIsKeyframeStart(t *testing.T) {
+	cases := []struct {
+		mime    string
+		payload []byte
+		want    bool
+	}{
+		{webrtc.MimeTypeVP8, []byte{0x10, 0x00}, true},                    // S=1, P=0
+		{webrtc.MimeTypeVP8, []byte{0x10, 0x01}, false},                   // 帧间帧
+		{webrtc.MimeTypeVP8, []byte{0x00, 0x00}, false},                   // 非起始分片
+		{webrtc.MimeTypeH264, []byte{0x65}, true},                         // IDR
+		{webrtc.MimeTypeH264, []byte{0x41}, false},                        // 非 IDR 片
+		{webrtc.MimeTypeH264, []byte{0x78, 0x00, 0x02, 0x67, 0x42}, true}, // STAP-A 以 SPS 开头
+		{webrtc.MimeTypeH264, []byte{0x7C, 0x85}, true},                   // FU-A 起始分片，IDR
+		{webrtc.MimeTypeH264, []byte{0x7C, 0x05}, false},                  // FU-A 后续分片
+	}
+	for _, c := range cases {
+		if got := isKeyframeStart(c.mime, c.payload); got != c.want {
+			t.Errorf("%s %x: expected %v, got %v", c.mime, c.payload, c.want, got)
+		}
+	}
+}
+
+func TestTrackFanout_SegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	var writers []*fakeRecorder
+	var opened []string
+	keyframes := 0
+	open := func(name, mime string) (rtpWriter, string, error) {
+		w := &fakeRecorder{}
+		writers = append(writers, w)
+		return w, filepath.Join(dir, name), nil
+	}
+	first, path, _ := open(segmentName("room_cam_1", 1, ".ivf"), webrtc.MimeTypeVP8)
+
+	f := newTrackFanout(nil, "room")
+	f.video = true
+	f.setRecorder(first, path, true)
+	seg := &segmenter{
+		base: "room_cam_1", ext: ".ivf", mime: webrtc.MimeTypeVP8, n: 1, start: time.Now(), maxBytes: 1000,
+		open:     open,
+		opened:   func(p string) { opened = append(opened, p) },
+		keyframe: func() { keyframes++ },
+	}
+	f.setSegmenter(seg)
+
+	delta := &rtp.Packet{Payload: append([]byte{0x10, 0x01}, make([]byte, 598)...)}
+	key := &rtp.Packet{Payload: append([]byte{0x10, 0x00}, make([]byte, 598)...)}
+	f.record(first, seg, delta, 600)
+	f.record(first, seg, delta, 600) // 达到 1000 字节上限
+	f.record(first, seg, delta, 600) // 到期但不是关键帧：继续写当前段并请求关键帧
+	f.record(first, seg, delta, 600)
+	if len(writers) != 1 || keyframes != 1 || writers[0].packets != 4 {
+		t.Fatalf("Expected rotation deferred to a keyframe with one PLI, got %d writers, %d PLIs, %d packets", len(writers), keyframes, writers[0].packets)
+	}
+
+	f.record(first, seg, key, 600)
+	if len(writers) != 2 || !writers[0].closed || writers[1].packets != 1 {
+		t.Fatalf("Expected rotation on keyframe, got %d writers (first closed=%v)", len(writers), writers[0].closed)
+	}
+	next := filepath.Join(dir, "room_cam_1_seg2.ivf")
+	if f.recPath != next || seg.n != 2 || len(opened) != 1 || opened[0] != next {
+		t.Errorf("Expected second segment %s registered, got %s (opened %v)", next, f.recPath, opened)
+	}
+	st, err := ReadSidecar(path)
+	if err != nil || st.Packets != 4 {
+		t.Errorf("Expected sidecar for the finished segment with 4 packets, got %+v, %v", st, err)
+	}
+	if f.stats.Packets != 1 || f.stats.Room != "room" {
+		t.Errorf("Expected fresh stats for the new segment, got %+v", f.stats.RecordingStats)
+	}
+
+	f.close()
+	if !writers[1].closed {
+		t.Error("Expected current segment closed with the fanout")
+	}
+}
@@ -0,0 +1,76 @@
+package sfu
+
+import "github.com/pion/webrtc/v3"
+
+// trackSession 在会话注册表中登记房间 r 内的会话 id（发布者 ID 或订阅者 ID），
+// 供 DELETE 会话资源时精确找到对应的 PeerConnection；条目在会话结束时移除。
+// 锁顺序为 r.mu -> m.sessMu，可在持有 r.mu 时调用。
+func (m *Manager) trackSession(id string, r *Room) {
+	m.sessMu.Lock()
+	if m.sessions == nil {
+		m.sessions = make(map[string]*Room)
+	}
+	m.sessions[id] = r
+	m.sessMu.Unlock()
+}
+
+// untrackSession 移除会话 id；仅当其仍登记在房间 r 下时生效，避免误删同 ID 的新会话。
+func (m *Manager) untrackSession(id string, r *Room) {
+	m.sessMu.Lock()
+	if m.sessions[id] == r {
+		delete(m.sessions, id)
+	}
+	m.sessMu.Unlock()
+}
+
+// CloseSession 关闭 WHIP/WHEP 会话资源对应的连接：发布者会话关闭推流及其轨道，
+// 订阅者会话只断开该订阅者，不影响发布者与其他观众。会话不存在时返回 ErrSessionNotFound。
+func (m *Manager) CloseSession(id string) error {
+	m.sessMu.Lock()
+	r := m.sessions[id]
+	m.sessMu.Unlock()
+	if r == nil {
+		return ErrSessionNotFound
+	}
+	return r.closeSession(id)
+}
+
+// closeSession 按会话 ID 关闭房间内的发布者或单个订阅者。
+func (r *Room) closeSession(id string) error {
+	r.mu.RLock()
+	var pub, sub *webrtc.PeerConnection
+	if p := r.publishers[id]; p != nil {
+		pub = p.pc
+	} else {
+		for pc, s := range r.subs {
+			if s.id == id {
+				sub = pc
+				break
+			}
+		}
+	}
+	r.mu.RUnlock()
+	switch {
+	case pub != nil:
+		r.closePublisher(pub)
+	case sub != nil:
+		r.removeSubscriber(sub)
+	default:
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// trackSession 在房间隶属于 Manager 时登记会话 id。
+func (r *Room) trackSession(id string) {
+	if r.mgr != nil {
+		r.mgr.trackSession(id, r)
+	}
+}
+
+// untrackSession 在房间隶属于 Manager 时注销会话 id。
+func (r *Room) untrackSession(id string) {
+	if r.mgr != nil {
+		r.mgr.untrackSession(id, r)
+	}
+}
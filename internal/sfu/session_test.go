@@ -0,0 +1,89 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManager_CloseSession(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+	ctx := context.Background()
+
+	_, pubID, err := mgr.PublishWithID(ctx, "session-room", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	_, subA, err := mgr.SubscribeWithID(ctx, "session-room", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	if _, _, err := mgr.SubscribeWithID(ctx, "session-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	room := mgr.getOrCreateRoom("session-room")
+
+	// 删除订阅者会话只断开该订阅者，发布者与其他订阅者不受影响
+	if err := mgr.CloseSession(subA); err != nil {
+		t.Fatalf("Expected subscriber session to close, got %v", err)
+	}
+	if info := room.stats(); !info.HasPublisher || info.Subscribers != 1 {
+		t.Errorf("Expected publisher and one subscriber to remain, got %+v", info)
+	}
+	if err := mgr.CloseSession(subA); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected closed session to be gone, got %v", err)
+	}
+
+	if err := mgr.CloseSession(pubID); err != nil {
+		t.Fatalf("Expected publisher session to close, got %v", err)
+	}
+	if info := room.stats(); info.HasPublisher || info.Subscribers != 1 {
+		t.Errorf("Expected only the publisher to be removed, got %+v", info)
+	}
+
+	mgr.CloseRoom("session-room")
+	mgr.sessMu.Lock()
+	n := len(mgr.sessions)
+	mgr.sessMu.Unlock()
+	if n != 0 {
+		t.Errorf("Expected session registry to be empty after room close, got %d", n)
+	}
+}
+
+func TestRoom_SubscribeWith_ClientIDDedup(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.MaxSubsPerRoom = 1
+	defer mgr.CloseAll()
+	ctx := context.Background()
+	room := mgr.getOrCreateRoom("dedup-room")
+
+	first, err := room.SubscribeWith(ctx, newTestOffer(t, nil), SubscribeOptions{ClientID: "viewer-1"})
+	if err != nil {
+		t.Fatalf("Expected subscribe to succeed, got %v", err)
+	}
+	// 同一客户端重试：旧连接占用的名额不计入容量检查，新应答生成后旧连接才被关闭
+	second, err := room.SubscribeWith(ctx, newTestOffer(t, nil), SubscribeOptions{ClientID: "viewer-1"})
+	if err != nil {
+		t.Fatalf("Expected retry with the same client ID to replace the old session, got %v", err)
+	}
+	if info := room.stats(); info.Subscribers != 1 {
+		t.Errorf("Expected a single subscriber after dedup, got %d", info.Subscribers)
+	}
+	if err := mgr.CloseSession(first.ID); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected the replaced session to be gone, got %v", err)
+	}
+	if _, err := room.SubscribeWith(ctx, newTestOffer(t, nil), SubscribeOptions{}); !errors.Is(err, ErrRoomFull) {
+		t.Errorf("Expected subscribers without client ID to keep counting, got %v", err)
+	}
+	// 协商失败的重试不影响仍在播放的旧连接
+	if _, err := room.SubscribeWith(ctx, "not sdp", SubscribeOptions{ClientID: "viewer-1"}); err == nil {
+		t.Fatal("Expected malformed offer to fail")
+	}
+	if info := room.stats(); info.Subscribers != 1 {
+		t.Errorf("Expected the existing session to survive a failed retry, got %d subscribers", info.Subscribers)
+	}
+	if err := mgr.CloseSession(second.ID); err != nil {
+		t.Errorf("Expected the new session to be active, got %v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package sfu
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"live-webrtc-go/internal/metrics"
+	"live-webrtc-go/internal/uploader"
+)
+
+// RoomState 描述可跨优雅重启保留的房间信息。媒体会话本身无法保留，
+// 重启后只恢复房间、预置的 Token/元数据，以及中断时仍在进行的录制标记。
+type RoomState struct {
+	Name        string            `json:"name"`
+	Provisioned bool              `json:"provisioned,omitempty"` // 是否由管理接口预置
+	Token       string            `json:"token,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Recordings  []string          `json:"recordings,omitempty"` // 进行中的录制文件路径
+}
+
+// ProvisionRoom 创建（或更新）房间并设置 Token 与元数据，结果会写入状态文件。
+func (m *Manager) ProvisionRoom(name, token string, meta map[string]string) RoomState {
+	r := m.getOrCreateRoom(name)
+	r.mu.Lock()
+	r.provisioned = true
+	r.token = token
+	r.meta = meta
+	r.mu.Unlock()
+	m.persist()
+	return r.state()
+}
+
+// RoomToken 返回管理接口为房间预置的 Token。
+func (m *Manager) RoomToken(name string) (string, bool) {
+	m.mu.RLock()
+	r, ok := m.rooms[name]
+	m.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.token, r.token != ""
+}
+
+// IsProvisioned 报告房间是否已通过管理接口预置。
+func (m *Manager) IsProvisioned(name string) bool {
+	m.mu.RLock()
+	r, ok := m.rooms[name]
+	m.mu.RUnlock()
+	if !ok {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.provisioned
+}
+
+// state 生成房间的可持久化快照。
+func (r *Room) state() RoomState {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	st := RoomState{Name: r.name, Provisioned: r.provisioned, Token: r.token, Metadata: r.meta}
+	for _, f := range r.trackFeeds {
+		f.mu.RLock()
+		if f.recPath != "" {
+			st.Recordings = append(st.Recordings, f.recPath)
+		}
+		f.mu.RUnlock()
+	}
+	sort.Strings(st.Recordings)
+	return st
+}
+
+// persist 在房间状态变化时写入状态文件；未配置 ROOM_STATE_FILE 时不做任何事。
+func (m *Manager) persist() {
+	if m == nil || m.cfg == nil || m.cfg.RoomStateFile == "" {
+		return
+	}
+	if err := m.saveState(); err != nil {
+		log.Printf("sfu: save room state: %v", err)
+	}
+}
+
+// saveState 先写临时文件再原子替换，避免进程中途退出留下半截 JSON。
+// 快照在持有 stateMu 时生成，保证并发调用时后写入的总是较新的状态。
+func (m *Manager) saveState() error {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+
+	m.mu.RLock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.mu.RUnlock()
+	states := make([]RoomState, 0, len(rooms))
+	for _, r := range rooms {
+		states = append(states, r.state())
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Name < states[j].Name })
+
+	data, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := m.cfg.RoomStateFile
+	if dir := filepath.Dir(path); dir != "" {
+		_ = os.MkdirAll(dir, 0o755)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadState 从状态文件恢复房间。上次退出时仍在进行的录制不会再被正常关闭，
+// 因此对仍存在于磁盘上的文件补一次上传。
+func (m *Manager) loadState() error {
+	data, err := os.ReadFile(m.cfg.RoomStateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var states []RoomState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	for _, st := range states {
+		if st.Name == "" {
+			continue
+		}
+		r := NewRoom(st.Name, m)
+		r.provisioned = st.Provisioned
+		r.token = st.Token
+		r.meta = st.Metadata
+		m.rooms[st.Name] = r
+		for _, p := range st.Recordings {
+			if _, err := os.Stat(p); err == nil {
+				uploader.Enqueue(st.Name, p)
+			}
+		}
+	}
+	metrics.SetRooms(float64(len(m.rooms)))
+	m.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,58 @@
+package sfu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"live-webrtc-go/internal/config"
+)
+
+func TestManager_RoomStatePersistence(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.RoomStateFile = filepath.Join(t.TempDir(), "state", "rooms.json")
+
+	mgr.ProvisionRoom("lecture", "secret", map[string]string{"title": "Go 101"})
+	mgr.getOrCreateRoom("lobby")
+
+	if _, err := os.Stat(cfg.RoomStateFile); err != nil {
+		t.Fatalf("Expected state file to be written: %v", err)
+	}
+
+	// 模拟重启：新的 Manager 读取同一状态文件
+	restored := NewManager(cfg)
+	if n := len(restored.ListRooms()); n != 2 {
+		t.Fatalf("Expected 2 restored rooms, got %d", n)
+	}
+	if tok, ok := restored.RoomToken("lecture"); !ok || tok != "secret" {
+		t.Errorf("Expected restored token 'secret', got %q", tok)
+	}
+	st := restored.rooms["lecture"].state()
+	if st.Metadata["title"] != "Go 101" {
+		t.Errorf("Expected restored metadata, got %v", st.Metadata)
+	}
+
+	// 管理员关闭房间后不再恢复
+	restored.CloseRoom("lobby")
+	again := NewManager(cfg)
+	if n := len(again.ListRooms()); n != 1 {
+		t.Errorf("Expected 1 room after close, got %d", n)
+	}
+}
+
+func TestRoom_RecordFormatOverride(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.RecordFormat = config.RecordFormatSeparate
+
+	mgr.ProvisionRoom("podcast", "", map[string]string{MetaRecordFormat: config.RecordFormatAudio})
+	if f := mgr.getOrCreateRoom("podcast").recordFormat(); f != config.RecordFormatAudio {
+		t.Errorf("Expected per-room audio format, got %q", f)
+	}
+	mgr.ProvisionRoom("bogus", "", map[string]string{MetaRecordFormat: "mkv"})
+	if f := mgr.getOrCreateRoom("bogus").recordFormat(); f != config.RecordFormatSeparate {
+		t.Errorf("Expected fallback to global format for invalid override, got %q", f)
+	}
+	if f := mgr.getOrCreateRoom("lecture").recordFormat(); f != config.RecordFormatSeparate {
+		t.Errorf("Expected global format without override, got %q", f)
+	}
+}
@@ -0,0 +1,29 @@
+package sfu
+
+// ServerStats 是全部房间的汇总统计，供 GET /api/stats 使用。
+type ServerStats struct {
+	Rooms       int    `json:"rooms"`
+	Subscribers int    `json:"subscribers"`
+	Publishers  int    `json:"publishers"`
+	RTPBytes    uint64 `json:"rtpBytes"`   // 进程启动以来接收的 RTP 字节数，含已结束的轨道，只增不减
+	RTPPackets  uint64 `json:"rtpPackets"` // 进程启动以来接收的 RTP 包数，含已结束的轨道，只增不减
+}
+
+// Stats 汇总全部房间的订阅者、发布者与入站 RTP 计数。只在各房间读锁下读取计数，
+// RTP 计数取 Manager 级原子量，不触及转发路径上的锁，可被频繁调用。
+func (m *Manager) Stats() ServerStats {
+	m.mu.RLock()
+	rooms := make([]*Room, 0, len(m.rooms))
+	for _, r := range m.rooms {
+		rooms = append(rooms, r)
+	}
+	m.mu.RUnlock()
+	st := ServerStats{Rooms: len(rooms), RTPBytes: m.rxBytes.Load(), RTPPackets: m.rxPkts.Load()}
+	for _, r := range rooms {
+		r.mu.RLock()
+		st.Subscribers += len(r.subs)
+		st.Publishers += len(r.publishers)
+		r.mu.RUnlock()
+	}
+	return st
+}
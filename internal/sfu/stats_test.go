@@ -0,0 +1,37 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestManager_Stats(t *testing.T) {
+	mgr, _ := setupTestManager()
+	defer mgr.CloseAll()
+
+	if st := mgr.Stats(); st != (ServerStats{}) {
+		t.Fatalf("Expected empty stats, got %+v", st)
+	}
+
+	a := mgr.getOrCreateRoom("a")
+	mgr.getOrCreateRoom("b")
+	mgr.rxBytes.Add(3000)
+	mgr.rxPkts.Add(30)
+	a.mu.Lock()
+	a.publishers["p1"] = &publisherSession{id: "p1"}
+	a.subs[&webrtc.PeerConnection{}] = &subscriber{id: "s1"}
+	a.subs[&webrtc.PeerConnection{}] = &subscriber{id: "s2"}
+	a.mu.Unlock()
+
+	st := mgr.Stats()
+	want := ServerStats{Rooms: 2, Subscribers: 2, Publishers: 1, RTPBytes: 3000, RTPPackets: 30}
+	if st != want {
+		t.Errorf("Expected %+v, got %+v", want, st)
+	}
+
+	a.mu.Lock()
+	a.publishers = make(map[string]*publisherSession) // 零值连接不可 Close，关闭房间前移除
+	a.subs = make(map[*webrtc.PeerConnection]*subscriber)
+	a.mu.Unlock()
+}
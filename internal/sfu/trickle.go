@@ -0,0 +1,143 @@
+package sfu
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// localCandidates 缓存一个 PeerConnection 已收集到的本地 ICE 候选。开启 TRICKLE_ICE 时 Answer
+// 不再等待收集完成，之后收集到的候选经 PATCH 会话资源的响应交给客户端。
+type localCandidates struct {
+	mu      sync.Mutex
+	lines   []string     // a=candidate 行
+	relayed bool         // 是否收集到 relay 候选
+	done    bool         // 收集是否已结束
+	drop    []*net.IPNet // DROP_CANDIDATE_CIDRS：不交给客户端的 host 候选网段
+}
+
+// add 记录一个候选；c 为 nil 表示收集结束，此时返回 done=true（仅一次）及是否拿到过 relay 候选。
+func (l *localCandidates) add(c *webrtc.ICECandidate) (relayed, done bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c == nil {
+		if l.done {
+			return false, false
+		}
+		l.done = true
+		return l.relayed, true
+	}
+	if c.Typ == webrtc.ICECandidateTypeRelay {
+		l.relayed = true
+	}
+	if cand := c.ToJSON().Candidate; !droppedHostCandidate(cand, l.drop) {
+		l.lines = append(l.lines, "a="+cand)
+	}
+	return false, false
+}
+
+// sdpFrag 以 application/trickle-ice-sdpfrag 格式输出当前全部本地候选；收集结束时追加 a=end-of-candidates。
+func (l *localCandidates) sdpFrag(pc *webrtc.PeerConnection) string {
+	var b strings.Builder
+	if desc := pc.LocalDescription(); desc != nil {
+		for _, line := range strings.Split(desc.SDP, "\n") {
+			line = strings.TrimRight(line, "\r")
+			if strings.HasPrefix(line, "a=ice-ufrag:") || strings.HasPrefix(line, "a=ice-pwd:") {
+				b.WriteString(line + "\r\n")
+			}
+			if strings.HasPrefix(line, "m=") && b.Len() > 0 {
+				break // 会话内各 m 段共用同一组凭据（BUNDLE），取第一组即可
+			}
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		b.WriteString(line + "\r\n")
+	}
+	if l.done {
+		b.WriteString("a=end-of-candidates\r\n")
+	}
+	return b.String()
+}
+
+// addRemoteCandidates 把 sdpfrag 中的 a=candidate 行交给 pc；候选归属于其前最近的 a=mid，没有时归属第一个 m 段。
+func addRemoteCandidates(pc *webrtc.PeerConnection, frag string) error {
+	var mid string
+	for _, line := range strings.Split(frag, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			mid = strings.TrimPrefix(line, "a=mid:")
+		case strings.HasPrefix(line, "a=candidate:"):
+			init := webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a=")}
+			if mid != "" {
+				m := mid
+				init.SDPMid = &m
+			} else {
+				var idx uint16
+				init.SDPMLineIndex = &idx
+			}
+			if err := pc.AddICECandidate(init); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// TrickleICE 向会话 id 的连接追加客户端的 ICE 候选（sdpfrag），并返回服务端目前收集到的本地候选；
+// frag 为空时仅用于轮询本地候选。会话不存在时返回 ErrSessionNotFound。
+func (m *Manager) TrickleICE(id, frag string) (string, error) {
+	m.sessMu.Lock()
+	r := m.sessions[id]
+	m.sessMu.Unlock()
+	if r == nil {
+		return "", ErrSessionNotFound
+	}
+	return r.trickleICE(id, frag)
+}
+
+func (r *Room) trickleICE(id, frag string) (string, error) {
+	r.mu.RLock()
+	var pc *webrtc.PeerConnection
+	var cands *localCandidates
+	if p := r.publishers[id]; p != nil {
+		pc, cands = p.pc, p.ice
+	} else {
+		for p, s := range r.subs {
+			if s.id == id {
+				pc, cands = p, s.ice
+				break
+			}
+		}
+	}
+	r.mu.RUnlock()
+	if pc == nil {
+		return "", ErrSessionNotFound
+	}
+	if err := addRemoteCandidates(pc, frag); err != nil {
+		return "", err
+	}
+	if cands == nil {
+		return "", nil
+	}
+	return cands.sdpFrag(pc), nil
+}
+
+// awaitGathering 在未开启 TRICKLE_ICE 时等待本地候选收集完成，使 Answer 携带全部候选；
+// 开启时立即返回，Answer 只包含已收集到的候选。g 须在 SetLocalDescription 之前创建。
+func (r *Room) awaitGathering(ctx context.Context, g <-chan struct{}) error {
+	if r.mgr != nil && r.mgr.cfg != nil && r.mgr.cfg.TrickleICE {
+		return nil
+	}
+	select {
+	case <-g:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
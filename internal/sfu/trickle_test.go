@@ -0,0 +1,41 @@
+package sfu
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManager_TrickleICE(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.STUN = nil
+	cfg.TrickleICE = true
+	defer mgr.CloseAll()
+
+	_, pubID, err := mgr.PublishWithID(context.Background(), "trickle-room", newTestOffer(t, nil))
+	if err != nil {
+		t.Fatalf("Expected publish to succeed, got %v", err)
+	}
+	frag := "a=mid:0\r\na=candidate:1 1 udp 2130706431 127.0.0.1 40000 typ host\r\na=end-of-candidates\r\n"
+	var local string
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(local, "a=end-of-candidates") && time.Now().Before(deadline) {
+		if local, err = mgr.TrickleICE(pubID, frag); err != nil {
+			t.Fatalf("Expected candidates to be accepted, got %v", err)
+		}
+		frag = "" // 之后只轮询服务端候选
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(local, "a=ice-ufrag:") || !strings.Contains(local, "a=candidate:") || !strings.Contains(local, "a=end-of-candidates") {
+		t.Errorf("Expected credentials, candidates and end-of-candidates, got %q", local)
+	}
+
+	if _, err := mgr.TrickleICE(pubID, "a=candidate:garbage"); err == nil {
+		t.Error("Expected malformed candidate to be rejected")
+	}
+	if _, err := mgr.TrickleICE("unknown", ""); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("Expected ErrSessionNotFound, got %v", err)
+	}
+}
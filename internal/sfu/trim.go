@@ -0,0 +1,108 @@
+package sfu
+
+import (
+	"sync"
+
+	"github.com/pion/rtp"
+)
+
+// opusSilenceBytes 是视为静音的 Opus 负载长度上限：DTX 与静音帧通常只有几个字节，
+// 有实际声音的 20ms 帧一般在数十字节以上。这只是按长度的近似判断：极低码率下的
+// 轻声可能被当作静音而略晚开始，编码器不开 DTX 时底噪帧也可能提前触发录制。
+const opusSilenceBytes = 10
+
+// pendingBufferPackets 是创建录制文件期间最多暂存的包数，足以容纳一个高清关键帧；超出部分丢弃。
+const pendingBufferPackets = 512
+
+// pendingRecording 是 RECORD_TRIM_START 下等待内容开始的录制：收到首个关键帧（视频）
+// 或首个非静音包（音频）时在后台调用 start 创建录制文件，期间到达的包暂存后补写，
+// 避免文件创建与状态持久化阻塞 readLoop。
+type pendingRecording struct {
+	mime  string
+	start func()
+
+	mu       sync.Mutex
+	starting bool
+	buf      []*rtp.Packet
+	done     chan struct{} // 录制创建并补写暂存包后关闭
+}
+
+// deferRecording 推迟录制的创建，直到 startsContent 判定轨道出现实际内容。
+func (f *trackFanout) deferRecording(mime string, start func()) {
+	f.mu.Lock()
+	f.pendingRec = &pendingRecording{mime: mime, start: start, done: make(chan struct{})}
+	f.mu.Unlock()
+}
+
+// startsContent 报告 pkt 是否为实际内容的开始：视频为关键帧的首个包，音频为非静音包。
+func (p *pendingRecording) startsContent(video bool, pkt *rtp.Packet) bool {
+	if video {
+		return isKeyframeStart(p.mime, pkt.Payload)
+	}
+	return len(pkt.Payload) > opusSilenceBytes
+}
+
+// deferPacket 处理推迟录制期间的包：内容开始前丢弃，开始后在后台创建录制并暂存包，
+// 返回 true 表示 pkt 已被处理。录制已建立（暂存包补写完毕）时返回 false，调用方改为直接写入录制。
+func (f *trackFanout) deferPacket(p *pendingRecording, pkt *rtp.Packet) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	select {
+	case <-p.done:
+		return false
+	default:
+	}
+	if !p.starting {
+		if !p.startsContent(f.video, pkt) {
+			return true
+		}
+		p.starting = true
+		go f.startPending(p)
+	}
+	if len(p.buf) < pendingBufferPackets {
+		clone := *pkt
+		clone.Payload = append([]byte(nil), pkt.Payload...)
+		p.buf = append(p.buf, &clone)
+	}
+	return true
+}
+
+// startPending 创建推迟的录制并补写暂存的包；fanout 已关闭或录制已被撤销时不创建。
+// 创建期间 fanout 被关闭时，由这里结束刚创建的录制。
+func (f *trackFanout) startPending(p *pendingRecording) {
+	f.mu.RLock()
+	current := f.pendingRec == p
+	f.mu.RUnlock()
+	if current {
+		p.start()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.mu.Lock()
+	if f.pendingRec == p {
+		f.pendingRec = nil
+	}
+	select {
+	case <-f.closed:
+		f.stopRecordingLocked()
+	default:
+	}
+	f.mu.Unlock()
+	for _, pkt := range p.buf {
+		rec, seg := f.recorder()
+		if rec == nil {
+			break
+		}
+		f.record(rec, seg, pkt, pkt.MarshalSize())
+	}
+	p.buf = nil
+	close(p.done)
+}
+
+// recorder 返回当前的录制写入器与分段状态。
+func (f *trackFanout) recorder() (rtpWriter, *segmenter) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.rec, f.seg
+}
@@ -0,0 +1,76 @@
+package sfu
+
+import (
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestTrackFanout_TrimStart(t *testing.T) {
+	marshal := func(payload []byte) []byte {
+		raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1}, Payload: payload}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+	cases := []struct {
+		name          string
+		video         bool
+		mime          string
+		skip, content []byte
+	}{
+		{"audio", false, webrtc.MimeTypeOpus, []byte{0xF8, 0xFF, 0xFE}, make([]byte, 60)},
+		{"video", true, webrtc.MimeTypeVP8, append([]byte{0x10, 0x01}, make([]byte, 100)...), append([]byte{0x10, 0x00}, make([]byte, 100)...)},
+	}
+	for _, c := range cases {
+		f := newTrackFanout(nil, "room")
+		f.video = c.video
+		w := &fakeRecorder{}
+		starts := 0
+		release := make(chan struct{})
+		f.deferRecording(c.mime, func() {
+			<-release // 模拟较慢的文件创建：期间到达的包应被暂存而不是阻塞 readLoop
+			starts++
+			f.setRecorder(w, "", false)
+		})
+		pending := f.pendingRec
+		var scratch rtp.Packet
+		f.forward(marshal(c.skip), &scratch)
+		f.forward(marshal(c.skip), &scratch)
+		f.forward(marshal(c.content), &scratch)
+		f.forward(marshal(c.skip), &scratch)
+		close(release)
+		<-pending.done
+		f.forward(marshal(c.skip), &scratch)
+		if starts != 1 || w.packets != 3 {
+			t.Errorf("%s: expected recording to start at the first content packet, got %d starts, %d packets", c.name, starts, w.packets)
+		}
+	}
+
+	f := newTrackFanout(nil, "room")
+	f.deferRecording(webrtc.MimeTypeOpus, func() { t.Error("Expected no recording after close") })
+	f.close()
+	var scratch rtp.Packet
+	f.forward(marshal(make([]byte, 60)), &scratch)
+
+	// 创建录制期间 fanout 被关闭：刚创建的录制应被结束，而不是遗留打开的文件
+	f = newTrackFanout(nil, "room")
+	w := &fakeRecorder{}
+	entered, release := make(chan struct{}), make(chan struct{})
+	f.deferRecording(webrtc.MimeTypeOpus, func() {
+		close(entered)
+		<-release
+		f.setRecorder(w, "", false)
+	})
+	pending := f.pendingRec
+	f.forward(marshal(make([]byte, 60)), &scratch)
+	<-entered
+	f.close()
+	close(release)
+	<-pending.done
+	if !w.closed || w.packets != 0 {
+		t.Errorf("Expected the recording created after close to be closed unwritten, got closed=%v packets=%d", w.closed, w.packets)
+	}
+}
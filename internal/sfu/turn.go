@@ -0,0 +1,101 @@
+package sfu
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+
+	"live-webrtc-go/internal/metrics"
+)
+
+// turnPool 维护 TURN 主服务器组（TURN_URLS）与备用服务器（TURN_FALLBACK_URLS，每个 URL 一组），
+// 新连接只使用当前组。ICE 收集结束时按是否拿到 relay 候选统计该组的分配成败；
+// 分配失败则切换到下一组，备用组同样失败时再轮回主服务器组，以便其恢复后重新启用。
+type turnPool struct {
+	groups [][]string
+	active atomic.Int32
+}
+
+func newTurnPool(primary, fallback []string) *turnPool {
+	p := &turnPool{}
+	if len(primary) > 0 {
+		p.groups = append(p.groups, primary)
+	}
+	for _, u := range fallback {
+		p.groups = append(p.groups, []string{u})
+	}
+	return p
+}
+
+// current 返回当前使用的服务器组下标及其 URL；未配置 TURN 时下标为 -1。
+func (p *turnPool) current() (int, []string) {
+	if len(p.groups) == 0 {
+		return -1, nil
+	}
+	i := int(p.active.Load())
+	return i, p.groups[i]
+}
+
+// report 记录一次分配结果；失败且该组仍为当前组时切换到下一组。
+func (p *turnPool) report(i int, ok bool) {
+	server := p.groups[i][0]
+	if ok {
+		metrics.IncTURNAllocation(server, "success")
+		return
+	}
+	metrics.IncTURNAllocation(server, "failure")
+	if len(p.groups) < 2 {
+		return
+	}
+	next := (i + 1) % len(p.groups)
+	if p.active.CompareAndSwap(int32(i), int32(next)) {
+		log.Printf("sfu: TURN allocation via %s failed, switching to %s", server, p.groups[next][0])
+	}
+}
+
+// TURNCredentials 按 coturn REST API 约定（use-auth-secret）生成临时 TURN 凭据：
+// username 为 "过期时间戳:user"（user 为空时只有时间戳），credential 为以 secret 为密钥对
+// username 做 HMAC-SHA1 后的 Base64。凭据在 now+ttl 之后失效。
+func TURNCredentials(secret, user string, ttl time.Duration, now time.Time) (username, credential string) {
+	username = strconv.FormatInt(now.Add(ttl).Unix(), 10)
+	if user != "" {
+		username += ":" + user
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// turn 按需创建 TURN 服务器池；测试中常在 NewManager 之后才修改配置，故延迟到首次使用时读取。
+func (m *Manager) turn() *turnPool {
+	m.turnOnce.Do(func() {
+		m.turnPool = newTurnPool(m.cfg.TURN, m.cfg.TURNFallback)
+	})
+	return m.turnPool
+}
+
+// newPeerConnection 使用 iceConfig 创建 PeerConnection，并缓存其本地候选（供 trickle ICE 使用）；
+// 配置了 TURN 时，收集结束后按是否拿到 relay 候选统计本次分配结果。
+func (r *Room) newPeerConnection(api *webrtc.API) (*webrtc.PeerConnection, *localCandidates, error) {
+	cfg, turnGroup := r.iceConfig()
+	pc, err := api.NewPeerConnection(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	cands := &localCandidates{}
+	if r.mgr != nil && r.mgr.cfg != nil {
+		cands.drop = r.mgr.cfg.DropCandidateCIDRs
+	}
+	pc.OnICECandidate(func(c *webrtc.ICECandidate) {
+		if relayed, done := cands.add(c); done && turnGroup >= 0 {
+			r.mgr.turn().report(turnGroup, relayed)
+		}
+	})
+	return pc, cands, nil
+}
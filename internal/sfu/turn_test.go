@@ -0,0 +1,87 @@
+package sfu
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"live-webrtc-go/internal/metrics"
+)
+
+func TestTurnPool_FallbackRotation(t *testing.T) {
+	p := newTurnPool([]string{"turn:primary.test:3478", "turn:primary.test:3478?transport=tcp"}, []string{"turn:backup.test:3478"})
+	if i, urls := p.current(); i != 0 || len(urls) != 2 {
+		t.Fatalf("Expected primary group first, got %d %v", i, urls)
+	}
+	fail := metrics.TURNAllocations.WithLabelValues("turn:primary.test:3478", "failure")
+	before := testutil.ToFloat64(fail)
+
+	p.report(0, true)
+	if i, _ := p.current(); i != 0 {
+		t.Fatalf("Expected success to keep primary, got group %d", i)
+	}
+	p.report(0, false)
+	p.report(0, false) // 已切换后迟到的失败不应再次切换
+	if i, urls := p.current(); i != 1 || urls[0] != "turn:backup.test:3478" {
+		t.Fatalf("Expected fallback after failure, got %d %v", i, urls)
+	}
+	if got := testutil.ToFloat64(fail) - before; got != 2 {
+		t.Errorf("Expected 2 failures counted, got %v", got)
+	}
+	p.report(1, false)
+	if i, _ := p.current(); i != 0 {
+		t.Errorf("Expected rotation back to primary, got group %d", i)
+	}
+
+	if i, _ := newTurnPool(nil, nil).current(); i != -1 {
+		t.Errorf("Expected -1 without TURN, got %d", i)
+	}
+}
+
+func TestRoom_Publish_CountsTURNFailure(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	// pion 仅以 IPv4 连接 TURN 并兼作 STUN 探测，IPv6 回环地址会让 relay 分配立即失败、不必等待超时
+	cfg.TURN = []string{"turn:[::1]:1?transport=tcp"}
+	cfg.TURNFallback = []string{"turn:[::1]:2?transport=tcp"}
+	cfg.TURNUsername, cfg.TURNPassword = "user", "pass"
+	defer mgr.CloseAll()
+
+	fail := metrics.TURNAllocations.WithLabelValues(cfg.TURN[0], "failure")
+	before := testutil.ToFloat64(fail)
+	if _, err := mgr.Publish(context.Background(), "turn-room", newTestOffer(t, nil)); err != nil {
+		t.Fatalf("Expected publish to succeed without relay, got %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(fail) == before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := testutil.ToFloat64(fail) - before; got != 1 {
+		t.Fatalf("Expected one TURN failure counted, got %v", got)
+	}
+	if i, _ := mgr.turn().current(); i != 1 {
+		t.Errorf("Expected manager to switch to fallback TURN, got group %d", i)
+	}
+}
+
+func TestTURNCredentials(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	user, cred := TURNCredentials("north", "alice", 24*time.Hour, now)
+	if user != "1700086400:alice" || cred != "SXua5ne/+mDhiHTp0pQJzRO4ESg=" {
+		t.Errorf("Unexpected credentials %q / %q", user, cred)
+	}
+	if user, _ := TURNCredentials("north", "", time.Hour, now); user != "1700003600" {
+		t.Errorf("Expected bare expiry timestamp without user, got %q", user)
+	}
+
+	mgr, cfg := setupTestManager()
+	cfg.TURN = []string{"turn:turn.test:3478"}
+	cfg.TURNStaticSecret, cfg.TURNCredentialTTL = "north", time.Hour
+	ice, _ := NewRoom("turn-cred", mgr).iceConfig()
+	s := ice.ICEServers[len(ice.ICEServers)-1]
+	if !strings.HasSuffix(s.Username, ":sfu") || s.Credential == "" {
+		t.Errorf("Expected server to use ephemeral TURN credentials, got %+v", s)
+	}
+}
@@ -0,0 +1,103 @@
+package sfu
+
+import (
+	"errors"
+	"math"
+)
+
+// ErrQueueFull 表示房间的等候队列已达 WAIT_QUEUE_SIZE 上限。
+var ErrQueueFull = errors.New("waiting room is full")
+
+// Waiter 是房间满员时排队等待空位的观众。
+type Waiter struct {
+	Position int // 入队时的排队位置（从 1 开始），无需排队时为 0
+	ready    chan struct{}
+	room     *Room
+}
+
+// Ready 在出现空位或房间关闭时关闭，观众随后应重新发起 WHEP 请求。
+// 空位并不为该观众保留，重试时仍可能因满员失败。
+func (w *Waiter) Ready() <-chan struct{} { return w.ready }
+
+// Leave 退出等候队列；已被通知或无需排队时不做任何事。
+func (w *Waiter) Leave() {
+	if w.room == nil {
+		return
+	}
+	r := w.room
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, x := range r.waiters {
+		if x == w {
+			r.waiters = append(r.waiters[:i], r.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// JoinQueue 在房间订阅者达到 MAX_SUBS_PER_ROOM 时加入等候队列，空位按先来后到依次通知。
+// 房间不存在、未设上限或仍有空位时返回立即就绪的 Waiter；队列已满时返回 ErrQueueFull。
+func (m *Manager) JoinQueue(name string) (*Waiter, error) {
+	w := &Waiter{ready: make(chan struct{})}
+	m.mu.RLock()
+	r, ok := m.rooms[name]
+	m.mu.RUnlock()
+	if !ok || m.cfg == nil || m.cfg.MaxSubsPerRoom <= 0 {
+		close(w.ready)
+		return w, nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.freeSlotsLocked() > len(r.waiters) {
+		close(w.ready)
+		return w, nil
+	}
+	if len(r.waiters) >= m.cfg.WaitQueueSize {
+		return nil, ErrQueueFull
+	}
+	w.room = r
+	r.waiters = append(r.waiters, w)
+	w.Position = len(r.waiters)
+	return w, nil
+}
+
+// releaseWaitersLocked 按当前空位数通知队首的等候者，调用方需持有 r.mu。
+func (r *Room) releaseWaitersLocked() {
+	if len(r.waiters) == 0 || r.mgr == nil || r.mgr.cfg == nil {
+		return
+	}
+	free := len(r.waiters)
+	if r.mgr.cfg.MaxSubsPerRoom > 0 {
+		free = r.freeSlotsLocked()
+	}
+	for ; free > 0 && len(r.waiters) > 0; free-- {
+		close(r.waiters[0].ready)
+		r.waiters = r.waiters[1:]
+	}
+}
+
+// subscriberWeight 返回订阅者占用的容量：接收视频的订阅者为 1，纯音频订阅者为 AUDIO_ONLY_SUB_WEIGHT。
+func (r *Room) subscriberWeight(offerSDP string) float64 {
+	if r.mgr == nil || r.mgr.cfg == nil || r.mgr.cfg.AudioOnlySubWeight <= 0 || offerReceivesVideo(offerSDP) {
+		return 1
+	}
+	return r.mgr.cfg.AudioOnlySubWeight
+}
+
+// hasCapacityLocked 判断加入权重为 weight 的订阅者后是否仍不超过 MAX_SUBS_PER_ROOM，调用方需持有 r.mu。
+func (r *Room) hasCapacityLocked(weight float64) bool {
+	return r.subLoad+weight <= float64(r.mgr.cfg.MaxSubsPerRoom)+1e-9
+}
+
+// freeSlotsLocked 返回按完整订阅者（权重 1）计的剩余空位数，调用方需持有 r.mu。
+func (r *Room) freeSlotsLocked() int {
+	return int(math.Floor(float64(r.mgr.cfg.MaxSubsPerRoom) - r.subLoad + 1e-9))
+}
+
+// releaseAllWaitersLocked 在房间关闭时通知全部等候者，调用方需持有 r.mu。
+func (r *Room) releaseAllWaitersLocked() {
+	for _, w := range r.waiters {
+		close(w.ready)
+	}
+	r.waiters = nil
+}
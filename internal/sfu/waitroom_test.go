@@ -0,0 +1,95 @@
+package sfu
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestManager_JoinQueue(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.MaxSubsPerRoom = 1
+	cfg.WaitQueueSize = 1
+	defer mgr.CloseAll()
+
+	w, err := mgr.JoinQueue("missing")
+	if err != nil || w.Position != 0 {
+		t.Fatalf("Expected missing room not to queue, got %+v, %v", w, err)
+	}
+	select {
+	case <-w.Ready():
+	default:
+		t.Error("Expected waiter for missing room to be ready")
+	}
+
+	room := mgr.getOrCreateRoom("full")
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatalf("new peer connection: %v", err)
+	}
+	room.mu.Lock()
+	room.subs[pc] = &subscriber{id: "s1", weight: 1}
+	room.subLoad = 1
+	room.mu.Unlock()
+
+	w, err = mgr.JoinQueue("full")
+	if err != nil || w.Position != 1 {
+		t.Fatalf("Expected to be queued at position 1, got %+v, %v", w, err)
+	}
+	if _, err := mgr.JoinQueue("full"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	select {
+	case <-w.Ready():
+		t.Fatal("Expected waiter not to be ready while room is full")
+	default:
+	}
+
+	room.removeSubscriber(pc)
+	select {
+	case <-w.Ready():
+	default:
+		t.Error("Expected waiter to be notified when a slot frees up")
+	}
+	w.Leave()
+}
+
+func TestRoom_WeightedCapacity(t *testing.T) {
+	mgr, cfg := setupTestManager()
+	cfg.MaxSubsPerRoom = 1
+	cfg.AudioOnlySubWeight = 0.25
+	defer mgr.CloseAll()
+
+	audioOnly := "v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=recvonly\r\nm=video 0 UDP/TLS/RTP/SAVPF 96\r\n"
+	full := "v=0\r\nm=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=recvonly\r\nm=video 9 UDP/TLS/RTP/SAVPF 96\r\na=recvonly\r\n"
+	room := mgr.getOrCreateRoom("weighted")
+	if w := room.subscriberWeight(audioOnly); w != 0.25 {
+		t.Errorf("Expected audio-only weight 0.25, got %v", w)
+	}
+	if w := room.subscriberWeight(full); w != 1 {
+		t.Errorf("Expected full weight 1, got %v", w)
+	}
+
+	room.mu.Lock()
+	for i := 0; i < 4; i++ {
+		if !room.hasCapacityLocked(0.25) {
+			t.Fatalf("Expected room for audio-only subscriber %d", i+1)
+		}
+		room.subLoad += 0.25
+	}
+	if room.hasCapacityLocked(0.25) {
+		t.Error("Expected weighted cap to reject a fifth audio-only subscriber")
+	}
+	room.subLoad = 0.5
+	if room.hasCapacityLocked(1) || !room.hasCapacityLocked(0.25) {
+		t.Error("Expected full subscriber rejected and audio-only admitted at load 0.5")
+	}
+	if room.freeSlotsLocked() != 0 {
+		t.Errorf("Expected no full slots at load 0.5, got %d", room.freeSlotsLocked())
+	}
+	room.mu.Unlock()
+	if info := room.stats(); info.WeightedSubscribers != 0.5 {
+		t.Errorf("Expected weighted subscribers 0.5 in stats, got %v", info.WeightedSubscribers)
+	}
+}
@@ -0,0 +1,66 @@
+package sfu
+
+import (
+	"log"
+	"time"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// markRead 记录一次成功读取，并清除卡顿标记。
+func (f *trackFanout) markRead(now time.Time) {
+	f.lastRead.Store(now.UnixNano())
+	f.stalled.Store(false)
+}
+
+// idleFor 返回距上次成功读取 RTP 的时长。
+func (f *trackFanout) idleFor(now time.Time) time.Duration {
+	return now.Sub(time.Unix(0, f.lastRead.Load()))
+}
+
+// minStallCheck 是卡顿检测的最短检查间隔，避免过小的 TRACK_STALL_TIMEOUT 让检查空转。
+const minStallCheck = 100 * time.Millisecond
+
+// stallCheckInterval 返回卡顿检测的检查间隔：timeout 的一半，但不小于 minStallCheck。
+func stallCheckInterval(timeout time.Duration) time.Duration {
+	return max(timeout/2, minStallCheck)
+}
+
+// watchStall 监控单个 fanout 的读取活性：remote.Read 长时间既无数据也不报错时，
+// 流看似在线实则冻结。超过 timeout 后标记为卡顿、记录日志并发送 PLI；
+// closePub 为 true 时直接关闭发布者，让客户端重新推流。
+func (r *Room) watchStall(f *trackFanout, timeout time.Duration, closePub bool) {
+	ticker := time.NewTicker(stallCheckInterval(timeout))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-f.closed:
+			return
+		case now := <-ticker.C:
+			idle := f.idleFor(now)
+			if idle < timeout || f.stalled.Swap(true) {
+				continue
+			}
+			trackID := ""
+			src := f.source()
+			if src != nil {
+				trackID = src.ID()
+			}
+			log.Printf("sfu: room %s track %s stalled, no RTP for %s", r.name, trackID, idle.Round(time.Second))
+			r.mu.RLock()
+			pub := r.publisherPCLocked(f.publisher)
+			r.mu.RUnlock()
+			if pub == nil {
+				continue
+			}
+			if src != nil && src.Kind() == webrtc.RTPCodecTypeVideo {
+				_ = pub.WriteRTCP([]rtcp.Packet{&rtcp.PictureLossIndication{MediaSSRC: uint32(src.SSRC())}})
+			}
+			if closePub {
+				go r.closePublisher(pub)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package sfu
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoom_WatchStall_MarksStalledTrack(t *testing.T) {
+	mgr, _ := setupTestManager()
+	room := mgr.getOrCreateRoom("stall-room")
+
+	feed := newTrackFanout(nil, room.name)
+	feed.lastRead.Store(time.Now().Add(-time.Minute).UnixNano())
+	room.mu.Lock()
+	room.trackFeeds["t1"] = feed
+	room.mu.Unlock()
+	defer feed.close()
+
+	go room.watchStall(feed, 20*time.Millisecond, false)
+
+	deadline := time.Now().Add(time.Second)
+	for room.stats().StalledTracks != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected track to be reported as stalled")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	feed.markRead(time.Now())
+	if n := room.stats().StalledTracks; n != 0 {
+		t.Errorf("Expected stall flag cleared after a successful read, got %d stalled", n)
+	}
+}
+
+func TestStallCheckInterval(t *testing.T) {
+	if got := stallCheckInterval(10 * time.Second); got != 5*time.Second {
+		t.Errorf("Expected half the timeout, got %s", got)
+	}
+	// 1ns 时 timeout/2 为 0，NewTicker 会 panic
+	if got := stallCheckInterval(time.Nanosecond); got != minStallCheck {
+		t.Errorf("Expected tiny timeouts clamped to %s, got %s", minStallCheck, got)
+	}
+}
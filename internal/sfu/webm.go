@@ -0,0 +1,434 @@
+package sfu
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/rtp"
+	"github.com/pion/rtp/codecs"
+	"github.com/pion/webrtc/v3"
+)
+
+// webmTrackWait 是 WebM 录制等待 Offer 中其余轨道到达的最长时间；超时后只封装已到达的轨道。
+const webmTrackWait = time.Second
+
+// EBML/Matroska 元素 ID（已含长度标记位）。
+const (
+	ebmlHeaderID      = 0x1A45DFA3
+	ebmlVersionID     = 0x4286
+	ebmlReadVersionID = 0x42F7
+	ebmlMaxIDLenID    = 0x42F2
+	ebmlMaxSizeLenID  = 0x42F3
+	ebmlDocTypeID     = 0x4282
+	ebmlDocTypeVerID  = 0x4287
+	ebmlDocTypeReadID = 0x4285
+	mkvSegmentID      = 0x18538067
+	mkvInfoID         = 0x1549A966
+	mkvTimecodeScale  = 0x2AD7B1
+	mkvMuxingAppID    = 0x4D80
+	mkvWritingAppID   = 0x5741
+	mkvTracksID       = 0x1654AE6B
+	mkvTrackEntryID   = 0xAE
+	mkvTrackNumberID  = 0xD7
+	mkvTrackUIDID     = 0x73C5
+	mkvTrackTypeID    = 0x83
+	mkvCodecIDID      = 0x86
+	mkvCodecPrivateID = 0x63A2
+	mkvVideoID        = 0xE0
+	mkvPixelWidthID   = 0xB0
+	mkvPixelHeightID  = 0xBA
+	mkvAudioID        = 0xE1
+	mkvSamplingFreqID = 0xB5
+	mkvChannelsID     = 0x9F
+	mkvClusterID      = 0x1F43B675
+	mkvTimecodeID     = 0xE7
+	mkvSimpleBlockID  = 0xA3
+)
+
+// ebmlUnknownSize 表示长度未知的主元素（Segment/Cluster），使文件可以流式写入、无需回填。
+var ebmlUnknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+func ebmlID(id uint32) []byte {
+	switch {
+	case id >= 1<<24:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 1<<16:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id >= 1<<8:
+		return []byte{byte(id >> 8), byte(id)}
+	}
+	return []byte{byte(id)}
+}
+
+// ebmlSize 以最短的 EBML 变长整数编码长度。
+func ebmlSize(n uint64) []byte {
+	for l := 1; l <= 8; l++ {
+		if n < 1<<(7*l)-1 {
+			b := make([]byte, l)
+			for i := l - 1; i >= 0; i-- {
+				b[i] = byte(n)
+				n >>= 8
+			}
+			b[0] |= 0x80 >> (l - 1)
+			return b
+		}
+	}
+	return ebmlUnknownSize
+}
+
+func ebmlElem(id uint32, data []byte) []byte {
+	out := append(ebmlID(id), ebmlSize(uint64(len(data)))...)
+	return append(out, data...)
+}
+
+func ebmlUint(id uint32, v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	i := 0
+	for i < 7 && b[i] == 0 {
+		i++
+	}
+	return ebmlElem(id, b[i:])
+}
+
+func ebmlFloat(id uint32, v float64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, math.Float64bits(v))
+	return ebmlElem(id, b)
+}
+
+func ebmlMaster(id uint32, children ...[]byte) []byte {
+	var data []byte
+	for _, c := range children {
+		data = append(data, c...)
+	}
+	return ebmlElem(id, data)
+}
+
+// opusHead 返回 WebM 中 A_OPUS 的 CodecPrivate（RFC 7845 的 ID 头）。
+func opusHead(channels uint8) []byte {
+	b := make([]byte, 19)
+	copy(b, "OpusHead")
+	b[8] = 1 // 版本
+	b[9] = channels
+	binary.LittleEndian.PutUint32(b[12:], 48000)
+	return b
+}
+
+// webmMuxer 把同一发布者的 Opus 音频与 VP8/VP9 视频封装进一个 WebM 文件。各轨道的 webmTrack
+// 共用该封装器；轨道头在 Offer 中的轨道全部到达（或等待 webmTrackWait）后写出，时间码以封装器
+// 创建时刻为零点，按各轨道首包到达的墙钟时间对齐。
+type webmMuxer struct {
+	mu       sync.Mutex
+	out      io.WriteCloser
+	start    time.Time
+	expected int // Offer 中预期的轨道数
+	tracks   []*webmTrack
+	header   bool  // 是否已写出文件头与 Tracks
+	cluster  int64 // 当前 Cluster 的时间码（毫秒），-1 表示尚未开始
+	refs     int   // 尚未关闭的轨道数
+	err      error
+}
+
+func newWebMMuxer(out io.WriteCloser, expected int) *webmMuxer {
+	return &webmMuxer{out: out, start: time.Now(), expected: expected, cluster: -1}
+}
+
+// addTrack 为远端轨道创建写入器；编码不受支持或文件头已写出时返回 nil。
+func (m *webmMuxer) addTrack(mime string, clock uint32, channels uint16) *webmTrack {
+	codec := webmCodecID(mime)
+	if codec == "" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.header {
+		return nil
+	}
+	if channels == 0 {
+		channels = 2
+	}
+	t := &webmTrack{m: m, num: uint64(len(m.tracks) + 1), codec: codec, mime: mime, clock: clock, channels: channels}
+	m.tracks = append(m.tracks, t)
+	m.refs++
+	if len(m.tracks) >= m.expected {
+		m.writeHeaderLocked()
+	}
+	return t
+}
+
+// webmCodecID 返回 mime 对应的 Matroska CodecID，WebM 不支持的编码返回空串。
+func webmCodecID(mime string) string {
+	switch mime {
+	case webrtc.MimeTypeOpus:
+		return "A_OPUS"
+	case webrtc.MimeTypeVP8:
+		return "V_VP8"
+	case webrtc.MimeTypeVP9:
+		return "V_VP9"
+	}
+	return ""
+}
+
+func (m *webmMuxer) writeLocked(b []byte) {
+	if m.err != nil {
+		return
+	}
+	if _, err := m.out.Write(b); err != nil {
+		m.err = err
+	}
+}
+
+func (m *webmMuxer) writeHeaderLocked() {
+	m.header = true
+	m.writeLocked(ebmlMaster(ebmlHeaderID,
+		ebmlUint(ebmlVersionID, 1),
+		ebmlUint(ebmlReadVersionID, 1),
+		ebmlUint(ebmlMaxIDLenID, 4),
+		ebmlUint(ebmlMaxSizeLenID, 8),
+		ebmlElem(ebmlDocTypeID, []byte("webm")),
+		ebmlUint(ebmlDocTypeVerID, 4),
+		ebmlUint(ebmlDocTypeReadID, 2),
+	))
+	m.writeLocked(append(ebmlID(mkvSegmentID), ebmlUnknownSize...))
+	m.writeLocked(ebmlMaster(mkvInfoID,
+		ebmlUint(mkvTimecodeScale, 1000000), // 时间码单位：毫秒
+		ebmlElem(mkvMuxingAppID, []byte("live-webrtc-go")),
+		ebmlElem(mkvWritingAppID, []byte("live-webrtc-go")),
+	))
+	var entries [][]byte
+	for _, t := range m.tracks {
+		fields := [][]byte{
+			ebmlUint(mkvTrackNumberID, t.num),
+			ebmlUint(mkvTrackUIDID, t.num),
+			ebmlElem(mkvCodecIDID, []byte(t.codec)),
+		}
+		if t.mime == webrtc.MimeTypeOpus {
+			fields = append(fields,
+				ebmlUint(mkvTrackTypeID, 2),
+				ebmlElem(mkvCodecPrivateID, opusHead(uint8(t.channels))),
+				ebmlMaster(mkvAudioID, ebmlFloat(mkvSamplingFreqID, 48000), ebmlUint(mkvChannelsID, uint64(t.channels))),
+			)
+		} else {
+			// 分辨率与 IVF 头一致取占位值，播放器以码流中的实际尺寸为准
+			fields = append(fields,
+				ebmlUint(mkvTrackTypeID, 1),
+				ebmlMaster(mkvVideoID, ebmlUint(mkvPixelWidthID, 640), ebmlUint(mkvPixelHeightID, 480)),
+			)
+		}
+		entries = append(entries, ebmlMaster(mkvTrackEntryID, fields...))
+	}
+	m.writeLocked(ebmlMaster(mkvTracksID, entries...))
+}
+
+// writeFrame 以 SimpleBlock 写入一帧；视频关键帧、时间码超出 int16 相对范围时开始新的 Cluster。
+func (m *webmMuxer) writeFrame(t *webmTrack, tc int64, key bool, frame []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.closed {
+		return
+	}
+	if !m.header {
+		if time.Since(m.start) < webmTrackWait {
+			return
+		}
+		m.writeHeaderLocked()
+	}
+	rel := tc - m.cluster
+	if m.cluster < 0 || (key && t.codec != "A_OPUS") || rel > math.MaxInt16 || rel < math.MinInt16 {
+		m.cluster, rel = tc, 0
+		m.writeLocked(append(ebmlID(mkvClusterID), ebmlUnknownSize...))
+		m.writeLocked(ebmlUint(mkvTimecodeID, uint64(max(tc, 0))))
+	}
+	block := make([]byte, 0, len(frame)+4)
+	block = append(block, ebmlSize(t.num)...)
+	block = binary.BigEndian.AppendUint16(block, uint16(int16(rel)))
+	flags := byte(0)
+	if key {
+		flags = 0x80
+	}
+	block = append(block, flags)
+	block = append(block, frame...)
+	m.writeLocked(ebmlElem(mkvSimpleBlockID, block))
+}
+
+// release 在轨道 t 关闭时调用（可重复调用），最后一个轨道关闭时关闭输出并返回 last=true。
+func (m *webmMuxer) release(t *webmTrack) (last bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if t.closed {
+		return false, nil
+	}
+	t.closed = true
+	m.refs--
+	if m.refs > 0 {
+		return false, nil
+	}
+	if !m.header {
+		m.writeHeaderLocked()
+	}
+	if m.err != nil {
+		// 直传流写入失败时中止分片上传，避免留下不完整的对象
+		if a, ok := m.out.(interface{ Abort(error) error }); ok {
+			_ = a.Abort(m.err)
+		} else {
+			_ = m.out.Close()
+		}
+		return true, m.err
+	}
+	return true, m.out.Close()
+}
+
+// webmTrack 把单个轨道的 RTP 包重组为帧后交给 webmMuxer，实现 rtpWriter 与 sharedWriter。
+type webmTrack struct {
+	m        *webmMuxer
+	num      uint64
+	codec    string
+	mime     string
+	clock    uint32
+	channels uint16
+
+	started bool
+	baseMs  int64  // 首包相对封装器零点的毫秒数
+	lastTS  uint32 // 上一个 RTP 时间戳，用于处理回绕
+	elapsed int64  // 自首包起累计的 RTP 时间戳增量
+	frame   []byte
+	key     bool
+	seenKey bool
+	closed  bool // 受 m.mu 保护
+}
+
+func (t *webmTrack) timecode(ts uint32) int64 {
+	if !t.started {
+		t.started = true
+		t.baseMs = time.Since(t.m.start).Milliseconds()
+		t.lastTS = ts
+	}
+	t.elapsed += int64(int32(ts - t.lastTS))
+	t.lastTS = ts
+	return t.baseMs + t.elapsed*1000/int64(t.clock)
+}
+
+func (t *webmTrack) WriteRTP(pkt *rtp.Packet) error {
+	if len(pkt.Payload) == 0 {
+		return nil
+	}
+	switch t.mime {
+	case webrtc.MimeTypeOpus:
+		t.m.writeFrame(t, t.timecode(pkt.Timestamp), true, pkt.Payload)
+		return nil
+	case webrtc.MimeTypeVP8:
+		var vp8 codecs.VP8Packet
+		payload, err := vp8.Unmarshal(pkt.Payload)
+		if err != nil {
+			return err
+		}
+		if vp8.S == 1 && vp8.PID == 0 {
+			t.frame = t.frame[:0]
+			t.key = len(payload) > 0 && payload[0]&0x01 == 0
+		} else if len(t.frame) == 0 {
+			return nil
+		}
+		t.frame = append(t.frame, payload...)
+	case webrtc.MimeTypeVP9:
+		var vp9 codecs.VP9Packet
+		if _, err := vp9.Unmarshal(pkt.Payload); err != nil {
+			return err
+		}
+		if vp9.B {
+			t.frame = t.frame[:0]
+			t.key = !vp9.P
+		} else if len(t.frame) == 0 {
+			return nil
+		}
+		t.frame = append(t.frame, vp9.Payload...)
+	}
+	if !pkt.Marker {
+		return nil
+	}
+	frame := t.frame
+	t.frame = t.frame[:0:0]
+	if !t.seenKey {
+		if !t.key {
+			return nil // 等到首个关键帧才开始写入
+		}
+		t.seenKey = true
+	}
+	t.m.writeFrame(t, t.timecode(pkt.Timestamp), t.key, frame)
+	return nil
+}
+
+func (t *webmTrack) Close() error {
+	_, err := t.closeShared()
+	return err
+}
+
+func (t *webmTrack) closeShared() (bool, error) { return t.m.release(t) }
+
+// sharedWriter 由多个 fanout 共用同一录制文件的写入器实现；closeShared 仅在最后一个使用者关闭时
+// 返回 last=true，此时文件才完整，fanout 据此决定由谁上传。
+type sharedWriter interface {
+	rtpWriter
+	closeShared() (last bool, err error)
+}
+
+// webmRecording 是一次推流的 WebM 录制：首个可封装的轨道到达时创建文件，其余轨道加入同一文件。
+type webmRecording struct {
+	once     sync.Once
+	mux      *webmMuxer
+	path     string
+	expected int
+	claimed  atomic.Bool // 创建文件时的写入中登记是否已交给某个轨道
+}
+
+// newWebMRecording 按 Offer 中收流的媒体段数确定需要等待的轨道数。
+func newWebMRecording(offerSDP string) *webmRecording {
+	_, _, sections := splitSections(offerSDP)
+	n := 0
+	for _, sec := range sections {
+		fields := strings.Fields(sec[0])
+		if len(fields) < 2 || fields[1] == "0" || hasAttr(sec, "a=recvonly") || hasAttr(sec, "a=inactive") {
+			continue
+		}
+		if strings.HasPrefix(sec[0], "m=audio") || strings.HasPrefix(sec[0], "m=video") {
+			n++
+		}
+	}
+	return &webmRecording{expected: n}
+}
+
+// track 为 remote 返回共享 WebM 文件中的写入器及文件路径（直传时为空）；不支持的编码返回 nil。
+func (w *webmRecording) track(r *Room, remote *webrtc.TrackRemote) (rtpWriter, string) {
+	c := remote.Codec()
+	if webmCodecID(c.MimeType) == "" {
+		return nil, "" // 不支持的编码（如 H.264）不创建文件，避免留下没有轨道的空录制
+	}
+	w.once.Do(func() {
+		name := fmt.Sprintf("%s_%d.webm", r.name, time.Now().Unix())
+		out, p, err := r.openRecordOutput(name)
+		if err != nil {
+			log.Printf("sfu: room %s open webm recording: %v", r.name, err)
+			return
+		}
+		w.mux, w.path = newWebMMuxer(out, w.expected), p
+	})
+	if w.mux == nil {
+		return nil, ""
+	}
+	t := w.mux.addTrack(c.MimeType, c.ClockRate, c.Channels)
+	if t == nil {
+		return nil, ""
+	}
+	// 每个轨道各持一次写入中登记，由 trackRecording 接管；首个轨道沿用创建文件时的登记
+	if w.path != "" && w.claimed.Swap(true) {
+		r.mgr.beginRecording(w.path)
+	}
+	return t, w.path
+}
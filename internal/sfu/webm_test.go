@@ -0,0 +1,183 @@
+package sfu
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestEBMLSize(t *testing.T) {
+	cases := map[uint64][]byte{
+		0:     {0x80},
+		126:   {0xFE},
+		127:   {0x40, 0x7F}, // 0xFF 保留给未知长度
+		16000: {0x7E, 0x80},
+	}
+	for n, want := range cases {
+		if got := ebmlSize(n); !bytes.Equal(got, want) {
+			t.Errorf("ebmlSize(%d) = % x, want % x", n, got, want)
+		}
+	}
+}
+
+// readEBML 解析一层 EBML 元素并返回 ID、数据与剩余字节；未知长度的主元素把其后全部字节作为数据。
+func readEBML(t *testing.T, b []byte) (id uint32, data, rest []byte) {
+	t.Helper()
+	vint := func(keepMarker bool) (uint64, bool) {
+		l := 1
+		for l <= 8 && b[0]&(0x80>>(l-1)) == 0 {
+			l++
+		}
+		v := uint64(b[0])
+		if !keepMarker {
+			v &^= 0x80 >> (l - 1)
+		}
+		for i := 1; i < l; i++ {
+			v = v<<8 | uint64(b[i])
+		}
+		b = b[l:]
+		return v, !keepMarker && v == 1<<(7*l)-1
+	}
+	i, _ := vint(true)
+	size, unknown := vint(false)
+	if unknown {
+		return uint32(i), b, nil
+	}
+	if uint64(len(b)) < size {
+		t.Fatalf("element %x truncated: need %d bytes, have %d", i, size, len(b))
+	}
+	return uint32(i), b[:size], b[size:]
+}
+
+func TestWebMMuxer_InterleavesAudioAndVideo(t *testing.T) {
+	out := &closeBuffer{}
+	m := newWebMMuxer(out, 2)
+	audio := m.addTrack(webrtc.MimeTypeOpus, 48000, 2)
+	video := m.addTrack(webrtc.MimeTypeVP8, 90000, 0)
+	if audio == nil || video == nil {
+		t.Fatal("Expected writers for Opus and VP8")
+	}
+	if m.addTrack(webrtc.MimeTypeOpus, 48000, 2) != nil {
+		t.Error("Expected no new tracks once the header is written")
+	}
+
+	// VP8 帧间帧先于关键帧到达时应被丢弃；关键帧分两个包，Marker 时整帧写出
+	_ = video.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: 0, Marker: true}, Payload: []byte{0x10, 0x01}})
+	_ = video.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: 3000}, Payload: []byte{0x10, 0x00, 0xAA}})
+	_ = video.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: 3000, Marker: true}, Payload: []byte{0x00, 0xBB}})
+	for i := 0; i < 3; i++ {
+		_ = audio.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: uint32(i) * 960}, Payload: []byte{0xFC, byte(i)}})
+	}
+	if last, err := audio.closeShared(); last || err != nil {
+		t.Fatalf("Expected file kept open for remaining track, got last=%v err=%v", last, err)
+	}
+	if last, _ := audio.closeShared(); last {
+		t.Fatal("Expected repeated close to be a no-op")
+	}
+	if last, err := video.closeShared(); !last || err != nil || !out.closed {
+		t.Fatalf("Expected last close to close output, got last=%v err=%v closed=%v", last, err, out.closed)
+	}
+
+	id, header, rest := readEBML(t, out.Bytes())
+	if id != ebmlHeaderID || !bytes.Contains(header, []byte("webm")) {
+		t.Fatalf("Expected EBML header with webm doctype, got id %x", id)
+	}
+	id, segment, _ := readEBML(t, rest)
+	if id != mkvSegmentID {
+		t.Fatalf("Expected Segment, got %x", id)
+	}
+	var codecs []string
+	blocks := map[byte]int{}
+	clusters := 0
+	for b := segment; len(b) > 0; {
+		id, data, next := readEBML(t, b)
+		switch id {
+		case mkvTracksID:
+			for e := data; len(e) > 0; {
+				_, entry, more := readEBML(t, e)
+				for f := entry; len(f) > 0; {
+					fid, fdata, fmore := readEBML(t, f)
+					if fid == mkvCodecIDID {
+						codecs = append(codecs, string(fdata))
+					}
+					f = fmore
+				}
+				e = more
+			}
+		case mkvClusterID:
+			clusters++
+			next = data // 未知长度：子元素紧随其后
+		case mkvSimpleBlockID:
+			blocks[data[0]&0x7F]++
+			if data[0]&0x7F == 2 && !bytes.Equal(data[4:], []byte{0x00, 0xAA, 0xBB}) {
+				t.Errorf("Expected reassembled VP8 keyframe, got % x", data[4:])
+			}
+		}
+		b = next
+	}
+	if len(codecs) != 2 || codecs[0] != "A_OPUS" || codecs[1] != "V_VP8" {
+		t.Errorf("Expected Opus and VP8 track entries, got %v", codecs)
+	}
+	if blocks[1] != 3 || blocks[2] != 1 {
+		t.Errorf("Expected 3 audio and 1 video blocks, got %v", blocks)
+	}
+	if clusters == 0 {
+		t.Error("Expected at least one cluster")
+	}
+}
+
+func TestWebMMuxer_WritesHeaderAfterWait(t *testing.T) {
+	out := &closeBuffer{}
+	m := newWebMMuxer(out, 2)
+	audio := m.addTrack(webrtc.MimeTypeOpus, 48000, 2)
+	_ = audio.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: 0}, Payload: []byte{0xFC}})
+	if out.Len() != 0 {
+		t.Fatal("Expected header deferred while waiting for the second track")
+	}
+	m.start = m.start.Add(-2 * webmTrackWait)
+	_ = audio.WriteRTP(&rtp.Packet{Header: rtp.Header{Timestamp: 960}, Payload: []byte{0xFC}})
+	if !bytes.HasPrefix(out.Bytes(), ebmlID(ebmlHeaderID)) || !bytes.Contains(out.Bytes(), ebmlID(mkvClusterID)) {
+		t.Error("Expected header and cluster written after the wait")
+	}
+	if m.addTrack(webrtc.MimeTypeVP8, 90000, 0) != nil {
+		t.Error("Expected late track to be rejected")
+	}
+}
+
+func TestNewWebMRecording_CountsSendingSections(t *testing.T) {
+	offer := "v=0\r\no=- 1 1 IN IP4 0.0.0.0\r\ns=-\r\nt=0 0\r\n" +
+		"m=audio 9 UDP/TLS/RTP/SAVPF 111\r\na=sendonly\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=sendonly\r\n" +
+		"m=video 9 UDP/TLS/RTP/SAVPF 96\r\na=inactive\r\n" +
+		"m=application 9 UDP/DTLS/SCTP webrtc-datachannel\r\n"
+	if got := newWebMRecording(offer).expected; got != 2 {
+		t.Errorf("Expected 2 media tracks, got %d", got)
+	}
+}
+
+func TestWebMCodecID(t *testing.T) {
+	// track 先按此判断编码，不支持的轨道不会创建录制文件
+	for mime, want := range map[string]string{
+		webrtc.MimeTypeOpus: "A_OPUS",
+		webrtc.MimeTypeVP8:  "V_VP8",
+		webrtc.MimeTypeVP9:  "V_VP9",
+		webrtc.MimeTypeH264: "",
+		webrtc.MimeTypeAV1:  "",
+	} {
+		if got := webmCodecID(mime); got != want {
+			t.Errorf("%s: expected %q, got %q", mime, want, got)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package sfu
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// subWriterQueue 是每个订阅者待写 RTP 包的缓冲长度，约合数秒的视频包。
+const subWriterQueue = 256
+
+// rtpSink 是订阅者侧的 RTP 写入端，由 *webrtc.TrackLocalStaticRTP 实现。
+type rtpSink interface {
+	WriteRTP(*rtp.Packet) error
+}
+
+// rtcpSender 是订阅者侧的 RTCP 读取端，由 *webrtc.RTPSender 实现；Stop 会使阻塞中的 Read 返回错误。
+type rtcpSender interface {
+	Read([]byte) (int, interceptor.Attributes, error)
+	Stop() error
+}
+
+// subWriter 在独立 goroutine 中向单个订阅者写入 RTP：fanout 只做非阻塞投递，
+// 慢订阅者的缓冲写满后丢包，而不会阻塞持有 fanout 读锁的分发循环、拖慢整个房间。
+type subWriter struct {
+	sink    rtpSink
+	ch      chan *rtp.Packet
+	busy    atomic.Int64  // 当前这次 WriteRTP 开始的时间（UnixNano），空闲时为 0
+	evicted atomic.Bool   // 是否已因写入卡死被移除
+	done    chan struct{} // stop 时关闭，通知 drainRTCP 停止 sender
+	ext     extRemap      // E2EE_PASSTHROUGH：写入前改写头扩展 ID，nil 表示原样写入
+}
+
+func newSubWriter(sink rtpSink, ext extRemap) *subWriter {
+	sw := &subWriter{sink: sink, ch: make(chan *rtp.Packet, subWriterQueue), done: make(chan struct{}), ext: ext}
+	go sw.run()
+	return sw
+}
+
+func (sw *subWriter) run() {
+	for pkt := range sw.ch {
+		sw.ext.apply(pkt)
+		sw.busy.Store(time.Now().UnixNano())
+		_ = sw.sink.WriteRTP(pkt)
+		sw.busy.Store(0)
+	}
+}
+
+// send 非阻塞地投递一个包，缓冲已满时丢弃并返回 false。
+func (sw *subWriter) send(pkt *rtp.Packet) bool {
+	select {
+	case sw.ch <- pkt:
+		return true
+	default:
+		return false
+	}
+}
+
+// stuckFor 返回当前这次写入已阻塞的时长，空闲时为 0。
+func (sw *subWriter) stuckFor(now time.Time) time.Duration {
+	if b := sw.busy.Load(); b != 0 {
+		return now.Sub(time.Unix(0, b))
+	}
+	return 0
+}
+
+// stop 结束写入 goroutine 并通知 drainRTCP 退出；调用方需保证之后不再 send（fanout 在持有写锁时调用）。
+func (sw *subWriter) stop() {
+	close(sw.ch)
+	close(sw.done)
+}
+
+// drainRTCP 持续读取并丢弃 sender 上的 RTCP 以清理接收缓冲。订阅者与轨道解绑（done 关闭）时
+// 停止 sender 使阻塞中的 Read 返回，goroutine 随之退出，而不必等到整个连接关闭。
+// Stop 放在单独的 goroutine 中执行，避免在 fanout 持锁期间等待 sender 内部的锁。
+func drainRTCP(sender rtcpSender, done <-chan struct{}) {
+	exited := make(chan struct{})
+	defer close(exited)
+	go func() {
+		select {
+		case <-done:
+			_ = sender.Stop()
+		case <-exited:
+		}
+	}()
+	buf := make([]byte, 1500)
+	for {
+		if _, _, err := sender.Read(buf); err != nil {
+			return
+		}
+	}
+}
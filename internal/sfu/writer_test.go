@@ -0,0 +1,100 @@
+package sfu
+
+import (
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+// blockingSink 的第一次写入会一直阻塞到 release 关闭，模拟卡死的订阅者连接。
+type blockingSink struct{ release chan struct{} }
+
+func (s *blockingSink) WriteRTP(*rtp.Packet) error { <-s.release; return nil }
+
+func TestTrackFanout_EvictsStuckSubscriber(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	defer close(sink.release)
+
+	stuck := make(chan *webrtc.PeerConnection, 1)
+	f := newTrackFanout(nil, "room")
+	f.writeTimeout = 20 * time.Millisecond
+	f.onStuck = func(pc *webrtc.PeerConnection) { stuck <- pc }
+	pc := &webrtc.PeerConnection{}
+	f.locals[pc] = newSubWriter(sink, nil)
+
+	raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96, SSRC: 1}, Payload: []byte{1}}).Marshal()
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var scratch rtp.Packet
+	f.forward(raw, &scratch)
+	deadline := time.Now().Add(time.Second)
+	for f.locals[pc].stuckFor(time.Now()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(30 * time.Millisecond)
+	f.forward(raw, &scratch)
+	f.forward(raw, &scratch) // 重复检测不应再次回调
+
+	select {
+	case got := <-stuck:
+		if got != pc {
+			t.Errorf("Expected stuck subscriber to be reported, got %p", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected stuck subscriber to be evicted")
+	}
+	select {
+	case <-stuck:
+		t.Error("Expected a stuck subscriber to be reported only once")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestSubWriter_DropsWhenFull(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	defer close(sink.release)
+	sw := newSubWriter(sink, nil)
+
+	dropped := 0
+	for i := 0; i < subWriterQueue+10; i++ {
+		if !sw.send(&rtp.Packet{}) {
+			dropped++
+		}
+	}
+	if dropped == 0 {
+		t.Error("Expected packets to be dropped once the queue is full")
+	}
+}
+
+// fakeSender 的 Read 一直阻塞到 Stop，模拟没有 RTCP 到达的 RTPSender。
+type fakeSender struct{ stopped chan struct{} }
+
+func (s *fakeSender) Read([]byte) (int, interceptor.Attributes, error) {
+	<-s.stopped
+	return 0, nil, io.ErrClosedPipe
+}
+
+func (s *fakeSender) Stop() error { close(s.stopped); return nil }
+
+func TestTrackFanout_DetachStopsDrain(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+	f := newTrackFanout(nil, "room")
+	for i := 0; i < 200; i++ {
+		pc := &webrtc.PeerConnection{}
+		f.attach(pc, &countingSink{}, &fakeSender{stopped: make(chan struct{})}, nil)
+		f.detachFromSubscriber(pc)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > baseline && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Errorf("Expected goroutines to return to %d after detaching subscribers, got %d", baseline, n)
+	}
+}
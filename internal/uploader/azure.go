@@ -0,0 +1,120 @@
+package uploader
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"live-webrtc-go/internal/config"
+)
+
+const azureAPIVersion = "2020-10-02"
+
+// azureUploader 通过 Put Blob 接口写入块 Blob，支持账户共享密钥（SharedKey）或 SAS 令牌鉴权。
+type azureUploader struct {
+	endpoint  string // 例如 https://{account}.blob.core.windows.net
+	account   string
+	key       []byte
+	sas       string
+	container string
+	client    *http.Client
+}
+
+func newAzureUploader(c *config.Config) (*azureUploader, error) {
+	if c.AzureAccount == "" || c.S3Bucket == "" {
+		return nil, errors.New("uploader: missing Azure account or container (S3_BUCKET)")
+	}
+	if c.AzureKey == "" && c.AzureSASToken == "" {
+		return nil, errors.New("uploader: missing Azure account key or SAS token")
+	}
+	u := &azureUploader{
+		endpoint:  strings.TrimRight(c.S3Endpoint, "/"),
+		account:   c.AzureAccount,
+		sas:       strings.TrimPrefix(c.AzureSASToken, "?"),
+		container: c.S3Bucket,
+		client:    &http.Client{},
+	}
+	if u.endpoint == "" {
+		u.endpoint = "https://" + c.AzureAccount + ".blob.core.windows.net"
+	}
+	if c.AzureKey != "" {
+		key, err := base64.StdEncoding.DecodeString(c.AzureKey)
+		if err != nil {
+			return nil, fmt.Errorf("uploader: decode Azure account key: %w", err)
+		}
+		u.key = key
+	}
+	return u, nil
+}
+
+func (u *azureUploader) Put(ctx context.Context, object string, r io.Reader, size int64, contentType string) error {
+	target, err := url.Parse(u.endpoint)
+	if err != nil {
+		return err
+	}
+	target.Path += "/" + u.container + "/" + object
+	if u.key == nil {
+		target.RawQuery = u.sas
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target.String(), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("x-ms-version", azureAPIVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	if u.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+u.account+":"+u.sign(req))
+	}
+	return doUpload(u.client, req, "azure")
+}
+
+// sign 按 Azure Storage SharedKey 规范计算请求签名。
+func (u *azureUploader) sign(req *http.Request) string {
+	length := ""
+	if req.ContentLength > 0 {
+		length = strconv.FormatInt(req.ContentLength, 10)
+	}
+	var msHeaders []string
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			msHeaders = append(msHeaders, lk)
+		}
+	}
+	sort.Strings(msHeaders)
+	var canonHeaders strings.Builder
+	for _, k := range msHeaders {
+		canonHeaders.WriteString(k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n")
+	}
+	toSign := strings.Join([]string{
+		req.Method,
+		"", // Content-Encoding
+		"", // Content-Language
+		length,
+		"", // Content-MD5
+		req.Header.Get("Content-Type"),
+		"", // Date（使用 x-ms-date）
+		"", // If-Modified-Since
+		"", // If-Match
+		"", // If-None-Match
+		"", // If-Unmodified-Since
+		"", // Range
+	}, "\n") + "\n" + canonHeaders.String() + "/" + u.account + req.URL.EscapedPath()
+	mac := hmac.New(sha256.New, u.key)
+	mac.Write([]byte(toSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,162 @@
+package uploader
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"live-webrtc-go/internal/config"
+)
+
+const (
+	gcsDefaultEndpoint = "https://storage.googleapis.com"
+	gcsScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// gcsUploader 通过 GCS JSON API 的 media 上传写入对象，使用服务账号 JSON 换取 OAuth2 访问令牌。
+// 未配置凭据时不带鉴权头，便于对接本地模拟器。
+type gcsUploader struct {
+	endpoint string
+	bucket   string
+	account  *gcsServiceAccount
+	client   *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// gcsServiceAccount 是服务账号密钥文件中用到的字段。
+type gcsServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+func newGCSUploader(c *config.Config) (*gcsUploader, error) {
+	if c.S3Bucket == "" {
+		return nil, errors.New("uploader: missing GCS bucket (S3_BUCKET)")
+	}
+	u := &gcsUploader{
+		endpoint: strings.TrimRight(c.S3Endpoint, "/"),
+		bucket:   c.S3Bucket,
+		client:   &http.Client{},
+	}
+	if u.endpoint == "" {
+		u.endpoint = gcsDefaultEndpoint
+	}
+	if c.GCSCredentialsFile != "" {
+		data, err := os.ReadFile(c.GCSCredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		var sa gcsServiceAccount
+		if err := json.Unmarshal(data, &sa); err != nil {
+			return nil, fmt.Errorf("uploader: parse GCS credentials: %w", err)
+		}
+		if sa.ClientEmail == "" || sa.PrivateKey == "" {
+			return nil, errors.New("uploader: GCS credentials missing client_email or private_key")
+		}
+		if sa.TokenURI == "" {
+			sa.TokenURI = "https://oauth2.googleapis.com/token"
+		}
+		u.account = &sa
+	}
+	return u, nil
+}
+
+func (u *gcsUploader) Put(ctx context.Context, object string, r io.Reader, size int64, contentType string) error {
+	q := url.Values{"uploadType": {"media"}, "name": {object}}
+	endpoint := u.endpoint + "/upload/storage/v1/b/" + url.PathEscape(u.bucket) + "/o?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if u.account != nil {
+		tok, err := u.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+tok)
+	}
+	return doUpload(u.client, req, "gcs")
+}
+
+// accessToken 用服务账号私钥签发 JWT 断言换取访问令牌，并缓存到过期前一分钟。
+func (u *gcsUploader) accessToken(ctx context.Context) (string, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.token != "" && time.Now().Before(u.expiry.Add(-time.Minute)) {
+		return u.token, nil
+	}
+	key, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(u.account.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("uploader: parse GCS private key: %w", err)
+	}
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   u.account.ClientEmail,
+		"scope": gcsScope,
+		"aud":   u.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(key)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("uploader: gcs token: %s", resp.Status)
+	}
+	var tr struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", err
+	}
+	u.token = tr.AccessToken
+	u.expiry = now.Add(time.Duration(tr.ExpiresIn) * time.Second)
+	return u.token, nil
+}
+
+// doUpload 发送上传请求，非 2xx 响应连同部分响应体作为错误返回。
+func doUpload(client *http.Client, req *http.Request, backend string) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("uploader: %s upload: %s: %s", backend, resp.Status, strings.TrimSpace(string(body)))
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
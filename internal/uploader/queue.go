@@ -0,0 +1,174 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"live-webrtc-go/internal/metrics"
+)
+
+// 上传队列：所有上传共享一个有界的 worker 池（UPLOAD_CONCURRENCY），超出的上传排队等待名额。开启 UPLOAD_SERIAL_PER_ROOM 后，
+// 同一房间的文件按入队顺序逐个上传，保证分段录制在下游按序拼接；不同房间之间仍然并行。
+var (
+	queueMu    sync.Mutex
+	roomQueues = make(map[string][]string) // 串行模式下各房间待上传的文件
+	draining   = make(map[string]bool)     // 房间是否已有 goroutine 在按序上传
+	workers    chan struct{}               // worker 池信号量，由 Init 按并发数创建
+	jobs       = make(map[string]*Job)     // 排队或上传中的文件，按本地路径索引
+	failed     []Job                       // 最近的上传失败，最多保留 maxFailedJobs 条
+	pending    sync.WaitGroup              // 已入队尚未结束的上传，供 Wait 在退出时等待
+)
+
+// maxFailedJobs 是管理接口保留的最近失败记录条数。
+const maxFailedJobs = 100
+
+// 上传任务状态。
+const (
+	JobPending   = "pending"   // 等待 worker 名额或房间内前序文件
+	JobUploading = "uploading" // 正在上传
+	JobFailed    = "failed"    // 重试用尽仍失败，本地文件移入 failed/ 子目录
+)
+
+// Job 描述一个排队、上传中或失败的录制文件，供 GET /api/admin/uploads 展示。
+type Job struct {
+	Room      string    `json:"room"`
+	File      string    `json:"file"`
+	State     string    `json:"state"`
+	LastError string    `json:"lastError,omitempty"`
+	Updated   time.Time `json:"updated"`
+}
+
+// Jobs 返回当前排队/上传中的任务（按入队时间）及最近的失败记录（由新到旧）。
+func Jobs() []Job {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	out := make([]Job, 0, len(jobs)+len(failed))
+	for _, j := range jobs {
+		out = append(out, *j)
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].Updated.Before(out[k].Updated) })
+	for i := len(failed) - 1; i >= 0; i-- {
+		out = append(out, failed[i])
+	}
+	return out
+}
+
+// trackJob 登记入队的文件并更新队列深度。
+func trackJob(room, localPath string) {
+	queueMu.Lock()
+	jobs[localPath] = &Job{Room: room, File: filepath.Base(localPath), State: JobPending, Updated: time.Now()}
+	metrics.SetUploadQueueDepth(len(jobs))
+	queueMu.Unlock()
+}
+
+// finishJob 在上传结束时移除任务；失败时计入失败计数并保留最近的错误。
+func finishJob(localPath string, err error) {
+	queueMu.Lock()
+	defer queueMu.Unlock()
+	j := jobs[localPath]
+	delete(jobs, localPath)
+	metrics.SetUploadQueueDepth(len(jobs))
+	if err == nil || j == nil {
+		return
+	}
+	metrics.IncUploadFailures()
+	j.State, j.LastError, j.Updated = JobFailed, err.Error(), time.Now()
+	failed = append(failed, *j)
+	if len(failed) > maxFailedJobs {
+		failed = failed[len(failed)-maxFailedJobs:]
+	}
+}
+
+// Enqueue 异步上传录制文件，room 用于按房间排序与统计积压；未启用上传时直接返回。
+func Enqueue(room, localPath string) {
+	if !Enabled() {
+		return
+	}
+	metrics.IncUploadBacklog(room)
+	trackJob(room, localPath)
+	pending.Add(1)
+	if !cfg.UploadSerialPerRoom {
+		go func() {
+			defer pending.Done()
+			uploadInPool(localPath)
+			metrics.DecUploadBacklog(room)
+		}()
+		return
+	}
+	queueMu.Lock()
+	roomQueues[room] = append(roomQueues[room], localPath)
+	start := !draining[room]
+	draining[room] = true
+	queueMu.Unlock()
+	if start {
+		go drainRoom(room)
+	}
+}
+
+// drainRoom 依次上传房间队列中的文件，队列清空后退出。
+func drainRoom(room string) {
+	for {
+		queueMu.Lock()
+		q := roomQueues[room]
+		if len(q) == 0 {
+			delete(roomQueues, room)
+			delete(draining, room)
+			queueMu.Unlock()
+			return
+		}
+		p := q[0]
+		roomQueues[room] = q[1:]
+		queueMu.Unlock()
+
+		uploadInPool(p)
+		metrics.DecUploadBacklog(room)
+		pending.Done()
+	}
+}
+
+// uploadInPool 上传入队的文件（含重试）并在结束时更新任务记录；worker 名额由每次上传尝试占用。
+func uploadInPool(localPath string) {
+	finishJob(localPath, UploadWithRetry(context.Background(), localPath))
+}
+
+// acquireWorker 等待一个 worker 名额，返回释放函数；ctx 先结束时返回其错误。
+func acquireWorker(ctx context.Context) (release func(), err error) {
+	select {
+	case workers <- struct{}{}:
+		return func() { <-workers }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// markUploading 把已入队的文件标记为上传中，未经 Enqueue 的文件忽略。
+func markUploading(localPath string) {
+	queueMu.Lock()
+	if j := jobs[localPath]; j != nil {
+		j.State, j.Updated = JobUploading, time.Now()
+	}
+	queueMu.Unlock()
+}
+
+// Wait 等待已入队的上传全部结束（含重试），用于退出前让关闭房间时产生的录制上传完成。
+// ctx 先结束时返回错误，未完成的文件保留在本地。
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		queueMu.Lock()
+		n := len(jobs)
+		queueMu.Unlock()
+		return fmt.Errorf("uploader: %d uploads still pending: %w", n, ctx.Err())
+	}
+}
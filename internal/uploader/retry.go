@@ -0,0 +1,75 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"live-webrtc-go/internal/metrics"
+)
+
+// FailedDir 是重试用尽后存放录制文件的子目录（位于文件所在目录下），供运维人员核对补传。
+const FailedDir = "failed"
+
+// maxRetryBackoff 是两次上传尝试之间的最长等待时间。
+const maxRetryBackoff = time.Minute
+
+// UploadWithRetry 上传录制文件，失败时按 UPLOAD_RETRY_BACKOFF 起始的指数退避重试，最多尝试
+// UPLOAD_MAX_ATTEMPTS 次。仍然失败时把文件移入 failed/ 子目录并记录日志，返回最后一次的错误。
+// 文件不存在或 ctx 取消时不再重试，也不移动文件。未启用上传时直接返回 nil。
+func UploadWithRetry(ctx context.Context, localPath string) error {
+	if !Enabled() {
+		return nil
+	}
+	attempts := max(cfg.UploadMaxAttempts, 1)
+	backoff := cfg.UploadRetryBackoff
+	var err error
+	for i := 1; ; i++ {
+		if err = Upload(ctx, localPath); err == nil {
+			metrics.IncUploadResult(true)
+			return nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			metrics.IncUploadResult(false)
+			return err
+		}
+		if i >= attempts {
+			break
+		}
+		log.Printf("uploader: upload %s (attempt %d/%d): %v, retrying in %s", localPath, i, attempts, err, backoff)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			metrics.IncUploadResult(false)
+			return err
+		case <-t.C:
+		}
+		backoff = min(backoff*2, maxRetryBackoff)
+	}
+	metrics.IncUploadResult(false)
+	dst, merr := moveToFailed(localPath)
+	if merr != nil {
+		log.Printf("uploader: upload %s failed after %d attempts: %v; move to %s/: %v", localPath, attempts, err, FailedDir, merr)
+		return err
+	}
+	log.Printf("uploader: upload %s failed after %d attempts: %v; moved to %s", localPath, attempts, err, dst)
+	return err
+}
+
+// moveToFailed 把文件移入同目录下的 failed/ 子目录，返回新路径。
+func moveToFailed(localPath string) (string, error) {
+	dir := filepath.Join(filepath.Dir(localPath), FailedDir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	dst := filepath.Join(dir, filepath.Base(localPath))
+	if err := os.Rename(localPath, dst); err != nil {
+		return "", err
+	}
+	return dst, nil
+}
@@ -0,0 +1,56 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"live-webrtc-go/internal/config"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Uploader 通过 minio-go 写入 S3 兼容存储（AWS S3、MinIO 等）。
+type s3Uploader struct {
+	client *minio.Client
+	bucket string
+}
+
+func newS3Uploader(c *config.Config) (*s3Uploader, error) {
+	if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3AccessKey == "" || c.S3SecretKey == "" {
+		return nil, errors.New("uploader: missing S3 configuration")
+	}
+	cl, err := minio.New(c.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(c.S3AccessKey, c.S3SecretKey, ""),
+		Secure: c.S3UseSSL,
+		Region: c.S3Region,
+		BucketLookup: func() minio.BucketLookupType {
+			if c.S3PathStyle {
+				return minio.BucketLookupPath
+			}
+			return minio.BucketLookupDNS
+		}(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3Uploader{client: cl, bucket: c.S3Bucket}, nil
+}
+
+func (u *s3Uploader) Put(ctx context.Context, object string, r io.Reader, size int64, contentType string) error {
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	if size < 0 {
+		// 未知长度时 minio 默认按 5 TiB / 10000 估算分片（每个上传约 512 MiB 缓冲），显式限定分片大小
+		opts.PartSize = streamPartSize
+	}
+	_, err := u.client.PutObject(ctx, u.bucket, object, r, size, opts)
+	return err
+}
+
+// streamPartSize 是未知长度直传的分片大小：每路直传最多缓冲一个分片，
+// 单个对象上限为 10000 个分片（约 156 GiB），足以覆盖单路录制。
+const streamPartSize = 16 << 20
+
+// streamable 表示 S3 支持未知长度的分片上传，可用于 RECORD_DIRECT_UPLOAD 直传。
+func (u *s3Uploader) streamable() bool { return true }
@@ -0,0 +1,133 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrStreamUnsupported 表示上传未启用，或当前后端不支持未知长度的直传。
+var ErrStreamUnsupported = errors.New("uploader: streaming upload requires the s3 backend")
+
+// streamBufferChunks 是直传写入端最多缓冲的写入块数（每块通常为一个 RTP 负载，约 1.2 KB）；
+// 上传跟不上时超出部分被丢弃，不阻塞调用方（SFU 的 RTP 读取循环）。
+const streamBufferChunks = 1024
+
+// streamer 由支持未知长度（size 为 -1，走分片上传）写入的后端实现。
+// Azure Put Blob 与 GCS 简单上传都需要预先知道长度，因此目前只有 S3 支持直传。
+type streamer interface {
+	streamable() bool
+}
+
+// Stream 是直传对象存储的写入端：写入的数据先进入有界缓冲，再由后台 goroutine 经 io.Pipe
+// 交给后端以未知长度上传，不在本地磁盘落文件，适合磁盘紧张的节点（RECORD_DIRECT_UPLOAD）。
+type Stream struct {
+	name     string
+	mu       sync.RWMutex
+	closed   bool
+	abortErr error
+	buf      chan []byte
+	dropped  atomic.Int64
+	done     chan struct{}
+	err      error // 上传结果，done 关闭后可读
+}
+
+// OpenStream 为名为 name 的录制开始一次直传，对象名规则与 Upload 相同（S3_PREFIX/name）。
+func OpenStream(name string) (*Stream, error) {
+	if !Enabled() {
+		return nil, ErrStreamUnsupported
+	}
+	if s, ok := backend.(streamer); !ok || !s.streamable() {
+		return nil, ErrStreamUnsupported
+	}
+	pr, pw := io.Pipe()
+	s := &Stream{name: name, buf: make(chan []byte, streamBufferChunks), done: make(chan struct{})}
+	object, contentType := objectName(name), mime.TypeByExtension(filepath.Ext(name))
+	uploaded := make(chan error, 1)
+	go func() {
+		err := backend.Put(context.Background(), object, pr, -1, contentType)
+		// 上传提前失败时让后续写入立即报错，而不是阻塞在管道上
+		_ = pr.CloseWithError(err)
+		uploaded <- err
+	}()
+	go s.pump(pw, uploaded)
+	return s, nil
+}
+
+// pump 把缓冲中的数据写入管道；写入端关闭后结束管道（Abort 时带上中止原因）并等待上传结果。
+func (s *Stream) pump(pw *io.PipeWriter, uploaded <-chan error) {
+	var werr error
+	for p := range s.buf {
+		if werr == nil {
+			_, werr = pw.Write(p)
+		}
+	}
+	s.mu.RLock()
+	abortErr := s.abortErr
+	s.mu.RUnlock()
+	if abortErr != nil {
+		_ = pw.CloseWithError(abortErr)
+	} else {
+		_ = pw.Close()
+	}
+	s.err = <-uploaded
+	if n := s.dropped.Load(); n > 0 {
+		log.Printf("uploader: stream %s dropped %d write(s) because the upload fell behind", s.name, n)
+	}
+	if s.err != nil && s.err != abortErr {
+		log.Printf("uploader: stream %s: %v", s.name, s.err)
+	}
+	close(s.done)
+}
+
+// Write 把 p 的副本放入缓冲，从不阻塞：缓冲已满时丢弃本次写入并计数，关闭后写入返回 io.ErrClosedPipe。
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	select {
+	case s.buf <- append([]byte(nil), p...):
+	default:
+		s.dropped.Add(1)
+	}
+	return len(p), nil
+}
+
+// Close 结束写入后立即返回，缓冲中的剩余数据与分片提交在后台完成，结果见 Wait；可重复调用。
+func (s *Stream) Close() error {
+	s.finish(nil)
+	return nil
+}
+
+// Abort 以 err 中止上传：读取端收到错误后后端放弃本次上传，S3 会中止（abort）已上传的分片。
+// 与 Close 一样不等待上传结束。
+func (s *Stream) Abort(err error) error {
+	s.finish(err)
+	return nil
+}
+
+func (s *Stream) finish(abortErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed, s.abortErr = true, abortErr
+	close(s.buf)
+}
+
+// Wait 等待 Close/Abort 之后的上传结束并返回上传错误。
+func (s *Stream) Wait() error {
+	<-s.done
+	return s.err
+}
+
+// Dropped 返回因缓冲已满被丢弃的写入次数。
+func (s *Stream) Dropped() int64 { return s.dropped.Load() }
@@ -1,62 +1,82 @@
-// Package uploader 抽象录制文件上传逻辑，可选对接 S3/MinIO。
+// Package uploader 抽象录制文件上传逻辑，可选对接 S3/MinIO、Google Cloud Storage 或 Azure Blob。
 // 教学场景下仅实现最小可用路径：初始化与单文件上传，可选删除本地文件。
 package uploader
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"io"
 	"mime"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"live-webrtc-go/internal/config"
-
-	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
+	"live-webrtc-go/internal/metrics"
 )
 
+// Uploader 是对象存储后端的最小抽象：把一段数据写入指定对象名。
+// 由 STORAGE_BACKEND 选择 s3、gcs 或 azure 实现。
+type Uploader interface {
+	Put(ctx context.Context, object string, r io.Reader, size int64, contentType string) error
+}
+
 var (
-    client *minio.Client
-    cfg    *config.Config
+    backend Uploader
+    cfg     *config.Config
 )
 
-// Init 根据配置初始化 MinIO/S3 客户端。
+// Init 根据配置初始化对象存储后端。
 // 若未开启上传或配置不完整，将返回错误或直接跳过。
 func Init(c *config.Config) error {
 	cfg = c
+	backend = nil
 	if !c.UploadEnabled {
 		return nil
 	}
-	if c.S3Endpoint == "" || c.S3Bucket == "" || c.S3AccessKey == "" || c.S3SecretKey == "" {
-		return errors.New("uploader: missing S3 configuration")
+	var (
+		b   Uploader
+		err error
+	)
+	switch c.StorageBackend {
+	case "", "s3":
+		b, err = newS3Uploader(c)
+	case "gcs":
+		b, err = newGCSUploader(c)
+	case "azure":
+		b, err = newAzureUploader(c)
+	default:
+		err = fmt.Errorf("uploader: unknown storage backend %q", c.StorageBackend)
 	}
-	cl, err := minio.New(c.S3Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(c.S3AccessKey, c.S3SecretKey, ""),
-		Secure: c.S3UseSSL,
-		Region: c.S3Region,
-		BucketLookup: func() minio.BucketLookupType {
-			if c.S3PathStyle {
-				return minio.BucketLookupPath
-			}
-			return minio.BucketLookupDNS
-		}(),
-	})
 	if err != nil {
 		return err
 	}
-	client = cl
+	n := c.UploadConcurrency
+	if n <= 0 {
+		n = 1
+	}
+	workers = make(chan struct{}, n)
+	backend = b
 	return nil
 }
 
 // Enabled 报告上传功能是否可用。
-func Enabled() bool { return cfg != nil && cfg.UploadEnabled && client != nil }
+func Enabled() bool { return cfg != nil && cfg.UploadEnabled && backend != nil }
 
 // Upload 将录制文件推送到对象存储，若配置要求则在成功后删除本地文件。
+// 同时进行的上传受 UPLOAD_CONCURRENCY 限制，名额已满时阻塞等待，ctx 结束则放弃并返回其错误。
+// 上传期间计入 webrtc_uploads_in_flight；单次调用不重试，需要重试时使用 UploadWithRetry。
 func Upload(ctx context.Context, localPath string) error {
 	if !Enabled() {
 		return nil
 	}
+	release, err := acquireWorker(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	markUploading(localPath)
+	defer metrics.UploadStarted()()
 	f, err := os.Open(localPath)
 	if err != nil {
 		return err
@@ -67,13 +87,8 @@ func Upload(ctx context.Context, localPath string) error {
 		return err
 	}
 	name := filepath.Base(localPath)
-	objectName := name
-	if p := strings.Trim(cfg.S3Prefix, "/"); p != "" {
-		objectName = p + "/" + name
-	}
 	contentType := mime.TypeByExtension(filepath.Ext(name))
-	_, err = client.PutObject(ctx, cfg.S3Bucket, objectName, f, info.Size(), minio.PutObjectOptions{ContentType: contentType})
-	if err != nil {
+	if err := backend.Put(ctx, objectName(name), f, info.Size(), contentType); err != nil {
 		return err
 	}
 	if cfg.DeleteAfterUpload {
@@ -81,3 +96,11 @@ func Upload(ctx context.Context, localPath string) error {
 	}
 	return nil
 }
+
+// objectName 返回录制文件在对象存储中的对象名（带 S3_PREFIX 前缀）。
+func objectName(name string) string {
+	if p := strings.Trim(cfg.S3Prefix, "/"); p != "" {
+		return p + "/" + name
+	}
+	return name
+}
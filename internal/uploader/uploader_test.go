@@ -0,0 +1,387 @@
+package uploader
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"live-webrtc-go/internal/config"
+	"live-webrtc-go/internal/metrics"
+)
+
+// writeRecording 在临时目录创建一个待上传的录制文件。
+func writeRecording(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	return p
+}
+
+func TestInit_UnknownBackend(t *testing.T) {
+	if err := Init(&config.Config{UploadEnabled: true, StorageBackend: "ftp"}); err == nil {
+		t.Error("Expected error for unknown storage backend")
+	}
+	if Enabled() {
+		t.Error("Expected uploader to stay disabled")
+	}
+}
+
+func TestUpload_GCS(t *testing.T) {
+	var gotPath, gotName, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotName = r.URL.Query().Get("name")
+		b, _ := io.ReadAll(r.Body)
+		gotBody = string(b)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	err := Init(&config.Config{UploadEnabled: true, StorageBackend: "gcs", S3Endpoint: srv.URL, S3Bucket: "recs", S3Prefix: "live"})
+	if err != nil {
+		t.Fatalf("Expected GCS init to succeed, got %v", err)
+	}
+	defer Init(&config.Config{})
+
+	p := writeRecording(t, "demo.ivf", "ivf-data")
+	if err := Upload(context.Background(), p); err != nil {
+		t.Fatalf("Expected upload to succeed, got %v", err)
+	}
+	if gotPath != "/upload/storage/v1/b/recs/o" || gotName != "live/demo.ivf" || gotBody != "ivf-data" {
+		t.Errorf("Unexpected GCS request: path=%q name=%q body=%q", gotPath, gotName, gotBody)
+	}
+}
+
+func TestUpload_AzureSharedKey(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("secret"))
+	var gotReq *http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotReq = r
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	c := &config.Config{UploadEnabled: true, StorageBackend: "azure", S3Endpoint: srv.URL, S3Bucket: "recs", AzureAccount: "acct", AzureKey: key}
+	if err := Init(c); err != nil {
+		t.Fatalf("Expected Azure init to succeed, got %v", err)
+	}
+	defer Init(&config.Config{})
+
+	p := writeRecording(t, "demo.ogg", "ogg-data")
+	if err := Upload(context.Background(), p); err != nil {
+		t.Fatalf("Expected upload to succeed, got %v", err)
+	}
+	if gotReq.Method != http.MethodPut || gotReq.URL.Path != "/recs/demo.ogg" {
+		t.Errorf("Unexpected Azure request: %s %s", gotReq.Method, gotReq.URL.Path)
+	}
+	if gotReq.Header.Get("x-ms-blob-type") != "BlockBlob" {
+		t.Errorf("Expected BlockBlob type, got %q", gotReq.Header.Get("x-ms-blob-type"))
+	}
+	if auth := gotReq.Header.Get("Authorization"); !strings.HasPrefix(auth, "SharedKey acct:") {
+		t.Errorf("Expected SharedKey authorization, got %q", auth)
+	}
+}
+
+func TestUpload_BackendErrorKeepsFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	err := Init(&config.Config{UploadEnabled: true, DeleteAfterUpload: true, StorageBackend: "gcs", S3Endpoint: srv.URL, S3Bucket: "recs"})
+	if err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer Init(&config.Config{})
+
+	p := writeRecording(t, "demo.ivf", "ivf-data")
+	if err := Upload(context.Background(), p); err == nil {
+		t.Error("Expected upload error on 403")
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Errorf("Expected local file kept after failed upload, got %v", err)
+	}
+}
+
+func TestEnqueue_SerialPerRoom(t *testing.T) {
+	var mu sync.Mutex
+	var order []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		order = append(order, r.URL.Query().Get("name"))
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	c := &config.Config{UploadEnabled: true, StorageBackend: "gcs", S3Endpoint: srv.URL, S3Bucket: "recs", UploadConcurrency: 4, UploadSerialPerRoom: true}
+	if err := Init(c); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer Init(&config.Config{})
+
+	dir := t.TempDir()
+	var want []string
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("seg_%d.ivf", i)
+		p := filepath.Join(dir, name)
+		if err := os.WriteFile(p, []byte("x"), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		want = append(want, name)
+		Enqueue("serial-room", p)
+	}
+
+	backlog := metrics.UploadBacklog.WithLabelValues("serial-room")
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(backlog) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected backlog to drain, still %v", testutil.ToFloat64(backlog))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(order, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected uploads in order %v, got %v", want, order)
+	}
+}
+
+func TestEnqueue_TracksFailedJobs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	if err := Init(&config.Config{UploadEnabled: true, StorageBackend: "gcs", S3Endpoint: srv.URL, S3Bucket: "recs"}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer Init(&config.Config{})
+
+	failures := testutil.ToFloat64(metrics.UploadFailures)
+	Enqueue("jobs-room", writeRecording(t, "jobs.ivf", "x"))
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(metrics.UploadFailures) == failures {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected upload failure to be counted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if d := testutil.ToFloat64(metrics.UploadQueueDepth); d != 0 {
+		t.Errorf("Expected empty queue after failure, got depth %v", d)
+	}
+	var got *Job
+	for _, j := range Jobs() {
+		if j.Room == "jobs-room" {
+			j := j
+			got = &j
+			break
+		}
+	}
+	if got == nil || got.File != "jobs.ivf" || got.State != JobFailed || !strings.Contains(got.LastError, "403") {
+		t.Errorf("Expected failed job with last error, got %+v", got)
+	}
+}
+
+func TestUploadWithRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls, failFirst := 0, 2
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls <= failFirst {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := &config.Config{UploadEnabled: true, StorageBackend: "gcs", S3Endpoint: srv.URL, S3Bucket: "recs", UploadMaxAttempts: 3, UploadRetryBackoff: time.Millisecond}
+	if err := Init(c); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer Init(&config.Config{})
+	success := metrics.Uploads.WithLabelValues("success")
+	failure := metrics.Uploads.WithLabelValues("failure")
+
+	okBefore := testutil.ToFloat64(success)
+	p := writeRecording(t, "retry.ivf", "x")
+	if err := UploadWithRetry(context.Background(), p); err != nil || calls != 3 {
+		t.Fatalf("Expected success on the third attempt, got %v after %d calls", err, calls)
+	}
+	if testutil.ToFloat64(success) != okBefore+1 {
+		t.Error("Expected success counted once")
+	}
+
+	failBefore := testutil.ToFloat64(failure)
+	calls, failFirst = 0, 100
+	c.UploadMaxAttempts = 2
+	p = writeRecording(t, "dead.ivf", "x")
+	if err := UploadWithRetry(context.Background(), p); err == nil || calls != 2 {
+		t.Fatalf("Expected failure after 2 attempts, got %v after %d calls", err, calls)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Errorf("Expected file moved away, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(p), FailedDir, "dead.ivf")); err != nil {
+		t.Errorf("Expected file in failed directory, got %v", err)
+	}
+	if testutil.ToFloat64(failure) != failBefore+1 {
+		t.Error("Expected failure counted once")
+	}
+}
+
+func TestUpload_ConcurrencyAndWait(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+
+	if err := Init(&config.Config{UploadEnabled: true, StorageBackend: "gcs", S3Endpoint: srv.URL, S3Bucket: "recs", UploadConcurrency: 1}); err != nil {
+		t.Fatalf("init: %v", err)
+	}
+	defer Init(&config.Config{})
+
+	Enqueue("wait-room", writeRecording(t, "first.ivf", "x"))
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(metrics.UploadsInFlight) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected first upload to start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Upload(ctx, writeRecording(t, "second.ivf", "x")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected upload to wait for a free slot until ctx expires, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Wait(ctx); err == nil {
+		t.Error("Expected Wait to time out while an upload is in flight")
+	}
+
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := Wait(ctx); err != nil {
+		t.Errorf("Expected Wait to return after uploads finish, got %v", err)
+	}
+}
+
+// streamBackend 是支持未知长度上传的假后端，记录收到的对象与内容。
+type streamBackend struct {
+	object string
+	size   int64
+	body   string
+	fail   error
+	hold   chan struct{} // 非空时读取前等待，模拟上传跟不上
+}
+
+func (b *streamBackend) Put(_ context.Context, object string, r io.Reader, size int64, _ string) error {
+	b.object, b.size = object, size
+	if b.hold != nil {
+		<-b.hold
+	}
+	data, err := io.ReadAll(r)
+	b.body = string(data)
+	if err != nil {
+		return err
+	}
+	return b.fail
+}
+
+func (b *streamBackend) streamable() bool { return true }
+
+func TestOpenStream(t *testing.T) {
+	if _, err := OpenStream("demo.ogg"); err != ErrStreamUnsupported {
+		t.Fatalf("Expected ErrStreamUnsupported while disabled, got %v", err)
+	}
+
+	b := &streamBackend{}
+	cfg, backend = &config.Config{UploadEnabled: true, S3Prefix: "live"}, b
+	defer Init(&config.Config{})
+
+	s, err := OpenStream("demo.ogg")
+	if err != nil {
+		t.Fatalf("Expected stream to open, got %v", err)
+	}
+	_, _ = s.Write([]byte("ogg-"))
+	_, _ = s.Write([]byte("data"))
+	if err := s.Close(); err != nil {
+		t.Fatalf("Expected Close to return without waiting, got %v", err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatalf("Expected upload to succeed, got %v", err)
+	}
+	if b.object != "live/demo.ogg" || b.size != -1 || b.body != "ogg-data" {
+		t.Errorf("Unexpected upload: object=%q size=%d body=%q", b.object, b.size, b.body)
+	}
+	if err := s.Close(); err != nil || s.Wait() != nil {
+		t.Errorf("Expected repeated Close to be a no-op, got %v", err)
+	}
+	if _, err := s.Write([]byte("late")); err != io.ErrClosedPipe {
+		t.Errorf("Expected write after Close to fail, got %v", err)
+	}
+
+	s, _ = OpenStream("demo.ivf")
+	abort := fmt.Errorf("recorder failed")
+	_ = s.Abort(abort)
+	if err := s.Wait(); err != abort {
+		t.Errorf("Expected Abort to surface the upload error, got %v", err)
+	}
+
+	b.fail = fmt.Errorf("bucket gone")
+	s, _ = OpenStream("demo.ivf")
+	if _, err := s.Write([]byte("x")); err != nil {
+		t.Fatalf("Expected write to reach backend, got %v", err)
+	}
+	_ = s.Close()
+	if err := s.Wait(); err != b.fail {
+		t.Errorf("Expected backend error from Wait, got %v", err)
+	}
+}
+
+func TestStream_DropsWhenUploadFallsBehind(t *testing.T) {
+	b := &streamBackend{hold: make(chan struct{})}
+	cfg, backend = &config.Config{UploadEnabled: true}, b
+	defer Init(&config.Config{})
+
+	s, err := OpenStream("slow.ivf")
+	if err != nil {
+		t.Fatalf("Expected stream to open, got %v", err)
+	}
+	// 后端尚未读取：写入不得阻塞，超出缓冲的部分被丢弃
+	for i := 0; i < streamBufferChunks*2; i++ {
+		if n, err := s.Write([]byte("x")); n != 1 || err != nil {
+			t.Fatalf("Expected buffered write, got %d %v", n, err)
+		}
+	}
+	if s.Dropped() == 0 {
+		t.Error("Expected writes beyond the buffer to be dropped")
+	}
+	_ = s.Close() // 上传仍被挂起时 Close 也立即返回
+	close(b.hold)
+	if err := s.Wait(); err != nil {
+		t.Fatalf("Expected upload to finish, got %v", err)
+	}
+	if got := int64(len(b.body)) + s.Dropped(); got != streamBufferChunks*2 {
+		t.Errorf("Expected every write either uploaded or dropped, got %d", got)
+	}
+}
@@ -272,15 +272,20 @@ func TestSecurity_LargePayload(t *testing.T) {
 	largeSDP := strings.Repeat("a=large-payload-line\r\n", 100000)
 	largeSDP = "v=0\r\no=- 1234567890 1234567890 IN IP4 127.0.0.1\r\ns=-\r\nt=0 0\r\n" + largeSDP
 	
-	req := httptest.NewRequest("POST", "/api/whip/publish/test-room", bytes.NewReader([]byte(largeSDP)))
-	w := httptest.NewRecorder()
-	
-	h.ServeWHIPPublish(w, req, "test-room")
-	
-	resp := w.Result()
-	// Should handle large payload gracefully
-	if resp.StatusCode == 0 {
-		t.Error("Should handle large payload without crashing")
+	// 鉴权通过后，超过 MAX_SDP_BYTES 的请求体应被拒绝为 413，而不是截断后交给 SDP 解析
+	for name, serve := range map[string]func(http.ResponseWriter, *http.Request, string){
+		"whip": h.ServeWHIPPublish,
+		"whep": h.ServeWHEPPlay,
+	} {
+		req := httptest.NewRequest("POST", "/api/"+name+"/test-room", bytes.NewReader([]byte(largeSDP)))
+		req.Header.Set("Authorization", "Bearer secure-token")
+		w := httptest.NewRecorder()
+
+		serve(w, req, "test-room")
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("%s: expected 413 for an oversized offer, got %d", name, w.Code)
+		}
 	}
 }
 